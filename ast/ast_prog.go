@@ -16,6 +16,13 @@ func (m *Module) Eval(c *runtime.Context) {
 	c.RetVal = c.ExportValue
 }
 
+// EvalTop 在当前栈帧中执行模块，执行后模块顶层定义的变量保留在当前栈帧中
+func (m *Module) EvalTop(c *runtime.Context) {
+	defer c.RunDefers()
+	m.Block.EvalStmts(c)
+	c.RetVal = c.ExportValue
+}
+
 type Stmt interface {
 	Node
 	Position
@@ -40,6 +47,11 @@ type Block struct {
 func (m *Block) Eval(c *runtime.Context) {
 	c.PushStack()
 	defer c.PopStack()
+	m.EvalStmts(c)
+}
+
+// EvalStmts 在当前栈帧中依次执行语句，语句中定义的变量保留在当前栈帧中
+func (m *Block) EvalStmts(c *runtime.Context) {
 	for _, e := range m.Stmts {
 		c.SetPosition(e.Position())
 		c.AbortIfCancelled()
@@ -405,6 +417,7 @@ type StmtClassDefine struct {
 	Bases    []Expr
 	Body     *ExprObject
 	Static   *ExprObject
+	Source   string
 }
 
 func (s *StmtClassDefine) Eval(c *runtime.Context) {
@@ -413,6 +426,7 @@ func (s *StmtClassDefine) Eval(c *runtime.Context) {
 		return
 	}
 	newClass := runtime.NewType(runtime.NextTypeId(), s.Name)
+	newClass.Source = s.Source
 	if len(s.Bases) > 0 {
 		newClass.Bases = make([]runtime.ValueType, len(s.Bases))
 		for i, b := range s.Bases {
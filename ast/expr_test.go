@@ -1,15 +1,16 @@
 package ast
 
 import (
+	"context"
 	"testing"
 
 	"github.com/zgg-lang/zgg-go/runtime"
 )
 
 func assertResult(t *testing.T, expected runtime.Value, expr Expr) {
-	c := runtime.NewContext(true, false, false)
+	c := runtime.NewContext(true, false, false, context.Background())
 	expr.Eval(c)
-	if c.RetVal != expected {
+	if !c.ValuesEqual(c.RetVal, expected) {
 		t.Errorf("计算错误 %s != %s", c.RetVal, expected)
 	}
 }
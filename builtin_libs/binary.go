@@ -16,8 +16,6 @@ var (
 
 func libBinary(*Context) ValueObject {
 	lib := NewObject()
-	binaryReaderClass = binaryInitReaderClass()
-	binaryWriterClass = binaryInitWriterClass()
 	lib.SetMember("Reader", binaryReaderClass, nil)
 	lib.SetMember("Writer", binaryWriterClass, nil)
 	return lib
@@ -361,3 +359,8 @@ func binaryInitWriterClass() ValueType {
 		}).
 		Build()
 }
+
+func init() {
+	binaryReaderClass = binaryInitReaderClass()
+	binaryWriterClass = binaryInitWriterClass()
+}
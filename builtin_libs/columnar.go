@@ -0,0 +1,985 @@
+package builtin_libs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/decimal128"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet"
+	"github.com/apache/arrow/go/v17/parquet/compress"
+	"github.com/apache/arrow/go/v17/parquet/file"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+	"github.com/zgg-lang/zgg-go/internal/utils"
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// 列式存储文件(Parquet、Arrow IPC)的读写，全部基于纯go实现的arrow库
+
+const (
+	columnarDefaultBatchSize    = 4096
+	columnarDefaultRowGroupSize = 65536
+	columnarArrowFileMagic      = "ARROW1"
+)
+
+func libColumnar(c *Context) ValueObject {
+	lib := NewObject()
+	lib.SetMember("readParquet", NewNativeFunction("readParquet", columnarReadParquet, "source", "options"), c)
+	lib.SetMember("scanParquet", NewNativeFunction("scanParquet", columnarScanParquet, "source", "options"), c)
+	lib.SetMember("writeParquet", NewNativeFunction("writeParquet", columnarWriteParquet, "target", "data", "options"), c)
+	lib.SetMember("parquetInfo", NewNativeFunction("parquetInfo", columnarParquetInfo, "source"), c)
+	lib.SetMember("readArrow", NewNativeFunction("readArrow", columnarReadArrow, "source", "options"), c)
+	lib.SetMember("scanArrow", NewNativeFunction("scanArrow", columnarScanArrow, "source", "options"), c)
+	lib.SetMember("writeArrow", NewNativeFunction("writeArrow", columnarWriteArrow, "target", "data", "options"), c)
+	return lib
+}
+
+type columnarSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// columnarOpenSource 打开读取来源：文件路径、bytes或io.Reader
+func columnarOpenSource(c *Context, funcName string, source Value) (columnarSource, func()) {
+	switch s := source.(type) {
+	case ValueStr:
+		f, err := os.Open(s.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: open %s error %s", funcName, s.Value(), err)
+		}
+		return f, func() { f.Close() }
+	case ValueBytes:
+		return bytes.NewReader(s.ToGoValue(c).([]byte)), func() {}
+	case GoValue:
+		switch r := s.ToGoValue(c).(type) {
+		case columnarSource:
+			return r, func() {}
+		case io.Reader:
+			data, err := io.ReadAll(r)
+			if err != nil {
+				c.RaiseRuntimeError("%s: read source error %s", funcName, err)
+			}
+			return bytes.NewReader(data), func() {}
+		}
+	}
+	c.RaiseRuntimeError("%s: source must be a path, bytes or io.Reader", funcName)
+	return nil, nil
+}
+
+type columnarReadOptions struct {
+	columns   []string
+	rowGroups []int
+	batchSize int64
+}
+
+func columnarParseReadOptions(c *Context, funcName string, options ValueObject) *columnarReadOptions {
+	opts := &columnarReadOptions{batchSize: columnarDefaultBatchSize}
+	switch cols := options.GetMember("columns", c).(type) {
+	case ValueStr:
+		opts.columns = []string{cols.Value()}
+	case ValueArray:
+		for i := 0; i < cols.Len(); i++ {
+			opts.columns = append(opts.columns, cols.GetIndex(i, c).ToString(c))
+		}
+	}
+	if groups, ok := options.GetMember("rowGroups", c).(ValueArray); ok {
+		opts.rowGroups = []int{}
+		for i := 0; i < groups.Len(); i++ {
+			opts.rowGroups = append(opts.rowGroups, int(c.MustInt(groups.GetIndex(i, c))))
+		}
+	}
+	if n, ok := options.GetMember("batchSize", c).(ValueInt); ok && n.Value() > 0 {
+		opts.batchSize = n.Value()
+	}
+	return opts
+}
+
+// columnarRecords 按批读取的record，Read返回的record在下次调用前有效
+type columnarRecords struct {
+	schema *arrow.Schema
+	read   func() (arrow.Record, error)
+	close  func()
+	// 投影后的列名和对应record中的列下标
+	names []string
+	index []int
+}
+
+func (r *columnarRecords) project(c *Context, funcName string, columns []string) {
+	if columns == nil {
+		for i, f := range r.schema.Fields() {
+			r.names = append(r.names, f.Name)
+			r.index = append(r.index, i)
+		}
+		return
+	}
+	for _, name := range columns {
+		indices := r.schema.FieldIndices(name)
+		if len(indices) == 0 {
+			r.close()
+			c.RaiseRuntimeError("%s: column %s not found", funcName, name)
+		}
+		r.names = append(r.names, name)
+		r.index = append(r.index, indices[0])
+	}
+}
+
+func (r *columnarRecords) next(c *Context, funcName string) arrow.Record {
+	rec, err := r.read()
+	if rec != nil {
+		return rec
+	}
+	if err != nil && err != io.EOF {
+		c.RaiseRuntimeError("%s: read error %s", funcName, err)
+	}
+	return nil
+}
+
+func (r *columnarRecords) row(c *Context, rec arrow.Record, i int) []Value {
+	row := make([]Value, len(r.index))
+	for j, k := range r.index {
+		row[j] = columnarValue(c, rec.Column(k), i)
+	}
+	return row
+}
+
+// toTable 读取全部数据到PTable
+func (r *columnarRecords) toTable(c *Context, funcName string) Value {
+	defer r.close()
+	rows := make([][]Value, 0)
+	for rec := r.next(c, funcName); rec != nil; rec = r.next(c, funcName) {
+		for i := 0; i < int(rec.NumRows()); i++ {
+			rows = append(rows, r.row(c, rec, i))
+		}
+	}
+	return ptableNew(c, r.names, nil, rows)
+}
+
+// toIterator 返回逐行读取的可迭代对象，每行是以列名为key的对象
+func (r *columnarRecords) toIterator(c *Context, funcName string) Value {
+	var (
+		rec  arrow.Record
+		i    int
+		done bool
+	)
+	return MakeIterator(c, func() Value {
+		for !done && (rec == nil || i >= int(rec.NumRows())) {
+			if rec = r.next(c, funcName); rec == nil {
+				done = true
+			}
+			i = 0
+		}
+		if done {
+			return nil
+		}
+		obj := NewObject()
+		for j, v := range r.row(c, rec, i) {
+			obj.SetMember(r.names[j], v, c)
+		}
+		i++
+		return obj
+	}, r.close)
+}
+
+func columnarOpenParquet(c *Context, funcName string, source Value, opts *columnarReadOptions) *columnarRecords {
+	src, closeSrc := columnarOpenSource(c, funcName, source)
+	pf, err := file.NewParquetReader(src)
+	if err != nil {
+		closeSrc()
+		c.RaiseRuntimeError("%s: open parquet error %s", funcName, err)
+	}
+	closeAll := func() {
+		pf.Close()
+		closeSrc()
+	}
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{BatchSize: opts.batchSize}, memory.DefaultAllocator)
+	if err != nil {
+		closeAll()
+		c.RaiseRuntimeError("%s: read parquet schema error %s", funcName, err)
+	}
+	// 只读取需要的列
+	var leaves []int
+	if opts.columns != nil {
+		schema, err := fr.Schema()
+		if err != nil {
+			closeAll()
+			c.RaiseRuntimeError("%s: read parquet schema error %s", funcName, err)
+		}
+		fields := make([]int, 0, len(opts.columns))
+		for _, name := range opts.columns {
+			indices := schema.FieldIndices(name)
+			if len(indices) == 0 {
+				closeAll()
+				c.RaiseRuntimeError("%s: column %s not found", funcName, name)
+			}
+			fields = append(fields, indices[0])
+		}
+		if leaves, err = fr.Manifest.GetFieldIndices(fields); err != nil {
+			closeAll()
+			c.RaiseRuntimeError("%s: %s", funcName, err)
+		}
+	}
+	rr, err := fr.GetRecordReader(context.Background(), leaves, opts.rowGroups)
+	if err != nil {
+		closeAll()
+		c.RaiseRuntimeError("%s: %s", funcName, err)
+	}
+	rv := &columnarRecords{
+		schema: rr.Schema(),
+		read:   rr.Read,
+		close: func() {
+			rr.Release()
+			closeAll()
+		},
+	}
+	rv.project(c, funcName, opts.columns)
+	return rv
+}
+
+// columnarOpenArrow 根据文件头自动识别Arrow IPC的file格式和stream格式
+func columnarOpenArrow(c *Context, funcName string, source Value, opts *columnarReadOptions) *columnarRecords {
+	src, closeSrc := columnarOpenSource(c, funcName, source)
+	magic := make([]byte, len(columnarArrowFileMagic))
+	n, _ := src.ReadAt(magic, 0)
+	var rv *columnarRecords
+	if string(magic[:n]) == columnarArrowFileMagic {
+		fr, err := ipc.NewFileReader(src)
+		if err != nil {
+			closeSrc()
+			c.RaiseRuntimeError("%s: open arrow file error %s", funcName, err)
+		}
+		rv = &columnarRecords{
+			schema: fr.Schema(),
+			read:   fr.Read,
+			close: func() {
+				fr.Close()
+				closeSrc()
+			},
+		}
+	} else {
+		sr, err := ipc.NewReader(src)
+		if err != nil {
+			closeSrc()
+			c.RaiseRuntimeError("%s: open arrow stream error %s", funcName, err)
+		}
+		rv = &columnarRecords{
+			schema: sr.Schema(),
+			read:   sr.Read,
+			close: func() {
+				sr.Release()
+				closeSrc()
+			},
+		}
+	}
+	rv.project(c, funcName, opts.columns)
+	return rv
+}
+
+func columnarReadArgs(c *Context, funcName string, args []Value) (Value, *columnarReadOptions) {
+	var (
+		source  Value
+		options ValueObject
+	)
+	EnsureFuncParams(c, funcName, args,
+		ArgRuleRequired("source", TypeAny, &source),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	return source, columnarParseReadOptions(c, funcName, options)
+}
+
+func columnarReadParquet(c *Context, this Value, args []Value) Value {
+	source, opts := columnarReadArgs(c, "columnar.readParquet", args)
+	return columnarOpenParquet(c, "columnar.readParquet", source, opts).toTable(c, "columnar.readParquet")
+}
+
+func columnarScanParquet(c *Context, this Value, args []Value) Value {
+	source, opts := columnarReadArgs(c, "columnar.scanParquet", args)
+	return columnarOpenParquet(c, "columnar.scanParquet", source, opts).toIterator(c, "columnar.scanParquet")
+}
+
+func columnarReadArrow(c *Context, this Value, args []Value) Value {
+	source, opts := columnarReadArgs(c, "columnar.readArrow", args)
+	return columnarOpenArrow(c, "columnar.readArrow", source, opts).toTable(c, "columnar.readArrow")
+}
+
+func columnarScanArrow(c *Context, this Value, args []Value) Value {
+	source, opts := columnarReadArgs(c, "columnar.scanArrow", args)
+	return columnarOpenArrow(c, "columnar.scanArrow", source, opts).toIterator(c, "columnar.scanArrow")
+}
+
+func columnarParquetInfo(c *Context, this Value, args []Value) Value {
+	var source Value
+	EnsureFuncParams(c, "columnar.parquetInfo", args, ArgRuleRequired("source", TypeAny, &source))
+	src, closeSrc := columnarOpenSource(c, "columnar.parquetInfo", source)
+	defer closeSrc()
+	pf, err := file.NewParquetReader(src)
+	if err != nil {
+		c.RaiseRuntimeError("columnar.parquetInfo: open parquet error %s", err)
+	}
+	defer pf.Close()
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		c.RaiseRuntimeError("columnar.parquetInfo: read parquet schema error %s", err)
+	}
+	schema, err := fr.Schema()
+	if err != nil {
+		c.RaiseRuntimeError("columnar.parquetInfo: read parquet schema error %s", err)
+	}
+	rv := NewObject()
+	rv.SetMember("numRows", NewInt(pf.NumRows()), c)
+	rv.SetMember("numRowGroups", NewInt(int64(pf.NumRowGroups())), c)
+	rowGroups := NewArray(pf.NumRowGroups())
+	for i := 0; i < pf.NumRowGroups(); i++ {
+		rowGroups.PushBack(NewInt(pf.MetaData().RowGroup(i).NumRows()))
+	}
+	rv.SetMember("rowGroups", rowGroups, c)
+	rv.SetMember("columns", columnarSchemaToValue(c, schema), c)
+	return rv
+}
+
+func columnarSchemaToValue(c *Context, schema *arrow.Schema) Value {
+	rv := NewArray(schema.NumFields())
+	for _, f := range schema.Fields() {
+		col := NewObject()
+		col.SetMember("name", NewStr(f.Name), c)
+		col.SetMember("type", NewStr(f.Type.String()), c)
+		col.SetMember("nullable", NewBool(f.Nullable), c)
+		rv.PushBack(col)
+	}
+	return rv
+}
+
+// columnarValue 把arrow数组中的一个元素转换为zgg的值
+func columnarValue(c *Context, arr arrow.Array, i int) Value {
+	if arr.IsNull(i) {
+		return Nil()
+	}
+	switch a := arr.(type) {
+	case *array.Int8:
+		return NewInt(int64(a.Value(i)))
+	case *array.Int16:
+		return NewInt(int64(a.Value(i)))
+	case *array.Int32:
+		return NewInt(int64(a.Value(i)))
+	case *array.Int64:
+		return NewInt(a.Value(i))
+	case *array.Uint8:
+		return NewInt(int64(a.Value(i)))
+	case *array.Uint16:
+		return NewInt(int64(a.Value(i)))
+	case *array.Uint32:
+		return NewInt(int64(a.Value(i)))
+	case *array.Uint64:
+		return NewInt(int64(a.Value(i)))
+	case *array.Float32:
+		return NewFloat(float64(a.Value(i)))
+	case *array.Float64:
+		return NewFloat(a.Value(i))
+	case *array.Boolean:
+		return NewBool(a.Value(i))
+	case *array.String:
+		return NewStr(a.Value(i))
+	case *array.LargeString:
+		return NewStr(a.Value(i))
+	case *array.Binary:
+		return NewBytes(append([]byte(nil), a.Value(i)...))
+	case *array.LargeBinary:
+		return NewBytes(append([]byte(nil), a.Value(i)...))
+	case *array.FixedSizeBinary:
+		return NewBytes(append([]byte(nil), a.Value(i)...))
+	case *array.Timestamp:
+		typ := a.DataType().(*arrow.TimestampType)
+		t := a.Value(i).ToTime(typ.Unit)
+		if typ.TimeZone == "" {
+			// 没有时区的时间按本地时间解释
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		} else {
+			t = t.Local()
+		}
+		return NewObjectAndInit(timeTimeClass, c, NewGoValue(t))
+	case *array.Date32:
+		return columnarDate(c, a.Value(i).ToTime())
+	case *array.Date64:
+		return columnarDate(c, a.Value(i).ToTime())
+	case *array.Decimal128:
+		typ := a.DataType().(*arrow.Decimal128Type)
+		f, _, err := big.ParseFloat(a.Value(i).ToString(typ.Scale), 10, 128, big.ToNearestEven)
+		if err != nil {
+			return NewStr(a.ValueStr(i))
+		}
+		return NewBigNum(f)
+	case *array.Map:
+		keys, items := a.Keys(), a.Items()
+		start, end := a.ValueOffsets(i)
+		rv := NewObject()
+		for j := int(start); j < int(end); j++ {
+			rv.SetMember(columnarValue(c, keys, j).ToString(c), columnarValue(c, items, j), c)
+		}
+		return rv
+	case array.ListLike:
+		values := a.ListValues()
+		start, end := a.ValueOffsets(i)
+		rv := NewArray(int(end - start))
+		for j := int(start); j < int(end); j++ {
+			rv.PushBack(columnarValue(c, values, j))
+		}
+		return rv
+	case *array.Struct:
+		typ := a.DataType().(*arrow.StructType)
+		rv := NewObject()
+		for j, f := range typ.Fields() {
+			rv.SetMember(f.Name, columnarValue(c, a.Field(j), i), c)
+		}
+		return rv
+	case *array.Dictionary:
+		return columnarValue(c, a.Dictionary(), a.GetValueIndex(i))
+	}
+	return NewStr(arr.ValueStr(i))
+}
+
+func columnarDate(c *Context, t time.Time) Value {
+	return NewObjectAndInit(timeTimeClass, c, NewGoValue(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)))
+}
+
+var columnarDecimalTypeRegexp = regexp.MustCompile(`^decimal\((\d+),\s*(\d+)\)$`)
+
+// columnarParseType 解析声明的列类型
+func columnarParseType(c *Context, funcName, name string) arrow.DataType {
+	switch name {
+	case "int", "int64":
+		return arrow.PrimitiveTypes.Int64
+	case "int32":
+		return arrow.PrimitiveTypes.Int32
+	case "int16":
+		return arrow.PrimitiveTypes.Int16
+	case "int8":
+		return arrow.PrimitiveTypes.Int8
+	case "float", "float64", "double":
+		return arrow.PrimitiveTypes.Float64
+	case "float32":
+		return arrow.PrimitiveTypes.Float32
+	case "str", "string":
+		return arrow.BinaryTypes.String
+	case "bytes", "binary":
+		return arrow.BinaryTypes.Binary
+	case "bool":
+		return arrow.FixedWidthTypes.Boolean
+	case "time", "timestamp":
+		return arrow.FixedWidthTypes.Timestamp_us
+	case "date":
+		return arrow.FixedWidthTypes.Date32
+	}
+	if m := columnarDecimalTypeRegexp.FindStringSubmatch(name); m != nil {
+		precision, _ := strconv.Atoi(m[1])
+		scale, _ := strconv.Atoi(m[2])
+		return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}
+	}
+	c.RaiseRuntimeError("%s: unsupported column type %s", funcName, name)
+	return nil
+}
+
+// columnarInferType 根据已有的值推断列类型，无法推断或类型不一致时使用字符串
+func columnarInferType(c *Context, values []Value) arrow.DataType {
+	var rv arrow.DataType
+	for _, v := range values {
+		var t arrow.DataType
+		switch vv := v.(type) {
+		case ValueNil, ValueUndefined:
+			continue
+		case ValueInt:
+			t = arrow.PrimitiveTypes.Int64
+		case ValueFloat, ValueBigNum:
+			t = arrow.PrimitiveTypes.Float64
+		case ValueBool:
+			t = arrow.FixedWidthTypes.Boolean
+		case ValueBytes:
+			t = arrow.BinaryTypes.Binary
+		case ValueObject:
+			if vv.Type() == timeTimeClass {
+				t = arrow.FixedWidthTypes.Timestamp_us
+			} else {
+				t = arrow.BinaryTypes.String
+			}
+		default:
+			t = arrow.BinaryTypes.String
+		}
+		switch {
+		case rv == nil || arrow.TypeEqual(rv, t):
+			rv = t
+		case (rv.ID() == arrow.INT64 && t.ID() == arrow.FLOAT64) || (rv.ID() == arrow.FLOAT64 && t.ID() == arrow.INT64):
+			rv = arrow.PrimitiveTypes.Float64
+		default:
+			return arrow.BinaryTypes.String
+		}
+	}
+	if rv == nil {
+		return arrow.BinaryTypes.String
+	}
+	return rv
+}
+
+// columnarCell 取一行中的某列，行可以是数组或对象
+func columnarCell(c *Context, row Value, index int, name string) Value {
+	if arr, ok := row.(ValueArray); ok {
+		if index >= 0 && index < arr.Len() {
+			return arr.GetIndex(index, c)
+		}
+		return Nil()
+	}
+	return row.GetMember(name, c)
+}
+
+// columnarSchemaDeclared 判断是否声明了schema，未声明时根据数据推断
+func columnarSchemaDeclared(spec Value) bool {
+	switch spec.(type) {
+	case ValueArray, ValueObject:
+		return true
+	}
+	return false
+}
+
+// columnarBuildSchema 使用声明的schema，或根据batch中的行推断schema
+//
+// 声明的schema可以是[{name, type, nullable}]、[[name, type]]或{name: type}
+func columnarBuildSchema(c *Context, funcName string, spec Value, cols []string, batch []Value) *arrow.Schema {
+	fields := make([]arrow.Field, 0)
+	addField := func(name string, typ Value, nullable bool) {
+		fields = append(fields, arrow.Field{
+			Name:     name,
+			Type:     columnarParseType(c, funcName, typ.ToString(c)),
+			Nullable: nullable,
+		})
+	}
+	switch s := spec.(type) {
+	case ValueArray:
+		for i := 0; i < s.Len(); i++ {
+			switch f := s.GetIndex(i, c).(type) {
+			case ValueArray:
+				addField(f.GetIndex(0, c).ToString(c), f.GetIndex(1, c), true)
+			case ValueObject:
+				nullable := f.GetMember("nullable", c)
+				addField(f.GetMember("name", c).ToString(c), f.GetMember("type", c), dbIsNil(nullable) || nullable.IsTrue())
+			default:
+				c.RaiseRuntimeError("%s: invalid schema field %s", funcName, f.ToString(c))
+			}
+		}
+		return arrow.NewSchema(fields, nil)
+	case ValueObject:
+		names := make([]string, 0, s.Len())
+		s.Iterate(func(k string, _ Value) {
+			names = append(names, k)
+		})
+		columnarSortNames(names, cols)
+		for _, name := range names {
+			addField(name, s.GetMember(name, c), true)
+		}
+		return arrow.NewSchema(fields, nil)
+	}
+	if cols == nil {
+		if len(batch) == 0 {
+			c.RaiseRuntimeError("%s: cannot infer schema from empty data", funcName)
+		}
+		keys := map[string]bool{}
+		for _, row := range batch {
+			obj, ok := row.(ValueObject)
+			if !ok {
+				c.RaiseRuntimeError("%s: schema is required when rows are not objects", funcName)
+			}
+			obj.Iterate(func(k string, _ Value) {
+				if !keys[k] {
+					keys[k] = true
+					cols = append(cols, k)
+				}
+			})
+		}
+		sort.Strings(cols)
+	}
+	for i, name := range cols {
+		values := make([]Value, len(batch))
+		for j, row := range batch {
+			values[j] = columnarCell(c, row, i, name)
+		}
+		fields = append(fields, arrow.Field{Name: name, Type: columnarInferType(c, values), Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// 对象成员无序，已知列顺序时按列顺序排列，其余按名字排序
+func columnarSortNames(names []string, cols []string) {
+	order := map[string]int{}
+	for i, col := range cols {
+		order[col] = i
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, iok := order[names[i]]
+		oj, jok := order[names[j]]
+		if iok && jok {
+			return oi < oj
+		} else if iok != jok {
+			return iok
+		}
+		return names[i] < names[j]
+	})
+}
+
+func columnarTime(c *Context, funcName string, v Value) time.Time {
+	switch vv := v.(type) {
+	case ValueObject:
+		if vv.Type() == timeTimeClass {
+			return vv.Reserved.(timeTimeInfo).t
+		}
+	case ValueInt:
+		return time.Unix(vv.Value(), 0)
+	case ValueStr:
+		if t, _, err := utils.ParseTime(vv.Value(), "", nil); err == nil {
+			return t
+		}
+	}
+	c.RaiseRuntimeError("%s: cannot convert %s to time", funcName, v.ToString(c))
+	return time.Time{}
+}
+
+func columnarInt(c *Context, funcName string, v Value) int64 {
+	switch vv := v.(type) {
+	case ValueInt:
+		return vv.Value()
+	case ValueFloat:
+		// 不截断小数
+		if f := vv.Value(); f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f)
+		}
+	case ValueBool:
+		if vv.Value() {
+			return 1
+		}
+		return 0
+	case ValueStr:
+		if i, err := strconv.ParseInt(vv.Value(), 10, 64); err == nil {
+			return i
+		}
+	}
+	c.RaiseRuntimeError("%s: cannot convert %s to int", funcName, v.ToString(c))
+	return 0
+}
+
+func columnarFloat(c *Context, funcName string, v Value) float64 {
+	switch vv := v.(type) {
+	case ValueInt:
+		return float64(vv.Value())
+	case ValueFloat:
+		return vv.Value()
+	case ValueBigNum:
+		f, _ := vv.ToGoValue(c).(*big.Float).Float64()
+		return f
+	case ValueStr:
+		if f, err := strconv.ParseFloat(vv.Value(), 64); err == nil {
+			return f
+		}
+	}
+	c.RaiseRuntimeError("%s: cannot convert %s to float", funcName, v.ToString(c))
+	return 0
+}
+
+// columnarAppend 按列类型把值追加到builder中
+func columnarAppend(c *Context, funcName string, b array.Builder, field arrow.Field, v Value) {
+	if dbIsNil(v) {
+		if !field.Nullable {
+			c.RaiseRuntimeError("%s: column %s is not nullable", funcName, field.Name)
+		}
+		b.AppendNull()
+		return
+	}
+	switch bb := b.(type) {
+	case *array.Int64Builder:
+		bb.Append(columnarInt(c, funcName, v))
+	case *array.Int32Builder:
+		bb.Append(int32(columnarInt(c, funcName, v)))
+	case *array.Int16Builder:
+		bb.Append(int16(columnarInt(c, funcName, v)))
+	case *array.Int8Builder:
+		bb.Append(int8(columnarInt(c, funcName, v)))
+	case *array.Float64Builder:
+		bb.Append(columnarFloat(c, funcName, v))
+	case *array.Float32Builder:
+		bb.Append(float32(columnarFloat(c, funcName, v)))
+	case *array.BooleanBuilder:
+		bb.Append(v.IsTrue())
+	case *array.StringBuilder:
+		bb.Append(v.ToString(c))
+	case *array.BinaryBuilder:
+		if bs, ok := v.(ValueBytes); ok {
+			bb.Append(bs.ToGoValue(c).([]byte))
+		} else {
+			bb.Append([]byte(v.ToString(c)))
+		}
+	case *array.TimestampBuilder:
+		ts, err := arrow.TimestampFromTime(columnarTime(c, funcName, v), field.Type.(*arrow.TimestampType).Unit)
+		if err != nil {
+			c.RaiseRuntimeError("%s: column %s: %s", funcName, field.Name, err)
+		}
+		bb.Append(ts)
+	case *array.Date32Builder:
+		t := columnarTime(c, funcName, v)
+		bb.Append(arrow.Date32FromTime(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)))
+	case *array.Decimal128Builder:
+		typ := field.Type.(*arrow.Decimal128Type)
+		n, err := decimal128.FromString(v.ToString(c), typ.Precision, typ.Scale)
+		if err != nil {
+			c.RaiseRuntimeError("%s: column %s: %s", funcName, field.Name, err)
+		}
+		bb.Append(n)
+	default:
+		c.RaiseRuntimeError("%s: unsupported column type %s", funcName, field.Type)
+	}
+}
+
+type columnarRecordWriter interface {
+	Write(arrow.Record) error
+	Close() error
+}
+
+// columnarWrite 分批把PTable或数组/可迭代对象中的行写入，返回写入的行数。
+// 未声明schema时，PTable和数组根据全部行推断schema；可迭代对象只能根据第一批推断，
+// 之后出现的新列或与推断类型不符的值会抛出异常
+func columnarWrite(c *Context, funcName string, data Value, options ValueObject, open func(*arrow.Schema) columnarRecordWriter) int {
+	batchSize := columnarDefaultRowGroupSize
+	if n, ok := options.GetMember("rowGroupSize", c).(ValueInt); ok && n.Value() > 0 {
+		batchSize = n.AsInt()
+	}
+	var (
+		spec    = options.GetMember("schema", c)
+		cols    []string
+		all     []Value
+		known   map[string]bool
+		schema  *arrow.Schema
+		w       columnarRecordWriter
+		rb      *array.RecordBuilder
+		srcIdx  []int
+		batch   = make([]Value, 0, batchSize)
+		total   = 0
+		success = false
+	)
+	defer func() {
+		if rb != nil {
+			rb.Release()
+		}
+		if w != nil {
+			if err := w.Close(); err != nil && success {
+				c.RaiseRuntimeError("%s: close error %s", funcName, err)
+			}
+		}
+	}()
+	flush := func() {
+		if schema == nil {
+			if all != nil {
+				schema = columnarBuildSchema(c, funcName, spec, cols, all)
+			} else {
+				schema = columnarBuildSchema(c, funcName, spec, cols, batch)
+			}
+			if !columnarSchemaDeclared(spec) && cols == nil {
+				known = map[string]bool{}
+				for _, f := range schema.Fields() {
+					known[f.Name] = true
+				}
+			}
+			// 行是数组时，按列名找到schema中每一列在行中的位置
+			srcIdx = make([]int, schema.NumFields())
+			for i, f := range schema.Fields() {
+				srcIdx[i] = i
+				for j, col := range cols {
+					if col == f.Name {
+						srcIdx[i] = j
+						break
+					}
+				}
+			}
+			w = open(schema)
+			rb = array.NewRecordBuilder(memory.DefaultAllocator, schema)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		for _, row := range batch {
+			if obj, ok := row.(ValueObject); ok && known != nil {
+				obj.Iterate(func(k string, _ Value) {
+					if !known[k] {
+						c.RaiseRuntimeError("%s: column %s is not in the schema inferred from the first %d rows, please declare schema", funcName, k, batchSize)
+					}
+				})
+			}
+			for i, f := range schema.Fields() {
+				columnarAppend(c, funcName, rb.Field(i), f, columnarCell(c, row, srcIdx[i], f.Name))
+			}
+		}
+		rec := rb.NewRecord()
+		defer rec.Release()
+		if err := w.Write(rec); err != nil {
+			c.RaiseRuntimeError("%s: write error %s", funcName, err)
+		}
+		total += len(batch)
+		batch = batch[:0]
+	}
+	if obj, ok := data.(ValueObject); ok && obj.Type().IsSubOf(ptablePTableClass) {
+		meta, rows := ptableData(c, obj)
+		cols = meta.headers
+		all = make([]Value, len(rows))
+		for i, row := range rows {
+			all[i] = NewArrayByValues(row...)
+		}
+		for _, row := range all {
+			batch = append(batch, row)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		}
+	} else if arr, ok := data.(ValueArray); ok {
+		all = make([]Value, arr.Len())
+		for i := range all {
+			all[i] = arr.GetIndex(i, c)
+		}
+		for _, row := range all {
+			batch = append(batch, row)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		}
+	} else {
+		dbIterate(c, funcName, data, func(row Value) bool {
+			batch = append(batch, row)
+			if len(batch) >= batchSize {
+				flush()
+			}
+			return true
+		})
+	}
+	flush()
+	success = true
+	return total
+}
+
+func columnarWriteArgs(c *Context, funcName string, args []Value) (Value, Value, ValueObject) {
+	var (
+		target  Value
+		data    Value
+		options ValueObject
+	)
+	EnsureFuncParams(c, funcName, args,
+		ArgRuleRequired("target", TypeAny, &target),
+		ArgRuleRequired("data", TypeAny, &data),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	return target, data, options
+}
+
+type columnarParquetWriter struct {
+	*pqarrow.FileWriter
+	closeFn func()
+}
+
+func (w *columnarParquetWriter) Close() error {
+	err := w.FileWriter.Close()
+	w.closeFn()
+	return err
+}
+
+func columnarWriteParquet(c *Context, this Value, args []Value) Value {
+	const funcName = "columnar.writeParquet"
+	target, data, options := columnarWriteArgs(c, funcName, args)
+	codec := compress.Codecs.Snappy
+	if s, ok := options.GetMember("compression", c).(ValueStr); ok {
+		switch s.Value() {
+		case "snappy":
+		case "gzip":
+			codec = compress.Codecs.Gzip
+		case "zstd":
+			codec = compress.Codecs.Zstd
+		case "brotli":
+			codec = compress.Codecs.Brotli
+		case "none", "uncompressed":
+			codec = compress.Codecs.Uncompressed
+		default:
+			c.RaiseRuntimeError("%s: unsupported compression %s", funcName, s.Value())
+		}
+	}
+	n := columnarWrite(c, funcName, data, options, func(schema *arrow.Schema) columnarRecordWriter {
+		bw, closeFn := dbOpenWriter(c, funcName, target)
+		props := parquet.NewWriterProperties(parquet.WithCompression(codec))
+		fw, err := pqarrow.NewFileWriter(schema, bw, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+		if err != nil {
+			closeFn()
+			c.RaiseRuntimeError("%s: %s", funcName, err)
+		}
+		return &columnarParquetWriter{FileWriter: fw, closeFn: closeFn}
+	})
+	return NewInt(int64(n))
+}
+
+type columnarArrowWriter struct {
+	columnarRecordWriter
+	closeFn func()
+}
+
+func (w *columnarArrowWriter) Close() error {
+	err := w.columnarRecordWriter.Close()
+	w.closeFn()
+	return err
+}
+
+func columnarWriteArrow(c *Context, this Value, args []Value) Value {
+	const funcName = "columnar.writeArrow"
+	target, data, options := columnarWriteArgs(c, funcName, args)
+	format := "file"
+	if s, ok := options.GetMember("format", c).(ValueStr); ok {
+		format = s.Value()
+	}
+	ipcOpts := []ipc.Option{}
+	if s, ok := options.GetMember("compression", c).(ValueStr); ok {
+		switch s.Value() {
+		case "zstd":
+			ipcOpts = append(ipcOpts, ipc.WithZstd())
+		case "lz4":
+			ipcOpts = append(ipcOpts, ipc.WithLZ4())
+		case "none", "uncompressed":
+		default:
+			c.RaiseRuntimeError("%s: unsupported compression %s", funcName, s.Value())
+		}
+	}
+	n := columnarWrite(c, funcName, data, options, func(schema *arrow.Schema) columnarRecordWriter {
+		opts := append(ipcOpts, ipc.WithSchema(schema))
+		switch format {
+		case "file":
+			// file格式需要回写文件尾，只支持写入文件路径
+			path, ok := target.(ValueStr)
+			if !ok {
+				c.RaiseRuntimeError("%s: file format requires a path target, use format 'stream' instead", funcName)
+			}
+			f, err := os.Create(path.Value())
+			if err != nil {
+				c.RaiseRuntimeError("%s: create %s error %s", funcName, path.Value(), err)
+			}
+			fw, err := ipc.NewFileWriter(f, opts...)
+			if err != nil {
+				f.Close()
+				c.RaiseRuntimeError("%s: %s", funcName, err)
+			}
+			return &columnarArrowWriter{columnarRecordWriter: fw, closeFn: func() { f.Close() }}
+		case "stream":
+			bw, closeFn := dbOpenWriter(c, funcName, target)
+			return &columnarArrowWriter{columnarRecordWriter: ipc.NewWriter(bw, opts...), closeFn: closeFn}
+		}
+		c.RaiseRuntimeError("%s: unsupported format %s", funcName, format)
+		return nil
+	})
+	return NewInt(int64(n))
+}
@@ -0,0 +1,134 @@
+package builtin_libs_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testColumnarSetup = `
+col := import('columnar')
+pt := import('ptable')
+db := import('db')
+t := pt('id', 'name', 'score')
+t.add(1, 'a', 1.5).add(2, 'b', 2).add(3, nil, 3.25)
+writeT := () => col.writeParquet('{dir}/t.parquet', t, {compression: 'zstd', rowGroupSize: 2})
+errorOf := f => {
+	try {
+		f()
+		return 'ok'
+	} catch (e) {
+		return e.message
+	}
+}
+`
+
+// testColumnarRun 在临时目录中运行testColumnarSetup和code，比较导出的result
+func testColumnarRun(t *testing.T, code string, expected interface{}) {
+	dir := filepath.ToSlash(t.TempDir())
+	os.WriteFile(dir+"/mixed.jsonl", []byte("{\"v\":1}\n{\"v\":2}\n{\"v\":2.7}\n"), 0644)
+	os.WriteFile(dir+"/late.jsonl", []byte("{\"v\":1}\n{\"v\":2}\n{\"v\":3,\"late\":\"x\"}\n"), 0644)
+	exported, err := zgg.RunCode(strings.ReplaceAll(testColumnarSetup+code, "{dir}", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exported["result"], expected) {
+		t.Fatalf("expected %#v, got %#v", expected, exported["result"])
+	}
+}
+
+func TestColumnar(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"WriteParquet", `
+			export result := writeT()
+		`, int64(3)},
+		{"ParquetInfo", `
+			writeT()
+			info := col.parquetInfo('{dir}/t.parquet')
+			export result := [info.numRows, info.rowGroups, info.columns.map(c => c.type)]
+		`, []interface{}{int64(3), []interface{}{int64(2), int64(1)}, []interface{}{"int64", "utf8", "float64"}}},
+		{"ReadColumnsAndRowGroups", `
+			writeT()
+			export result := col.readParquet('{dir}/t.parquet', {columns: ['score', 'id'], rowGroups: [1]}).toArray(true)
+		`, []interface{}{
+			[]interface{}{"score", "id"},
+			[]interface{}{3.25, int64(3)},
+		}},
+		{"ScanParquet", `
+			writeT()
+			names := []
+			for row in col.scanParquet('{dir}/t.parquet', {columns: 'name'}) {
+				names.push(row.name)
+			}
+			export result := names
+		`, []interface{}{"a", "b", nil}},
+		{"WriteObjects", `
+			col.writeParquet('{dir}/o.parquet', [{k: 'x', v: 1}, {k: 'y', v: 2.5, extra: true}])
+			export result := col.readParquet('{dir}/o.parquet').toArray(true)
+		`, []interface{}{
+			[]interface{}{"extra", "k", "v"},
+			[]interface{}{nil, "x", 1.0},
+			[]interface{}{true, "y", 2.5},
+		}},
+		{"NotNullable", `
+			export result := errorOf(() => col.writeParquet('{dir}/e.parquet', [{k: nil}], {schema: [{name: 'k', type: 'str', nullable: false}]}))
+		`, "columnar.writeParquet: column k is not nullable"},
+		{"ArrowFile", `
+			col.writeArrow('{dir}/t.arrow', t)
+			export result := col.readArrow('{dir}/t.arrow', {columns: ['name']}).toArray()
+		`, []interface{}{[]interface{}{"a"}, []interface{}{"b"}, []interface{}{nil}}},
+		{"ArrowStream", `
+			col.writeArrow('{dir}/t.arrows', t, {format: 'stream', compression: 'zstd'})
+			sum := 0
+			for row in col.scanArrow('{dir}/t.arrows') {
+				sum += row.id
+			}
+			export result := sum
+		`, int64(6)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testColumnarRun(t, tc.code, tc.expected)
+		})
+	}
+}
+
+func TestColumnarInferSchema(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"AllRows", `
+			col.writeParquet('{dir}/a.parquet', [{id: 1, v: 1}, {id: 2, v: 2}, {id: 3, v: 2.7, late: 'x'}], {rowGroupSize: 2})
+			export result := col.readParquet('{dir}/a.parquet').toArray(true)
+		`, []interface{}{
+			[]interface{}{"id", "late", "v"},
+			[]interface{}{int64(1), nil, 1.0},
+			[]interface{}{int64(2), nil, 2.0},
+			[]interface{}{int64(3), "x", 2.7},
+		}},
+		{"IteratorRejectsTruncatedInt", `
+			export result := errorOf(() => col.writeParquet('{dir}/m.parquet', db.readJsonl('{dir}/mixed.jsonl'), {rowGroupSize: 2}))
+		`, "columnar.writeParquet: cannot convert 2.7 to int"},
+		{"IteratorRejectsUnknownColumn", `
+			export result := errorOf(() => col.writeParquet('{dir}/l.parquet', db.readJsonl('{dir}/late.jsonl'), {rowGroupSize: 2}))
+		`, "columnar.writeParquet: column late is not in the schema inferred from the first 2 rows, please declare schema"},
+		{"DeclaredIntRejectsFloat", `
+			export result := errorOf(() => col.writeParquet('{dir}/s.parquet', [{id: 1, v: 2.7}], {schema: {id: 'int64', v: 'int64'}}))
+		`, "columnar.writeParquet: cannot convert 2.7 to int"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testColumnarRun(t, tc.code, tc.expected)
+		})
+	}
+}
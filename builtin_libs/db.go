@@ -20,31 +20,6 @@ var (
 	dbActiveRecordClass ValueType
 )
 
-type (
-	dbDialect interface {
-		ShowTablesSQL() string
-		Quote(name string) string
-	}
-
-	dbCommonDialect struct{}
-	dbMySQLDialect  struct{}
-	dbSQLiteDialect struct{}
-)
-
-func (dbCommonDialect) Quote(name string) string { return name }
-func (dbCommonDialect) ShowTablesSQL() string    { return "SHOW TABLES" }
-func (dbMySQLDialect) Quote(name string) string  { return fmt.Sprintf("`%s`", name) }
-func (dbMySQLDialect) ShowTablesSQL() string     { return "SHOW TABLES" }
-func (dbSQLiteDialect) Quote(name string) string { return fmt.Sprintf("`%s`", name) }
-func (dbSQLiteDialect) ShowTablesSQL() string    { return ".TABLES" }
-
-var (
-	dbDialectMap = map[string]dbDialect{
-		"mysql":  dbMySQLDialect{},
-		"sqlite": dbSQLiteDialect{},
-	}
-)
-
 func libDb(*Context) ValueObject {
 	lib := NewObject()
 	queryResultClass := initQueryResultClass()
@@ -105,6 +80,25 @@ func libDb(*Context) ValueObject {
 		}
 		return NewObjectAndInit(dbClass, c, NewGoValue(db), NewGoValue(dialect))
 	}), nil)
+	lib.SetMember("copy", NewNativeFunction("copy", dbCopy, "src", "dst", "table", "options"), nil)
+	lib.SetMember("readCsv", NewNativeFunction("readCsv", dbReadCsv, "source", "options"), nil)
+	lib.SetMember("readJsonl", NewNativeFunction("readJsonl", dbReadJsonl, "source"), nil)
+	lib.SetMember("Model", dbModelClass, nil)
+	lib.SetMember("model", NewNativeFunction("model", func(c *Context, this Value, args []Value) Value {
+		return NewObjectAndInit(dbModelClass, c, args...)
+	}), nil)
+	lib.SetMember("Migrator", dbMigratorClass, nil)
+	lib.SetMember("migrator", NewNativeFunction("migrator", func(c *Context, this Value, args []Value) Value {
+		return NewObjectAndInit(dbMigratorClass, c, args...)
+	}), nil)
+	lib.SetMember("migrate", NewNativeFunction("migrate", func(c *Context, this Value, args []Value) Value {
+		migrator := NewObjectAndInit(dbMigratorClass, c, args...)
+		return c.InvokeMethod(migrator, "up", NoArgs)
+	}), nil)
+	lib.SetMember("__main__", NewNativeFunction("__main__", func(c *Context, this Value, args []Value) Value {
+		dbMigrateMain(c, lib)
+		return Undefined()
+	}), nil)
 	return lib
 }
 
@@ -151,26 +145,19 @@ func dbScanRowsMakeFields(c *Context, rows *sql.Rows, colTypes []*sql.ColumnType
 	for i, ct := range colTypes {
 		st := ct.ScanType()
 		if st == nil {
+			// 驱动未给出扫描类型(如sqlite)时按声明类型选择，统一用可为NULL的类型
 			dtn := strings.ToUpper(ct.DatabaseTypeName())
 			switch dtn {
 			case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "UNSIGNED BIG INT", "INT2", "INT8":
-				st = reflect.TypeOf((*int64)(nil)).Elem()
+				st = reflect.TypeOf((*sql.NullInt64)(nil)).Elem()
 			case "BOOL":
-				st = reflect.TypeOf((*bool)(nil)).Elem()
+				st = reflect.TypeOf((*sql.NullBool)(nil)).Elem()
 			case "DECIMAL", "REAL", "DOUBLE", "DOUBLE PRECISION", "FLOAT":
-				st = reflect.TypeOf((*float64)(nil)).Elem()
-			case "TEXT":
-				st = reflect.TypeOf((*string)(nil)).Elem()
+				st = reflect.TypeOf((*sql.NullFloat64)(nil)).Elem()
 			case "DATETIME":
-				st = reflect.TypeOf((*time.Time)(nil)).Elem()
+				st = reflect.TypeOf((*sql.NullTime)(nil)).Elem()
 			default:
-				if strings.HasPrefix(dtn, "VARCHAR(") ||
-					strings.HasPrefix(dtn, "NVARCHAR(") ||
-					strings.HasPrefix(dtn, "CHARACTER(") {
-					st = reflect.TypeOf((*string)(nil)).Elem()
-				} else {
-					st = reflect.TypeOf((*string)(nil)).Elem()
-				}
+				st = reflect.TypeOf((*sql.NullString)(nil)).Elem()
 			}
 		}
 		fields[i] = reflect.New(st).Interface()
@@ -539,6 +526,8 @@ func initQueryResultClass() ValueType {
 			}
 			return table
 		}).
+		Method("writeCsv", dbQueryResultWriteCsv, "target", "options").
+		Method("writeJsonl", dbQueryResultWriteJsonl, "target").
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
 			rows := this.GetMember("_rows", c).ToGoValue(c).(*sql.Rows)
 			if err := rows.Close(); err != nil {
@@ -558,13 +547,13 @@ func initDatabaseClass(queryResultClass ValueType) ValueType {
 			this.SetMember("_dialect", args[1], c)
 		}).
 		Method("m", func(c *Context, this ValueObject, args []Value) Value {
-			var table ValueStr
+			var table Value
 			requiredArgs := args
 			if len(args) > 1 {
 				requiredArgs = args[:1]
 			}
 			EnsureFuncParams(c, "Database.m", requiredArgs,
-				ArgRuleRequired("table", TypeStr, &table),
+				ArgRuleRequired("table", TypeAny, &table),
 			)
 			rv := NewObjectAndInit(dbActiveRecordClass, c, this, table)
 			if len(args) > 1 {
@@ -626,14 +615,20 @@ func initDatabaseClass(queryResultClass ValueType) ValueType {
 			} else {
 				rv.SetMember("affected", NewInt(affetcted), c)
 			}
-			if lastInsertID, err := res.LastInsertId(); err != nil {
-				c.RaiseRuntimeError("Database.execute get lastInsertID fail %s", err)
-				return nil
-			} else {
+			// postgres等驱动不支持LastInsertId，此时需用returning取得自增主键
+			if lastInsertID, err := res.LastInsertId(); err == nil {
 				rv.SetMember("lastInsertID", NewInt(lastInsertID), c)
+			} else {
+				rv.SetMember("lastInsertID", Nil(), c)
 			}
 			return rv
 		}).
+		Method("bulkInsert", dbDatabaseBulkInsert, "table", "rows", "options").
+		Method("describe", func(c *Context, this ValueObject, args []Value) Value {
+			var table ValueStr
+			EnsureFuncParams(c, "Database.describe", args, ArgRuleRequired("table", TypeStr, &table))
+			return dbDescribe(c, this, table.Value())
+		}).
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
 			db := this.GetMember("_db", c).ToGoValue(c).(*sql.DB)
 			if err := db.Close(); err != nil {
@@ -698,13 +693,13 @@ func initDatabaseSessionClass() ValueType {
 			this.SetMember("_dialect", args[1], c)
 		}).
 		Method("m", func(c *Context, this ValueObject, args []Value) Value {
-			var table ValueStr
+			var table Value
 			requiredArgs := args
 			if len(args) > 1 {
 				requiredArgs = args[:1]
 			}
 			EnsureFuncParams(c, "Session.m", requiredArgs,
-				ArgRuleRequired("table", TypeStr, &table),
+				ArgRuleRequired("table", TypeAny, &table),
 			)
 			rv := NewObjectAndInit(dbActiveRecordClass, c, this, table)
 			if len(args) > 1 {
@@ -755,14 +750,15 @@ func initDatabaseSessionClass() ValueType {
 			} else {
 				rv.SetMember("affected", NewInt(affetcted), c)
 			}
-			if lastInsertID, err := res.LastInsertId(); err != nil {
-				c.RaiseRuntimeError("Session.execute get lastInsertID fail %s", err)
-				return nil
-			} else {
+			// postgres等驱动不支持LastInsertId，此时需用returning取得自增主键
+			if lastInsertID, err := res.LastInsertId(); err == nil {
 				rv.SetMember("lastInsertID", NewInt(lastInsertID), c)
+			} else {
+				rv.SetMember("lastInsertID", Nil(), c)
 			}
 			return rv
 		}).
+		Method("bulkInsert", dbDatabaseBulkInsert, "table", "rows", "options").
 		Method("commit", func(c *Context, this ValueObject, args []Value) Value {
 			tx := this.GetMember("_tx", c).ToGoValue(c).(*sql.Tx)
 			if spId, ok := this.GetMember("__spId", c).(ValueStr); ok {
@@ -813,34 +809,85 @@ func initDatabaseSessionClass() ValueType {
 		Build()
 }
 
-func initDatabaseActiveRecordClass() ValueType {
-	_buildWhere := func(c *Context, builder *strings.Builder, filters ValueArray) {
-		if fn := filters.Len(); fn > 0 {
-			for i := 0; i < fn; i++ {
-				if i == 0 {
-					builder.WriteString(" WHERE (")
-				} else {
-					builder.WriteString(" AND (")
-				}
-				f := filters.GetIndex(i, c)
-				builder.WriteString(f.ToString(c))
-				builder.WriteString(")")
+// dbStrings 取出filters、orderBys等成员中的字符串
+func dbStrings(c *Context, arr ValueArray) []string {
+	rv := make([]string, arr.Len())
+	for i := range rv {
+		rv[i] = arr.GetIndex(i, c).ToString(c)
+	}
+	return rv
+}
+
+func dbRecordLimitOffset(c *Context, this ValueObject) (limit, offset int64) {
+	limit, offset = -1, -1
+	if v, ok := this.GetMember("_limit", c).(ValueInt); ok {
+		limit = v.Value()
+	}
+	if v, ok := this.GetMember("_offset", c).(ValueInt); ok {
+		offset = v.Value()
+	}
+	return
+}
+
+func dbRecordReturning(c *Context, this ValueObject) []string {
+	if arr, ok := this.GetMember("_returning", c).(ValueArray); ok {
+		return dbStrings(c, arr)
+	}
+	return nil
+}
+
+// dbRecordRows 取出要写入的记录及所有记录中出现过的字段
+func dbRecordRows(c *Context, args []Value) ([]ValueObject, []string) {
+	var rows []ValueObject
+	for _, arg := range args {
+		if arr, ok := arg.(ValueArray); ok {
+			for i := 0; i < arr.Len(); i++ {
+				rows = append(rows, c.MustObject(arr.GetIndex(i, c)))
 			}
+		} else {
+			rows = append(rows, c.MustObject(arg))
 		}
 	}
-	_buildOrderBy := func(c *Context, builder *strings.Builder, orderBys ValueArray) {
-		if fn := orderBys.Len(); fn > 0 {
-			for i := 0; i < fn; i++ {
-				if i == 0 {
-					builder.WriteString(" ORDER BY ")
-				} else {
-					builder.WriteString(", ")
-				}
-				f := orderBys.GetIndex(i, c)
-				builder.WriteString(f.ToString(c))
+	fieldMap := map[string]bool{}
+	fields := []string{}
+	for _, item := range rows {
+		item.Iterate(func(key string, value Value) {
+			if !fieldMap[key] {
+				fieldMap[key] = true
+				fields = append(fields, key)
 			}
+		})
+	}
+	return rows, fields
+}
+
+func dbRowsArgs(c *Context, rows []ValueObject, fields []string) []Value {
+	rv := make([]Value, 0, len(rows)*len(fields))
+	for _, row := range rows {
+		for _, field := range fields {
+			rv = append(rv, row.GetMember(field, c))
 		}
 	}
+	return rv
+}
+
+// dbRecordWrite 执行写入语句。设置了returning时以查询方式执行并返回写入后的记录
+func dbRecordWrite(c *Context, this ValueObject, query string, sqlArgs []Value, returning []string) Value {
+	if this.GetMember("_showSql", c).IsTrue() {
+		fmt.Fprintln(c.Stdout, query, NewArrayByValues(sqlArgs...).ToGoValue(c))
+	}
+	conn := c.MustObject(this.GetMember("conn", c))
+	callArgs := append([]Value{NewStr(query)}, sqlArgs...)
+	if len(returning) == 0 {
+		return c.InvokeMethod(conn, "execute", Args(callArgs...))
+	}
+	res := c.InvokeMethod(conn, "query", Args(callArgs...))
+	records := c.InvokeMethod(res, "all", NoArgs)
+	c.InvokeMethod(res, "close", NoArgs)
+	return records
+}
+
+func initDatabaseActiveRecordClass() ValueType {
 	var _addFiltersByKV func(c *Context, dialect dbDialect, key string, val Value, filters, sqlArgs ValueArray)
 	_addFiltersByKV = func(c *Context, dialect dbDialect, key string, val Value, filters, sqlArgs ValueArray) {
 		if paramNum := strings.Count(key, "?"); paramNum > 0 {
@@ -875,56 +922,22 @@ func initDatabaseActiveRecordClass() ValueType {
 			}
 		}
 	}
-	_buildOffsetLimit := func(c *Context, builder *strings.Builder, this ValueObject, sqlArgs ValueArray) {
-		switch offset := this.GetMember("_offset", c).(type) {
-		case ValueInt:
-			switch limit := this.GetMember("_limit", c).(type) {
-			case ValueInt:
-				builder.WriteString(" LIMIT ?, ?")
-				sqlArgs.PushBack(offset)
-				sqlArgs.PushBack(limit)
-			case ValueUndefined:
-				builder.WriteString(" LIMIT ?, 18446744073709551610")
-				sqlArgs.PushBack(offset)
-			}
-		case ValueUndefined:
-			switch limit := this.GetMember("_limit", c).(type) {
-			case ValueInt:
-				builder.WriteString(" LIMIT ?")
-				sqlArgs.PushBack(limit)
-			case ValueUndefined:
-			}
-		}
-
-	}
-	doQuery := func(c *Context, this ValueObject, args []Value) ValueObject {
+	// doQuery 执行查询，paged为false时忽略排序与分页(用于count)
+	doQuery := func(c *Context, this ValueObject, fields []string, paged bool) ValueObject {
 		conn := c.MustObject(this.GetMember("conn", c))
 		table := c.MustStr(this.GetMember("table", c))
 		dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
 		filters := c.MustArray(this.GetMember("filters", c))
 		sqlArgs := c.MustArray(this.GetMember("sqlArgs", c))
-		var sqlBuilder strings.Builder
-		sqlBuilder.WriteString("SELECT")
-		if len(args) > 0 {
-			for i, arg := range args {
-				field := arg.ToString(c)
-				if !strings.ContainsAny(field, "`( ") {
-					field = dialect.Quote(field)
-				}
-				if i > 0 {
-					sqlBuilder.WriteString(fmt.Sprintf(", %s", field))
-				} else {
-					sqlBuilder.WriteString(fmt.Sprintf(" %s", field))
-				}
-			}
-		} else {
-			sqlBuilder.WriteString(" *")
+		var (
+			orderBys      []string
+			limit, offset int64 = -1, -1
+		)
+		if paged {
+			orderBys = dbStrings(c, c.MustArray(this.GetMember("orderBys", c)))
+			limit, offset = dbRecordLimitOffset(c, this)
 		}
-		sqlBuilder.WriteString(fmt.Sprintf(" FROM %s", dialect.Quote(table)))
-		_buildWhere(c, &sqlBuilder, filters)
-		_buildOrderBy(c, &sqlBuilder, c.MustArray(this.GetMember("orderBys", c)))
-		_buildOffsetLimit(c, &sqlBuilder, this, sqlArgs)
-		sql := sqlBuilder.String()
+		sql := dbSelectSQL(dialect, table, fields, dbStrings(c, filters), orderBys, limit, offset)
 		if this.GetMember("_showSql", c).IsTrue() {
 			fmt.Fprintln(c.Stdout, sql, sqlArgs.ToGoValue(c))
 		}
@@ -938,16 +951,34 @@ func initDatabaseActiveRecordClass() ValueType {
 		})
 		return c.MustObject(c.RetVal)
 	}
+	fieldsOf := func(c *Context, args []Value) []string {
+		fields := make([]string, len(args))
+		for i, arg := range args {
+			fields[i] = arg.ToString(c)
+		}
+		return fields
+	}
 	return NewClassBuilder("ActiveRecord").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
 			var (
 				conn  ValueObject
-				table ValueStr
+				table Value
 			)
 			EnsureFuncParams(c, "ActiveRecord.__init__", args,
 				ArgRuleRequired("conn", TypeObject, &conn),
-				ArgRuleRequired("table", TypeStr, &table),
+				ArgRuleRequired("table", TypeAny, &table),
 			)
+			// table为db.Model时查询结果为模型实例
+			if obj, ok := table.(ValueObject); ok {
+				model, isModel := obj.Reserved.(*dbModel)
+				if !isModel {
+					c.RaiseRuntimeError("ActiveRecord.__init__: table must be a str or db.Model")
+				}
+				this.SetMember("_model", NewGoValue(model), c)
+				table = NewStr(model.table)
+			} else if _, ok := table.(ValueStr); !ok {
+				c.RaiseRuntimeError("ActiveRecord.__init__: table must be a str or db.Model")
+			}
 			this.SetMember("conn", conn, c)
 			this.SetMember("_dialect", conn.GetMember("_dialect", c), c)
 			this.SetMember("table", table, c)
@@ -986,11 +1017,7 @@ func initDatabaseActiveRecordClass() ValueType {
 			orderBys := c.MustArray(this.GetMember("orderBys", c))
 			dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
 			for _, arg := range args {
-				field := arg.ToString(c)
-				if !strings.ContainsAny(field, "`( ") {
-					field = dialect.Quote(field)
-				}
-				orderBys.PushBack(NewStr("%s ASC", field))
+				orderBys.PushBack(NewStr("%s ASC", dbQuoteField(dialect, arg.ToString(c))))
 			}
 			return this
 		}).
@@ -998,11 +1025,7 @@ func initDatabaseActiveRecordClass() ValueType {
 			orderBys := c.MustArray(this.GetMember("orderBys", c))
 			dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
 			for _, arg := range args {
-				field := arg.ToString(c)
-				if !strings.ContainsAny(field, "`( ") {
-					field = dialect.Quote(field)
-				}
-				orderBys.PushBack(NewStr("%s DESC", field))
+				orderBys.PushBack(NewStr("%s DESC", dbQuoteField(dialect, arg.ToString(c))))
 			}
 			return this
 		}).
@@ -1018,16 +1041,38 @@ func initDatabaseActiveRecordClass() ValueType {
 			this.SetMember("_offset", v, c)
 			return this
 		}).
+		Method("returning", func(c *Context, this ValueObject, args []Value) Value {
+			fields := NewArray(len(args))
+			for _, arg := range args {
+				fields.PushBack(NewStr(arg.ToString(c)))
+			}
+			this.SetMember("_returning", fields, c)
+			return this
+		}).
+		Method("include", func(c *Context, this ValueObject, args []Value) Value {
+			if IsUndefined(this.GetMember("_model", c)) {
+				c.RaiseRuntimeError("ActiveRecord.include: requires a query on db.Model")
+			}
+			includes, ok := this.GetMember("_include", c).(ValueArray)
+			if !ok {
+				includes = NewArray(len(args))
+				this.SetMember("_include", includes, c)
+			}
+			for _, arg := range args {
+				includes.PushBack(NewStr(arg.ToString(c)))
+			}
+			return this
+		}).
 		Method("find", func(c *Context, this ValueObject, args []Value) Value {
-			res := doQuery(c, this, args)
+			res := doQuery(c, this, fieldsOf(c, args), true)
 			records := c.InvokeMethod(res, "all", Args(args...))
 			c.InvokeMethod(res, "close", NoArgs)
-			return records
+			return dbModelWrap(c, this, records)
 		}).
 		Method("findOne", func(c *Context, this ValueObject, args []Value) Value {
 			this.SetMember("_limit", NewInt(1), c)
-			res := doQuery(c, this, args)
-			records := c.InvokeMethod(res, "all", Args(args...))
+			res := doQuery(c, this, fieldsOf(c, args), true)
+			records := dbModelWrap(c, this, c.InvokeMethod(res, "all", Args(args...)))
 			c.InvokeMethod(res, "close", NoArgs)
 			if arr, ok := records.(ValueArray); ok && arr.Len() > 0 {
 				return arr.GetIndex(0, c)
@@ -1045,17 +1090,28 @@ func initDatabaseActiveRecordClass() ValueType {
 			EnsureFuncParams(c, "ActiveRecord.count", args,
 				ArgRuleOptional("countField", TypeStr, &countField, NewStr("(1)")),
 			)
-			args = []Value{countField}
-			res := doQuery(c, this, args)
-			records := c.InvokeMethod(res, "allArray", Args(args...))
+			field := countField.Value()
+			if !strings.HasPrefix(field, "(") {
+				dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
+				field = "(" + dbQuoteField(dialect, field) + ")"
+			}
+			res := doQuery(c, this, []string{"COUNT" + field}, false)
+			records := c.InvokeMethod(res, "allArray", NoArgs)
 			c.InvokeMethod(res, "close", NoArgs)
 			if arr, ok := records.(ValueArray); ok && arr.Len() > 0 {
-				return arr.GetIndex(0, c).(ValueArray).GetIndex(0, c)
+				count := arr.GetIndex(0, c).(ValueArray).GetIndex(0, c)
+				// sqlite等驱动不提供聚合列的类型，结果会被当作字符串读出
+				if s, isStr := count.(ValueStr); isStr {
+					if n, err := strconv.ParseInt(s.Value(), 10, 64); err == nil {
+						return NewInt(n)
+					}
+				}
+				return count
 			}
 			return NewInt(0)
 		}).
 		Method("toTable", func(c *Context, this ValueObject, args []Value) Value {
-			res := doQuery(c, this, args)
+			res := doQuery(c, this, fieldsOf(c, args), true)
 			records := c.InvokeMethod(res, "toTable", Args(args...))
 			c.InvokeMethod(res, "close", NoArgs)
 			return records
@@ -1063,39 +1119,34 @@ func initDatabaseActiveRecordClass() ValueType {
 		Method("update", func(c *Context, this ValueObject, args []Value) Value {
 			var updates ValueObject
 			EnsureFuncParams(c, "ActiveRecord.update", args, ArgRuleRequired("updates", TypeObject, &updates))
-			conn := c.MustObject(this.GetMember("conn", c))
 			dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
 			table := c.MustStr(this.GetMember("table", c))
 			filters := c.MustArray(this.GetMember("filters", c))
 			sqlArgs := c.MustArray(this.GetMember("sqlArgs", c))
-			realArgs := make([]Value, 1, 10+sqlArgs.Len())
-			var sqlBuilder strings.Builder
-			sqlBuilder.WriteString(fmt.Sprintf("UPDATE %s", dialect.Quote(table)))
-			updateAdded := false
+			var (
+				sets     []string
+				realArgs []Value
+			)
 			updates.Iterate(func(k string, v Value) {
-				if !updateAdded {
-					sqlBuilder.WriteString(fmt.Sprintf(" SET %s = ?", dialect.Quote(k)))
-					updateAdded = true
-				} else {
-					sqlBuilder.WriteString(fmt.Sprintf(", %s = ?", dialect.Quote(k)))
-				}
+				sets = append(sets, k)
 				realArgs = append(realArgs, v)
 			})
-			if !updateAdded {
+			if len(sets) == 0 {
 				return NewInt(0)
 			}
-			_buildWhere(c, &sqlBuilder, filters)
-			_buildOrderBy(c, &sqlBuilder, c.MustArray(this.GetMember("orderBys", c)))
-			_buildOffsetLimit(c, &sqlBuilder, this, sqlArgs)
-			sql := sqlBuilder.String()
-			realArgs[0] = NewStr(sql)
+			limit, offset := dbRecordLimitOffset(c, this)
+			returning := dbRecordReturning(c, this)
+			sql, err := dbUpdateSQL(dialect, table, sets, dbStrings(c, filters),
+				dbStrings(c, c.MustArray(this.GetMember("orderBys", c))), limit, offset, returning)
+			if err != nil {
+				c.RaiseRuntimeError("ActiveRecord.update: %s", err)
+			}
 			realArgs = append(realArgs, *sqlArgs.Values...)
-			if this.GetMember("_showSql", c).IsTrue() {
-				fmt.Fprintln(c.Stdout, sql, NewArrayByValues(realArgs[1:]...).ToGoValue(c))
+			res := dbRecordWrite(c, this, sql, realArgs, returning)
+			if len(returning) > 0 {
+				return res
 			}
-			c.InvokeMethod(conn, "execute", Args(realArgs...))
-			res := c.MustObject(c.RetVal)
-			return res.GetMember("affected", c)
+			return c.MustObject(res).GetMember("affected", c)
 		}).
 		Method("add", func(c *Context, this ValueObject, args []Value) Value {
 			if len(args) < 1 {
@@ -1103,53 +1154,60 @@ func initDatabaseActiveRecordClass() ValueType {
 			}
 			table := c.MustStr(this.GetMember("table", c))
 			dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
-			var sqlBuilder strings.Builder
-			sqlBuilder.WriteString("INSERT INTO ")
-			sqlBuilder.WriteString(dialect.Quote(table))
-			sqlBuilder.WriteString(" (")
-			fieldMap := map[string]bool{}
-			fields := []string{}
-			for _, arg := range args {
-				item := c.MustObject(arg)
-				item.Iterate(func(key string, value Value) {
-					if _, added := fieldMap[key]; !added {
-						fieldMap[key] = true
-						if len(fields) > 0 {
-							sqlBuilder.WriteString(", ")
-						}
-						sqlBuilder.WriteString(dialect.Quote(key))
-						fields = append(fields, key)
-					}
-				})
+			rows, fields := dbRecordRows(c, args)
+			returning := dbRecordReturning(c, this)
+			sql, err := dbInsertSQL(dialect, table, fields, len(rows), returning)
+			if err != nil {
+				c.RaiseRuntimeError("ActiveRecord.add: %s", err)
 			}
-			execArgs := make([]Value, 1+len(args)*len(fields))
-			sqlBuilder.WriteString(")")
-			p := 1
-			for i, arg := range args {
-				if i == 0 {
-					sqlBuilder.WriteString(" VALUES (")
-				} else {
-					sqlBuilder.WriteString("), (")
+			return dbRecordWrite(c, this, sql, dbRowsArgs(c, rows, fields), returning)
+		}).
+		Method("upsert", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				records      Value
+				keys         Value
+				updateFields Value
+			)
+			EnsureFuncParams(c, "ActiveRecord.upsert", args,
+				ArgRuleRequired("records", TypeAny, &records),
+				ArgRuleRequired("keys", TypeAny, &keys),
+				ArgRuleOptional("updateFields", TypeAny, &updateFields, Nil()),
+			)
+			table := c.MustStr(this.GetMember("table", c))
+			dialect := this.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
+			rows, fields := dbRecordRows(c, []Value{records})
+			if len(rows) == 0 {
+				return NewInt(0)
+			}
+			toStrings := func(v Value) []string {
+				if arr, ok := v.(ValueArray); ok {
+					return dbStrings(c, arr)
 				}
-				for j, field := range fields {
-					if j == 0 {
-						sqlBuilder.WriteString("?")
-					} else {
-						sqlBuilder.WriteString(", ?")
+				return []string{v.ToString(c)}
+			}
+			keyFields := toStrings(keys)
+			// 未指定时更新除冲突字段外的所有字段
+			var updates []string
+			if _, isNil := updateFields.(ValueNil); isNil {
+				isKey := map[string]bool{}
+				for _, k := range keyFields {
+					isKey[k] = true
+				}
+				for _, f := range fields {
+					if !isKey[f] {
+						updates = append(updates, f)
 					}
-					execArgs[p] = arg.GetMember(field, c)
-					p++
 				}
+			} else {
+				updates = toStrings(updateFields)
 			}
-			sqlBuilder.WriteString(")")
-			sql := sqlBuilder.String()
-			execArgs[0] = NewStr(sql)
-			if this.GetMember("_showSql", c).IsTrue() {
-				fmt.Fprintln(c.Stdout, sql, NewArrayByValues(execArgs[1:]...).ToGoValue(c))
+			returning := dbRecordReturning(c, this)
+			sql, err := dbUpsertSQL(dialect, table, fields, len(rows), keyFields, updates, returning)
+			if err != nil {
+				c.RaiseRuntimeError("ActiveRecord.upsert: %s", err)
 			}
-			conn := c.MustObject(this.GetMember("conn", c))
-			return c.InvokeMethod(conn, "execute", Args(execArgs...))
-		}).
+			return dbRecordWrite(c, this, sql, dbRowsArgs(c, rows, fields), returning)
+		}, "records", "keys", "updateFields").
 		Build()
 }
 
@@ -1256,4 +1314,7 @@ func libDbOp(*Context) ValueObject {
 func init() {
 	dbSessionClass = initDatabaseSessionClass()
 	dbActiveRecordClass = initDatabaseActiveRecordClass()
+	dbMigratorClass = initDbMigratorClass()
+	dbModelClass = initDbModelClass()
+	dbRecordClass = initDbRecordClass()
 }
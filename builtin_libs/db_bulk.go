@@ -0,0 +1,527 @@
+package builtin_libs
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type dbBulkOptions struct {
+	batchSize  int
+	onConflict string
+	keys       []string
+	// updates 为nil时冲突后更新除keys外的所有字段
+	updates    []string
+	columns    []string
+	atomic     bool
+	onProgress ValueCallable
+}
+
+type dbFuncWriter struct {
+	c *Context
+	f ValueCallable
+}
+
+func (w *dbFuncWriter) Write(p []byte) (int, error) {
+	w.c.Invoke(w.f, nil, Args(NewBytes(append([]byte(nil), p...))))
+	return len(p), nil
+}
+
+// dbIterate 依次取出数组或可迭代对象(实现了__iter__)中的元素，fn返回false时停止
+func dbIterate(c *Context, funcName string, iterable Value, fn func(Value) bool) {
+	if getIter, ok := c.GetCallable(iterable.GetMember("__iter__", c)); ok {
+		c.Invoke(getIter, nil, NoArgs)
+		iterVal := c.RetVal
+		iter, ok := c.GetCallable(iterVal)
+		if !ok {
+			c.RaiseRuntimeError("%s: __iter__ should return a callable value", funcName)
+		}
+		if closer, ok := c.GetCallable(iterVal.GetMember("close", c)); ok {
+			defer c.Invoke(closer, nil, NoArgs)
+		}
+		for {
+			c.Invoke(iter, nil, NoArgs)
+			ret, ok := c.RetVal.(ValueArray)
+			if !ok || ret.Len() != 2 || !ret.GetIndex(1, c).IsTrue() {
+				return
+			}
+			if !fn(ret.GetIndex(0, c)) {
+				return
+			}
+		}
+	}
+	arr, ok := iterable.(ValueArray)
+	if !ok {
+		c.RaiseRuntimeError("%s: rows must be an array or iterable", funcName)
+	}
+	for i := 0; i < arr.Len(); i++ {
+		if !fn(arr.GetIndex(i, c)) {
+			return
+		}
+	}
+}
+
+func dbParseBulkOptions(c *Context, funcName string, options ValueObject) *dbBulkOptions {
+	opts := &dbBulkOptions{batchSize: 1000, onConflict: "error", atomic: true}
+	strs := func(name string) []string {
+		switch v := options.GetMember(name, c).(type) {
+		case ValueArray:
+			return dbStrings(c, v)
+		case ValueStr:
+			return []string{v.Value()}
+		}
+		return nil
+	}
+	if v, ok := options.GetMember("batchSize", c).(ValueInt); ok {
+		if opts.batchSize = v.AsInt(); opts.batchSize <= 0 {
+			c.RaiseRuntimeError("%s: batchSize must be positive", funcName)
+		}
+	}
+	if v, ok := options.GetMember("onConflict", c).(ValueStr); ok {
+		opts.onConflict = v.Value()
+	}
+	switch opts.onConflict {
+	case "error", "ignore", "update":
+	default:
+		c.RaiseRuntimeError("%s: onConflict must be error, ignore or update", funcName)
+	}
+	opts.keys = strs("keys")
+	opts.updates = strs("updateFields")
+	opts.columns = strs("columns")
+	if v := options.GetMember("atomic", c); !IsUndefined(v) {
+		opts.atomic = v.IsTrue()
+	}
+	if v := options.GetMember("onProgress", c); !IsUndefined(v) {
+		opts.onProgress = c.MustCallable(v, "onProgress")
+	}
+	return opts
+}
+
+func (opts *dbBulkOptions) sql(d dbDialect, table string, fields []string, rows int) (string, error) {
+	if opts.onConflict == "error" {
+		return dbInsertSQL(d, table, fields, rows, nil)
+	}
+	var updates []string
+	if opts.onConflict == "update" {
+		if updates = opts.updates; updates == nil {
+			isKey := map[string]bool{}
+			for _, k := range opts.keys {
+				isKey[k] = true
+			}
+			for _, f := range fields {
+				if !isKey[f] {
+					updates = append(updates, f)
+				}
+			}
+		}
+	}
+	return dbUpsertSQL(d, table, fields, rows, opts.keys, updates, nil)
+}
+
+// dbBulkInsert 按batchSize分批用多行INSERT写入rows。conn为Database时在事务中写入，
+// atomic为true时全部写完才提交，否则每批提交一次；conn为Session时直接使用其事务
+func dbBulkInsert(c *Context, funcName string, conn ValueObject, table string, rows Value, opts *dbBulkOptions) ValueObject {
+	d := conn.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
+	var (
+		target  = conn
+		session ValueObject
+		success bool
+	)
+	_, isDb := conn.GetMember("_db", c).ToGoValue(c).(*sql.DB)
+	begin := func() {
+		session = NewObjectAndInit(dbSessionClass, c, conn.GetMember("_db", c), conn.GetMember("_dialect", c))
+		target = session
+	}
+	if isDb {
+		begin()
+		defer func() {
+			if success {
+				c.InvokeMethod(session, "commit", NoArgs)
+			} else {
+				c.InvokeMethod(session, "rollback", NoArgs)
+			}
+		}()
+	}
+	var (
+		batch                    []ValueObject
+		total, affected, batches int64
+		sqlCache                 = map[string]string{}
+	)
+	exec := func(rows []ValueObject, fields []string) {
+		key := strings.Join(fields, ",") + "/" + strconv.Itoa(len(rows))
+		query, found := sqlCache[key]
+		if !found {
+			var err error
+			if query, err = opts.sql(d, table, fields, len(rows)); err != nil {
+				c.RaiseRuntimeError("%s: %s", funcName, err)
+			}
+			sqlCache[key] = query
+		}
+		args := append([]Value{NewStr(query)}, dbRowsArgs(c, rows, fields)...)
+		res := c.MustObject(c.InvokeMethod(target, "execute", Args(args...)))
+		if n, ok := res.GetMember("affected", c).(ValueInt); ok {
+			affected += n.Value()
+		}
+	}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		fieldSet := map[string]bool{}
+		var fields []string
+		for _, row := range batch {
+			row.Iterate(func(k string, _ Value) {
+				if !fieldSet[k] {
+					fieldSet[k] = true
+					fields = append(fields, k)
+				}
+			})
+		}
+		if len(fields) == 0 {
+			c.RaiseRuntimeError("%s: rows have no fields", funcName)
+		}
+		// 按方言的参数上限拆成多条语句
+		perStmt := d.MaxParams() / len(fields)
+		if perStmt < 1 {
+			c.RaiseRuntimeError("%s: too many fields (%d)", funcName, len(fields))
+		}
+		for start := 0; start < len(batch); start += perStmt {
+			end := start + perStmt
+			if end > len(batch) {
+				end = len(batch)
+			}
+			exec(batch[start:end], fields)
+		}
+		total += int64(len(batch))
+		batches++
+		batch = batch[:0]
+		if isDb && !opts.atomic {
+			c.InvokeMethod(session, "commit", NoArgs)
+			begin()
+		}
+		if opts.onProgress != nil {
+			c.Invoke(opts.onProgress, nil, Args(NewInt(total)))
+		}
+	}
+	dbIterate(c, funcName, rows, func(v Value) bool {
+		switch row := v.(type) {
+		case ValueObject:
+			batch = append(batch, row)
+		case ValueArray:
+			if len(opts.columns) == 0 {
+				c.RaiseRuntimeError("%s: array rows require the columns option", funcName)
+			}
+			obj := NewObject()
+			for i, col := range opts.columns {
+				if i < row.Len() {
+					obj.SetMember(col, row.GetIndex(i, c), c)
+				}
+			}
+			batch = append(batch, obj)
+		default:
+			c.RaiseRuntimeError("%s: row must be an object or array, got %s", funcName, v.Type().GetName())
+		}
+		if len(batch) >= opts.batchSize {
+			flush()
+		}
+		return true
+	})
+	flush()
+	success = true
+	rv := NewObject()
+	rv.SetMember("rows", NewInt(total), c)
+	rv.SetMember("affected", NewInt(affected), c)
+	rv.SetMember("batches", NewInt(batches), c)
+	return rv
+}
+
+// dbOpenWriter 打开导出目标：文件路径、io.Writer或接收bytes的函数
+func dbOpenWriter(c *Context, funcName string, target Value) (*bufio.Writer, func()) {
+	var (
+		w      io.Writer
+		closer func() error
+	)
+	switch t := target.(type) {
+	case ValueStr:
+		f, err := os.Create(t.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: create %s error %s", funcName, t.Value(), err)
+		}
+		w, closer = f, f.Close
+	case GoValue:
+		gw, ok := t.ToGoValue(c).(io.Writer)
+		if !ok {
+			c.RaiseRuntimeError("%s: target is not an io.Writer", funcName)
+		}
+		w = gw
+	default:
+		fn, ok := c.GetCallable(target)
+		if !ok {
+			c.RaiseRuntimeError("%s: target must be a path, io.Writer or function", funcName)
+		}
+		w = &dbFuncWriter{c: c, f: fn}
+	}
+	bw := bufio.NewWriterSize(w, 64*1024)
+	return bw, func() {
+		err := bw.Flush()
+		if closer != nil {
+			if cerr := closer(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			c.RaiseRuntimeError("%s: write error %s", funcName, err)
+		}
+	}
+}
+
+// dbOpenReader 打开导入来源：文件路径或io.Reader
+func dbOpenReader(c *Context, funcName string, source Value) (io.Reader, func()) {
+	switch s := source.(type) {
+	case ValueStr:
+		f, err := os.Open(s.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: open %s error %s", funcName, s.Value(), err)
+		}
+		return f, func() { f.Close() }
+	case GoValue:
+		if r, ok := s.ToGoValue(c).(io.Reader); ok {
+			return r, func() {}
+		}
+	}
+	c.RaiseRuntimeError("%s: source must be a path or io.Reader", funcName)
+	return nil, nil
+}
+
+// dbEachResultRow 逐行读取QueryResult，不把结果全部放入内存
+func dbEachResultRow(c *Context, funcName string, this ValueObject, asObject bool, fn func(cols []string, row Value)) {
+	cts := this.GetMember("_colTypes", c).ToGoValue(c).([]*sql.ColumnType)
+	rows := this.GetMember("_rows", c).ToGoValue(c).(*sql.Rows)
+	cols, err := rows.Columns()
+	if err != nil {
+		c.RaiseRuntimeError("%s: get columns error %s", funcName, err)
+	}
+	for rows.Next() {
+		if asObject {
+			fn(cols, dbScanRowsToObject(c, rows, cts, cols))
+		} else {
+			fn(cols, dbScanRowsToArray(c, rows, cts, cols))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.RaiseRuntimeError("%s: read rows error %s", funcName, err)
+	}
+}
+
+func dbCsvCell(c *Context, v Value, nullValue string) string {
+	switch tv := v.(type) {
+	case ValueNil, ValueUndefined:
+		return nullValue
+	case ValueStr:
+		return tv.Value()
+	case ValueBytes:
+		return string(tv.ToGoValue(c).([]byte))
+	}
+	return v.ToString(c)
+}
+
+func dbCsvOptions(c *Context, options ValueObject) (delimiter rune, header bool, nullValue string) {
+	delimiter, header = ',', true
+	if v, ok := options.GetMember("delimiter", c).(ValueStr); ok && v.Len() > 0 {
+		delimiter = []rune(v.Value())[0]
+	}
+	if v := options.GetMember("header", c); !IsUndefined(v) {
+		header = v.IsTrue()
+	}
+	if v, ok := options.GetMember("nullValue", c).(ValueStr); ok {
+		nullValue = v.Value()
+	}
+	return
+}
+
+func dbQueryResultWriteCsv(c *Context, this ValueObject, args []Value) Value {
+	var (
+		target  Value
+		options ValueObject
+	)
+	EnsureFuncParams(c, "QueryResult.writeCsv", args,
+		ArgRuleRequired("target", TypeAny, &target),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	delimiter, header, nullValue := dbCsvOptions(c, options)
+	bw, closeFn := dbOpenWriter(c, "QueryResult.writeCsv", target)
+	defer closeFn()
+	w := csv.NewWriter(bw)
+	w.Comma = delimiter
+	n := 0
+	if header {
+		cols, err := this.GetMember("_rows", c).ToGoValue(c).(*sql.Rows).Columns()
+		if err != nil {
+			c.RaiseRuntimeError("QueryResult.writeCsv: get columns error %s", err)
+		}
+		w.Write(cols)
+	}
+	var record []string
+	dbEachResultRow(c, "QueryResult.writeCsv", this, false, func(cols []string, row Value) {
+		arr := row.(ValueArray)
+		record = record[:0]
+		for i := 0; i < arr.Len(); i++ {
+			record = append(record, dbCsvCell(c, arr.GetIndex(i, c), nullValue))
+		}
+		if err := w.Write(record); err != nil {
+			c.RaiseRuntimeError("QueryResult.writeCsv: write error %s", err)
+		}
+		n++
+	})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		c.RaiseRuntimeError("QueryResult.writeCsv: write error %s", err)
+	}
+	return NewInt(int64(n))
+}
+
+func dbQueryResultWriteJsonl(c *Context, this ValueObject, args []Value) Value {
+	var target Value
+	EnsureFuncParams(c, "QueryResult.writeJsonl", args, ArgRuleRequired("target", TypeAny, &target))
+	bw, closeFn := dbOpenWriter(c, "QueryResult.writeJsonl", target)
+	defer closeFn()
+	n := 0
+	dbEachResultRow(c, "QueryResult.writeJsonl", this, true, func(cols []string, row Value) {
+		bs, err := jsonMarshal(row.ToGoValue(c))
+		if err != nil {
+			c.RaiseRuntimeError("QueryResult.writeJsonl: encode row error %s", err)
+		}
+		// jsonMarshal输出的内容已经以换行结尾
+		if _, err := bw.Write(bs); err != nil {
+			c.RaiseRuntimeError("QueryResult.writeJsonl: write error %s", err)
+		}
+		n++
+	})
+	return NewInt(int64(n))
+}
+
+// dbReadCsv 返回逐行读取CSV的可迭代对象。有表头时每行为以表头为键的对象，否则为数组
+func dbReadCsv(c *Context, this Value, args []Value) Value {
+	var (
+		source  Value
+		options ValueObject
+	)
+	EnsureFuncParams(c, "db.readCsv", args,
+		ArgRuleRequired("source", TypeAny, &source),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	delimiter, header, nullValue := dbCsvOptions(c, options)
+	_, hasNull := options.GetMember("nullValue", c).(ValueStr)
+	reader, closeFn := dbOpenReader(c, "db.readCsv", source)
+	r := csv.NewReader(bufio.NewReaderSize(reader, 64*1024))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	var cols []string
+	if header {
+		record, err := r.Read()
+		if err != nil && err != io.EOF {
+			closeFn()
+			c.RaiseRuntimeError("db.readCsv: read header error %s", err)
+		}
+		cols = append(cols, record...)
+	}
+	cell := func(s string) Value {
+		if hasNull && s == nullValue {
+			return Nil()
+		}
+		return NewStr(s)
+	}
+	return MakeIterator(c, func() Value {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			c.RaiseRuntimeError("db.readCsv: read error %s", err)
+		}
+		if !header {
+			rv := NewArray(len(record))
+			for _, s := range record {
+				rv.PushBack(cell(s))
+			}
+			return rv
+		}
+		rv := NewObject()
+		for i, col := range cols {
+			if i < len(record) {
+				rv.SetMember(col, cell(record[i]), c)
+			} else {
+				rv.SetMember(col, Nil(), c)
+			}
+		}
+		return rv
+	}, closeFn)
+}
+
+// dbReadJsonl 返回逐行解析JSON Lines的可迭代对象，空行会被跳过
+func dbReadJsonl(c *Context, this Value, args []Value) Value {
+	var source Value
+	EnsureFuncParams(c, "db.readJsonl", args, ArgRuleRequired("source", TypeAny, &source))
+	reader, closeFn := dbOpenReader(c, "db.readJsonl", source)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return MakeIterator(c, func() Value {
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(strings.TrimSpace(string(line))) == 0 {
+				continue
+			}
+			var j interface{}
+			if err := json.Unmarshal(line, &j); err != nil {
+				c.RaiseRuntimeError("db.readJsonl: decode error %s", err)
+			}
+			return jsonToValue(j, c)
+		}
+		if err := scanner.Err(); err != nil {
+			c.RaiseRuntimeError("db.readJsonl: read error %s", err)
+		}
+		return nil
+	}, closeFn)
+}
+
+func dbDatabaseBulkInsert(c *Context, this ValueObject, args []Value) Value {
+	var (
+		table   ValueStr
+		rows    Value
+		options ValueObject
+	)
+	EnsureFuncParams(c, "Database.bulkInsert", args,
+		ArgRuleRequired("table", TypeStr, &table),
+		ArgRuleRequired("rows", TypeAny, &rows),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	opts := dbParseBulkOptions(c, "Database.bulkInsert", options)
+	return dbBulkInsert(c, "Database.bulkInsert", this, table.Value(), rows, opts)
+}
+
+// dbCopy 把src(通常是另一个库的QueryResult)逐行写入dst的table中，结束后关闭src
+func dbCopy(c *Context, this Value, args []Value) Value {
+	var (
+		src, dst ValueObject
+		table    ValueStr
+		options  ValueObject
+	)
+	EnsureFuncParams(c, "db.copy", args,
+		ArgRuleRequired("src", TypeObject, &src),
+		ArgRuleRequired("dst", TypeObject, &dst),
+		ArgRuleRequired("table", TypeStr, &table),
+		ArgRuleOptional("options", TypeObject, &options, NewObject()),
+	)
+	if closer, ok := c.GetCallable(src.GetMember("close", c)); ok {
+		defer c.Invoke(closer, nil, NoArgs)
+	}
+	opts := dbParseBulkOptions(c, "db.copy", options)
+	return dbBulkInsert(c, "db.copy", dst, table.Value(), src, opts)
+}
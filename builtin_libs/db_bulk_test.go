@@ -0,0 +1,123 @@
+package builtin_libs_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testDbBulkSetup = `
+db := import('db')
+src := db.open('sqlite', ':memory:')
+src.execute('CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score INT)')
+seed := () => src.execute('INSERT INTO t VALUES (1, ?, 10), (2, ?, 20), (3, NULL, 30)', 'a', 'b,c')
+`
+
+func TestDbBulkLoadAndExport(t *testing.T) {
+	dir := filepath.ToSlash(t.TempDir())
+	if err := os.WriteFile(dir+"/in.csv", []byte("id,name,score\n1,a,10\n2,\"b,c\",20\n3,,30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+		// 非空时检查导出的文件内容
+		file    string
+		content string
+	}{
+		{"ReadCsvInBatches", `
+			progress := []
+			rows := src.bulkInsert('t', db.readCsv('{dir}/in.csv', {nullValue: ''}), {batchSize: 2, onProgress: n => progress.push(n)}).rows
+			export result := [rows, progress, src.m('t').asc('id').find('name').map(r => r.name)]
+		`, []interface{}{int64(3), []interface{}{int64(2), int64(3)}, []interface{}{"a", "b,c", nil}}, "", ""},
+		{"IgnoreConflict", `
+			seed()
+			export result := src.bulkInsert('t', [[3, 'x', 0], [4, 'd', 40]], {columns: ['id', 'name', 'score'], onConflict: 'ignore', keys: 'id'}).affected
+		`, int64(1), "", ""},
+		{"UpdateConflict", `
+			seed()
+			affected := src.bulkInsert('t', [{id: 1, name: 'A', score: 11}], {onConflict: 'update', keys: ['id']}).affected
+			export result := [affected, src.m('t', {id: 1}).findOne().name]
+		`, []interface{}{int64(1), "A"}, "", ""},
+		{"RollbackOnError", `
+			seed()
+			failed := false
+			try {
+				src.bulkInsert('t', [{id: 5, name: 'e'}, {id: 1, name: 'dup'}])
+			} catch (e) {
+				failed = true
+			}
+			export result := [failed, src.m('t').count()]
+		`, []interface{}{true, int64(3)}, "", ""},
+		{"Copy", `
+			seed()
+			dst := db.open('sqlite', ':memory:')
+			dst.execute('CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, score INT)')
+			rows := db.copy(src.query('SELECT * FROM t ORDER BY id'), dst, 't').rows
+			export result := [rows, dst.m('t').asc('id').find('name').map(r => r.name)]
+		`, []interface{}{int64(3), []interface{}{"a", "b,c", nil}}, "", ""},
+		{"WriteCsv", `
+			seed()
+			export result := src.query('SELECT * FROM t ORDER BY id').writeCsv('{dir}/out.csv')
+		`, int64(3), "out.csv", "id,name,score\n1,a,10\n2,\"b,c\",20\n3,,30\n"},
+		{"WriteJsonlKeepsNull", `
+			seed()
+			n := src.query('SELECT * FROM t ORDER BY id').writeJsonl('{dir}/out.jsonl')
+			nulls := []
+			for r in db.readJsonl('{dir}/out.jsonl') {
+				if r.name == nil {
+					nulls.push(r.id)
+				}
+			}
+			export result := [n, nulls]
+		`, []interface{}{int64(3), []interface{}{int64(3)}}, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(strings.ReplaceAll(testDbBulkSetup+tc.code, "{dir}", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+			if tc.file != "" {
+				out, _ := os.ReadFile(dir + "/" + tc.file)
+				if string(out) != tc.content {
+					t.Fatalf("expected %s %q, got %q", tc.file, tc.content, out)
+				}
+			}
+		})
+	}
+}
+
+const testDbWriteJsonlErrorCode = `
+db := import('db')
+conn := db.open('sqlite', ':memory:')
+rows := conn.query('WITH RECURSIVE s(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM s WHERE i < 200) SELECT i, hex(zeroblob(1000)) AS v FROM s')
+msg := nil
+try {
+	msg = rows.writeJsonl('/dev/full')
+} catch (e) {
+	msg = e.message
+}
+export result := msg
+`
+
+func TestDbWriteJsonlError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("/dev/full is not available")
+	}
+	exported, err := zgg.RunCode(testDbWriteJsonlErrorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := exported["result"].(string); !strings.Contains(msg, "QueryResult.writeJsonl: write error") {
+		t.Fatalf("expected write error, got %v", exported["result"])
+	}
+}
@@ -0,0 +1,470 @@
+package builtin_libs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// dbDialect 屏蔽各数据库在SQL语法上的差异。
+	// ActiveRecord生成的SQL统一用?作占位符，最后再由dbRebind换成方言的写法
+	dbDialect interface {
+		Name() string
+		ShowTablesSQL() string
+		Quote(name string) string
+		// Placeholder 第n个参数(从1开始)的占位符
+		Placeholder(n int) string
+		// LimitOffset 分页子句，limit、offset小于0表示未设置。ordered表示语句中已有ORDER BY
+		LimitOffset(limit, offset int64, ordered bool) string
+		// UpdateLimit UPDATE语句能否带ORDER BY和LIMIT
+		UpdateLimit() bool
+		// Returning 返回写入后结果的子句，prefix放在VALUES/WHERE之前，suffix放在语句末尾
+		Returning(fields []string) (prefix, suffix string, ok bool)
+		// Upsert 生成rows行记录的插入或更新语句，keys为判断冲突的字段，updates为冲突时更新的字段
+		Upsert(table string, fields []string, rows int, keys, updates, returning []string) (string, error)
+		// TransactionalDDL DDL语句能否在事务中执行并回滚
+		TransactionalDDL() bool
+		// CreateTableIfNotExists 表不存在时建表，columns为字段定义
+		CreateTableIfNotExists(table, columns string) string
+		// DescribeSQL 以表名为参数查询表结构，每行依次为字段名、类型、可否为空、默认值、是否主键
+		DescribeSQL() string
+		// MaxParams 单条语句最多可绑定的参数个数，批量写入时据此拆分语句
+		MaxParams() int
+	}
+
+	dbCommonDialect    struct{}
+	dbMySQLDialect     struct{ dbCommonDialect }
+	dbSQLiteDialect    struct{ dbCommonDialect }
+	dbPostgresDialect  struct{ dbCommonDialect }
+	dbSQLServerDialect struct{ dbCommonDialect }
+)
+
+var (
+	dbDialectMap = map[string]dbDialect{
+		"mysql":     dbMySQLDialect{},
+		"sqlite":    dbSQLiteDialect{},
+		"sqlite3":   dbSQLiteDialect{},
+		"postgres":  dbPostgresDialect{},
+		"pgx":       dbPostgresDialect{},
+		"sqlserver": dbSQLServerDialect{},
+		"mssql":     dbSQLServerDialect{},
+	}
+)
+
+func (dbCommonDialect) Name() string             { return "common" }
+func (dbCommonDialect) Quote(name string) string { return name }
+func (dbCommonDialect) ShowTablesSQL() string    { return "SHOW TABLES" }
+func (dbCommonDialect) Placeholder(int) string   { return "?" }
+func (dbCommonDialect) UpdateLimit() bool        { return false }
+func (dbCommonDialect) LimitOffset(limit, offset int64, ordered bool) string {
+	switch {
+	case limit >= 0 && offset >= 0:
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	case limit >= 0:
+		return fmt.Sprintf("LIMIT %d", limit)
+	case offset >= 0:
+		return fmt.Sprintf("OFFSET %d", offset)
+	}
+	return ""
+}
+func (dbCommonDialect) Returning([]string) (string, string, bool) { return "", "", false }
+func (dbCommonDialect) TransactionalDDL() bool                    { return false }
+func (dbCommonDialect) DescribeSQL() string                       { return dbInfoSchemaDescribeSQL("") }
+func (dbCommonDialect) MaxParams() int                            { return 999 }
+func (d dbCommonDialect) CreateTableIfNotExists(table, columns string) string {
+	return dbCreateTableIfNotExists(d, table, columns)
+}
+func (d dbCommonDialect) Upsert(string, []string, int, []string, []string, []string) (string, error) {
+	return "", fmt.Errorf("upsert is not supported by %s dialect", d.Name())
+}
+
+func (dbMySQLDialect) Name() string { return "mysql" }
+func (dbMySQLDialect) Quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+func (dbMySQLDialect) UpdateLimit() bool { return true }
+func (dbMySQLDialect) MaxParams() int    { return 65535 }
+func (dbMySQLDialect) DescribeSQL() string {
+	return dbInfoSchemaDescribeSQL("DATABASE()")
+}
+func (d dbMySQLDialect) CreateTableIfNotExists(table, columns string) string {
+	return dbCreateTableIfNotExists(d, table, columns)
+}
+
+// LimitOffset MySQL只有偏移时也必须带LIMIT，用无符号64位整数的最大值表示不限制
+func (dbMySQLDialect) LimitOffset(limit, offset int64, ordered bool) string {
+	switch {
+	case limit >= 0 && offset >= 0:
+		return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+	case limit >= 0:
+		return fmt.Sprintf("LIMIT %d", limit)
+	case offset >= 0:
+		return fmt.Sprintf("LIMIT 18446744073709551615 OFFSET %d", offset)
+	}
+	return ""
+}
+func (d dbMySQLDialect) Upsert(table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	if len(returning) > 0 {
+		return "", fmt.Errorf("returning is not supported by %s dialect", d.Name())
+	}
+	var b strings.Builder
+	dbWriteInsert(&b, d, table, fields, rows, "")
+	b.WriteString(" ON DUPLICATE KEY UPDATE ")
+	if len(updates) == 0 {
+		// 没有需要更新的字段时保持原值，相当于忽略冲突
+		q := d.Quote(fields[0])
+		b.WriteString(q + " = " + q)
+	}
+	for i, f := range updates {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		q := d.Quote(f)
+		b.WriteString(q + " = VALUES(" + q + ")")
+	}
+	return b.String(), nil
+}
+
+func (dbSQLiteDialect) Name() string { return "sqlite" }
+func (dbSQLiteDialect) Quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+func (dbSQLiteDialect) TransactionalDDL() bool { return true }
+func (dbSQLiteDialect) MaxParams() int         { return 32766 }
+func (dbSQLiteDialect) DescribeSQL() string {
+	return `SELECT name, type, CASE WHEN "notnull" = 0 THEN 1 ELSE 0 END, dflt_value, CASE WHEN pk > 0 THEN 1 ELSE 0 END` +
+		" FROM pragma_table_info(?) ORDER BY cid"
+}
+func (d dbSQLiteDialect) CreateTableIfNotExists(table, columns string) string {
+	return dbCreateTableIfNotExists(d, table, columns)
+}
+func (dbSQLiteDialect) ShowTablesSQL() string {
+	return "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name"
+}
+
+// LimitOffset SQLite只有偏移时用LIMIT -1表示不限制
+func (dbSQLiteDialect) LimitOffset(limit, offset int64, ordered bool) string {
+	if limit < 0 && offset >= 0 {
+		return fmt.Sprintf("LIMIT -1 OFFSET %d", offset)
+	}
+	return dbCommonDialect{}.LimitOffset(limit, offset, ordered)
+}
+func (d dbSQLiteDialect) Returning(fields []string) (string, string, bool) {
+	return "", "RETURNING " + dbQuoteFields(d, fields), true
+}
+func (d dbSQLiteDialect) Upsert(table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	return dbOnConflictUpsert(d, table, fields, rows, keys, updates, returning)
+}
+
+func (dbPostgresDialect) Name() string { return "postgres" }
+func (dbPostgresDialect) Quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+func (dbPostgresDialect) ShowTablesSQL() string {
+	return "SELECT tablename FROM pg_catalog.pg_tables WHERE schemaname = current_schema() ORDER BY tablename"
+}
+func (dbPostgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+func (dbPostgresDialect) TransactionalDDL() bool   { return true }
+func (dbPostgresDialect) MaxParams() int           { return 65535 }
+func (dbPostgresDialect) DescribeSQL() string {
+	return dbInfoSchemaDescribeSQL("current_schema()")
+}
+func (d dbPostgresDialect) CreateTableIfNotExists(table, columns string) string {
+	return dbCreateTableIfNotExists(d, table, columns)
+}
+func (d dbPostgresDialect) Returning(fields []string) (string, string, bool) {
+	return "", "RETURNING " + dbQuoteFields(d, fields), true
+}
+func (d dbPostgresDialect) Upsert(table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	return dbOnConflictUpsert(d, table, fields, rows, keys, updates, returning)
+}
+
+func (dbSQLServerDialect) Name() string { return "sqlserver" }
+func (dbSQLServerDialect) Quote(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+func (dbSQLServerDialect) ShowTablesSQL() string {
+	return "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"
+}
+func (dbSQLServerDialect) Placeholder(n int) string { return "@p" + strconv.Itoa(n) }
+func (dbSQLServerDialect) TransactionalDDL() bool   { return true }
+func (dbSQLServerDialect) MaxParams() int           { return 2000 }
+func (dbSQLServerDialect) DescribeSQL() string {
+	return dbInfoSchemaDescribeSQL("SCHEMA_NAME()")
+}
+
+// CreateTableIfNotExists SQL Server不支持IF NOT EXISTS，改用OBJECT_ID判断
+func (d dbSQLServerDialect) CreateTableIfNotExists(table, columns string) string {
+	return "IF OBJECT_ID(N'" + strings.ReplaceAll(table, "'", "''") + "', N'U') IS NULL CREATE TABLE " +
+		d.Quote(table) + " (" + columns + ")"
+}
+
+// LimitOffset SQL Server的OFFSET/FETCH必须跟在ORDER BY之后
+func (dbSQLServerDialect) LimitOffset(limit, offset int64, ordered bool) string {
+	if limit < 0 && offset < 0 {
+		return ""
+	}
+	var b strings.Builder
+	if !ordered {
+		b.WriteString("ORDER BY (SELECT NULL) ")
+	}
+	b.WriteString(fmt.Sprintf("OFFSET %d ROWS", max(offset, 0)))
+	if limit >= 0 {
+		b.WriteString(fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit))
+	}
+	return b.String()
+}
+func (d dbSQLServerDialect) Returning(fields []string) (string, string, bool) {
+	items := make([]string, len(fields))
+	for i, f := range fields {
+		items[i] = "INSERTED." + dbQuoteField(d, f)
+	}
+	return "OUTPUT " + strings.Join(items, ", "), "", true
+}
+func (d dbSQLServerDialect) Upsert(table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("upsert requires conflict keys for %s dialect", d.Name())
+	}
+	var b strings.Builder
+	b.WriteString("MERGE INTO " + d.Quote(table) + " AS target USING (")
+	dbWriteValues(&b, len(fields), rows)
+	b.WriteString(") AS source (" + dbQuoteFields(d, fields) + ") ON ")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(" AND ")
+		}
+		q := d.Quote(k)
+		b.WriteString("target." + q + " = source." + q)
+	}
+	if len(updates) > 0 {
+		b.WriteString(" WHEN MATCHED THEN UPDATE SET ")
+		for i, f := range updates {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			q := d.Quote(f)
+			b.WriteString(q + " = source." + q)
+		}
+	}
+	b.WriteString(" WHEN NOT MATCHED THEN INSERT (" + dbQuoteFields(d, fields) + ") VALUES (")
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("source." + d.Quote(f))
+	}
+	b.WriteString(")")
+	if len(returning) > 0 {
+		prefix, _, _ := d.Returning(returning)
+		b.WriteString(" " + prefix)
+	}
+	// MERGE语句必须以分号结尾
+	b.WriteString(";")
+	return b.String(), nil
+}
+
+// dbInfoSchemaDescribeSQL 通过标准的INFORMATION_SCHEMA查询表结构，schema为当前库/模式的表达式
+func dbInfoSchemaDescribeSQL(schema string) string {
+	var b strings.Builder
+	b.WriteString("SELECT c.COLUMN_NAME, c.DATA_TYPE, CASE WHEN c.IS_NULLABLE = 'YES' THEN 1 ELSE 0 END, c.COLUMN_DEFAULT," +
+		" CASE WHEN EXISTS (SELECT 1 FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc" +
+		" JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE k ON k.CONSTRAINT_NAME = tc.CONSTRAINT_NAME" +
+		" AND k.TABLE_SCHEMA = tc.TABLE_SCHEMA AND k.TABLE_NAME = tc.TABLE_NAME" +
+		" WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY' AND tc.TABLE_SCHEMA = c.TABLE_SCHEMA" +
+		" AND tc.TABLE_NAME = c.TABLE_NAME AND k.COLUMN_NAME = c.COLUMN_NAME) THEN 1 ELSE 0 END" +
+		" FROM INFORMATION_SCHEMA.COLUMNS c WHERE c.TABLE_NAME = ?")
+	if schema != "" {
+		b.WriteString(" AND c.TABLE_SCHEMA = " + schema)
+	}
+	b.WriteString(" ORDER BY c.ORDINAL_POSITION")
+	return b.String()
+}
+
+func dbCreateTableIfNotExists(d dbDialect, table, columns string) string {
+	return "CREATE TABLE IF NOT EXISTS " + d.Quote(table) + " (" + columns + ")"
+}
+
+// dbQuoteField 给字段名加引号，已经带引号或是表达式的原样保留
+func dbQuoteField(d dbDialect, field string) string {
+	if field == "*" || strings.ContainsAny(field, "`\"[( ") {
+		return field
+	}
+	return d.Quote(field)
+}
+
+func dbQuoteFields(d dbDialect, fields []string) string {
+	items := make([]string, len(fields))
+	for i, f := range fields {
+		items[i] = dbQuoteField(d, f)
+	}
+	return strings.Join(items, ", ")
+}
+
+// dbRebind 把?占位符换成方言的写法，跳过字符串和加引号的标识符中的问号
+func dbRebind(d dbDialect, query string) string {
+	if d.Placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '[':
+			quote = ']'
+		case ch == '?':
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+func dbWriteValues(b *strings.Builder, fields, rows int) {
+	b.WriteString("VALUES ")
+	row := "(" + strings.Repeat("?, ", fields-1) + "?)"
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(row)
+	}
+}
+
+func dbWriteInsert(b *strings.Builder, d dbDialect, table string, fields []string, rows int, prefix string) {
+	b.WriteString("INSERT INTO " + d.Quote(table) + " (" + dbQuoteFields(d, fields) + ") ")
+	if prefix != "" {
+		b.WriteString(prefix + " ")
+	}
+	dbWriteValues(b, len(fields), rows)
+}
+
+func dbOnConflictUpsert(d dbDialect, table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("upsert requires conflict keys for %s dialect", d.Name())
+	}
+	var b strings.Builder
+	dbWriteInsert(&b, d, table, fields, rows, "")
+	b.WriteString(" ON CONFLICT (" + dbQuoteFields(d, keys) + ")")
+	if len(updates) == 0 {
+		b.WriteString(" DO NOTHING")
+	} else {
+		b.WriteString(" DO UPDATE SET ")
+		for i, f := range updates {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			q := d.Quote(f)
+			b.WriteString(q + " = excluded." + q)
+		}
+	}
+	if len(returning) > 0 {
+		_, suffix, _ := d.Returning(returning)
+		b.WriteString(" " + suffix)
+	}
+	return b.String(), nil
+}
+
+func dbWriteWhere(b *strings.Builder, where []string) {
+	for i, w := range where {
+		if i == 0 {
+			b.WriteString(" WHERE (")
+		} else {
+			b.WriteString(" AND (")
+		}
+		b.WriteString(w)
+		b.WriteString(")")
+	}
+}
+
+func dbWriteOrderBy(b *strings.Builder, orderBy []string) {
+	if len(orderBy) > 0 {
+		b.WriteString(" ORDER BY ")
+		b.WriteString(strings.Join(orderBy, ", "))
+	}
+}
+
+// dbSelectSQL 生成查询语句，limit、offset小于0表示未设置
+func dbSelectSQL(d dbDialect, table string, fields, where, orderBy []string, limit, offset int64) string {
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if len(fields) == 0 {
+		b.WriteString("*")
+	} else {
+		b.WriteString(dbQuoteFields(d, fields))
+	}
+	b.WriteString(" FROM " + d.Quote(table))
+	dbWriteWhere(&b, where)
+	dbWriteOrderBy(&b, orderBy)
+	if lo := d.LimitOffset(limit, offset, len(orderBy) > 0); lo != "" {
+		b.WriteString(" " + lo)
+	}
+	return dbRebind(d, b.String())
+}
+
+func dbUpdateSQL(d dbDialect, table string, sets, where, orderBy []string, limit, offset int64, returning []string) (string, error) {
+	if (len(orderBy) > 0 || limit >= 0 || offset >= 0) && !d.UpdateLimit() {
+		return "", fmt.Errorf("order by/limit/offset in update is not supported by %s dialect", d.Name())
+	}
+	var prefix, suffix string
+	if len(returning) > 0 {
+		var ok bool
+		if prefix, suffix, ok = d.Returning(returning); !ok {
+			return "", fmt.Errorf("returning is not supported by %s dialect", d.Name())
+		}
+	}
+	var b strings.Builder
+	b.WriteString("UPDATE " + d.Quote(table) + " SET ")
+	for i, f := range sets {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(d.Quote(f) + " = ?")
+	}
+	if prefix != "" {
+		b.WriteString(" " + prefix)
+	}
+	dbWriteWhere(&b, where)
+	dbWriteOrderBy(&b, orderBy)
+	if lo := d.LimitOffset(limit, offset, len(orderBy) > 0); lo != "" {
+		b.WriteString(" " + lo)
+	}
+	if suffix != "" {
+		b.WriteString(" " + suffix)
+	}
+	return dbRebind(d, b.String()), nil
+}
+
+func dbInsertSQL(d dbDialect, table string, fields []string, rows int, returning []string) (string, error) {
+	var prefix, suffix string
+	if len(returning) > 0 {
+		var ok bool
+		if prefix, suffix, ok = d.Returning(returning); !ok {
+			return "", fmt.Errorf("returning is not supported by %s dialect", d.Name())
+		}
+	}
+	var b strings.Builder
+	dbWriteInsert(&b, d, table, fields, rows, prefix)
+	if suffix != "" {
+		b.WriteString(" " + suffix)
+	}
+	return dbRebind(d, b.String()), nil
+}
+
+func dbUpsertSQL(d dbDialect, table string, fields []string, rows int, keys, updates, returning []string) (string, error) {
+	query, err := d.Upsert(table, fields, rows, keys, updates, returning)
+	if err != nil {
+		return "", err
+	}
+	return dbRebind(d, query), nil
+}
@@ -0,0 +1,130 @@
+package builtin_libs
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testDialects = []dbDialect{
+	dbCommonDialect{},
+	dbMySQLDialect{},
+	dbSQLiteDialect{},
+	dbPostgresDialect{},
+	dbSQLServerDialect{},
+}
+
+// checkDialectSQL 以what为名，对每种方言各运行一个子测试
+func checkDialectSQL(t *testing.T, what string, gen func(d dbDialect) (string, error), expected []string) {
+	t.Run(what, func(t *testing.T) {
+		for i, d := range testDialects {
+			t.Run(d.Name(), func(t *testing.T) {
+				got, err := gen(d)
+				if err != nil {
+					got = "error: " + err.Error()
+				}
+				if got != expected[i] {
+					t.Errorf("expected %s\n     got %s", expected[i], got)
+				}
+			})
+		}
+	})
+}
+
+func TestDbDialectSelect(t *testing.T) {
+	checkDialectSQL(t, "select", func(d dbDialect) (string, error) {
+		return dbSelectSQL(d, "users", []string{"id", "name"}, []string{d.Quote("age") + " > ?", "name like ?"},
+			[]string{d.Quote("id") + " DESC"}, 10, 20), nil
+	}, []string{
+		"SELECT id, name FROM users WHERE (age > ?) AND (name like ?) ORDER BY id DESC LIMIT 10 OFFSET 20",
+		"SELECT `id`, `name` FROM `users` WHERE (`age` > ?) AND (name like ?) ORDER BY `id` DESC LIMIT 10 OFFSET 20",
+		"SELECT `id`, `name` FROM `users` WHERE (`age` > ?) AND (name like ?) ORDER BY `id` DESC LIMIT 10 OFFSET 20",
+		`SELECT "id", "name" FROM "users" WHERE ("age" > $1) AND (name like $2) ORDER BY "id" DESC LIMIT 10 OFFSET 20`,
+		"SELECT [id], [name] FROM [users] WHERE ([age] > @p1) AND (name like @p2) ORDER BY [id] DESC OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY",
+	})
+	checkDialectSQL(t, "offset only", func(d dbDialect) (string, error) {
+		return dbSelectSQL(d, "t", []string{"COUNT(1)"}, nil, nil, -1, 5), nil
+	}, []string{
+		"SELECT COUNT(1) FROM t OFFSET 5",
+		"SELECT COUNT(1) FROM `t` LIMIT 18446744073709551615 OFFSET 5",
+		"SELECT COUNT(1) FROM `t` LIMIT -1 OFFSET 5",
+		`SELECT COUNT(1) FROM "t" OFFSET 5`,
+		"SELECT COUNT(1) FROM [t] ORDER BY (SELECT NULL) OFFSET 5 ROWS",
+	})
+}
+
+func TestDbDialectWrite(t *testing.T) {
+	checkDialectSQL(t, "insert", func(d dbDialect) (string, error) {
+		return dbInsertSQL(d, "users", []string{"name", "age"}, 2, []string{"id"})
+	}, []string{
+		"error: returning is not supported by common dialect",
+		"error: returning is not supported by mysql dialect",
+		"INSERT INTO `users` (`name`, `age`) VALUES (?, ?), (?, ?) RETURNING `id`",
+		`INSERT INTO "users" ("name", "age") VALUES ($1, $2), ($3, $4) RETURNING "id"`,
+		"INSERT INTO [users] ([name], [age]) OUTPUT INSERTED.[id] VALUES (@p1, @p2), (@p3, @p4)",
+	})
+	checkDialectSQL(t, "update", func(d dbDialect) (string, error) {
+		return dbUpdateSQL(d, "users", []string{"age"}, []string{d.Quote("id") + " = ?"}, nil, -1, -1, nil)
+	}, []string{
+		"UPDATE users SET age = ? WHERE (id = ?)",
+		"UPDATE `users` SET `age` = ? WHERE (`id` = ?)",
+		"UPDATE `users` SET `age` = ? WHERE (`id` = ?)",
+		`UPDATE "users" SET "age" = $1 WHERE ("id" = $2)`,
+		"UPDATE [users] SET [age] = @p1 WHERE ([id] = @p2)",
+	})
+	checkDialectSQL(t, "update limit", func(d dbDialect) (string, error) {
+		return dbUpdateSQL(d, "users", []string{"age"}, nil, nil, 1, -1, nil)
+	}, []string{
+		"error: order by/limit/offset in update is not supported by common dialect",
+		"UPDATE `users` SET `age` = ? LIMIT 1",
+		"error: order by/limit/offset in update is not supported by sqlite dialect",
+		"error: order by/limit/offset in update is not supported by postgres dialect",
+		"error: order by/limit/offset in update is not supported by sqlserver dialect",
+	})
+	checkDialectSQL(t, "upsert", func(d dbDialect) (string, error) {
+		return dbUpsertSQL(d, "users", []string{"id", "name"}, 1, []string{"id"}, []string{"name"}, nil)
+	}, []string{
+		"error: upsert is not supported by common dialect",
+		"INSERT INTO `users` (`id`, `name`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)",
+		"INSERT INTO `users` (`id`, `name`) VALUES (?, ?) ON CONFLICT (`id`) DO UPDATE SET `name` = excluded.`name`",
+		`INSERT INTO "users" ("id", "name") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`,
+		"MERGE INTO [users] AS target USING (VALUES (@p1, @p2)) AS source ([id], [name]) ON target.[id] = source.[id]" +
+			" WHEN MATCHED THEN UPDATE SET [name] = source.[name]" +
+			" WHEN NOT MATCHED THEN INSERT ([id], [name]) VALUES (source.[id], source.[name]);",
+	})
+	checkDialectSQL(t, "upsert nothing", func(d dbDialect) (string, error) {
+		return dbUpsertSQL(d, "t", []string{"k"}, 1, []string{"k"}, nil, []string{"k"})
+	}, []string{
+		"error: upsert is not supported by common dialect",
+		"error: returning is not supported by mysql dialect",
+		"INSERT INTO `t` (`k`) VALUES (?) ON CONFLICT (`k`) DO NOTHING RETURNING `k`",
+		`INSERT INTO "t" ("k") VALUES ($1) ON CONFLICT ("k") DO NOTHING RETURNING "k"`,
+		"MERGE INTO [t] AS target USING (VALUES (@p1)) AS source ([k]) ON target.[k] = source.[k]" +
+			" WHEN NOT MATCHED THEN INSERT ([k]) VALUES (source.[k]) OUTPUT INSERTED.[k];",
+	})
+}
+
+func TestDbRebind(t *testing.T) {
+	query := "SELECT '?' AS q, \"a?\", [b?] FROM t WHERE x = ? AND y IN (?, ?)"
+	checkDialectSQL(t, "rebind", func(d dbDialect) (string, error) {
+		return dbRebind(d, query), nil
+	}, []string{
+		query,
+		query,
+		query,
+		"SELECT '?' AS q, \"a?\", [b?] FROM t WHERE x = $1 AND y IN ($2, $3)",
+		"SELECT '?' AS q, \"a?\", [b?] FROM t WHERE x = @p1 AND y IN (@p2, @p3)",
+	})
+}
+
+func TestDbSplitStatements(t *testing.T) {
+	script := "-- init\nCREATE TABLE t (s TEXT DEFAULT ';');\n/* a; b */\n" +
+		"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;\nINSERT INTO t VALUES ($1)\n-- end;\n"
+	expected := []string{
+		"-- init\nCREATE TABLE t (s TEXT DEFAULT ';')",
+		"/* a; b */\nCREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+		"INSERT INTO t VALUES ($1)\n-- end;",
+	}
+	if got := dbSplitStatements(script); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,82 @@
+package builtin_libs_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testDbMigrateSetup = `
+db := import('db')
+conn := db.open('sqlite', ':memory:')
+m := db.migrator(conn, '{dir}')
+`
+
+var testDbMigrateFiles = map[string]string{
+	"001_users.up.sql":   "-- 用户表\nCREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\nINSERT INTO users (name) VALUES ('a;b');\n",
+	"001_users.down.sql": "DROP TABLE users;",
+	"002_seed.zgg": "export up := db => db.execute('INSERT INTO users (name) VALUES (?)', 'seed')\n" +
+		"export down := db => db.execute('DELETE FROM users WHERE name = ?', 'seed')\n",
+	"003_broken.up.sql": "CREATE TABLE broken (id INT);\nINSERT INTO missing VALUES (1);\n",
+}
+
+func TestDbMigrateSQLite(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range testDbMigrateFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Up", `
+			export result := [m.up(2), conn.m('users').find('name').map(r => r.name)]
+		`, []interface{}{
+			[]interface{}{"001_users", "002_seed"},
+			[]interface{}{"a;b", "seed"},
+		}},
+		{"FailureRollsBack", `
+			m.up(2)
+			failed := false
+			try {
+				db.migrate(conn, '{dir}')
+			} catch (e) {
+				failed = true
+			}
+			export result := [failed, conn.tables()]
+		`, []interface{}{true, []interface{}{"schema_migrations", "users"}}},
+		{"Status", `
+			m.up(2)
+			export result := m.status().map(s => [s.version, s.name, s.applied])
+		`, []interface{}{
+			[]interface{}{int64(1), "users", true},
+			[]interface{}{int64(2), "seed", true},
+			[]interface{}{int64(3), "broken", false},
+		}},
+		{"Down", `
+			m.up(2)
+			export result := [m.down(2), conn.tables()]
+		`, []interface{}{
+			[]interface{}{"002_seed", "001_users"},
+			[]interface{}{"schema_migrations"},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(strings.ReplaceAll(testDbMigrateSetup+tc.code, "{dir}", filepath.ToSlash(dir)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
@@ -0,0 +1,713 @@
+package builtin_libs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// dbModelField 模型声明的字段，typ为int/float/str/bool/time/bytes/any
+	dbModelField struct {
+		name         string
+		typ          string
+		required     bool
+		defaultValue Value
+	}
+	// dbRelation hasMany/belongsTo关联。model为Model对象或表名，用到时才解析，因此可以引用后定义的模型
+	dbRelation struct {
+		name       string
+		many       bool
+		model      Value
+		foreignKey string
+	}
+	dbModel struct {
+		table      string
+		primaryKey string
+		fields     []*dbModelField
+		relations  map[string]*dbRelation
+		validators []ValueCallable
+		value      ValueObject
+	}
+	// dbRecordState 模型实例的状态，original为最近一次读取或保存时的字段值，用于只更新改动过的字段
+	dbRecordState struct {
+		model     *dbModel
+		conn      ValueObject
+		persisted bool
+		original  map[string]Value
+	}
+)
+
+var (
+	dbModelClass      ValueType
+	dbRecordClass     ValueType
+	dbModels          sync.Map
+	dbModelFieldTypes = map[string]bool{"int": true, "float": true, "str": true, "bool": true, "time": true, "bytes": true, "any": true}
+)
+
+// dbModelInChunk IN查询每批的参数个数，避免超出数据库的参数上限
+const dbModelInChunk = 500
+
+func dbIsNil(v Value) bool {
+	switch v.(type) {
+	case nil, ValueNil, ValueUndefined:
+		return true
+	}
+	return false
+}
+
+// dbOwnMember 只取对象自身的成员，不经过__getAttr__
+func dbOwnMember(c *Context, obj ValueObject, name string) (Value, bool) {
+	if !obj.Contains(c, NewStr(name)) {
+		return nil, false
+	}
+	return obj.GetMember(name, c), true
+}
+
+func newDbModel(c *Context, table string, options ValueObject) *dbModel {
+	m := &dbModel{
+		table:      table,
+		primaryKey: "id",
+		relations:  map[string]*dbRelation{},
+	}
+	switch pk := options.GetMember("primaryKey", c).(type) {
+	case ValueStr:
+		m.primaryKey = pk.Value()
+	case ValueNil, ValueUndefined:
+	default:
+		c.RaiseRuntimeError("db.model: primaryKey of %s must be a single field name", table)
+	}
+	addField := func(name string, spec Value) {
+		f := &dbModelField{name: name, typ: "any"}
+		switch s := spec.(type) {
+		case ValueStr:
+			f.typ = s.Value()
+		case ValueObject:
+			if t, ok := s.GetMember("type", c).(ValueStr); ok {
+				f.typ = t.Value()
+			}
+			f.required = s.GetMember("required", c).IsTrue()
+			if dv := s.GetMember("defaultValue", c); !IsUndefined(dv) {
+				f.defaultValue = dv
+			}
+		}
+		if !dbModelFieldTypes[f.typ] {
+			c.RaiseRuntimeError("db.model: unknown type %s of field %s.%s", f.typ, table, name)
+		}
+		m.fields = append(m.fields, f)
+	}
+	switch fields := options.GetMember("fields", c).(type) {
+	case ValueArray:
+		for i := 0; i < fields.Len(); i++ {
+			if spec, ok := fields.GetIndex(i, c).(ValueObject); ok {
+				addField(c.MustStr(spec.GetMember("name", c), "field name"), spec)
+			} else {
+				addField(fields.GetIndex(i, c).ToString(c), Undefined())
+			}
+		}
+	case ValueObject:
+		var names []string
+		fields.Iterate(func(name string, _ Value) { names = append(names, name) })
+		sort.Strings(names)
+		for _, name := range names {
+			addField(name, fields.GetMember(name, c))
+		}
+	}
+	addRelations := func(option string, many bool) {
+		relations, ok := options.GetMember(option, c).(ValueObject)
+		if !ok {
+			return
+		}
+		relations.Iterate(func(name string, spec Value) {
+			r := &dbRelation{name: name, many: many, model: spec}
+			if obj, ok := spec.(ValueObject); ok && obj.Type() != dbModelClass {
+				r.model = obj.GetMember("model", c)
+				if fk, ok := obj.GetMember("foreignKey", c).(ValueStr); ok {
+					r.foreignKey = fk.Value()
+				}
+			}
+			// 默认外键: users hasMany posts为posts.user_id，posts belongsTo author为posts.author_id
+			if r.foreignKey == "" {
+				if many {
+					r.foreignKey = strings.TrimSuffix(table, "s") + "_id"
+				} else {
+					r.foreignKey = name + "_id"
+				}
+			}
+			m.relations[name] = r
+		})
+	}
+	addRelations("hasMany", true)
+	addRelations("belongsTo", false)
+	switch v := options.GetMember("validate", c).(type) {
+	case ValueArray:
+		for i := 0; i < v.Len(); i++ {
+			m.validators = append(m.validators, c.MustCallable(v.GetIndex(i, c), "validate"))
+		}
+	case ValueNil, ValueUndefined:
+	default:
+		m.validators = append(m.validators, c.MustCallable(v, "validate"))
+	}
+	return m
+}
+
+func (r *dbRelation) target(c *Context) *dbModel {
+	switch v := r.model.(type) {
+	case ValueStr:
+		if m, found := dbModels.Load(v.Value()); found {
+			return m.(*dbModel)
+		}
+		c.RaiseRuntimeError("db.model: model %s of relation %s is not defined", v.Value(), r.name)
+	case ValueObject:
+		if m, ok := v.Reserved.(*dbModel); ok {
+			return m
+		}
+	}
+	c.RaiseRuntimeError("db.model: relation %s requires a model or table name", r.name)
+	return nil
+}
+
+func (m *dbModel) dialect(c *Context, conn ValueObject) dbDialect {
+	return conn.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
+}
+
+// query 返回绑定了本模型的ActiveRecord
+func (m *dbModel) query(c *Context, conn ValueObject) ValueObject {
+	return NewObjectAndInit(dbActiveRecordClass, c, conn, m.value)
+}
+
+func (m *dbModel) newRecord(c *Context, conn ValueObject, data ValueObject, persisted bool) ValueObject {
+	rv := NewObject(dbRecordClass)
+	st := &dbRecordState{model: m, conn: conn, persisted: persisted}
+	rv.Reserved = st
+	if data != nil {
+		data.Iterate(func(k string, v Value) {
+			rv.SetMember(k, v, c)
+		})
+	}
+	if persisted {
+		st.snapshot(c, rv)
+		return rv
+	}
+	for _, f := range m.fields {
+		if _, found := dbOwnMember(c, rv, f.name); found || f.defaultValue == nil {
+			continue
+		}
+		if fn, ok := c.GetCallable(f.defaultValue); ok {
+			c.Invoke(fn, nil, NoArgs)
+			rv.SetMember(f.name, c.RetVal, c)
+		} else {
+			rv.SetMember(f.name, f.defaultValue, c)
+		}
+	}
+	return rv
+}
+
+// columns 要写入数据库的字段。声明了fields时只写声明的字段，否则写除关联外的所有成员
+func (m *dbModel) columns(c *Context, rec ValueObject) []string {
+	var rv []string
+	if len(m.fields) > 0 {
+		for _, f := range m.fields {
+			if _, found := dbOwnMember(c, rec, f.name); found {
+				rv = append(rv, f.name)
+			}
+		}
+		return rv
+	}
+	rec.Iterate(func(name string, _ Value) {
+		if _, isRelation := m.relations[name]; !isRelation {
+			rv = append(rv, name)
+		}
+	})
+	sort.Strings(rv)
+	return rv
+}
+
+func (st *dbRecordState) snapshot(c *Context, rec ValueObject) {
+	st.original = map[string]Value{}
+	for _, name := range st.model.columns(c, rec) {
+		st.original[name] = rec.GetMember(name, c)
+	}
+}
+
+func (st *dbRecordState) changed(c *Context, name string, v Value) bool {
+	orig, found := st.original[name]
+	return !st.persisted || !found || !c.ValuesEqual(orig, v)
+}
+
+func dbModelTypeMatch(typ string, v Value) bool {
+	switch typ {
+	case "int":
+		_, ok := v.(ValueInt)
+		return ok
+	case "float":
+		switch v.(type) {
+		case ValueInt, ValueFloat:
+			return true
+		}
+		return false
+	case "str":
+		_, ok := v.(ValueStr)
+		return ok
+	case "bool":
+		_, ok := v.(ValueBool)
+		return ok
+	case "time":
+		if obj, ok := v.(ValueObject); ok {
+			return obj.Type() == timeTimeClass
+		}
+		_, ok := v.(ValueStr)
+		return ok
+	case "bytes":
+		switch v.(type) {
+		case ValueBytes, ValueStr:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// validate 检查必填字段和字段类型，再依次执行validate钩子。钩子把错误信息加入传入的errors数组
+func (m *dbModel) validate(c *Context, rec ValueObject) []string {
+	st := rec.Reserved.(*dbRecordState)
+	var errs []string
+	for _, f := range m.fields {
+		v, _ := dbOwnMember(c, rec, f.name)
+		if dbIsNil(v) {
+			if f.required && f.name != m.primaryKey {
+				errs = append(errs, f.name+" is required")
+			}
+			continue
+		}
+		if st.changed(c, f.name, v) && !dbModelTypeMatch(f.typ, v) {
+			errs = append(errs, fmt.Sprintf("%s must be %s", f.name, f.typ))
+		}
+	}
+	for _, fn := range m.validators {
+		errors := NewArray()
+		c.Invoke(fn, nil, Args(rec, errors))
+		for i := 0; i < errors.Len(); i++ {
+			errs = append(errs, errors.GetIndex(i, c).ToString(c))
+		}
+	}
+	return errs
+}
+
+func (m *dbModel) pkValue(c *Context, rec ValueObject, op string) Value {
+	v, _ := dbOwnMember(c, rec, m.primaryKey)
+	if dbIsNil(v) {
+		c.RaiseRuntimeError("%s.%s: primary key %s is not set", m.table, op, m.primaryKey)
+	}
+	return v
+}
+
+func (m *dbModel) save(c *Context, rec ValueObject) {
+	st := rec.Reserved.(*dbRecordState)
+	if errs := m.validate(c, rec); len(errs) > 0 {
+		c.RaiseRuntimeError("%s: validation failed: %s", m.table, strings.Join(errs, "; "))
+	}
+	d := m.dialect(c, st.conn)
+	var (
+		fields []string
+		args   []Value
+	)
+	for _, name := range m.columns(c, rec) {
+		v := rec.GetMember(name, c)
+		if !st.changed(c, name, v) || (!st.persisted && name == m.primaryKey && dbIsNil(v)) {
+			continue
+		}
+		fields = append(fields, name)
+		args = append(args, v)
+	}
+	if st.persisted {
+		if len(fields) == 0 {
+			return
+		}
+		query, err := dbUpdateSQL(d, m.table, fields, []string{d.Quote(m.primaryKey) + " = ?"}, nil, -1, -1, nil)
+		if err != nil {
+			c.RaiseRuntimeError("%s.save: %s", m.table, err)
+		}
+		args = append(args, m.pkValue(c, rec, "save"))
+		c.InvokeMethod(st.conn, "execute", Args(append([]Value{NewStr(query)}, args...)...))
+	} else {
+		pk, _ := dbOwnMember(c, rec, m.primaryKey)
+		var returning []string
+		if _, _, ok := d.Returning([]string{m.primaryKey}); ok && dbIsNil(pk) {
+			returning = []string{m.primaryKey}
+		}
+		query, err := dbInsertSQL(d, m.table, fields, 1, returning)
+		if err != nil {
+			c.RaiseRuntimeError("%s.save: %s", m.table, err)
+		}
+		callArgs := Args(append([]Value{NewStr(query)}, args...)...)
+		if len(returning) > 0 {
+			res := c.InvokeMethod(st.conn, "query", callArgs)
+			rows := c.MustArray(c.InvokeMethod(res, "all", NoArgs))
+			c.InvokeMethod(res, "close", NoArgs)
+			if rows.Len() > 0 {
+				rec.SetMember(m.primaryKey, c.MustObject(rows.GetIndex(0, c)).GetMember(m.primaryKey, c), c)
+			}
+		} else {
+			res := c.MustObject(c.InvokeMethod(st.conn, "execute", callArgs))
+			if id := res.GetMember("lastInsertID", c); dbIsNil(pk) && !dbIsNil(id) {
+				rec.SetMember(m.primaryKey, id, c)
+			}
+		}
+		st.persisted = true
+	}
+	st.snapshot(c, rec)
+}
+
+func (m *dbModel) delete(c *Context, rec ValueObject) Value {
+	st := rec.Reserved.(*dbRecordState)
+	d := m.dialect(c, st.conn)
+	query := dbRebind(d, fmt.Sprintf("DELETE FROM %s WHERE %s = ?", d.Quote(m.table), d.Quote(m.primaryKey)))
+	res := c.InvokeMethod(st.conn, "execute", Args(NewStr(query), m.pkValue(c, rec, "delete")))
+	st.persisted = false
+	return c.MustObject(res).GetMember("affected", c)
+}
+
+// reload 重新读取记录，已加载的关联会被清掉，下次访问时重新查询
+func (m *dbModel) reload(c *Context, rec ValueObject) {
+	st := rec.Reserved.(*dbRecordState)
+	filter := NewObject()
+	filter.SetMember(m.primaryKey, m.pkValue(c, rec, "reload"), c)
+	q := NewObjectAndInit(dbActiveRecordClass, c, st.conn, NewStr(m.table))
+	c.InvokeMethod(q, "and", Args(filter))
+	row, ok := c.InvokeMethod(q, "findOne", NoArgs).(ValueObject)
+	if !ok {
+		c.RaiseRuntimeError("%s.reload: record %s not found", m.table, filter.GetMember(m.primaryKey, c).ToString(c))
+	}
+	var names []string
+	rec.Iterate(func(name string, _ Value) { names = append(names, name) })
+	for _, name := range names {
+		rec.SetMember(name, Undefined(), c)
+	}
+	row.Iterate(func(name string, v Value) { rec.SetMember(name, v, c) })
+	st.persisted = true
+	st.snapshot(c, rec)
+}
+
+// findIn 查询field在keys中的记录，include为需要继续加载的下一级关联
+func (m *dbModel) findIn(c *Context, conn ValueObject, field string, keys []Value, include string) []ValueObject {
+	seen := map[string]bool{}
+	var uniq []Value
+	for _, k := range keys {
+		if s := k.ToString(c); !dbIsNil(k) && !seen[s] {
+			seen[s] = true
+			uniq = append(uniq, k)
+		}
+	}
+	d := m.dialect(c, conn)
+	var rv []ValueObject
+	for start := 0; start < len(uniq); start += dbModelInChunk {
+		chunk := uniq[start:]
+		if len(chunk) > dbModelInChunk {
+			chunk = chunk[:dbModelInChunk]
+		}
+		q := m.query(c, conn)
+		filter := d.Quote(field) + " IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ") + ")"
+		c.InvokeMethod(q, "and", Args(append([]Value{NewStr(filter)}, chunk...)...))
+		if include != "" {
+			c.InvokeMethod(q, "include", Args(NewStr(include)))
+		}
+		found := c.MustArray(c.InvokeMethod(q, "find", NoArgs))
+		for i := 0; i < found.Len(); i++ {
+			rv = append(rv, c.MustObject(found.GetIndex(i, c)))
+		}
+	}
+	return rv
+}
+
+// loadRelation 为一批记录加载关联，path可以用.连接多级关联，如posts.comments
+func (m *dbModel) loadRelation(c *Context, conn ValueObject, recs []ValueObject, path string) {
+	name, rest, _ := strings.Cut(path, ".")
+	r, found := m.relations[name]
+	if !found {
+		c.RaiseRuntimeError("%s has no relation %s", m.table, name)
+	}
+	target := r.target(c)
+	if r.many {
+		keys := make([]Value, len(recs))
+		for i, rec := range recs {
+			keys[i], _ = dbOwnMember(c, rec, m.primaryKey)
+		}
+		groups := map[string]ValueArray{}
+		for _, child := range target.findIn(c, conn, r.foreignKey, keys, rest) {
+			fk := child.GetMember(r.foreignKey, c).ToString(c)
+			group, found := groups[fk]
+			if !found {
+				group = NewArray()
+				groups[fk] = group
+			}
+			group.PushBack(child)
+		}
+		for i, rec := range recs {
+			children := NewArray()
+			if group, found := groups[keys[i].ToString(c)]; found && !dbIsNil(keys[i]) {
+				children = group
+			}
+			rec.SetMember(name, children, c)
+		}
+		return
+	}
+	keys := make([]Value, len(recs))
+	for i, rec := range recs {
+		keys[i], _ = dbOwnMember(c, rec, r.foreignKey)
+	}
+	parents := map[string]ValueObject{}
+	for _, parent := range target.findIn(c, conn, target.primaryKey, keys, rest) {
+		parents[parent.GetMember(target.primaryKey, c).ToString(c)] = parent
+	}
+	for i, rec := range recs {
+		if parent, found := parents[keys[i].ToString(c)]; found && !dbIsNil(keys[i]) {
+			rec.SetMember(name, parent, c)
+		} else {
+			rec.SetMember(name, Nil(), c)
+		}
+	}
+}
+
+// dbModelWrap 把绑定了模型的ActiveRecord的查询结果转换为模型实例，并预加载include的关联
+func dbModelWrap(c *Context, query ValueObject, records Value) Value {
+	m, ok := query.GetMember("_model", c).ToGoValue(c).(*dbModel)
+	if !ok {
+		return records
+	}
+	conn := c.MustObject(query.GetMember("conn", c))
+	rows := c.MustArray(records)
+	recs := make([]ValueObject, rows.Len())
+	rv := NewArray(len(recs))
+	for i := range recs {
+		recs[i] = m.newRecord(c, conn, c.MustObject(rows.GetIndex(i, c)), true)
+		rv.PushBack(recs[i])
+	}
+	if includes, ok := query.GetMember("_include", c).(ValueArray); ok && len(recs) > 0 {
+		for i := 0; i < includes.Len(); i++ {
+			m.loadRelation(c, conn, recs, includes.GetIndex(i, c).ToString(c))
+		}
+	}
+	return rv
+}
+
+// dbModelType 按字段的数据库类型推断模型字段类型，规则与SQLite的类型亲和性相近
+func dbModelType(sqlType string) string {
+	t := strings.ToUpper(sqlType)
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+	has := func(subs ...string) bool {
+		for _, s := range subs {
+			if strings.Contains(t, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("BOOL") || t == "BIT":
+		return "bool"
+	case has("INT", "SERIAL"):
+		return "int"
+	case has("CHAR", "CLOB", "TEXT", "UUID", "JSON"):
+		return "str"
+	case has("BLOB", "BINARY", "BYTEA", "IMAGE"):
+		return "bytes"
+	case has("REAL", "FLOA", "DOUB", "DEC", "NUMERIC", "MONEY"):
+		return "float"
+	case has("DATE", "TIME"):
+		return "time"
+	}
+	return "any"
+}
+
+func dbTruthy(v Value) bool {
+	switch tv := v.(type) {
+	case ValueStr:
+		switch strings.ToUpper(tv.Value()) {
+		case "1", "YES", "TRUE", "T", "Y":
+			return true
+		}
+		return false
+	case ValueNil, ValueUndefined:
+		return false
+	}
+	return v.IsTrue()
+}
+
+// dbDescribe 查询表结构。返回值中的fields可直接作为db.model的fields选项
+func dbDescribe(c *Context, conn ValueObject, table string) ValueObject {
+	d := conn.GetMember("_dialect", c).ToGoValue(c).(dbDialect)
+	res := c.InvokeMethod(conn, "query", Args(NewStr(dbRebind(d, d.DescribeSQL())), NewStr(table)))
+	rows := c.MustArray(c.InvokeMethod(res, "allArray", NoArgs))
+	c.InvokeMethod(res, "close", NoArgs)
+	if rows.Len() == 0 {
+		c.RaiseRuntimeError("Database.describe: table %s not found", table)
+	}
+	columns := NewArray(rows.Len())
+	fields := NewArray(rows.Len())
+	var pks []Value
+	for i := 0; i < rows.Len(); i++ {
+		row := c.MustArray(rows.GetIndex(i, c))
+		name := NewStr(row.GetIndex(0, c).ToString(c))
+		sqlType := ""
+		if t := row.GetIndex(1, c); !dbIsNil(t) {
+			sqlType = t.ToString(c)
+		}
+		nullable := dbTruthy(row.GetIndex(2, c))
+		defaultValue := row.GetIndex(3, c)
+		isPk := dbTruthy(row.GetIndex(4, c))
+		if isPk {
+			pks = append(pks, name)
+		}
+		col := NewObject()
+		col.SetMember("name", name, c)
+		col.SetMember("type", NewStr(sqlType), c)
+		col.SetMember("nullable", NewBool(nullable), c)
+		col.SetMember("defaultValue", defaultValue, c)
+		col.SetMember("primaryKey", NewBool(isPk), c)
+		columns.PushBack(col)
+		field := NewObject()
+		field.SetMember("name", name, c)
+		field.SetMember("type", NewStr(dbModelType(sqlType)), c)
+		field.SetMember("required", NewBool(!nullable && !isPk && dbIsNil(defaultValue)), c)
+		fields.PushBack(field)
+	}
+	rv := NewObject()
+	rv.SetMember("table", NewStr(table), c)
+	rv.SetMember("columns", columns, c)
+	rv.SetMember("fields", fields, c)
+	switch len(pks) {
+	case 0:
+		rv.SetMember("primaryKey", Nil(), c)
+	case 1:
+		rv.SetMember("primaryKey", pks[0], c)
+	default:
+		rv.SetMember("primaryKey", NewArrayByValues(pks...), c)
+	}
+	return rv
+}
+
+func initDbModelClass() ValueType {
+	return NewClassBuilder("Model").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var (
+				table   ValueStr
+				options ValueObject
+			)
+			EnsureFuncParams(c, "db.Model.__init__", args,
+				ArgRuleRequired("table", TypeStr, &table),
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			)
+			m := newDbModel(c, table.Value(), options)
+			m.value = this
+			this.Reserved = m
+			fields := NewArray(len(m.fields))
+			for _, f := range m.fields {
+				fields.PushBack(NewStr(f.name))
+			}
+			this.SetMember("table", table, c)
+			this.SetMember("primaryKey", NewStr(m.primaryKey), c)
+			this.SetMember("fields", fields, c)
+			dbModels.Store(m.table, m)
+		}).
+		Method("query", func(c *Context, this ValueObject, args []Value) Value {
+			var conn ValueObject
+			requiredArgs := args
+			if len(args) > 1 {
+				requiredArgs = args[:1]
+			}
+			EnsureFuncParams(c, "db.Model.query", requiredArgs, ArgRuleRequired("conn", TypeObject, &conn))
+			rv := this.Reserved.(*dbModel).query(c, conn)
+			if len(args) > 1 {
+				c.InvokeMethod(rv, "and", Args(args[1:]...))
+			}
+			return rv
+		}).
+		Method("get", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				conn ValueObject
+				id   Value
+			)
+			EnsureFuncParams(c, "db.Model.get", args,
+				ArgRuleRequired("conn", TypeObject, &conn),
+				ArgRuleRequired("id", TypeAny, &id),
+			)
+			m := this.Reserved.(*dbModel)
+			filter := NewObject()
+			filter.SetMember(m.primaryKey, id, c)
+			q := m.query(c, conn)
+			c.InvokeMethod(q, "and", Args(filter))
+			return c.InvokeMethod(q, "findOne", NoArgs)
+		}, "conn", "id").
+		Method("build", func(c *Context, this ValueObject, args []Value) Value {
+			var conn, data ValueObject
+			EnsureFuncParams(c, "db.Model.build", args,
+				ArgRuleRequired("conn", TypeObject, &conn),
+				ArgRuleOptional("data", TypeObject, &data, NewObject()),
+			)
+			return this.Reserved.(*dbModel).newRecord(c, conn, data, false)
+		}, "conn", "data").
+		Method("create", func(c *Context, this ValueObject, args []Value) Value {
+			var conn, data ValueObject
+			EnsureFuncParams(c, "db.Model.create", args,
+				ArgRuleRequired("conn", TypeObject, &conn),
+				ArgRuleRequired("data", TypeObject, &data),
+			)
+			m := this.Reserved.(*dbModel)
+			rec := m.newRecord(c, conn, data, false)
+			m.save(c, rec)
+			return rec
+		}, "conn", "data").
+		Build()
+}
+
+func initDbRecordClass() ValueType {
+	return NewClassBuilder("Record").
+		Method("save", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*dbRecordState).model.save(c, this)
+			return this
+		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			return this.Reserved.(*dbRecordState).model.delete(c, this)
+		}).
+		Method("reload", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*dbRecordState).model.reload(c, this)
+			return this
+		}).
+		Method("validate", func(c *Context, this ValueObject, args []Value) Value {
+			errs := this.Reserved.(*dbRecordState).model.validate(c, this)
+			rv := NewArray(len(errs))
+			for _, e := range errs {
+				rv.PushBack(NewStr(e))
+			}
+			return rv
+		}).
+		Method("isNew", func(c *Context, this ValueObject, args []Value) Value {
+			return NewBool(!this.Reserved.(*dbRecordState).persisted)
+		}).
+		Method("toObject", func(c *Context, this ValueObject, args []Value) Value {
+			m := this.Reserved.(*dbRecordState).model
+			rv := NewObject()
+			for _, name := range m.columns(c, this) {
+				rv.SetMember(name, this.GetMember(name, c), c)
+			}
+			return rv
+		}).
+		// __getAttr__ 访问未加载的关联时才查询，结果缓存在实例上
+		Method("__getAttr__", func(c *Context, this ValueObject, args []Value) Value {
+			st := this.Reserved.(*dbRecordState)
+			name := args[0].ToString(c)
+			if _, isRelation := st.model.relations[name]; !isRelation {
+				return Undefined()
+			}
+			st.model.loadRelation(c, st.conn, []ValueObject{this}, name)
+			v, _ := dbOwnMember(c, this, name)
+			return v
+		}).
+		Build()
+}
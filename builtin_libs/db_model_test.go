@@ -0,0 +1,93 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testDbModelSetup = `
+db := import('db')
+conn := db.open('sqlite', ':memory:')
+conn.execute('CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, age INT DEFAULT 0)')
+conn.execute('CREATE TABLE posts (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INT NOT NULL, title TEXT)')
+User := db.model('users', {
+	fields: conn.describe('users').fields,
+	hasMany: {posts: 'posts'},
+	validate: (u, errors) => {
+		if u.age < 0 {
+			errors.push('age must not be negative')
+		}
+	},
+})
+Post := db.model('posts', {
+	fields: ['id', 'user_id', 'title'],
+	belongsTo: {author: {model: User, foreignKey: 'user_id'}},
+})
+bob := User.create(conn, {name: 'bob', age: 20})
+ann := User.create(conn, {name: 'ann', age: 30})
+Post.create(conn, {user_id: bob.id, title: 'p1'})
+Post.create(conn, {user_id: bob.id, title: 'p2'})
+errorOf := data => {
+	try {
+		User.create(conn, data)
+		return 'no error'
+	} catch (e) {
+		return e.message
+	}
+}
+`
+
+func TestDbModelSQLite(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Describe", `
+			export result := conn.describe('users').fields
+		`, []interface{}{
+			map[string]interface{}{"name": "id", "type": "int", "required": false},
+			map[string]interface{}{"name": "name", "type": "str", "required": true},
+			map[string]interface{}{"name": "age", "type": "int", "required": false},
+		}},
+		{"Create", `
+			export result := [bob.id, ann.id, bob.isNew()]
+		`, []interface{}{int64(1), int64(2), false}},
+		{"HasMany", `
+			export result := User.get(conn, bob.id).posts.map(p => p.title)
+		`, []interface{}{"p1", "p2"}},
+		{"IncludeBelongsTo", `
+			export result := conn.m(Post).include('author').desc('id').find().map(p => p.author.name)
+		`, []interface{}{"bob", "bob"}},
+		{"IncludeHasMany", `
+			export result := User.query(conn).include('posts').asc('id').find().map(u => len(u.posts))
+		`, []interface{}{int64(2), int64(0)}},
+		{"RequiredField", `
+			export result := errorOf({age: 1})
+		`, "users: validation failed: name is required"},
+		{"Validate", `
+			export result := errorOf({name: 'x', age: -1})
+		`, "users: validation failed: age must not be negative"},
+		{"Save", `
+			bob.age = 21
+			bob.save()
+			export result := User.get(conn, bob.id).toObject()
+		`, map[string]interface{}{"id": int64(1), "name": "bob", "age": int64(21)}},
+		{"DeleteAndReload", `
+			export result := [Post.get(conn, 1).delete(), len(bob.reload().posts)]
+		`, []interface{}{int64(1), int64(1)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testDbModelSetup + tc.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
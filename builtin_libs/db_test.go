@@ -0,0 +1,70 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	_ "github.com/glebarez/go-sqlite"
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testDbSQLiteSetup = `
+db := import('db')
+dbop := import('dbop')
+conn := db.open('sqlite', ':memory:')
+conn.execute('CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE, age INT)')
+conn.execute('INSERT INTO users (name, age) VALUES (?, ?), (?, ?), (?, ?)', 'a', 10, 'b', 20, 'c', 30)
+`
+
+func TestDbSQLiteActiveRecord(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Tables", `
+			export result := conn.tables()
+		`, []interface{}{"users"}},
+		{"AddAffected", `
+			export result := conn.m('users').add({name: 'x', age: 1}, {name: 'y', age: 2}).affected
+		`, int64(2)},
+		{"AddReturning", `
+			export result := conn.m('users').returning('id', 'name').add([{name: 'd', age: 40}])
+		`, []interface{}{map[string]interface{}{"id": int64(4), "name": "d"}}},
+		{"Count", `
+			export result := [conn.m('users').count(), conn.m('users', {age: dbop.gt(15)}).count()]
+		`, []interface{}{int64(3), int64(2)}},
+		{"OrderAndOffset", `
+			export result := conn.m('users').desc('age').offset(1).find('name').map(r => r.name)
+		`, []interface{}{"b", "a"}},
+		{"LimitAndOffset", `
+			export result := conn.m('users').asc('id').limit(1).offset(1).find('name').map(r => r.name)
+		`, []interface{}{"b"}},
+		{"UpsertReturning", `
+			export result := conn.m('users').returning('age').upsert([{name: 'a', age: 11}, {name: 'd', age: 40}], 'name').map(r => r.age)
+		`, []interface{}{int64(11), int64(40)}},
+		{"Update", `
+			export result := [conn.m('users', {name: 'b'}).update({age: 21}), conn.m('users', {name: 'b'}).findOne().age]
+		`, []interface{}{int64(1), int64(21)}},
+		{"RejectLimitedUpdate", `
+			rejected := false
+			try {
+				conn.m('users').limit(1).update({age: 1})
+			} catch (e) {
+				rejected = true
+			}
+			export result := rejected
+		`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testDbSQLiteSetup + tc.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
@@ -0,0 +1,732 @@
+package builtin_libs
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	dnsResolver struct {
+		server    string
+		timeout   time.Duration
+		retries   int
+		tcp       bool
+		recursion bool
+	}
+	dnsServer struct {
+		// base 是启动服务时脚本上下文的快照，查询协程都从它复制，不与脚本主线程竞争
+		base     *Context
+		handler  Value
+		udpConn  net.PacketConn
+		listener net.Listener
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		closed   bool
+	}
+)
+
+var (
+	dnsResolverClass ValueType
+	dnsServerClass   ValueType
+	dnsTypes         = map[string]dnsmessage.Type{
+		"A":     dnsmessage.TypeA,
+		"NS":    dnsmessage.TypeNS,
+		"CNAME": dnsmessage.TypeCNAME,
+		"SOA":   dnsmessage.TypeSOA,
+		"PTR":   dnsmessage.TypePTR,
+		"MX":    dnsmessage.TypeMX,
+		"TXT":   dnsmessage.TypeTXT,
+		"AAAA":  dnsmessage.TypeAAAA,
+		"SRV":   dnsmessage.TypeSRV,
+		"OPT":   dnsmessage.TypeOPT,
+		"ANY":   dnsmessage.TypeALL,
+	}
+	dnsRCodes = map[string]dnsmessage.RCode{
+		"NOERROR":  dnsmessage.RCodeSuccess,
+		"FORMERR":  dnsmessage.RCodeFormatError,
+		"SERVFAIL": dnsmessage.RCodeServerFailure,
+		"NXDOMAIN": dnsmessage.RCodeNameError,
+		"NOTIMP":   dnsmessage.RCodeNotImplemented,
+		"REFUSED":  dnsmessage.RCodeRefused,
+	}
+)
+
+func libDns(c *Context) ValueObject {
+	lib := NewObject()
+	lib.SetMember("Resolver", dnsResolverClass, c)
+	lib.SetMember("Server", dnsServerClass, c)
+	lib.SetMember("lookup", NewNativeFunction("lookup", func(c *Context, this Value, args []Value) Value {
+		var (
+			name    ValueStr
+			qtype   ValueStr
+			options ValueObject
+		)
+		EnsureFuncParams(c, "dns.lookup", args,
+			ArgRuleRequired("name", TypeStr, &name),
+			ArgRuleOptional("type", TypeStr, &qtype, NewStr("A")),
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		return newDnsResolver(c, options).lookup(c, name.Value(), qtype.Value())
+	}, "name", "type", "options"), c)
+	lib.SetMember("resolve", NewNativeFunction("resolve", func(c *Context, this Value, args []Value) Value {
+		var (
+			name    ValueStr
+			qtype   ValueStr
+			options ValueObject
+		)
+		EnsureFuncParams(c, "dns.resolve", args,
+			ArgRuleRequired("name", TypeStr, &name),
+			ArgRuleOptional("type", TypeStr, &qtype, NewStr("A")),
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		return newDnsResolver(c, options).resolve(c, name.Value(), qtype.Value())
+	}, "name", "type", "options"), c)
+	lib.SetMember("serve", NewNativeFunction("serve", func(c *Context, this Value, args []Value) Value {
+		var (
+			addr    ValueStr
+			handler ValueCallable
+		)
+		EnsureFuncParams(c, "dns.serve", args,
+			ArgRuleRequired("addr", TypeStr, &addr),
+			ArgRuleRequired("handler", TypeCallable, &handler),
+		)
+		server := &dnsServer{handler: handler}
+		server.start(c, addr.Value())
+		server.wg.Wait()
+		return Undefined()
+	}, "addr", "handler"), c)
+	return lib
+}
+
+// dnsDefaultServer 取/etc/resolv.conf中的第一个nameserver
+func dnsDefaultServer() string {
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "8.8.8.8:53"
+}
+
+func newDnsResolver(c *Context, options ValueObject) *dnsResolver {
+	r := &dnsResolver{
+		timeout:   2 * time.Second,
+		retries:   2,
+		recursion: true,
+	}
+	if server, ok := options.GetMember("server", c).(ValueStr); ok {
+		r.server = server.Value()
+		if _, _, err := net.SplitHostPort(r.server); err != nil {
+			r.server = net.JoinHostPort(r.server, "53")
+		}
+	} else {
+		r.server = dnsDefaultServer()
+	}
+	if d := httpOptionDuration(c, options, "timeout"); d > 0 {
+		r.timeout = d
+	}
+	if retries, ok := options.GetMember("retries", c).(ValueInt); ok {
+		r.retries = retries.AsInt()
+	}
+	if tcp, ok := options.GetMember("tcp", c).(ValueBool); ok {
+		r.tcp = tcp.Value()
+	}
+	if recursion, ok := options.GetMember("recursion", c).(ValueBool); ok {
+		r.recursion = recursion.Value()
+	}
+	return r
+}
+
+func dnsParseType(c *Context, name string) dnsmessage.Type {
+	if t, found := dnsTypes[strings.ToUpper(name)]; found {
+		return t
+	}
+	c.RaiseRuntimeError("dns: unsupported record type %s", name)
+	return 0
+}
+
+func dnsTypeName(t dnsmessage.Type) string {
+	for name, tt := range dnsTypes {
+		if tt == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func dnsRCodeName(r dnsmessage.RCode) string {
+	for name, rr := range dnsRCodes {
+		if rr == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("RCODE%d", r)
+}
+
+func dnsName(c *Context, name string) dnsmessage.Name {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		c.RaiseRuntimeError("dns: invalid name %s: %s", name, err)
+	}
+	return n
+}
+
+// dnsReverseName 把IP转换为PTR查询用的in-addr.arpa/ip6.arpa域名
+func dnsReverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0])
+	}
+	var sb strings.Builder
+	digits := hex.EncodeToString(ip.To16())
+	for i := len(digits) - 1; i >= 0; i-- {
+		sb.WriteByte(digits[i])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
+
+func (r *dnsResolver) query(c *Context, name, qtype string) *dnsmessage.Message {
+	t := dnsParseType(c, qtype)
+	if ip := net.ParseIP(name); ip != nil && t == dnsmessage.TypePTR {
+		name = dnsReverseName(ip)
+	}
+	req := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: r.recursion},
+		Questions: []dnsmessage.Question{
+			{Name: dnsName(c, name), Type: t, Class: dnsmessage.ClassINET},
+		},
+	}
+	pkg, err := req.Pack()
+	if err != nil {
+		c.RaiseRuntimeError("dns.lookup: pack query error %s", err)
+	}
+	var resp []byte
+	if !r.tcp {
+		resp = r.exchangeUDP(c, pkg)
+	}
+	msg := &dnsmessage.Message{}
+	if resp != nil {
+		if err := msg.Unpack(resp); err != nil {
+			c.RaiseRuntimeError("dns.lookup: invalid response %s", err)
+		}
+	}
+	// 响应被截断时改用tcp重新查询
+	if resp == nil || msg.Truncated {
+		if err := msg.Unpack(r.exchangeTCP(c, pkg)); err != nil {
+			c.RaiseRuntimeError("dns.lookup: invalid response %s", err)
+		}
+	}
+	if msg.ID != req.ID {
+		c.RaiseRuntimeError("dns.lookup: response id mismatch")
+	}
+	return msg
+}
+
+func (r *dnsResolver) exchangeUDP(c *Context, pkg []byte) []byte {
+	raddr, err := net.ResolveUDPAddr("udp", r.server)
+	if err != nil {
+		c.RaiseRuntimeError("dns.lookup: resolve server %s error %s", r.server, err)
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		c.RaiseRuntimeError("dns.lookup: %s", err)
+	}
+	defer conn.Close()
+	rv, ok := udpExchange(c, conn, raddr, pkg, r.timeout, r.retries).(ValueArray)
+	if !ok {
+		c.RaiseRuntimeError("dns.lookup: query %s timeout", r.server)
+	}
+	return rv.GetIndex(0, c).(ValueBytes).Value()
+}
+
+func (r *dnsResolver) exchangeTCP(c *Context, pkg []byte) []byte {
+	conn, err := net.DialTimeout("tcp", r.server, r.timeout)
+	if err != nil {
+		c.RaiseRuntimeError("dns.lookup: connect %s error %s", r.server, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+	framed := newTcpFramed(c, &tcpConnReserved{conn: conn}, "u16be", nil, 1<<16-1)
+	if err := framed.writeFrame(pkg); err != nil {
+		c.RaiseRuntimeError("dns.lookup: send query error %s", err)
+	}
+	resp, err := framed.readFrame()
+	if err != nil {
+		c.RaiseRuntimeError("dns.lookup: read response error %s", err)
+	}
+	return resp
+}
+
+func (r *dnsResolver) lookup(c *Context, name, qtype string) Value {
+	msg := r.query(c, name, qtype)
+	rv := NewObject()
+	rv.SetMember("id", NewInt(int64(msg.ID)), c)
+	rv.SetMember("rcode", NewStr(dnsRCodeName(msg.RCode)), c)
+	rv.SetMember("authoritative", NewBool(msg.Authoritative), c)
+	rv.SetMember("truncated", NewBool(msg.Truncated), c)
+	rv.SetMember("recursionAvailable", NewBool(msg.RecursionAvailable), c)
+	rv.SetMember("server", NewStr(r.server), c)
+	questions := NewArray(len(msg.Questions))
+	for _, q := range msg.Questions {
+		qv := NewObject()
+		qv.SetMember("name", NewStr(q.Name.String()), c)
+		qv.SetMember("type", NewStr(dnsTypeName(q.Type)), c)
+		questions.PushBack(qv)
+	}
+	rv.SetMember("questions", questions, c)
+	rv.SetMember("answers", dnsResourcesToValue(c, msg.Answers), c)
+	rv.SetMember("authorities", dnsResourcesToValue(c, msg.Authorities), c)
+	rv.SetMember("additionals", dnsResourcesToValue(c, msg.Additionals), c)
+	return rv
+}
+
+// resolve 只返回与查询类型相同的记录值，NXDOMAIN时返回空数组
+func (r *dnsResolver) resolve(c *Context, name, qtype string) Value {
+	msg := r.query(c, name, qtype)
+	if msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError {
+		c.RaiseRuntimeError("dns.resolve: %s %s", name, dnsRCodeName(msg.RCode))
+	}
+	t := dnsParseType(c, qtype)
+	rv := NewArray()
+	for _, res := range msg.Answers {
+		if res.Header.Type == t || t == dnsmessage.TypeALL {
+			rv.PushBack(dnsResourceToValue(c, res).GetMember("value", c))
+		}
+	}
+	return rv
+}
+
+func dnsResourcesToValue(c *Context, resources []dnsmessage.Resource) Value {
+	rv := NewArray(len(resources))
+	for _, res := range resources {
+		rv.PushBack(dnsResourceToValue(c, res))
+	}
+	return rv
+}
+
+// dnsResourceToValue 转换一条记录，value为记录的主要内容，其余字段因类型而异
+func dnsResourceToValue(c *Context, res dnsmessage.Resource) ValueObject {
+	rv := NewObject()
+	rv.SetMember("name", NewStr(res.Header.Name.String()), c)
+	rv.SetMember("type", NewStr(dnsTypeName(res.Header.Type)), c)
+	rv.SetMember("ttl", NewInt(int64(res.Header.TTL)), c)
+	var value Value
+	switch body := res.Body.(type) {
+	case *dnsmessage.AResource:
+		value = NewStr(net.IP(body.A[:]).String())
+		rv.SetMember("address", value, c)
+	case *dnsmessage.AAAAResource:
+		value = NewStr(net.IP(body.AAAA[:]).String())
+		rv.SetMember("address", value, c)
+	case *dnsmessage.CNAMEResource:
+		value = NewStr(body.CNAME.String())
+		rv.SetMember("target", value, c)
+	case *dnsmessage.NSResource:
+		value = NewStr(body.NS.String())
+		rv.SetMember("ns", value, c)
+	case *dnsmessage.PTRResource:
+		value = NewStr(body.PTR.String())
+		rv.SetMember("ptr", value, c)
+	case *dnsmessage.MXResource:
+		value = NewStr(body.MX.String())
+		rv.SetMember("preference", NewInt(int64(body.Pref)), c)
+		rv.SetMember("mx", value, c)
+	case *dnsmessage.TXTResource:
+		txt := NewArray(len(body.TXT))
+		for _, s := range body.TXT {
+			txt.PushBack(NewStr(s))
+		}
+		value = NewStr(strings.Join(body.TXT, ""))
+		rv.SetMember("txt", txt, c)
+	case *dnsmessage.SRVResource:
+		value = NewStr(fmt.Sprintf("%s:%d", body.Target, body.Port))
+		rv.SetMember("priority", NewInt(int64(body.Priority)), c)
+		rv.SetMember("weight", NewInt(int64(body.Weight)), c)
+		rv.SetMember("port", NewInt(int64(body.Port)), c)
+		rv.SetMember("target", NewStr(body.Target.String()), c)
+	case *dnsmessage.SOAResource:
+		value = NewStr(body.NS.String())
+		rv.SetMember("ns", value, c)
+		rv.SetMember("mbox", NewStr(body.MBox.String()), c)
+		rv.SetMember("serial", NewInt(int64(body.Serial)), c)
+		rv.SetMember("refresh", NewInt(int64(body.Refresh)), c)
+		rv.SetMember("retry", NewInt(int64(body.Retry)), c)
+		rv.SetMember("expire", NewInt(int64(body.Expire)), c)
+		rv.SetMember("minTTL", NewInt(int64(body.MinTTL)), c)
+	case *dnsmessage.UnknownResource:
+		value = NewBytes(body.Data)
+	default:
+		value = NewStr(res.Body.GoString())
+	}
+	rv.SetMember("value", value, c)
+	return rv
+}
+
+// dnsResourceFromValue 把处理函数返回的记录转换为dns记录，字符串视为查询类型的记录值
+func dnsResourceFromValue(c *Context, q dnsmessage.Question, v Value) dnsmessage.Resource {
+	res := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60},
+	}
+	obj, isObj := v.(ValueObject)
+	if !isObj {
+		obj = NewObject()
+		obj.SetMember("value", v, c)
+	}
+	if name, ok := obj.GetMember("name", c).(ValueStr); ok {
+		res.Header.Name = dnsName(c, name.Value())
+	}
+	if t, ok := obj.GetMember("type", c).(ValueStr); ok {
+		res.Header.Type = dnsParseType(c, t.Value())
+	}
+	if ttl, ok := obj.GetMember("ttl", c).(ValueInt); ok {
+		res.Header.TTL = uint32(ttl.Value())
+	}
+	field := func(names ...string) Value {
+		for _, name := range names {
+			if v := obj.GetMember(name, c); !IsUndefined(v) {
+				return v
+			}
+		}
+		c.RaiseRuntimeError("dns: %s record requires %s", dnsTypeName(res.Header.Type), names[0])
+		return nil
+	}
+	intField := func(name string) uint16 {
+		if v, ok := obj.GetMember(name, c).(ValueInt); ok {
+			return uint16(v.Value())
+		}
+		return 0
+	}
+	ip := func(v Value) net.IP {
+		ip := net.ParseIP(v.ToString(c))
+		if ip == nil {
+			c.RaiseRuntimeError("dns: invalid address %s", v.ToString(c))
+		}
+		return ip
+	}
+	switch res.Header.Type {
+	case dnsmessage.TypeA:
+		addr := ip(field("address", "value")).To4()
+		if addr == nil {
+			c.RaiseRuntimeError("dns: A record requires an ipv4 address")
+		}
+		body := &dnsmessage.AResource{}
+		copy(body.A[:], addr)
+		res.Body = body
+	case dnsmessage.TypeAAAA:
+		body := &dnsmessage.AAAAResource{}
+		copy(body.AAAA[:], ip(field("address", "value")).To16())
+		res.Body = body
+	case dnsmessage.TypeCNAME:
+		res.Body = &dnsmessage.CNAMEResource{CNAME: dnsName(c, field("target", "value").ToString(c))}
+	case dnsmessage.TypeNS:
+		res.Body = &dnsmessage.NSResource{NS: dnsName(c, field("ns", "value").ToString(c))}
+	case dnsmessage.TypePTR:
+		res.Body = &dnsmessage.PTRResource{PTR: dnsName(c, field("ptr", "value").ToString(c))}
+	case dnsmessage.TypeMX:
+		res.Body = &dnsmessage.MXResource{Pref: intField("preference"), MX: dnsName(c, field("mx", "value").ToString(c))}
+	case dnsmessage.TypeTXT:
+		body := &dnsmessage.TXTResource{}
+		switch txt := field("txt", "value").(type) {
+		case ValueArray:
+			for i := 0; i < txt.Len(); i++ {
+				body.TXT = append(body.TXT, txt.GetIndex(i, c).ToString(c))
+			}
+		default:
+			// 单个字符串最长255字节，超出时拆分
+			s := txt.ToString(c)
+			for len(s) > 255 {
+				body.TXT = append(body.TXT, s[:255])
+				s = s[255:]
+			}
+			body.TXT = append(body.TXT, s)
+		}
+		res.Body = body
+	case dnsmessage.TypeSRV:
+		res.Body = &dnsmessage.SRVResource{
+			Priority: intField("priority"),
+			Weight:   intField("weight"),
+			Port:     intField("port"),
+			Target:   dnsName(c, field("target", "value").ToString(c)),
+		}
+	case dnsmessage.TypeSOA:
+		u32 := func(name string) uint32 {
+			if v, ok := obj.GetMember(name, c).(ValueInt); ok {
+				return uint32(v.Value())
+			}
+			return 0
+		}
+		res.Body = &dnsmessage.SOAResource{
+			NS:      dnsName(c, field("ns", "value").ToString(c)),
+			MBox:    dnsName(c, field("mbox").ToString(c)),
+			Serial:  u32("serial"),
+			Refresh: u32("refresh"),
+			Retry:   u32("retry"),
+			Expire:  u32("expire"),
+			MinTTL:  u32("minTTL"),
+		}
+	default:
+		c.RaiseRuntimeError("dns: cannot answer %s records", dnsTypeName(res.Header.Type))
+	}
+	return res
+}
+
+func dnsResourcesFromValue(c *Context, q dnsmessage.Question, v Value) []dnsmessage.Resource {
+	arr, ok := v.(ValueArray)
+	if !ok {
+		if IsUndefined(v) {
+			return nil
+		}
+		arr = NewArrayByValues(v)
+	}
+	rv := make([]dnsmessage.Resource, 0, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		rv = append(rv, dnsResourceFromValue(c, q, arr.GetIndex(i, c)))
+	}
+	return rv
+}
+
+func (s *dnsServer) start(c *Context, addr string) string {
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		c.RaiseRuntimeError("dns.Server: listen udp %s error %s", addr, err)
+	}
+	// 使用与udp相同的端口，监听端口为0时也能对上
+	tcpAddr := udpConn.LocalAddr().String()
+	listener, err := net.Listen("tcp", tcpAddr)
+	if err != nil {
+		udpConn.Close()
+		c.RaiseRuntimeError("dns.Server: listen tcp %s error %s", tcpAddr, err)
+	}
+	s.udpConn, s.listener = udpConn, listener
+	s.base = c.Clone()
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return tcpAddr
+}
+
+func (s *dnsServer) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.udpConn == nil {
+		return
+	}
+	s.closed = true
+	s.udpConn.Close()
+	s.listener.Close()
+}
+
+func (s *dnsServer) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, from, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.handle(req, from, "udp"); resp != nil {
+				s.udpConn.WriteTo(resp, from)
+			}
+		}()
+	}
+}
+
+func (s *dnsServer) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			framed := newTcpFramed(s.base, &tcpConnReserved{conn: conn}, "u16be", nil, 1<<16-1)
+			for {
+				conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+				req, err := framed.readFrame()
+				if err != nil {
+					return
+				}
+				resp := s.handle(req, conn.RemoteAddr(), "tcp")
+				if resp == nil || framed.writeFrame(resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle 调用处理函数生成响应。处理函数返回nil时回复NXDOMAIN，抛出异常时回复SERVFAIL
+func (s *dnsServer) handle(pkg []byte, from net.Addr, protocol string) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(pkg); err != nil {
+		return nil
+	}
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               req.ID,
+			Response:         true,
+			OpCode:           req.OpCode,
+			RecursionDesired: req.RecursionDesired,
+		},
+		Questions: req.Questions,
+	}
+	udpSize := 512
+	for _, res := range req.Additionals {
+		if res.Header.Type == dnsmessage.TypeOPT {
+			udpSize = max(udpSize, int(res.Header.Class))
+		}
+	}
+	if len(req.Questions) != 1 {
+		resp.RCode = dnsmessage.RCodeFormatError
+	} else {
+		s.answer(&resp, req.Questions[0], from, protocol)
+	}
+	out, err := resp.Pack()
+	if err != nil {
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		resp.RCode = dnsmessage.RCodeServerFailure
+		out, _ = resp.Pack()
+	}
+	if protocol == "udp" && len(out) > udpSize {
+		resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+		resp.Truncated = true
+		out, _ = resp.Pack()
+	}
+	return out
+}
+
+func (s *dnsServer) answer(resp *dnsmessage.Message, q dnsmessage.Question, from net.Addr, protocol string) {
+	c := s.base.CloneWithContext(s.base.Ctx)
+	defer func() {
+		if e := recover(); e != nil {
+			if ex, ok := e.(Exception); ok {
+				fmt.Fprint(c.Stderr, ex.MessageWithStack())
+			} else {
+				fmt.Fprintf(c.Stderr, "dns.Server: handler error %v\n", e)
+			}
+			resp.Answers, resp.Authorities, resp.Additionals = nil, nil, nil
+			resp.RCode = dnsmessage.RCodeServerFailure
+		}
+	}()
+	query := NewObject()
+	query.SetMember("id", NewInt(int64(resp.ID)), c)
+	query.SetMember("name", NewStr(q.Name.String()), c)
+	query.SetMember("type", NewStr(dnsTypeName(q.Type)), c)
+	query.SetMember("remote", NewStr(from.String()), c)
+	query.SetMember("protocol", NewStr(protocol), c)
+	c.Invoke(s.handler, nil, Args(query))
+	rv := c.RetVal
+	switch r := rv.(type) {
+	case ValueNil, ValueUndefined:
+		resp.RCode = dnsmessage.RCodeNameError
+	case ValueObject:
+		if IsUndefined(r.GetMember("answers", c)) && IsUndefined(r.GetMember("rcode", c)) {
+			resp.Answers = dnsResourcesFromValue(c, q, r)
+			break
+		}
+		if rcode, ok := r.GetMember("rcode", c).(ValueStr); ok {
+			code, found := dnsRCodes[strings.ToUpper(rcode.Value())]
+			if !found {
+				c.RaiseRuntimeError("dns.Server: unknown rcode %s", rcode.Value())
+			}
+			resp.RCode = code
+		}
+		resp.Authoritative = r.GetMember("authoritative", c).IsTrue()
+		resp.Answers = dnsResourcesFromValue(c, q, r.GetMember("answers", c))
+		resp.Authorities = dnsResourcesFromValue(c, q, r.GetMember("authorities", c))
+		resp.Additionals = dnsResourcesFromValue(c, q, r.GetMember("additionals", c))
+	default:
+		resp.Answers = dnsResourcesFromValue(c, q, r)
+	}
+}
+
+func initDnsResolverClass() ValueType {
+	return NewClassBuilder("Resolver").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var options ValueObject
+			EnsureFuncParams(c, "dns.Resolver.__init__", args,
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			)
+			this.Reserved = newDnsResolver(c, options)
+		}).
+		Method("lookup", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				name  ValueStr
+				qtype ValueStr
+			)
+			EnsureFuncParams(c, "dns.Resolver.lookup", args,
+				ArgRuleRequired("name", TypeStr, &name),
+				ArgRuleOptional("type", TypeStr, &qtype, NewStr("A")),
+			)
+			return this.Reserved.(*dnsResolver).lookup(c, name.Value(), qtype.Value())
+		}, "name", "type").
+		Method("resolve", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				name  ValueStr
+				qtype ValueStr
+			)
+			EnsureFuncParams(c, "dns.Resolver.resolve", args,
+				ArgRuleRequired("name", TypeStr, &name),
+				ArgRuleOptional("type", TypeStr, &qtype, NewStr("A")),
+			)
+			return this.Reserved.(*dnsResolver).resolve(c, name.Value(), qtype.Value())
+		}, "name", "type").
+		Build()
+}
+
+func initDnsServerClass() ValueType {
+	return NewClassBuilder("Server").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var handler ValueCallable
+			EnsureFuncParams(c, "dns.Server.__init__", args,
+				ArgRuleRequired("handler", TypeCallable, &handler),
+			)
+			this.Reserved = &dnsServer{handler: handler}
+		}).
+		Method("start", func(c *Context, this ValueObject, args []Value) Value {
+			var addr ValueStr
+			EnsureFuncParams(c, "dns.Server.start", args, ArgRuleRequired("addr", TypeStr, &addr))
+			return NewStr(this.Reserved.(*dnsServer).start(c, addr.Value()))
+		}, "addr").
+		Method("serve", func(c *Context, this ValueObject, args []Value) Value {
+			var addr ValueStr
+			EnsureFuncParams(c, "dns.Server.serve", args, ArgRuleRequired("addr", TypeStr, &addr))
+			s := this.Reserved.(*dnsServer)
+			s.start(c, addr.Value())
+			s.wg.Wait()
+			return Undefined()
+		}, "addr").
+		Method("stop", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*dnsServer).stop()
+			return Undefined()
+		}).
+		Build()
+}
+
+func init() {
+	dnsResolverClass = initDnsResolverClass()
+	dnsServerClass = initDnsServerClass()
+}
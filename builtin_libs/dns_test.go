@@ -0,0 +1,63 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testDnsSetup = `
+dns := import('dns')
+srv := dns.Server(q => when q.type {
+	'A' -> q.name == 'web.test.' ? ['10.0.0.1', {address: '10.0.0.2', ttl: 30}] : nil
+	'MX' -> [{preference: 10, mx: 'mail.test'}]
+	'TXT' -> range(0, 100).map(i => 'record ' + str(i))
+	else -> {rcode: 'REFUSED'}
+})
+opts := {server: srv.start('127.0.0.1:0'), timeout: 1}
+`
+
+const testDnsTeardown = `
+srv.stop()
+`
+
+func TestDnsLookupAndServe(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Answers", `
+			r := dns.lookup('web.test', 'A', opts)
+			export result := [r.rcode, r.answers[1].address, r.answers[1].ttl]
+		`, []interface{}{"NOERROR", "10.0.0.2", int64(30)}},
+		{"NilIsNxdomain", `
+			export result := dns.lookup('none.test', 'A', opts).rcode
+		`, "NXDOMAIN"},
+		{"MxRecord", `
+			mx := dns.lookup('web.test', 'MX', opts).answers[0]
+			export result := [mx.preference, mx.mx]
+		`, []interface{}{int64(10), "mail.test."}},
+		{"Rcode", `
+			export result := dns.lookup('web.test', 'NS', opts).rcode
+		`, "REFUSED"},
+		{"TruncatedRetriesTcp", `
+			export result := len(dns.resolve('web.test', 'TXT', opts))
+		`, int64(100)},
+		{"TcpResolver", `
+			export result := dns.Resolver({server: opts.server, tcp: true}).resolve('web.test')
+		`, []interface{}{"10.0.0.1", "10.0.0.2"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testDnsSetup + tc.code + testDnsTeardown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package builtin_libs
 
 import (
 	"reflect"
+	"sort"
 
 	"github.com/modern-go/reflect2"
 	. "github.com/zgg-lang/zgg-go/runtime"
@@ -157,6 +158,41 @@ func libGo(c *Context) ValueObject {
 		}
 		return NewGoType(tt)
 	}), c)
+	lib.SetMember("implement", NewNativeFunction("go.implement", func(c *Context, this Value, args []Value) Value {
+		var (
+			obj       Value
+			ifaceType reflect.Type
+		)
+		EnsureFuncParams(c, "go.implement", args,
+			ArgRuleRequired("obj", TypeAny, &obj),
+			NewOneOfHelper("interface").
+				On(TypeGoType, func(v Value) {
+					ifaceType = Unbound(v).(GoType).GoType()
+				}).
+				On(TypeStr, func(v Value) {
+					name := v.(ValueStr).Value()
+					if t, found := FindGoInterface(name); found {
+						ifaceType = t
+					} else {
+						c.RaiseRuntimeError("go.implement: go interface %s is not registered", name)
+					}
+				}),
+		)
+		rv, err := ImplementGoInterface(c, obj, ifaceType)
+		if err != nil {
+			c.RaiseRuntimeError("go.implement: %s", err)
+		}
+		return NewReflectedGoValue(rv)
+	}, "obj", "interface"), c)
+	lib.SetMember("interfaces", NewNativeFunction("go.interfaces", func(c *Context, this Value, args []Value) Value {
+		names := GoInterfaceNames()
+		sort.Strings(names)
+		rv := NewArray(len(names))
+		for _, name := range names {
+			rv.PushBack(NewStr(name))
+		}
+		return rv
+	}), c)
 	lib.SetMember("convert", NewNativeFunction("go.convert", func(c *Context, this Value, args []Value) Value {
 		var (
 			src        Value
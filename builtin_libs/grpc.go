@@ -0,0 +1,996 @@
+package builtin_libs
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// grpcDescriptorSource 服务描述的来源，可以是加载的proto文件或服务端反射
+	grpcDescriptorSource interface {
+		findService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error)
+		listServices(ctx context.Context) ([]string, error)
+	}
+	// grpcProtos grpc.load加载的proto文件
+	grpcProtos struct {
+		files *protoregistry.Files
+	}
+	// grpcReflectionSource 通过服务端反射获取服务描述，获取到的文件会缓存下来
+	grpcReflectionSource struct {
+		conn   *grpc.ClientConn
+		mu     sync.Mutex
+		protos map[string]*descriptorpb.FileDescriptorProto
+		files  *protoregistry.Files
+	}
+	grpcConn struct {
+		conn     *grpc.ClientConn
+		source   grpcDescriptorSource
+		metadata metadata.MD
+		timeout  time.Duration
+		mu       sync.Mutex
+		stubs    map[string]ValueObject
+	}
+	// grpcClientStream 客户端的流式调用
+	grpcClientStream struct {
+		stream grpc.ClientStream
+		method protoreflect.MethodDescriptor
+		cancel context.CancelFunc
+		done   bool
+	}
+	// grpcStatusError 带有grpc状态码的异常，服务端处理函数抛出时原样返回给客户端
+	grpcStatusError struct {
+		*RuntimeError
+		status *status.Status
+	}
+)
+
+var (
+	grpcProtosClass       ValueType
+	grpcConnClass         ValueType
+	grpcClientStreamClass ValueType
+	grpcServerClass       ValueType
+	grpcServerCallClass   ValueType
+	grpcCodeNames         = map[string]codes.Code{}
+	grpcStatusPattern     = regexp.MustCompile(`rpc error: code = (\w+) desc = ((?s).*)`)
+)
+
+func libGrpc(c *Context) ValueObject {
+	lib := NewObject()
+	codesObj := NewObject()
+	for name, code := range grpcCodeNames {
+		codesObj.SetMember(name, NewInt(int64(code)), c)
+	}
+	lib.SetMember("codes", codesObj, c)
+	lib.SetMember("load", NewNativeFunction("load", func(c *Context, this Value, args []Value) Value {
+		var (
+			files   []string
+			options ValueObject
+		)
+		for i, arg := range args {
+			switch v := arg.(type) {
+			case ValueStr:
+				files = append(files, v.Value())
+			case ValueArray:
+				for j := 0; j < v.Len(); j++ {
+					files = append(files, v.GetIndex(j, c).ToString(c))
+				}
+			case ValueObject:
+				if i == len(args)-1 {
+					options = v
+					continue
+				}
+				c.RaiseRuntimeError("grpc.load: invalid argument %d", i)
+			default:
+				c.RaiseRuntimeError("grpc.load: invalid argument %d", i)
+			}
+		}
+		if len(files) == 0 {
+			c.RaiseRuntimeError("grpc.load: requires at least one proto file")
+		}
+		var importPaths []string
+		if options != nil {
+			if paths, ok := options.GetMember("importPaths", c).(ValueArray); ok {
+				for i := 0; i < paths.Len(); i++ {
+					importPaths = append(importPaths, paths.GetIndex(i, c).ToString(c))
+				}
+			}
+		}
+		protos := &grpcProtos{files: &protoregistry.Files{}}
+		if len(importPaths) > 0 {
+			protos.compile(c, &protocompile.SourceResolver{ImportPaths: importPaths}, files)
+		} else {
+			// 未指定importPaths时，以各proto文件所在目录为import路径
+			for _, file := range files {
+				protos.compile(c, &protocompile.SourceResolver{ImportPaths: []string{filepath.Dir(file)}}, []string{filepath.Base(file)})
+			}
+		}
+		return NewObjectAndInit(grpcProtosClass, c, NewGoValue(protos))
+	}, "files", "options"), nil)
+	lib.SetMember("parse", NewNativeFunction("parse", func(c *Context, this Value, args []Value) Value {
+		var (
+			source ValueStr
+			name   ValueStr
+		)
+		EnsureFuncParams(c, "grpc.parse", args,
+			ArgRuleRequired("source", TypeStr, &source),
+			ArgRuleOptional("name", TypeStr, &name, NewStr("source.proto")),
+		)
+		protos := &grpcProtos{files: &protoregistry.Files{}}
+		resolver := &protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{name.Value(): source.Value()}),
+		}
+		protos.compile(c, resolver, []string{name.Value()})
+		return NewObjectAndInit(grpcProtosClass, c, NewGoValue(protos))
+	}, "source", "name"), nil)
+	lib.SetMember("dial", NewNativeFunction("dial", func(c *Context, this Value, args []Value) Value {
+		var (
+			addr    ValueStr
+			options ValueObject
+		)
+		EnsureFuncParams(c, "grpc.dial", args,
+			ArgRuleRequired("addr", TypeStr, &addr),
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		return NewObjectAndInit(grpcConnClass, c, NewGoValue(grpcDial(c, addr.Value(), options)))
+	}, "addr", "options"), nil)
+	lib.SetMember("server", NewNativeFunction("server", func(c *Context, this Value, args []Value) Value {
+		var options ValueObject
+		EnsureFuncParams(c, "grpc.server", args,
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		return NewObjectAndInit(grpcServerClass, c, options)
+	}, "options"), nil)
+	lib.SetMember("error", NewNativeFunction("error", func(c *Context, this Value, args []Value) Value {
+		var message ValueStr
+		if len(args) < 1 {
+			c.RaiseRuntimeError("grpc.error requires at least 1 argument")
+		}
+		EnsureFuncParams(c, "grpc.error", args[1:],
+			ArgRuleOptional("message", TypeStr, &message, NewStr("")),
+		)
+		grpcRaiseStatus(c, status.New(grpcCodeOf(c, args[0]), message.Value()))
+		return nil
+	}, "code", "message"), nil)
+	lib.SetMember("statusOf", NewNativeFunction("statusOf", func(c *Context, this Value, args []Value) Value {
+		if len(args) != 1 {
+			c.RaiseRuntimeError("grpc.statusOf requires 1 argument")
+		}
+		msg := args[0].ToString(c)
+		if obj, ok := args[0].(ValueObject); ok {
+			msg = obj.GetMember("message", c).ToString(c)
+		}
+		rv := NewObject()
+		code, desc := codes.Unknown, msg
+		if m := grpcStatusPattern.FindStringSubmatch(msg); m != nil {
+			if cc, found := grpcCodeNames[m[1]]; found {
+				code, desc = cc, m[2]
+			}
+		}
+		rv.SetMember("code", NewInt(int64(code)), c)
+		rv.SetMember("name", NewStr(code.String()), c)
+		rv.SetMember("message", NewStr(desc), c)
+		return rv
+	}, "error"), nil)
+	return lib
+}
+
+func grpcCodeOf(c *Context, v Value) codes.Code {
+	switch code := v.(type) {
+	case ValueInt:
+		return codes.Code(code.Value())
+	case ValueStr:
+		if cc, found := grpcCodeNames[code.Value()]; found {
+			return cc
+		}
+	}
+	c.RaiseRuntimeError("grpc: invalid status code %s", v.ToString(c))
+	return codes.Unknown
+}
+
+// grpcRaiseStatus 抛出带状态码的异常
+func grpcRaiseStatus(c *Context, st *status.Status) {
+	defer func() {
+		e := recover()
+		if re, ok := e.(*RuntimeError); ok {
+			panic(&grpcStatusError{RuntimeError: re, status: st})
+		}
+		panic(e)
+	}()
+	c.RaiseRuntimeError("%s", st.Err())
+}
+
+func grpcRaise(c *Context, what string, err error) {
+	if st, ok := status.FromError(err); ok {
+		grpcRaiseStatus(c, st)
+	}
+	c.RaiseRuntimeError("grpc: %s error %s", what, err)
+}
+
+func (p *grpcProtos) compile(c *Context, resolver protocompile.Resolver, files []string) {
+	compiler := protocompile.Compiler{
+		Resolver:       protocompile.WithStandardImports(resolver),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	compiled, err := compiler.Compile(c.Ctx, files...)
+	if err != nil {
+		c.RaiseRuntimeError("grpc: compile proto error %s", err)
+	}
+	for _, fd := range compiled {
+		p.register(fd)
+	}
+}
+
+// register 注册文件及其依赖，服务端反射需要能找到依赖的文件
+func (p *grpcProtos) register(fd protoreflect.FileDescriptor) {
+	if _, err := p.files.FindFileByPath(fd.Path()); err == nil {
+		return
+	}
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		p.register(imports.Get(i).FileDescriptor)
+	}
+	p.files.RegisterFile(fd)
+}
+
+func (p *grpcProtos) listServices(ctx context.Context) ([]string, error) {
+	var rv []string
+	p.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			rv = append(rv, string(services.Get(i).FullName()))
+		}
+		return true
+	})
+	return rv, nil
+}
+
+func (p *grpcProtos) findService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error) {
+	return grpcFindService(p.files, name)
+}
+
+// grpcFindService 按全名或不带包名的短名查找服务
+func grpcFindService(files *protoregistry.Files, name string) (protoreflect.ServiceDescriptor, error) {
+	if d, err := files.FindDescriptorByName(protoreflect.FullName(name)); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			return sd, nil
+		}
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+	var found []protoreflect.ServiceDescriptor
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		if sd := fd.Services().ByName(protoreflect.Name(name)); sd != nil {
+			found = append(found, sd)
+		}
+		return true
+	})
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("service %s not found", name)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("service name %s is ambiguous", name)
+}
+
+// reflect 调用服务端反射接口，优先使用v1，服务端不支持时使用v1alpha。两个版本的消息格式相同
+func (r *grpcReflectionSource) reflect(ctx context.Context, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	var lastErr error
+	for _, method := range []string{
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+	} {
+		stream, err := r.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.SendMsg(req); err != nil && err != io.EOF {
+			return nil, err
+		}
+		stream.CloseSend()
+		resp := &rpb.ServerReflectionResponse{}
+		err = stream.RecvMsg(resp)
+		if err == nil {
+			if errResp := resp.GetErrorResponse(); errResp != nil {
+				return nil, status.Error(codes.Code(errResp.ErrorCode), errResp.ErrorMessage)
+			}
+			return resp, nil
+		}
+		if status.Code(err) != codes.Unimplemented {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (r *grpcReflectionSource) listServices(ctx context.Context) ([]string, error) {
+	resp, err := r.reflect(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rv []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		rv = append(rv, s.Name)
+	}
+	return rv, nil
+}
+
+func (r *grpcReflectionSource) addFiles(resp *rpb.ServerReflectionResponse) error {
+	for _, bs := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fdp := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(bs, fdp); err != nil {
+			return err
+		}
+		r.protos[fdp.GetName()] = fdp
+	}
+	return nil
+}
+
+func (r *grpcReflectionSource) findService(ctx context.Context, name string) (protoreflect.ServiceDescriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.files != nil {
+		if sd, err := grpcFindService(r.files, name); err == nil {
+			return sd, nil
+		}
+	}
+	fullName := name
+	if !strings.Contains(name, ".") {
+		services, err := r.listServices(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range services {
+			if s == name || strings.HasSuffix(s, "."+name) {
+				fullName = s
+				break
+			}
+		}
+	}
+	resp, err := r.reflect(ctx, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: fullName},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.addFiles(resp); err != nil {
+		return nil, err
+	}
+	// 补齐服务端未一并返回的依赖
+	for {
+		var missing string
+		for _, fdp := range r.protos {
+			for _, dep := range fdp.GetDependency() {
+				if _, found := r.protos[dep]; !found {
+					missing = dep
+				}
+			}
+		}
+		if missing == "" {
+			break
+		}
+		resp, err := r.reflect(ctx, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: missing},
+		})
+		if err != nil {
+			return nil, err
+		}
+		before := len(r.protos)
+		if err := r.addFiles(resp); err != nil {
+			return nil, err
+		}
+		if len(r.protos) == before {
+			return nil, fmt.Errorf("file %s not found", missing)
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fdp := range r.protos {
+		set.File = append(set.File, fdp)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	r.files = files
+	return grpcFindService(files, fullName)
+}
+
+func grpcDial(c *Context, addr string, options ValueObject) *grpcConn {
+	creds := insecure.NewCredentials()
+	if tlsOpt, ok := options.GetMember("tls", c).(ValueObject); ok {
+		creds = credentials.NewTLS(tlsConfigFromOptions(c, "grpc.dial", tlsOpt, false))
+	} else if useTls, ok := options.GetMember("tls", c).(ValueBool); ok && useTls.Value() {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		c.RaiseRuntimeError("grpc.dial %s error %s", addr, err)
+	}
+	gc := &grpcConn{
+		conn:     conn,
+		metadata: grpcMetadata(c, options.GetMember("metadata", c)),
+		timeout:  httpOptionDuration(c, options, "timeout"),
+		stubs:    map[string]ValueObject{},
+	}
+	switch protos := options.GetMember("protos", c).(type) {
+	case ValueObject:
+		p, ok := protos.Reserved.(*grpcProtos)
+		if !ok {
+			c.RaiseRuntimeError("grpc.dial: protos must be loaded by grpc.load")
+		}
+		gc.source = p
+	default:
+		gc.source = &grpcReflectionSource{conn: conn, protos: map[string]*descriptorpb.FileDescriptorProto{}}
+	}
+	return gc
+}
+
+func grpcMetadata(c *Context, v Value) metadata.MD {
+	md := metadata.MD{}
+	if obj, ok := v.(ValueObject); ok {
+		obj.Iterate(func(key string, val Value) {
+			if arr, ok := val.(ValueArray); ok {
+				for i := 0; i < arr.Len(); i++ {
+					md.Append(key, arr.GetIndex(i, c).ToString(c))
+				}
+			} else {
+				md.Append(key, val.ToString(c))
+			}
+		})
+	}
+	return md
+}
+
+func grpcMetadataToValue(c *Context, md metadata.MD) Value {
+	rv := NewObject()
+	for key, values := range md {
+		if len(values) == 1 {
+			rv.SetMember(key, NewStr(values[0]), c)
+			continue
+		}
+		arr := NewArray(len(values))
+		for _, v := range values {
+			arr.PushBack(NewStr(v))
+		}
+		rv.SetMember(key, arr, c)
+	}
+	return rv
+}
+
+// stub 生成服务的调用对象，每个rpc方法对应一个函数
+func (gc *grpcConn) stub(c *Context, name string) ValueObject {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if stub, found := gc.stubs[name]; found {
+		return stub
+	}
+	sd, err := gc.source.findService(c.Ctx, name)
+	if err != nil {
+		grpcRaise(c, "find service "+name, err)
+	}
+	stub := NewObject()
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		stub.SetMember(string(md.Name()), NewNativeFunction(string(md.Name()), func(c *Context, this Value, args []Value) Value {
+			return gc.call(c, md, args)
+		}, "request", "options"), c)
+	}
+	gc.stubs[name] = stub
+	return stub
+}
+
+// callContext 为一次调用设置超时及metadata，options为调用时传入的{metadata, timeout}
+func (gc *grpcConn) callContext(c *Context, options Value) (context.Context, context.CancelFunc) {
+	md := gc.metadata.Copy()
+	timeout := gc.timeout
+	if opts, ok := options.(ValueObject); ok {
+		md = metadata.Join(md, grpcMetadata(c, opts.GetMember("metadata", c)))
+		if d := httpOptionDuration(c, opts, "timeout"); d > 0 {
+			timeout = d
+		}
+	}
+	ctx := metadata.NewOutgoingContext(c.Ctx, md)
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func grpcFullMethod(md protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+}
+
+func (gc *grpcConn) call(c *Context, md protoreflect.MethodDescriptor, args []Value) Value {
+	argAt := func(i int) Value {
+		if i < len(args) {
+			return args[i]
+		}
+		return Undefined()
+	}
+	fullMethod := grpcFullMethod(md)
+	switch {
+	case !md.IsStreamingClient() && !md.IsStreamingServer():
+		ctx, cancel := gc.callContext(c, argAt(1))
+		defer cancel()
+		req := grpcToMessage(c, argAt(0), md.Input())
+		resp := dynamicpb.NewMessage(md.Output())
+		if err := gc.conn.Invoke(ctx, fullMethod, req, resp); err != nil {
+			grpcRaise(c, "call "+fullMethod, err)
+		}
+		return grpcFromMessage(c, resp)
+	case !md.IsStreamingClient():
+		// 服务端流：发送请求后返回可迭代的流
+		ctx, cancel := gc.callContext(c, argAt(1))
+		s := gc.newStream(c, ctx, cancel, md)
+		if err := s.stream.SendMsg(grpcToMessage(c, argAt(0), md.Input())); err != nil {
+			cancel()
+			grpcRaise(c, "call "+fullMethod, err)
+		}
+		if err := s.stream.CloseSend(); err != nil {
+			cancel()
+			grpcRaise(c, "call "+fullMethod, err)
+		}
+		return NewObjectAndInit(grpcClientStreamClass, c, NewGoValue(s))
+	default:
+		// 客户端流及双向流：传入数组时发送全部消息，客户端流直接返回响应
+		msgs, isArray := argAt(0).(ValueArray)
+		options := argAt(0)
+		if isArray {
+			options = argAt(1)
+		}
+		ctx, cancel := gc.callContext(c, options)
+		s := gc.newStream(c, ctx, cancel, md)
+		stream := NewObjectAndInit(grpcClientStreamClass, c, NewGoValue(s))
+		if !isArray {
+			return stream
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			s.send(c, msgs.GetIndex(i, c))
+		}
+		if md.IsStreamingServer() {
+			if err := s.stream.CloseSend(); err != nil {
+				grpcRaise(c, "close send", err)
+			}
+			return stream
+		}
+		return s.closeAndRecv(c)
+	}
+}
+
+func (gc *grpcConn) newStream(c *Context, ctx context.Context, cancel context.CancelFunc, md protoreflect.MethodDescriptor) *grpcClientStream {
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+	stream, err := gc.conn.NewStream(ctx, desc, grpcFullMethod(md))
+	if err != nil {
+		cancel()
+		grpcRaise(c, "call "+grpcFullMethod(md), err)
+	}
+	return &grpcClientStream{stream: stream, method: md, cancel: cancel}
+}
+
+func (s *grpcClientStream) send(c *Context, msg Value) {
+	if err := s.stream.SendMsg(grpcToMessage(c, msg, s.method.Input())); err != nil {
+		if err == io.EOF {
+			// 服务端已结束调用，真正的错误由RecvMsg返回
+			resp := dynamicpb.NewMessage(s.method.Output())
+			err = s.stream.RecvMsg(resp)
+		}
+		s.finish()
+		grpcRaise(c, "send", err)
+	}
+}
+
+// recv 接收下一条消息，流结束时返回nil
+func (s *grpcClientStream) recv(c *Context) Value {
+	if s.done {
+		return nil
+	}
+	resp := dynamicpb.NewMessage(s.method.Output())
+	if err := s.stream.RecvMsg(resp); err != nil {
+		s.finish()
+		if err == io.EOF {
+			return nil
+		}
+		grpcRaise(c, "recv", err)
+	}
+	return grpcFromMessage(c, resp)
+}
+
+func (s *grpcClientStream) closeAndRecv(c *Context) Value {
+	if err := s.stream.CloseSend(); err != nil {
+		grpcRaise(c, "close send", err)
+	}
+	resp := s.recv(c)
+	if resp == nil {
+		c.RaiseRuntimeError("grpc: no response received")
+	}
+	s.finish()
+	return resp
+}
+
+func (s *grpcClientStream) finish() {
+	s.done = true
+	s.cancel()
+}
+
+// grpcToMessage 把zgg对象转换为desc类型的消息
+func grpcToMessage(c *Context, v Value, desc protoreflect.MessageDescriptor) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(desc)
+	grpcFillMessage(c, v, msg)
+	return msg
+}
+
+func grpcFillMessage(c *Context, v Value, msg protoreflect.Message) {
+	switch v.(type) {
+	case ValueUndefined, ValueNil:
+		return
+	}
+	desc := msg.Descriptor()
+	if strings.HasPrefix(string(desc.FullName()), "google.protobuf.") {
+		// 知名类型（Timestamp、Duration、Struct等）按其json格式转换
+		bs, err := json.Marshal(v.ToGoValue(c))
+		if err == nil {
+			err = protojson.Unmarshal(bs, msg.Interface())
+		}
+		if err != nil {
+			c.RaiseRuntimeError("grpc: convert to %s error %s", desc.FullName(), err)
+		}
+		return
+	}
+	obj, ok := v.(ValueObject)
+	if !ok {
+		c.RaiseRuntimeError("grpc: %s requires an object, got %s", desc.FullName(), v.Type().GetName())
+	}
+	fields := desc.Fields()
+	obj.Iterate(func(key string, val Value) {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			fd = fields.ByJSONName(key)
+		}
+		if fd == nil {
+			c.RaiseRuntimeError("grpc: %s has no field %s", desc.FullName(), key)
+		}
+		switch val.(type) {
+		case ValueUndefined, ValueNil:
+			return
+		}
+		switch {
+		case fd.IsList():
+			arr, ok := val.(ValueArray)
+			if !ok {
+				c.RaiseRuntimeError("grpc: field %s requires an array", fd.FullName())
+			}
+			list := msg.Mutable(fd).List()
+			for i := 0; i < arr.Len(); i++ {
+				list.Append(grpcToProtoValue(c, fd, arr.GetIndex(i, c)))
+			}
+		case fd.IsMap():
+			m, ok := val.(ValueObject)
+			if !ok {
+				c.RaiseRuntimeError("grpc: field %s requires an object", fd.FullName())
+			}
+			pm := msg.Mutable(fd).Map()
+			m.Iterate(func(k string, mv Value) {
+				key := grpcToProtoValue(c, fd.MapKey(), NewStr(k)).MapKey()
+				pm.Set(key, grpcToProtoValue(c, fd.MapValue(), mv))
+			})
+		default:
+			msg.Set(fd, grpcToProtoValue(c, fd, val))
+		}
+	})
+}
+
+func grpcInt(c *Context, fd protoreflect.FieldDescriptor, v Value) int64 {
+	switch n := v.(type) {
+	case ValueInt:
+		return n.Value()
+	case ValueFloat:
+		return int64(n.Value())
+	case ValueStr:
+		if i, err := strconv.ParseInt(n.Value(), 10, 64); err == nil {
+			return i
+		}
+	}
+	c.RaiseRuntimeError("grpc: field %s requires an integer, got %s", fd.FullName(), v.ToString(c))
+	return 0
+}
+
+func grpcFloat(c *Context, fd protoreflect.FieldDescriptor, v Value) float64 {
+	switch n := v.(type) {
+	case ValueInt:
+		return float64(n.Value())
+	case ValueFloat:
+		return n.Value()
+	}
+	c.RaiseRuntimeError("grpc: field %s requires a number, got %s", fd.FullName(), v.ToString(c))
+	return 0
+}
+
+func grpcToProtoValue(c *Context, fd protoreflect.FieldDescriptor, v Value) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(v.IsTrue())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(grpcInt(c, fd, v)))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(grpcInt(c, fd, v))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(grpcInt(c, fd, v)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(grpcInt(c, fd, v)))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(grpcFloat(c, fd, v)))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(grpcFloat(c, fd, v))
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v.ToString(c))
+	case protoreflect.BytesKind:
+		if bs, ok := v.(ValueBytes); ok {
+			return protoreflect.ValueOfBytes(bs.Value())
+		}
+		return protoreflect.ValueOfBytes([]byte(v.ToString(c)))
+	case protoreflect.EnumKind:
+		if name, ok := v.(ValueStr); ok {
+			ev := fd.Enum().Values().ByName(protoreflect.Name(name.Value()))
+			if ev == nil {
+				c.RaiseRuntimeError("grpc: enum %s has no value %s", fd.Enum().FullName(), name.Value())
+			}
+			return protoreflect.ValueOfEnum(ev.Number())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(grpcInt(c, fd, v)))
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protoreflect.ValueOfMessage(grpcToMessage(c, v, fd.Message()))
+	}
+	c.RaiseRuntimeError("grpc: unsupported field kind %s", fd.Kind())
+	return protoreflect.Value{}
+}
+
+// grpcFromMessage 把消息转换为zgg对象，未设置的message字段为nil，未设置的oneof字段不出现
+func grpcFromMessage(c *Context, msg protoreflect.Message) Value {
+	desc := msg.Descriptor()
+	if strings.HasPrefix(string(desc.FullName()), "google.protobuf.") {
+		bs, err := protojson.Marshal(msg.Interface())
+		if err != nil {
+			c.RaiseRuntimeError("grpc: convert from %s error %s", desc.FullName(), err)
+		}
+		var o interface{}
+		json.Unmarshal(bs, &o)
+		return jsonToValue(o, c)
+	}
+	rv := NewObject()
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.ContainingOneof() != nil && !msg.Has(fd) {
+			continue
+		}
+		name := string(fd.Name())
+		switch {
+		case fd.IsList():
+			list := msg.Get(fd).List()
+			arr := NewArray(list.Len())
+			for j := 0; j < list.Len(); j++ {
+				arr.PushBack(grpcFromProtoValue(c, fd, list.Get(j)))
+			}
+			rv.SetMember(name, arr, c)
+		case fd.IsMap():
+			m := NewObject()
+			msg.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				m.SetMember(k.String(), grpcFromProtoValue(c, fd.MapValue(), v), c)
+				return true
+			})
+			rv.SetMember(name, m, c)
+		case fd.Message() != nil && !msg.Has(fd):
+			rv.SetMember(name, Nil(), c)
+		default:
+			rv.SetMember(name, grpcFromProtoValue(c, fd, msg.Get(fd)), c)
+		}
+	}
+	return rv
+}
+
+func grpcFromProtoValue(c *Context, fd protoreflect.FieldDescriptor, v protoreflect.Value) Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return NewBool(v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return NewInt(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return NewInt(int64(v.Uint()))
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return NewFloat(v.Float())
+	case protoreflect.StringKind:
+		return NewStr(v.String())
+	case protoreflect.BytesKind:
+		return NewBytes(v.Bytes())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return NewStr(string(ev.Name()))
+		}
+		return NewInt(int64(v.Enum()))
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return grpcFromMessage(c, v.Message())
+	}
+	return Undefined()
+}
+
+func initGrpcProtosClass() ValueType {
+	return NewClassBuilder("Protos").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = args[0].ToGoValue(c).(*grpcProtos)
+		}).
+		Method("services", func(c *Context, this ValueObject, args []Value) Value {
+			services, _ := this.Reserved.(*grpcProtos).listServices(c.Ctx)
+			rv := NewArray(len(services))
+			for _, s := range services {
+				rv.PushBack(NewStr(s))
+			}
+			return rv
+		}).
+		Method("methods", func(c *Context, this ValueObject, args []Value) Value {
+			var name ValueStr
+			EnsureFuncParams(c, "grpc.Protos.methods", args, ArgRuleRequired("service", TypeStr, &name))
+			sd, err := this.Reserved.(*grpcProtos).findService(c.Ctx, name.Value())
+			if err != nil {
+				c.RaiseRuntimeError("grpc.Protos.methods: %s", err)
+			}
+			return grpcMethodsValue(c, sd)
+		}, "service").
+		Build()
+}
+
+func grpcMethodsValue(c *Context, sd protoreflect.ServiceDescriptor) Value {
+	methods := sd.Methods()
+	rv := NewArray(methods.Len())
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		item := NewObject()
+		item.SetMember("name", NewStr(string(md.Name())), c)
+		item.SetMember("input", NewStr(string(md.Input().FullName())), c)
+		item.SetMember("output", NewStr(string(md.Output().FullName())), c)
+		item.SetMember("clientStreaming", NewBool(md.IsStreamingClient()), c)
+		item.SetMember("serverStreaming", NewBool(md.IsStreamingServer()), c)
+		rv.PushBack(item)
+	}
+	return rv
+}
+
+func initGrpcConnClass() ValueType {
+	return NewClassBuilder("Conn").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = args[0].ToGoValue(c).(*grpcConn)
+		}).
+		Method("__getAttr__", func(c *Context, this ValueObject, args []Value) Value {
+			var name ValueStr
+			EnsureFuncParams(c, "grpc.Conn.__getAttr__", args, ArgRuleRequired("name", TypeStr, &name))
+			// 服务名首字母一般为大写，避免把拼错的方法名当作服务去查找
+			if n := name.Value(); n == "" || n[0] < 'A' || n[0] > 'Z' {
+				return Undefined()
+			}
+			return this.Reserved.(*grpcConn).stub(c, name.Value())
+		}).
+		Method("service", func(c *Context, this ValueObject, args []Value) Value {
+			var name ValueStr
+			EnsureFuncParams(c, "grpc.Conn.service", args, ArgRuleRequired("name", TypeStr, &name))
+			return this.Reserved.(*grpcConn).stub(c, name.Value())
+		}, "name").
+		Method("services", func(c *Context, this ValueObject, args []Value) Value {
+			services, err := this.Reserved.(*grpcConn).source.listServices(c.Ctx)
+			if err != nil {
+				grpcRaise(c, "list services", err)
+			}
+			rv := NewArray(len(services))
+			for _, s := range services {
+				rv.PushBack(NewStr(s))
+			}
+			return rv
+		}).
+		Method("methods", func(c *Context, this ValueObject, args []Value) Value {
+			var name ValueStr
+			EnsureFuncParams(c, "grpc.Conn.methods", args, ArgRuleRequired("service", TypeStr, &name))
+			sd, err := this.Reserved.(*grpcConn).source.findService(c.Ctx, name.Value())
+			if err != nil {
+				grpcRaise(c, "find service "+name.Value(), err)
+			}
+			return grpcMethodsValue(c, sd)
+		}, "service").
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*grpcConn).conn.Close()
+			return Undefined()
+		}).
+		Build()
+}
+
+func initGrpcClientStreamClass() ValueType {
+	return NewClassBuilder("ClientStream").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = args[0].ToGoValue(c).(*grpcClientStream)
+		}).
+		Method("send", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*grpcClientStream)
+			for _, arg := range args {
+				s.send(c, arg)
+			}
+			return this
+		}).
+		Method("recv", func(c *Context, this ValueObject, args []Value) Value {
+			if v := this.Reserved.(*grpcClientStream).recv(c); v != nil {
+				return v
+			}
+			return Nil()
+		}).
+		Method("closeSend", func(c *Context, this ValueObject, args []Value) Value {
+			if err := this.Reserved.(*grpcClientStream).stream.CloseSend(); err != nil {
+				grpcRaise(c, "close send", err)
+			}
+			return this
+		}).
+		Method("closeAndRecv", func(c *Context, this ValueObject, args []Value) Value {
+			return this.Reserved.(*grpcClientStream).closeAndRecv(c)
+		}).
+		Method("header", func(c *Context, this ValueObject, args []Value) Value {
+			md, err := this.Reserved.(*grpcClientStream).stream.Header()
+			if err != nil {
+				grpcRaise(c, "header", err)
+			}
+			return grpcMetadataToValue(c, md)
+		}).
+		Method("trailer", func(c *Context, this ValueObject, args []Value) Value {
+			return grpcMetadataToValue(c, this.Reserved.(*grpcClientStream).stream.Trailer())
+		}).
+		Method("cancel", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*grpcClientStream).finish()
+			return Undefined()
+		}).
+		Method("__iter__", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*grpcClientStream)
+			iter := MakeIterator(c, func() Value { return s.recv(c) }, s.finish)
+			return c.InvokeMethod(iter, "__iter__", NoArgs)
+		}).
+		Build()
+}
+
+func init() {
+	for i := codes.OK; i <= codes.Unauthenticated; i++ {
+		grpcCodeNames[i.String()] = i
+	}
+	grpcProtosClass = initGrpcProtosClass()
+	grpcConnClass = initGrpcConnClass()
+	grpcClientStreamClass = initGrpcClientStreamClass()
+	grpcServerClass = initGrpcServerClass()
+	grpcServerCallClass = initGrpcServerCallClass()
+}
@@ -0,0 +1,333 @@
+package builtin_libs
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	rpbalpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	grpcServer struct {
+		// base 是启动服务时脚本上下文的快照，处理函数都从它复制，不与脚本主线程竞争
+		base    *Context
+		server  *grpc.Server
+		protos  *grpcProtos
+		mu      sync.Mutex
+		started bool
+	}
+	// grpcServerCall 服务端处理函数收到的调用对象，流式调用时stream不为nil
+	grpcServerCall struct {
+		ctx    context.Context
+		stream grpc.ServerStream
+		method protoreflect.MethodDescriptor
+	}
+)
+
+func newGrpcServer(c *Context, options ValueObject) *grpcServer {
+	s := &grpcServer{}
+	if protos, ok := options.GetMember("protos", c).(ValueObject); ok {
+		p, ok := protos.Reserved.(*grpcProtos)
+		if !ok {
+			c.RaiseRuntimeError("grpc.server: protos must be loaded by grpc.load")
+		}
+		s.protos = p
+	}
+	var serverOptions []grpc.ServerOption
+	if tlsOpt, ok := options.GetMember("tls", c).(ValueObject); ok {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfigFromOptions(c, "grpc.server", tlsOpt, true))))
+	}
+	if n, ok := options.GetMember("maxRecvMsgSize", c).(ValueInt); ok {
+		serverOptions = append(serverOptions, grpc.MaxRecvMsgSize(n.AsInt()))
+	}
+	if n, ok := options.GetMember("maxSendMsgSize", c).(ValueInt); ok {
+		serverOptions = append(serverOptions, grpc.MaxSendMsgSize(n.AsInt()))
+	}
+	s.server = grpc.NewServer(serverOptions...)
+	if options.GetMember("reflection", c).IsTrue() {
+		if s.protos == nil {
+			c.RaiseRuntimeError("grpc.server: reflection requires protos")
+		}
+		// 服务列表在每次请求时从server获取，因此可以在handle之前注册
+		reflectionOptions := reflection.ServerOptions{Services: s.server, DescriptorResolver: s.protos.files}
+		rpb.RegisterServerReflectionServer(s.server, reflection.NewServerV1(reflectionOptions))
+		rpbalpha.RegisterServerReflectionServer(s.server, reflection.NewServer(reflectionOptions))
+	}
+	return s
+}
+
+// handle 用impl对象中与rpc方法同名的函数实现服务，未实现的方法返回Unimplemented
+func (s *grpcServer) handle(c *Context, name string, impl ValueObject) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		c.RaiseRuntimeError("grpc.Server.handle: server already started")
+	}
+	if s.protos == nil {
+		c.RaiseRuntimeError("grpc.Server.handle: server created without protos")
+	}
+	sd, err := s.protos.findService(c.Ctx, name)
+	if err != nil {
+		c.RaiseRuntimeError("grpc.Server.handle: %s", err)
+	}
+	if _, found := s.server.GetServiceInfo()[string(sd.FullName())]; found {
+		c.RaiseRuntimeError("grpc.Server.handle: service %s already registered", sd.FullName())
+	}
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: (*interface{})(nil),
+		Metadata:    sd.ParentFile().Path(),
+	}
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		if !md.IsStreamingClient() && !md.IsStreamingServer() {
+			desc.Methods = append(desc.Methods, grpc.MethodDesc{
+				MethodName: string(md.Name()),
+				Handler:    s.unaryHandler(impl, md),
+			})
+			continue
+		}
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    string(md.Name()),
+			Handler:       s.streamHandler(impl, md),
+			ServerStreams: md.IsStreamingServer(),
+			ClientStreams: md.IsStreamingClient(),
+		})
+	}
+	s.server.RegisterService(desc, struct{}{})
+}
+
+// invoke 在新的Context中调用处理函数，异常转换为grpc状态
+func (s *grpcServer) invoke(ctx context.Context, impl ValueObject, md protoreflect.MethodDescriptor, args ...Value) (rv Value, err error) {
+	newC := s.base.CloneWithContext(ctx)
+	defer func() {
+		if e := recover(); e != nil {
+			switch ex := e.(type) {
+			case *grpcStatusError:
+				err = ex.status.Err()
+			case Exception:
+				if ctx.Err() != nil {
+					err = status.FromContextError(ctx.Err()).Err()
+				} else {
+					err = status.Error(codes.Unknown, ex.GetMessage())
+				}
+			default:
+				err = status.Errorf(codes.Internal, "%v", e)
+			}
+		}
+	}()
+	name := string(md.Name())
+	if _, isFunc := impl.GetMember(name, newC).(ValueCallable); !isFunc {
+		return nil, status.Errorf(codes.Unimplemented, "method %s not implemented", name)
+	}
+	return newC.InvokeMethod(impl, name, Args(args...)), nil
+}
+
+func (s *grpcServer) unaryHandler(impl ValueObject, md protoreflect.MethodDescriptor) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(md.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			call := &grpcServerCall{ctx: ctx, method: md}
+			newC := s.base.CloneWithContext(ctx)
+			rv, err := s.invoke(ctx, impl, md, grpcFromMessage(newC, req.(*dynamicpb.Message)), NewObjectAndInit(grpcServerCallClass, newC, NewGoValue(call)))
+			if err != nil {
+				return nil, err
+			}
+			return s.toMessage(newC, rv, md.Output())
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: grpcFullMethod(md)}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func (s *grpcServer) streamHandler(impl ValueObject, md protoreflect.MethodDescriptor) grpc.StreamHandler {
+	return func(srv interface{}, stream grpc.ServerStream) error {
+		ctx := stream.Context()
+		newC := s.base.CloneWithContext(ctx)
+		call := &grpcServerCall{ctx: ctx, stream: stream, method: md}
+		callObj := NewObjectAndInit(grpcServerCallClass, newC, NewGoValue(call))
+		if md.IsStreamingClient() {
+			rv, err := s.invoke(ctx, impl, md, callObj)
+			if err != nil || md.IsStreamingServer() {
+				return err
+			}
+			// 客户端流：处理函数的返回值即为响应
+			resp, err := s.toMessage(newC, rv, md.Output())
+			if err != nil {
+				return err
+			}
+			return stream.SendMsg(resp)
+		}
+		req := dynamicpb.NewMessage(md.Input())
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		_, err := s.invoke(ctx, impl, md, grpcFromMessage(newC, req), callObj)
+		return err
+	}
+}
+
+func (s *grpcServer) toMessage(c *Context, v Value, desc protoreflect.MessageDescriptor) (msg *dynamicpb.Message, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if ex, ok := e.(Exception); ok {
+				err = status.Error(codes.Internal, ex.GetMessage())
+				return
+			}
+			panic(e)
+		}
+	}()
+	return grpcToMessage(c, v, desc), nil
+}
+
+func (s *grpcServer) listen(c *Context, addr string) net.Listener {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix://") {
+		network, addr = "unix", addr[len("unix://"):]
+	}
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		c.RaiseRuntimeError("grpc.Server: listen %s error %s", addr, err)
+	}
+	s.mu.Lock()
+	s.started = true
+	s.base = c.Clone()
+	s.mu.Unlock()
+	return lis
+}
+
+func (call *grpcServerCall) recv(c *Context) Value {
+	if call.stream == nil || !call.method.IsStreamingClient() {
+		c.RaiseRuntimeError("grpc: recv is only available in client streaming calls")
+	}
+	msg := dynamicpb.NewMessage(call.method.Input())
+	if err := call.stream.RecvMsg(msg); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		grpcRaise(c, "recv", err)
+	}
+	return grpcFromMessage(c, msg)
+}
+
+func initGrpcServerClass() ValueType {
+	return NewClassBuilder("Server").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = newGrpcServer(c, args[0].(ValueObject))
+		}).
+		Method("handle", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				name ValueStr
+				impl ValueObject
+			)
+			EnsureFuncParams(c, "grpc.Server.handle", args,
+				ArgRuleRequired("service", TypeStr, &name),
+				ArgRuleRequired("impl", TypeObject, &impl),
+			)
+			this.Reserved.(*grpcServer).handle(c, name.Value(), impl)
+			return this
+		}, "service", "impl").
+		Method("serve", func(c *Context, this ValueObject, args []Value) Value {
+			var addr ValueStr
+			EnsureFuncParams(c, "grpc.Server.serve", args, ArgRuleRequired("addr", TypeStr, &addr))
+			s := this.Reserved.(*grpcServer)
+			if err := s.server.Serve(s.listen(c, addr.Value())); err != nil && err != grpc.ErrServerStopped {
+				c.RaiseRuntimeError("grpc.Server.serve: %s", err)
+			}
+			return Undefined()
+		}, "addr").
+		Method("start", func(c *Context, this ValueObject, args []Value) Value {
+			var addr ValueStr
+			EnsureFuncParams(c, "grpc.Server.start", args, ArgRuleRequired("addr", TypeStr, &addr))
+			s := this.Reserved.(*grpcServer)
+			lis := s.listen(c, addr.Value())
+			go s.server.Serve(lis)
+			return NewStr(lis.Addr().String())
+		}, "addr").
+		Method("stop", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*grpcServer).server.Stop()
+			return Undefined()
+		}).
+		Method("gracefulStop", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*grpcServer).server.GracefulStop()
+			return Undefined()
+		}).
+		Build()
+}
+
+func initGrpcServerCallClass() ValueType {
+	return NewClassBuilder("ServerCall").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			call := args[0].ToGoValue(c).(*grpcServerCall)
+			this.Reserved = call
+			this.SetMember("method", NewStr(grpcFullMethod(call.method)), c)
+		}).
+		Method("metadata", func(c *Context, this ValueObject, args []Value) Value {
+			md, _ := metadata.FromIncomingContext(this.Reserved.(*grpcServerCall).ctx)
+			return grpcMetadataToValue(c, md)
+		}).
+		Method("send", func(c *Context, this ValueObject, args []Value) Value {
+			call := this.Reserved.(*grpcServerCall)
+			if call.stream == nil || !call.method.IsStreamingServer() {
+				c.RaiseRuntimeError("grpc: send is only available in server streaming calls")
+			}
+			for _, arg := range args {
+				if err := call.stream.SendMsg(grpcToMessage(c, arg, call.method.Output())); err != nil {
+					grpcRaise(c, "send", err)
+				}
+			}
+			return this
+		}).
+		Method("recv", func(c *Context, this ValueObject, args []Value) Value {
+			if v := this.Reserved.(*grpcServerCall).recv(c); v != nil {
+				return v
+			}
+			return Nil()
+		}).
+		Method("__iter__", func(c *Context, this ValueObject, args []Value) Value {
+			call := this.Reserved.(*grpcServerCall)
+			iter := MakeIterator(c, func() Value { return call.recv(c) }, func() {})
+			return c.InvokeMethod(iter, "__iter__", NoArgs)
+		}).
+		Method("setHeader", func(c *Context, this ValueObject, args []Value) Value {
+			var md ValueObject
+			EnsureFuncParams(c, "grpc.ServerCall.setHeader", args, ArgRuleRequired("metadata", TypeObject, &md))
+			if err := grpc.SetHeader(this.Reserved.(*grpcServerCall).ctx, grpcMetadata(c, md)); err != nil {
+				grpcRaise(c, "set header", err)
+			}
+			return this
+		}, "metadata").
+		Method("setTrailer", func(c *Context, this ValueObject, args []Value) Value {
+			var md ValueObject
+			EnsureFuncParams(c, "grpc.ServerCall.setTrailer", args, ArgRuleRequired("metadata", TypeObject, &md))
+			if err := grpc.SetTrailer(this.Reserved.(*grpcServerCall).ctx, grpcMetadata(c, md)); err != nil {
+				grpcRaise(c, "set trailer", err)
+			}
+			return this
+		}, "metadata").
+		Method("cancelled", func(c *Context, this ValueObject, args []Value) Value {
+			return NewBool(this.Reserved.(*grpcServerCall).ctx.Err() != nil)
+		}).
+		Build()
+}
@@ -0,0 +1,113 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testGrpcSetup = `
+grpc := import('grpc')
+protos := grpc.parse('''
+syntax = "proto3";
+package demo;
+enum Kind { UNKNOWN = 0; FOO = 1; }
+message Req { string name = 1; int64 times = 2; Kind kind = 3; map<string, int32> scores = 4; }
+message Resp { string msg = 1; Kind kind = 2; }
+service Greeter {
+	rpc Hello(Req) returns (Resp);
+	rpc Many(Req) returns (stream Resp);
+	rpc Collect(stream Req) returns (Resp);
+	rpc Chat(stream Req) returns (stream Resp);
+}
+''')
+svr := grpc.server({protos: protos, reflection: true})
+svr.handle('Greeter', {
+	Hello: (req, call) => {
+		if req.name == '' {
+			grpc.error('InvalidArgument', 'name required')
+		}
+		return {msg: 'hello ' + req.name + ' ' + call.metadata()['x-user'] + ' ' + req.scores.a, kind: req.kind}
+	},
+	Many: (req, stream) => {
+		for i in 0..<req.times {
+			stream.send({msg: req.name + i})
+		}
+	},
+	Collect: stream => {
+		names := []
+		for r in stream {
+			names.push(r.name)
+		}
+		return {msg: names.join(',')}
+	},
+	Chat: stream => {
+		for r in stream {
+			stream.send({msg: 'echo ' + r.name})
+		}
+	},
+})
+addr := svr.start('127.0.0.1:0')
+conn := grpc.dial(addr, {protos: protos, metadata: {'x-user': 'u1'}})
+`
+
+const testGrpcTeardown = `
+conn.close()
+svr.stop()
+`
+
+func TestGrpcClientAndServer(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Unary", `
+			resp := conn.Greeter.Hello({name: 'a', kind: 'FOO', scores: {a: 3}})
+			export result := [resp.msg, resp.kind]
+		`, []interface{}{"hello a u1 3", "FOO"}},
+		{"ServerStream", `
+			export result := []
+			for m in conn.Greeter.Many({name: 'm', times: 2}) {
+				result.push(m.msg)
+			}
+		`, []interface{}{"m0", "m1"}},
+		{"ClientStream", `
+			export result := conn.Greeter.Collect([{name: 'x'}, {name: 'y'}]).msg
+		`, "x,y"},
+		{"BidiStream", `
+			export result := []
+			chat := conn.Greeter.Chat()
+			chat.send({name: 'p'}).closeSend()
+			for m in chat {
+				result.push(m.msg)
+			}
+		`, []interface{}{"echo p"}},
+		{"StatusError", `
+			status := nil
+			try {
+				conn.Greeter.Hello({})
+			} catch (e) {
+				status = grpc.statusOf(e).name
+			}
+			export result := status
+		`, "InvalidArgument"},
+		{"Reflection", `
+			rconn := grpc.dial(addr)
+			export result := rconn.Greeter.Hello({name: 'r'}, {metadata: {'x-user': 'u2'}}).msg
+			rconn.close()
+		`, "hello r u2 undefined"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testGrpcSetup + tc.code + testGrpcTeardown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
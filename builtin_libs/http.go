@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	. "github.com/zgg-lang/zgg-go/runtime"
@@ -32,6 +33,10 @@ var (
 	httpRequestClass        ValueType
 	httpResponseClass       ValueType
 	httpFormFileClass       ValueType
+	httpTestClientClass     ValueType
+	httpSessionClass        ValueType
+	httpSSEStreamClass      ValueType
+	httpEventSourceClass    ValueType
 )
 
 const (
@@ -50,14 +55,26 @@ func libHttp(*Context) ValueObject {
 	lib.SetMember("postJson", httpPostJson, nil)
 	// lib.SetMember("head", httpHead, nil)
 	lib.SetMember("Request", httpRequestClass, nil)
+	lib.SetMember("Session", httpSessionClass, nil)
+	lib.SetMember("EventSource", httpEventSourceClass, nil)
+	lib.SetMember("eventSource", NewNativeFunction("eventSource", func(c *Context, this Value, args []Value) Value {
+		return NewObjectAndInit(httpEventSourceClass, c, args...)
+	}, "url", "options"), nil)
 	lib.SetMember("WebsocketClient", websocketClientClass, nil)
+	lib.SetMember("WebsocketHub", httpWebsocketHubClass, nil)
 	lib.SetMember("connectWebsocket", NewNativeFunction("connectWebsocket", func(c *Context, this Value, args []Value) Value {
-		var addr ValueStr
-		EnsureFuncParams(c, "connectWebsocket", args, ArgRuleRequired("addr", TypeStr, &addr))
-		conn := NewObjectAndInit(websocketClientClass, c, addr)
-		c.InvokeMethod(conn, "connect", NoArgs)
+		var (
+			addr    ValueStr
+			options ValueObject
+		)
+		EnsureFuncParams(c, "connectWebsocket", args,
+			ArgRuleRequired("addr", TypeStr, &addr),
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		conn := NewObjectAndInit(websocketClientClass, c, addr, options)
+		c.InvokeMethod(conn, "connect", Args(options.GetMember("headers", c)))
 		return conn
-	}, "addr"), nil)
+	}, "addr", "options"), nil)
 	lib.SetMember("escape", NewNativeFunction("escape", func(c *Context, this Value, args []Value) Value {
 		if len(args) < 1 {
 			return NewStr("")
@@ -107,6 +124,7 @@ func libHttp(*Context) ValueObject {
 			ctx := NewObjectAndInit(httpRequestContextClass, newC, wv, rv)
 			defer newC.Recover()
 			newC.Invoke(handleFunc, nil, Args(ctx))
+			newC.InvokeMethod(ctx, "close", NoArgs)
 		})
 		if strings.HasPrefix(addr, httpUnixPrefix) {
 			unixAddr, err := net.ResolveUnixAddr("unix", addr[len(httpUnixPrefix):])
@@ -129,6 +147,19 @@ func libHttp(*Context) ValueObject {
 		}
 		return nil
 	}), nil)
+	lib.SetMember("testClient", NewNativeFunction("testClient", func(c *Context, this Value, args []Value) Value {
+		if len(args) != 1 {
+			c.RaiseRuntimeError("http.testClient requires 1 argument")
+		}
+		handler := args[0]
+		if server, ok := handler.(ValueObject); ok {
+			handler = server.GetMember("__handler", c)
+		}
+		if _, ok := handler.ToGoValue(c).(http.Handler); !ok {
+			c.RaiseRuntimeError("http.testClient: argument must be a http server or a go http.Handler")
+		}
+		return NewObjectAndInit(httpTestClientClass, c, handler)
+	}, "server"), nil)
 	lib.SetMember("serveFS", NewNativeFunction("serveFS", func(c *Context, this Value, args []Value) Value {
 		var (
 			addr      ValueStr
@@ -451,15 +482,17 @@ var httpPostJson = NewNativeFunction("postJson", func(c *Context, this Value, ar
 // func (c *httpRequestContext) write
 
 var httpCreateServer = NewNativeFunction("createServer", func(c *Context, thisArg Value, args []Value) Value {
-	serverName := "zgg http server"
+	var opts ValueObject
 	if len(args) > 0 {
-		opts := c.MustObject(args[0], "http.createServer options")
-		if _serverName, ok := opts.GetMember("serverName", c).(ValueStr); ok {
-			serverName = _serverName.Value()
-		}
+		opts = c.MustObject(args[0], "http.createServer options")
 	}
-	svr := http.NewServeMux()
+	router := newHttpRouter(c, opts)
 	rv := NewObject()
+	router.root.bind(rv)
+	rv.SetMember("__handler", NewGoValue(http.Handler(router)), nil)
+	rv.SetMember("test", NewNativeFunction("test", func(c *Context, thisArgs Value, args []Value) Value {
+		return NewObjectAndInit(httpTestClientClass, c, NewGoValue(http.Handler(router)))
+	}), nil)
 	upgrader := &websocket.Upgrader{
 		ReadBufferSize:  65536,
 		WriteBufferSize: 65536,
@@ -467,37 +500,24 @@ var httpCreateServer = NewNativeFunction("createServer", func(c *Context, thisAr
 			return true
 		},
 	}
-	rv.SetMember("route", NewNativeFunction("route", func(c *Context, thisArg Value, args []Value) Value {
-		if len(args) < 2 {
-			c.RaiseRuntimeError("http.server: route requires at least 2 arguments")
-			return nil
-		}
-		path := c.MustStr(args[0], "http.server.route(path, handleFunc): path")
-		handleFunc := c.MustCallable(args[1], "http.server.route(path, handleFunc): function")
-		svr.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-			newC := c.CloneWithContext(r.Context())
-			w.Header().Set("server", serverName)
-			rv := NewGoValue(r)
-			wv := NewGoValue(w)
-			ctx := NewObjectAndInit(httpRequestContextClass, newC, wv, rv)
-			defer newC.Recover()
-			newC.Invoke(handleFunc, nil, Args(ctx))
-			if callable, ok := newC.GetCallable(ctx.GetMember("close", c)); ok {
-				c.Invoke(callable, ctx, NoArgs)
-			}
-		})
-		return thisArg
-	}, "path", "handleFunc"), nil)
 	rv.SetMember("routeWebsocket", NewNativeFunction("routeWebsocket", func(c *Context, thisArgs Value, args []Value) Value {
 		var (
 			routePath  ValueStr
-			handleFunc ValueCallable
+			handleFunc Value
 		)
 		EnsureFuncParams(c, "routeWebsocket", args,
 			ArgRuleRequired("path", TypeStr, &routePath),
-			ArgRuleRequired("handleFunc", TypeFunc, &handleFunc),
+			ArgRuleRequired("handleFunc", TypeAny, &handleFunc),
 		)
-		svr.HandleFunc(routePath.Value(), func(w http.ResponseWriter, r *http.Request) {
+		// 第二个参数也可以是WebsocketHub，连接直接交给hub管理
+		var hub *httpWsHub
+		if obj, ok := handleFunc.(ValueObject); ok {
+			hub, _ = obj.Reserved.(*httpWsHub)
+		}
+		if _, ok := c.GetCallable(handleFunc); !ok && hub == nil {
+			c.RaiseRuntimeError("routeWebsocket: handleFunc must be a function or a WebsocketHub")
+		}
+		err := httpMuxHandle(router.mux, routePath.Value(), func(w http.ResponseWriter, r *http.Request) {
 			conn, err := upgrader.Upgrade(w, r, nil)
 			if err != nil {
 				w.WriteHeader(500)
@@ -505,12 +525,18 @@ var httpCreateServer = NewNativeFunction("createServer", func(c *Context, thisAr
 			}
 			defer conn.Close()
 			newC := c.CloneWithContext(r.Context())
-			w.Header().Set("server", serverName)
-			ctx := NewObjectAndInit(websocketContextClass, newC, NewGoValue(conn), NewGoValue(w), NewGoValue(r))
 			defer newC.Recover()
+			if hub != nil {
+				hub.serve(newC, conn, r)
+				return
+			}
+			ctx := NewObjectAndInit(websocketContextClass, newC, NewGoValue(conn), NewGoValue(w), NewGoValue(r))
 			newC.Invoke(handleFunc, nil, Args(ctx))
 		})
-		return thisArg
+		if err != nil {
+			c.RaiseRuntimeError("http.server: add route %s error %s", routePath.Value(), err)
+		}
+		return thisArgs
 	}, "path", "handleFunc"), nil)
 	rv.SetMember("serve", NewNativeFunction("serve", func(c *Context, thisArgs Value, args []Value) Value {
 		var (
@@ -521,44 +547,35 @@ var httpCreateServer = NewNativeFunction("createServer", func(c *Context, thisAr
 			ArgRuleRequired("address", TypeStr, &addrStr),
 			ArgRuleOptional("options", TypeObject, &options, NewObject()),
 		)
-		addr := addrStr.Value()
-		if strings.HasPrefix(addr, httpUnixPrefix) {
-			unixAddr, err := net.ResolveUnixAddr("unix", addr[len(httpUnixPrefix):])
-			if err != nil {
-				c.RaiseRuntimeError("resolve unix addr %s error %s", addr, err)
-			}
-			listener, err := net.ListenUnix("unix", unixAddr)
-			if err != nil {
-				c.RaiseRuntimeError("listen %s error %s", addr, err)
-			}
-			defer listener.Close()
-			listener.SetUnlinkOnClose(true)
-			if err := http.Serve(listener, svr); err != nil {
-				c.RaiseRuntimeError("http serve on %s error %s", addr, err)
-			}
-		} else {
-			var certFile, keyFile string
-			useTls := false
-			if cf, ok := options.GetMember("certFile", c).(ValueStr); ok {
-				if kf, ok := options.GetMember("keyFile", c).(ValueStr); ok {
-					useTls = true
-					certFile = cf.Value()
-					keyFile = kf.Value()
-				}
-			}
-			var err error
-			if useTls {
-				err = http.ListenAndServeTLS(addr, certFile, keyFile, svr)
-			} else {
-				err = http.ListenAndServe(addr, svr)
-			}
-			if err != nil {
-				c.RaiseRuntimeError("http.server.serve fail: %s", err)
-				return nil
-			}
-		}
+		router.serve(c, addrStr.Value(), options)
 		return Undefined()
 	}), nil)
+	rv.SetMember("shutdown", NewNativeFunction("shutdown", func(c *Context, thisArgs Value, args []Value) Value {
+		var timeout timeDurationArg
+		EnsureFuncParams(c, "http.server.shutdown", args,
+			timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(0)))),
+		)
+		if router.inHandler(c) {
+			// 在请求处理中调用时异步关闭，等待当前请求结束
+			go router.shutdown(timeout.GetDuration(c))
+			return NewBool(true)
+		}
+		running, err := router.shutdown(timeout.GetDuration(c))
+		if err != nil {
+			c.RaiseRuntimeError("http.server.shutdown fail: %s", err)
+		}
+		return NewBool(running)
+	}, "timeout"), nil)
+	rv.SetMember("onShutdown", NewNativeFunction("onShutdown", func(c *Context, thisArgs Value, args []Value) Value {
+		var callback ValueCallable
+		EnsureFuncParams(c, "http.server.onShutdown", args,
+			ArgRuleRequired("callback", TypeFunc, &callback),
+		)
+		router.mu.Lock()
+		router.onShutdown = append(router.onShutdown, callback)
+		router.mu.Unlock()
+		return thisArgs
+	}, "callback"), nil)
 	return rv
 })
 
@@ -636,7 +653,7 @@ func initHttpRequestContextClass() ValueType {
 			EnsureFuncParams(c, className+".parseMultipartForm", args,
 				ArgRuleOptional("maxSize", TypeInt, &maxSize, NewInt(10*1024*1024)),
 			)
-			r.ParseMultipartForm(maxSize.Value())
+			httpCheckBodyError(c, className+".parseMultipartForm", r.ParseMultipartForm(maxSize.Value()))
 			return Nil()
 		}).
 		Method("file", func(c *Context, this ValueObject, args []Value) Value {
@@ -682,7 +699,7 @@ func initHttpRequestContextClass() ValueType {
 		}).
 		Method("form", func(c *Context, this ValueObject, args []Value) Value {
 			r := this.GetMember("_r", c).ToGoValue(c).(*http.Request)
-			r.ParseForm()
+			httpCheckBodyError(c, className+".form", r.ParseForm())
 			var argName ValueStr
 			EnsureFuncParams(c, className+".form", args,
 				ArgRuleRequired("name", TypeStr, &argName),
@@ -960,7 +977,30 @@ func initHttpRequestContextClass() ValueType {
 			io.Copy(w, response.Body)
 			return Undefined()
 		}).
+		Method("sse", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				keepAlive timeDurationArg
+				retry     ValueInt
+			)
+			EnsureFuncParams(c, className+".sse", args,
+				keepAlive.Rule(c, "keepAlive", NewObjectAndInit(timeDurationClass, c, NewGoValue(15*time.Second))),
+				ArgRuleOptional("retry", TypeInt, &retry, NewInt(0)),
+			)
+			if stream, ok := this.GetMember("__sse", c).(ValueObject); ok {
+				return stream
+			}
+			w := this.GetMember("_w", c).ToGoValue(c).(http.ResponseWriter)
+			r := this.GetMember("_r", c).ToGoValue(c).(*http.Request)
+			s, err := newHttpSSEStream(w, r, keepAlive.GetDuration(c), retry.Value())
+			if err != nil {
+				c.RaiseRuntimeError("%s.sse error %s", className, err)
+			}
+			stream := NewObjectAndInit(httpSSEStreamClass, c, NewGoValue(s))
+			this.SetMember("__sse", stream, c)
+			return stream
+		}, "keepAlive", "retry").
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			httpCloseSSE(c, this)
 			r := this.GetMember("_r", c).ToGoValue(c).(*http.Request)
 			r.Body.Close()
 			return Undefined()
@@ -1022,6 +1062,30 @@ func initWebsocketContextClass() ValueType {
 			}
 			return Undefined()
 		}).
+		Method("writeJson", func(c *Context, this ValueObject, args []Value) Value {
+			var val Value
+			EnsureFuncParams(c, "WebsocketContext.writeJson", args, ArgRuleRequired("value", TypeAny, &val))
+			conn := this.GetMember("_conn", c).ToGoValue(c).(*websocket.Conn)
+			if err := conn.WriteJSON(val.ToGoValue(c)); err != nil {
+				c.RaiseRuntimeError("websocket write message error %s", err)
+			}
+			return Undefined()
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				code   ValueInt
+				reason ValueStr
+			)
+			EnsureFuncParams(c, "WebsocketContext.close", args,
+				ArgRuleOptional("code", TypeInt, &code, NewInt(websocket.CloseNormalClosure)),
+				ArgRuleOptional("reason", TypeStr, &reason, NewStr("")),
+			)
+			conn := this.GetMember("_conn", c).ToGoValue(c).(*websocket.Conn)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code.AsInt(), reason.Value()), time.Now().Add(time.Second))
+			conn.Close()
+			return Undefined()
+		}).
 		Build()
 
 }
@@ -1083,6 +1147,20 @@ func initHttpRequestClass() ValueType {
 			body.PushBack(NewStr(form.Encode()))
 			return this
 		}, "form").
+		Method("json", func(c *Context, this ValueObject, args []Value) Value {
+			if len(args) != 1 {
+				c.RaiseRuntimeError("Request.json requires 1 argument")
+			}
+			bs, err := json.Marshal(args[0].ToGoValue(c))
+			if err != nil {
+				c.RaiseRuntimeError("Request.json: encode to json error %s", err)
+			}
+			headers := this.GetMember("__headers", c).(ValueArray)
+			headers.PushBack(NewArrayByValues(NewStr("Content-Type"), NewStr("application/json")))
+			body := this.GetMember("__body", c).(ValueArray)
+			body.PushBack(NewBytes(bs))
+			return this
+		}, "value").
 		Method("multipartForm", func(c *Context, this ValueObject, args []Value) Value {
 			var formArg ValueObject
 			EnsureFuncParams(c, "Request.multipartForm", args, ArgRuleRequired("form", TypeObject, &formArg))
@@ -1180,6 +1258,10 @@ func initHttpRequestClass() ValueType {
 				}
 				reqBody = io.MultiReader(readers...)
 			}
+			var (
+				resp       *http.Response
+				cancelBody context.CancelFunc
+			)
 			method := c.MustStr(this.GetMember("method", c))
 			url := c.MustStr(this.GetMember("url", c))
 			req, err := http.NewRequestWithContext(c.Ctx, strings.ToUpper(method), url, reqBody)
@@ -1193,117 +1275,144 @@ func initHttpRequestClass() ValueType {
 				val := item.GetIndex(1, c)
 				req.Header.Add(key.ToString(c), val.ToString(c))
 			}
-			var httpClient *http.Client = nil
-			if hc, ok := this.GetMember("__goHttpClient", c).ToGoValue(c).(*http.Client); ok {
-				httpClient = hc
-			} else {
-				if certs, ok := this.GetMember("__certs", c).(ValueArray); ok && certs.Len() == 3 {
-					certFile := certs.GetIndex(0, c).ToString(c)
-					keyFile := certs.GetIndex(1, c).ToString(c)
-					caFile := certs.GetIndex(2, c).ToString(c)
-					cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-					if err != nil {
-						c.RaiseRuntimeError("http.Request.call: load key pair error: %s", err)
-					}
-					tlsConfig := &tls.Config{
-						Certificates: []tls.Certificate{cert},
-					}
-					if caFile != "" {
-						caCertPool := x509.NewCertPool()
-						if caCert, err := os.ReadFile(caFile); err != nil {
-							c.RaiseRuntimeError("http.Request.call: load ca cert error: %s", err)
-						} else {
-							caCertPool.AppendCertsFromPEM(caCert)
-							tlsConfig.RootCAs = caCertPool
-						}
+			httpClient := httpRequestClient(c, this)
+			if timeout, ok := this.GetMember("__timeout", c).(GoValue); ok {
+				// 以请求的context控制超时，对session等共用的client同样有效；读完响应关闭body时释放
+				ctx, cancel := context.WithTimeout(req.Context(), timeout.ToGoValue(c).(time.Duration))
+				req = req.WithContext(ctx)
+				defer func() {
+					if resp == nil {
+						cancel()
 					}
-					tlsConfig.BuildNameToCertificate()
-					transport := &http.Transport{TLSClientConfig: tlsConfig}
-					if httpClient == nil {
-						httpClient = &http.Client{Transport: transport}
-					} else {
-						httpClient.Transport = transport
-					}
-				}
-				if s, ok := this.GetMember("__shouldFollowRedirect", c).(ValueBool); ok && !s.Value() {
-					if httpClient == nil {
-						httpClient = &http.Client{}
-					}
-					httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
-						return http.ErrUseLastResponse
-					}
-				}
-				if hosts, ok := this.GetMember("__hosts", c).(ValueObject); ok {
-					if httpClient == nil {
-						httpClient = &http.Client{}
-					}
-					hostsMap := hosts.ToGoValue(c).(map[string]interface{})
-					var dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-						if mapped, exists := hostsMap[addr]; exists {
-							addr = fmt.Sprint(mapped)
-						}
-						var d net.Dialer
-						return d.DialContext(ctx, network, addr)
-					}
-					if httpClient.Transport == nil {
-						httpClient.Transport = &http.Transport{
-							DialContext: dialContext,
-						}
-					} else if tr, ok := httpClient.Transport.(*http.Transport); ok {
-						if tr.DialContext != nil {
-							c.RaiseRuntimeError("DailContext already exists")
-						}
-						tr.DialContext = dialContext
-					} else {
-						c.RaiseRuntimeError("Cannot set DialContext")
-					}
-				}
-				if tlsConfig, ok := this.GetMember("__tlsConfig", c).(ValueObject); ok {
-					if httpClient == nil {
-						httpClient = &http.Client{}
-					}
-					var transport *http.Transport
-					if httpClient.Transport == nil {
-						transport = &http.Transport{}
-						httpClient.Transport = transport
-					} else if tr, ok := httpClient.Transport.(*http.Transport); ok {
-						transport = tr
-					} else {
-						c.RaiseRuntimeError("Get transport failed")
-					}
-					if transport.TLSClientConfig == nil {
-						transport.TLSClientConfig = &tls.Config{}
-					}
-					if jsonBs, err := json.Marshal(tlsConfig.ToGoValue(c)); err != nil {
-						c.RaiseRuntimeError("set tls config error: %+v", err)
-					} else if err := json.Unmarshal(jsonBs, transport.TLSClientConfig); err != nil {
-						c.RaiseRuntimeError("set tls config error: %+v", err)
-					}
-				}
-				if timeout, ok := this.GetMember("__timeout", c).(GoValue); ok {
-					if httpClient == nil {
-						httpClient = &http.Client{}
-					}
-					httpClient.Timeout = timeout.ToGoValue(c).(time.Duration)
-					// time.Duration(timeout.Value() * float64(time.Second))
-				}
-				if httpClient == nil {
-					httpClient = http.DefaultClient
-				}
+				}()
+				cancelBody = cancel
 			}
 			if host, ok := this.GetMember("__host", c).(ValueStr); ok {
 				req.Host = host.Value()
 			}
-			resp, err := httpClient.Do(req)
+			resp, err = httpClient.Do(req)
 			if err != nil {
 				c.RaiseRuntimeError("http.Request.call: do request error %s", err)
 			}
+			if cancelBody != nil {
+				resp.Body = httpCancelBody{ReadCloser: resp.Body, cancel: cancelBody}
+			}
 			return NewObjectAndInit(httpResponseClass, c, NewGoValue(resp))
 			// return NewGoValue(resp)
 		}).
 		Build()
 }
 
+// httpCancelBody 关闭响应body时结束请求的超时context
+type httpCancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b httpCancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// httpRequestClient 返回执行请求使用的client。请求通过certFile、hosts、tlsConfig、followRedirect做的设置
+// 作用在client及transport的副本上，不影响session或useClient指定的client
+func httpRequestClient(c *Context, req ValueObject) *http.Client {
+	var (
+		certs, hasCerts         = req.GetMember("__certs", c).(ValueArray)
+		hosts, hasHosts         = req.GetMember("__hosts", c).(ValueObject)
+		tlsConfig, hasTLSConfig = req.GetMember("__tlsConfig", c).(ValueObject)
+		follow, hasFollow       = req.GetMember("__shouldFollowRedirect", c).(ValueBool)
+		client                  http.Client
+	)
+	if hc, ok := req.GetMember("__goHttpClient", c).ToGoValue(c).(*http.Client); ok {
+		client = *hc
+	} else if tr, ok := req.GetMember("__goTransport", c).ToGoValue(c).(http.RoundTripper); ok {
+		// 测试客户端不经过网络，这些连接相关的设置没有意义
+		if hasCerts || hasHosts || hasTLSConfig {
+			c.RaiseRuntimeError("http.Request.call: certFile, hosts and tlsConfig are not supported by test client")
+		}
+		client.Transport = tr
+	} else if !hasCerts && !hasHosts && !hasTLSConfig && !hasFollow {
+		return http.DefaultClient
+	}
+	if hasCerts || hasHosts || hasTLSConfig {
+		var (
+			transport *http.Transport
+			session   *httpSession
+		)
+		switch tr := client.Transport.(type) {
+		case nil:
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		case *http.Transport:
+			transport = tr.Clone()
+		case *httpSession:
+			session = tr
+			transport = tr.transport.Clone()
+		default:
+			c.RaiseRuntimeError("http.Request.call: cannot apply certFile, hosts or tlsConfig to transport %T", tr)
+		}
+		if hasCerts && certs.Len() == 3 {
+			httpApplyCertFile(c, transport, certs.GetIndex(0, c).ToString(c), certs.GetIndex(1, c).ToString(c), certs.GetIndex(2, c).ToString(c))
+		}
+		if hasHosts {
+			hostsMap := hosts.ToGoValue(c).(map[string]interface{})
+			dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if mapped, exists := hostsMap[addr]; exists {
+					addr = fmt.Sprint(mapped)
+				}
+				return dialer.DialContext(ctx, network, addr)
+			}
+		}
+		if hasTLSConfig {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			if jsonBs, err := json.Marshal(tlsConfig.ToGoValue(c)); err != nil {
+				c.RaiseRuntimeError("set tls config error: %+v", err)
+			} else if err := json.Unmarshal(jsonBs, transport.TLSClientConfig); err != nil {
+				c.RaiseRuntimeError("set tls config error: %+v", err)
+			}
+		}
+		if session != nil {
+			// 复制session，重试、限流等设置不变，只替换底层的transport
+			sessionCopy := *session
+			sessionCopy.transport = transport
+			client.Transport = &sessionCopy
+		} else {
+			client.Transport = transport
+		}
+	}
+	if hasFollow && !follow.Value() {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return &client
+}
+
+// httpApplyCertFile 设置客户端证书，caFile不为空时同时指定信任的CA
+func httpApplyCertFile(c *Context, transport *http.Transport, certFile, keyFile, caFile string) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		c.RaiseRuntimeError("http.Request.call: load key pair error: %s", err)
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	if caFile != "" {
+		caCertPool := x509.NewCertPool()
+		if caCert, err := os.ReadFile(caFile); err != nil {
+			c.RaiseRuntimeError("http.Request.call: load ca cert error: %s", err)
+		} else {
+			caCertPool.AppendCertsFromPEM(caCert)
+			transport.TLSClientConfig.RootCAs = caCertPool
+		}
+	}
+}
+
 func initHttpResponseClass() ValueType {
 	return NewClassBuilder("Response").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
@@ -1433,15 +1542,102 @@ func initHttpFormFileClass() ValueType {
 		Build()
 }
 
+// httpWsClientState 记录客户端的握手参数与重连策略
+type httpWsClientState struct {
+	headers        http.Header
+	reconnect      bool
+	reconnectDelay time.Duration
+	maxDelay       time.Duration
+	maxReconnects  int
+	onReconnect    Value
+	// close可能在另一个协程阻塞于read时调用
+	closed atomic.Bool
+}
+
+func httpWsClientConn(c *Context, this ValueObject, funcName string) *websocket.Conn {
+	conn, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn)
+	if !ok || conn == nil {
+		c.RaiseRuntimeError("%s error: no connection", funcName)
+	}
+	return conn
+}
+
+// httpWsClientRedial 读写失败后按指数退避重新连接。未开启重连、已主动关闭或对方正常关闭时返回false
+func httpWsClientRedial(c *Context, this ValueObject, cause error) bool {
+	st := this.Reserved.(*httpWsClientState)
+	if !st.reconnect || st.closed.Load() || websocket.IsCloseError(cause, websocket.CloseNormalClosure) {
+		return false
+	}
+	if old, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn); ok && old != nil {
+		old.Close()
+	}
+	url := this.GetMember("__url", c).ToString(c)
+	delay := st.reconnectDelay
+	for attempt := 1; st.maxReconnects < 0 || attempt <= st.maxReconnects; attempt++ {
+		if err := httpSleep(c.Ctx, delay); err != nil {
+			return false
+		}
+		if st.closed.Load() {
+			return false
+		}
+		conn, _, err := websocket.DefaultDialer.DialContext(c.Ctx, url, st.headers)
+		if err == nil {
+			this.SetMember("__conn", NewGoValue(conn), c)
+			if st.onReconnect != nil {
+				c.Invoke(st.onReconnect, this, Args(NewInt(int64(attempt)), NewStr(cause.Error())))
+			}
+			return true
+		}
+		if delay *= 2; delay > st.maxDelay {
+			delay = st.maxDelay
+		}
+	}
+	return false
+}
+
+func httpWsClientRead(c *Context, this ValueObject, funcName string) (int, []byte) {
+	for {
+		conn := httpWsClientConn(c, this, funcName)
+		msgType, msg, err := conn.ReadMessage()
+		if err == nil {
+			return msgType, msg
+		}
+		if !httpWsClientRedial(c, this, err) {
+			c.RaiseRuntimeError("%s error: %s", funcName, err)
+		}
+	}
+}
+
 func initHttpWebsocketClientClass() ValueType {
 	return NewClassBuilder("WebsocketClient").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
 			var (
-				url ValueStr
+				url     ValueStr
+				options ValueObject
 			)
 			EnsureFuncParams(c, "http.WebSocket", args,
 				ArgRuleRequired("url", TypeStr, &url),
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
 			)
+			st := &httpWsClientState{
+				reconnectDelay: time.Second,
+				maxDelay:       30 * time.Second,
+				maxReconnects:  -1,
+			}
+			st.reconnect = options.GetMember("reconnect", c).IsTrue()
+			if d := httpOptionDuration(c, options, "reconnectDelay"); d > 0 {
+				st.reconnectDelay = d
+			}
+			if d := httpOptionDuration(c, options, "maxReconnectDelay"); d > 0 {
+				st.maxDelay = d
+			}
+			if n, ok := options.GetMember("maxReconnects", c).(ValueInt); ok {
+				st.maxReconnects = n.AsInt()
+			}
+			if cb, ok := c.GetCallable(options.GetMember("onReconnect", c)); ok {
+				st.onReconnect = cb
+			}
+			this.Reserved = st
 			this.SetMember("__url", url, c)
 		}).
 		Method("connect", func(c *Context, this ValueObject, args []Value) Value {
@@ -1462,6 +1658,9 @@ func initHttpWebsocketClientClass() ValueType {
 					headers.Add(key, val.ToString(c))
 				}
 			})
+			st := this.Reserved.(*httpWsClientState)
+			st.headers = headers
+			st.closed.Store(false)
 			conn, _, err = websocket.DefaultDialer.Dial(this.GetMember("__url", c).ToString(c), headers)
 			this.SetMember("__conn", NewGoValue(conn), c)
 			if err != nil {
@@ -1470,21 +1669,17 @@ func initHttpWebsocketClientClass() ValueType {
 			return Undefined()
 		}).
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*httpWsClientState).closed.Store(true)
 			conn, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn)
-			if ok {
+			if ok && conn != nil {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
 				conn.Close()
 			}
 			return this
 		}).
 		Method("read", func(c *Context, this ValueObject, args []Value) Value {
-			conn, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn)
-			if !ok || conn == nil {
-				c.RaiseRuntimeError("websocket read error: no connection")
-			}
-			msgType, msg, err := conn.ReadMessage()
-			if err != nil {
-				c.RaiseRuntimeError("websocket read error: %s", err)
-			}
+			msgType, msg := httpWsClientRead(c, this, "websocket read")
 			if msgType == websocket.TextMessage {
 				return NewStr(string(msg))
 			} else {
@@ -1492,14 +1687,7 @@ func initHttpWebsocketClientClass() ValueType {
 			}
 		}).
 		Method("readJson", func(c *Context, this ValueObject, args []Value) Value {
-			conn, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn)
-			if !ok || conn == nil {
-				c.RaiseRuntimeError("websocket readJson error: no connection")
-			}
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				c.RaiseRuntimeError("websocket readJson error: %s", err)
-			}
+			_, msg := httpWsClientRead(c, this, "websocket readJson")
 			var j interface{}
 			if err := json.Unmarshal(msg, &j); err != nil {
 				c.RaiseRuntimeError("websocket readJson error: %s", err)
@@ -1507,19 +1695,21 @@ func initHttpWebsocketClientClass() ValueType {
 			return jsonToValue(j, c)
 		}).
 		Method("write", func(c *Context, this ValueObject, args []Value) Value {
-			conn, ok := this.GetMember("__conn", c).ToGoValue(c).(*websocket.Conn)
-			if !ok || conn == nil {
-				c.RaiseRuntimeError("websocket write error: no connection")
-			}
-			var err error
 			for _, arg := range args {
+				msgType, pkg := websocket.TextMessage, []byte(nil)
 				if bs, ok := arg.(ValueBytes); ok {
-					err = conn.WriteMessage(websocket.BinaryMessage, bs.Value())
+					msgType, pkg = websocket.BinaryMessage, bs.Value()
 				} else {
-					err = conn.WriteMessage(websocket.TextMessage, []byte(arg.ToString(c)))
+					pkg = []byte(arg.ToString(c))
 				}
-				if err != nil {
-					c.RaiseRuntimeError("websocket write error: %s", err)
+				for {
+					err := httpWsClientConn(c, this, "websocket write").WriteMessage(msgType, pkg)
+					if err == nil {
+						break
+					}
+					if !httpWsClientRedial(c, this, err) {
+						c.RaiseRuntimeError("websocket write error: %s", err)
+					}
 				}
 			}
 			return Undefined()
@@ -1543,6 +1733,12 @@ func init() {
 	httpRequestClass = initHttpRequestClass()
 	httpResponseClass = initHttpResponseClass()
 	httpFormFileClass = initHttpFormFileClass()
+	httpTestClientClass = initHttpTestClientClass()
+	httpSessionClass = initHttpSessionClass()
+	httpSSEStreamClass = initHttpSSEStreamClass()
+	httpEventSourceClass = initHttpEventSourceClass()
+	httpWebsocketHubClass = initHttpWebsocketHubClass()
+	httpWebsocketConnClass = initHttpWebsocketConnClass()
 }
 
 func getLocalIPs() ([]string, error) {
@@ -0,0 +1,438 @@
+package builtin_libs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// httpRouter http.createServer创建的服务对象，路由由http.ServeMux完成，
+	// 支持 GET /users/{id} 形式的方法及路径参数匹配
+	httpRouter struct {
+		c          *Context
+		mux        *http.ServeMux
+		serverName string
+		root       *httpRouteGroup
+		// 通过use添加的中间件及onShutdown回调，服务运行期间也可能修改
+		mu         sync.RWMutex
+		onShutdown []Value
+		// serve期间的服务实例
+		server      *http.Server
+		shutdownErr chan error
+		// createServer的配置
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+		maxHeaderBytes    int
+		maxBodySize       int64
+	}
+	// httpRouteGroup 路由分组，组内路由共享路径前缀及中间件。根分组的中间件对所有请求生效
+	httpRouteGroup struct {
+		router      *httpRouter
+		parent      *httpRouteGroup
+		prefix      string
+		middlewares []Value
+	}
+	// httpRouteCall 一次请求的上下文，通过request的context传给路由处理函数
+	httpRouteCall struct {
+		c   *Context
+		ctx ValueObject
+	}
+	httpRouteCallKey struct{}
+)
+
+var (
+	httpRouteParamPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(\.\.\.)?\}`)
+	httpRouteMethods      = []string{"get", "post", "put", "patch", "delete", "head", "options"}
+)
+
+func newHttpRouter(c *Context, opts ValueObject) *httpRouter {
+	rt := &httpRouter{
+		c:          c,
+		mux:        http.NewServeMux(),
+		serverName: "zgg http server",
+	}
+	rt.root = &httpRouteGroup{router: rt}
+	if opts == nil {
+		return rt
+	}
+	if serverName, ok := opts.GetMember("serverName", c).(ValueStr); ok {
+		rt.serverName = serverName.Value()
+	}
+	rt.readTimeout = httpOptionDuration(c, opts, "readTimeout")
+	rt.readHeaderTimeout = httpOptionDuration(c, opts, "readHeaderTimeout")
+	rt.writeTimeout = httpOptionDuration(c, opts, "writeTimeout")
+	rt.idleTimeout = httpOptionDuration(c, opts, "idleTimeout")
+	if n, ok := opts.GetMember("maxHeaderBytes", c).(ValueInt); ok {
+		rt.maxHeaderBytes = n.AsInt()
+	}
+	if n, ok := opts.GetMember("maxBodySize", c).(ValueInt); ok {
+		rt.maxBodySize = n.Value()
+	}
+	return rt
+}
+
+// httpOptionDuration 读取配置中的时长，可以是Duration对象、'5s'形式的字符串或秒数，未配置时返回0
+func httpOptionDuration(c *Context, opts ValueObject, name string) time.Duration {
+	v := opts.GetMember(name, c)
+	if IsUndefined(v) {
+		return 0
+	}
+	if n, ok := v.(ValueInt); ok {
+		return time.Duration(n.Value()) * time.Second
+	}
+	var d timeDurationArg
+	EnsureFuncParams(c, "http.createServer options", []Value{v}, d.Rule(c, name))
+	return d.GetDuration(c)
+}
+
+func (rt *httpRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body *httpRequestBody
+	if rt.maxBodySize > 0 {
+		body = &httpRequestBody{ReadCloser: http.MaxBytesReader(w, r.Body, rt.maxBodySize)}
+		r.Body = body
+	}
+	w.Header().Set("server", rt.serverName)
+	newC := rt.c.CloneWithContext(r.Context())
+	call := &httpRouteCall{c: newC}
+	r = r.WithContext(context.WithValue(r.Context(), httpRouteCallKey{}, call))
+	call.ctx = NewObjectAndInit(httpRequestContextClass, newC, NewGoValue(w), NewGoValue(r))
+	defer newC.Recover()
+	// 处理函数抛出异常时也要停止SSE流的keep-alive
+	defer httpCloseSSE(newC, call.ctx)
+	defer func() {
+		if e := recover(); e != nil {
+			if body != nil && body.exceeded {
+				// 读取请求体超出maxBodySize导致的异常，返回413
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			panic(e)
+		}
+	}()
+	rt.runChain(newC, call.ctx, rt.root.getMiddlewares(true), func() Value {
+		// ServeMux会把匹配结果记录在r中，路由处理函数再从中取路径参数
+		rt.mux.ServeHTTP(w, r)
+		return Undefined()
+	})
+	if callable, ok := newC.GetCallable(call.ctx.GetMember("close", newC)); ok {
+		newC.Invoke(callable, call.ctx, NoArgs)
+	}
+}
+
+// httpRequestBody 记录请求体是否超出了maxBodySize
+type httpRequestBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *httpRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// httpCheckBodyError 解析表单等忽略错误的地方，请求体超出maxBodySize时仍然需要抛出异常
+func httpCheckBodyError(c *Context, funcName string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.RaiseRuntimeError("%s error %s", funcName, err)
+	}
+}
+
+// runChain 依次执行中间件，中间件通过调用next()执行后续的中间件，最后执行final
+func (rt *httpRouter) runChain(c *Context, ctx ValueObject, middlewares []Value, final func() Value) Value {
+	var step func(i int) Value
+	step = func(i int) Value {
+		if i >= len(middlewares) {
+			return final()
+		}
+		called := false
+		next := NewNativeFunction("next", func(c *Context, this Value, args []Value) Value {
+			if called {
+				c.RaiseRuntimeError("http.server: next() called more than once")
+			}
+			called = true
+			return step(i + 1)
+		})
+		c.Invoke(middlewares[i], nil, Args(ctx, next))
+		return c.RetVal
+	}
+	return step(0)
+}
+
+func (rt *httpRouter) newServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           rt,
+		ReadTimeout:       rt.readTimeout,
+		ReadHeaderTimeout: rt.readHeaderTimeout,
+		WriteTimeout:      rt.writeTimeout,
+		IdleTimeout:       rt.idleTimeout,
+		MaxHeaderBytes:    rt.maxHeaderBytes,
+	}
+}
+
+// serve 启动服务并阻塞，直到服务出错或被shutdown关闭。被shutdown关闭时等待关闭完成后返回
+func (rt *httpRouter) serve(c *Context, addr string, options ValueObject) {
+	server := rt.newServer(addr)
+	rt.mu.Lock()
+	if rt.server != nil {
+		rt.mu.Unlock()
+		c.RaiseRuntimeError("http.server.serve: server is already running")
+	}
+	rt.server = server
+	rt.shutdownErr = make(chan error, 1)
+	shutdownErr := rt.shutdownErr
+	rt.mu.Unlock()
+	defer func() {
+		rt.mu.Lock()
+		rt.server = nil
+		rt.mu.Unlock()
+	}()
+	if shutdownOnSignal, ok := options.GetMember("shutdownOnSignal", c).(ValueBool); ok && shutdownOnSignal.Value() {
+		timeout := httpOptionDuration(c, options, "shutdownTimeout")
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		stopped := make(chan struct{})
+		defer func() {
+			signal.Stop(signals)
+			close(stopped)
+		}()
+		go func() {
+			select {
+			case <-signals:
+				rt.shutdown(timeout)
+			case <-stopped:
+			}
+		}()
+	}
+	var err error
+	if strings.HasPrefix(addr, httpUnixPrefix) {
+		unixAddr, rerr := net.ResolveUnixAddr("unix", addr[len(httpUnixPrefix):])
+		if rerr != nil {
+			c.RaiseRuntimeError("resolve unix addr %s error %s", addr, rerr)
+		}
+		listener, lerr := net.ListenUnix("unix", unixAddr)
+		if lerr != nil {
+			c.RaiseRuntimeError("listen %s error %s", addr, lerr)
+		}
+		defer listener.Close()
+		listener.SetUnlinkOnClose(true)
+		err = server.Serve(listener)
+	} else {
+		cf, hasCert := options.GetMember("certFile", c).(ValueStr)
+		kf, hasKey := options.GetMember("keyFile", c).(ValueStr)
+		if hasCert && hasKey {
+			err = server.ListenAndServeTLS(cf.Value(), kf.Value())
+		} else {
+			err = server.ListenAndServe()
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = <-shutdownErr
+		if err != nil {
+			c.RaiseRuntimeError("http.server.shutdown fail: %s", err)
+		}
+		return
+	}
+	if err != nil {
+		c.RaiseRuntimeError("http.server.serve fail: %s", err)
+	}
+}
+
+// inHandler 判断c是否属于该服务正在处理的请求
+func (rt *httpRouter) inHandler(c *Context) bool {
+	rt.mu.RLock()
+	server := rt.server
+	rt.mu.RUnlock()
+	return server != nil && c.Ctx != nil && c.Ctx.Value(http.ServerContextKey) == server
+}
+
+// shutdown 关闭服务：不再接受新连接，等待进行中的请求处理完成，超过timeout后强制关闭。
+// timeout为0时一直等待。关闭后依次执行onShutdown回调，返回false表示服务未在运行。
+// 不能在请求处理中直接调用，否则会一直等待调用方自己所在的请求结束
+func (rt *httpRouter) shutdown(timeout time.Duration) (bool, error) {
+	rt.mu.RLock()
+	server, shutdownErr := rt.server, rt.shutdownErr
+	rt.mu.RUnlock()
+	if server == nil {
+		return false, nil
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	rt.mu.RLock()
+	callbacks := rt.onShutdown
+	rt.mu.RUnlock()
+	for _, callback := range callbacks {
+		func() {
+			newC := rt.c.CloneWithContext(context.Background())
+			defer newC.Recover()
+			newC.Invoke(callback, nil, NoArgs)
+		}()
+	}
+	select {
+	case shutdownErr <- err:
+	default:
+	}
+	return true, err
+}
+
+func (g *httpRouteGroup) fullPath(path string) string {
+	if g.prefix == "" {
+		return path
+	}
+	return strings.TrimSuffix(g.prefix, "/") + path
+}
+
+// getMiddlewares 返回从根分组（includeRoot为false时不含根分组）到当前分组的所有中间件
+func (g *httpRouteGroup) getMiddlewares(includeRoot bool) []Value {
+	g.router.mu.RLock()
+	defer g.router.mu.RUnlock()
+	var groups []*httpRouteGroup
+	for p := g; p != nil && (includeRoot || p.parent != nil); p = p.parent {
+		groups = append(groups, p)
+	}
+	var rv []Value
+	for i := len(groups) - 1; i >= 0; i-- {
+		rv = append(rv, groups[i].middlewares...)
+	}
+	return rv
+}
+
+func (g *httpRouteGroup) handle(c *Context, method, path string, handler Value) {
+	pattern := g.fullPath(path)
+	if method != "" {
+		pattern = method + " " + pattern
+	}
+	var paramNames []string
+	for _, m := range httpRouteParamPattern.FindAllStringSubmatch(pattern, -1) {
+		paramNames = append(paramNames, m[1])
+	}
+	err := httpMuxHandle(g.router.mux, pattern, func(w http.ResponseWriter, r *http.Request) {
+		call, _ := r.Context().Value(httpRouteCallKey{}).(*httpRouteCall)
+		params := NewObject()
+		for _, name := range paramNames {
+			params.SetMember(name, NewStr(r.PathValue(name)), call.c)
+		}
+		call.ctx.SetMember("params", params, call.c)
+		g.router.runChain(call.c, call.ctx, g.getMiddlewares(false), func() Value {
+			call.c.Invoke(handler, nil, Args(call.ctx))
+			return call.c.RetVal
+		})
+	})
+	if err != nil {
+		c.RaiseRuntimeError("http.server: add route %s error %s", pattern, err)
+	}
+}
+
+// httpMuxHandle ServeMux遇到非法或冲突的路由时会panic，这里转为error
+func httpMuxHandle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	mux.HandleFunc(pattern, handler)
+	return nil
+}
+
+// bind 把路由相关的方法设置到obj上
+func (g *httpRouteGroup) bind(obj ValueObject) {
+	for _, method := range httpRouteMethods {
+		httpMethod := strings.ToUpper(method)
+		obj.SetMember(method, NewNativeFunction(method, func(c *Context, thisArg Value, args []Value) Value {
+			var (
+				path    ValueStr
+				handler ValueCallable
+			)
+			EnsureFuncParams(c, "http.server."+strings.ToLower(httpMethod), args,
+				ArgRuleRequired("path", TypeStr, &path),
+				ArgRuleRequired("handleFunc", TypeFunc, &handler),
+			)
+			g.handle(c, httpMethod, path.Value(), handler)
+			return thisArg
+		}, "path", "handleFunc"), nil)
+	}
+	obj.SetMember("any", NewNativeFunction("any", func(c *Context, thisArg Value, args []Value) Value {
+		var (
+			path    ValueStr
+			handler ValueCallable
+		)
+		EnsureFuncParams(c, "http.server.any", args,
+			ArgRuleRequired("path", TypeStr, &path),
+			ArgRuleRequired("handleFunc", TypeFunc, &handler),
+		)
+		g.handle(c, "", path.Value(), handler)
+		return thisArg
+	}, "path", "handleFunc"), nil)
+	obj.SetMember("route", NewNativeFunction("route", func(c *Context, thisArg Value, args []Value) Value {
+		if len(args) < 2 {
+			c.RaiseRuntimeError("http.server: route requires at least 2 arguments")
+			return nil
+		}
+		path := c.MustStr(args[0], "http.server.route(path, handleFunc): path")
+		handleFunc := c.MustCallable(args[1], "http.server.route(path, handleFunc): function")
+		g.handle(c, "", path, handleFunc)
+		return thisArg
+	}, "path", "handleFunc"), nil)
+	// use是关键词，不能写成obj.use(...)，因此同时提供别名middleware
+	use := NewNativeFunction("use", func(c *Context, thisArg Value, args []Value) Value {
+		middlewares := make([]Value, len(args))
+		for i, arg := range args {
+			middlewares[i] = c.MustCallable(arg, "http.server.use(middleware): middleware")
+		}
+		g.router.mu.Lock()
+		g.middlewares = append(g.middlewares, middlewares...)
+		g.router.mu.Unlock()
+		return thisArg
+	}, "middleware")
+	obj.SetMember("use", use, nil)
+	obj.SetMember("middleware", use, nil)
+	obj.SetMember("group", NewNativeFunction("group", func(c *Context, thisArg Value, args []Value) Value {
+		var (
+			prefix ValueStr
+			setup  ValueCallable
+		)
+		EnsureFuncParams(c, "http.server.group", args,
+			ArgRuleRequired("prefix", TypeStr, &prefix),
+			ArgRuleOptional("setup", TypeFunc, &setup, nil),
+		)
+		sub := &httpRouteGroup{
+			router: g.router,
+			parent: g,
+			prefix: g.fullPath(prefix.Value()),
+		}
+		groupObj := NewObject()
+		sub.bind(groupObj)
+		if setup != nil {
+			c.Invoke(setup, nil, Args(groupObj))
+		}
+		return groupObj
+	}, "prefix", "setup"), nil)
+}
@@ -0,0 +1,137 @@
+package builtin_libs_test
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testHttpRouterSetup = `
+http := import('http')
+server := http.createServer({maxBodySize: 8})
+logs := []
+server.middleware((ctx, next) => {
+	logs.push('root ' + ctx.method + ' ' + ctx.path)
+	if ctx.getHeader('x-deny') == 'yes' {
+		ctx.write(403, 'denied')
+		return
+	}
+	next()
+})
+server.get('/users/{id}', ctx => ctx.writeJson({id: ctx.params.id}))
+server.post('/echo', ctx => ctx.write(200, ctx.getBodyStr()))
+server.post('/form', ctx => ctx.write(200, ctx.form('a')))
+server.group('/api', g => {
+	g.middleware((ctx, next) => {
+		ctx.setHeader('x-group', 'api')
+		next()
+	})
+	g.get('/files/{path...}', ctx => ctx.write(200, ctx.params.path))
+})
+client := server.test()
+resp := (r, withHeader) => [r.statusCode, r.text()] + (withHeader ? [r.header('x-group')] : [])
+`
+
+func TestHttpRouter(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"PathParam", `
+			export result := resp(client.get('/users/42'))
+		`, []interface{}{int64(200), "{\"id\":\"42\"}\n"}},
+		{"GroupWildcard", `
+			export result := resp(client.get('/api/files/a/b.txt'), true)
+		`, []interface{}{int64(200), "a/b.txt", "api"}},
+		{"NotFound", `
+			export result := resp(client.get('/users/42/more'))
+		`, []interface{}{int64(404), "404 page not found\n"}},
+		{"MethodNotAllowed", `
+			export result := resp(client.post('/users/42'))
+		`, []interface{}{int64(405), "Method Not Allowed\n"}},
+		{"MiddlewareStops", `
+			export result := resp(client.request('GET', '/users/1').header({'x-deny': 'yes'}).call())
+		`, []interface{}{int64(403), "denied"}},
+		{"BodyWithinLimit", `
+			export result := resp(client.post('/echo', 'short'))
+		`, []interface{}{int64(200), "short"}},
+		{"BodyTooLarge", `
+			export result := resp(client.post('/echo', 'much too long'))
+		`, []interface{}{int64(413), "Request Entity Too Large\n"}},
+		{"FormTooLarge", `
+			export result := resp(client.request('POST', '/form').header({'Content-Type': 'application/x-www-form-urlencoded'}).data('a=1&b=22222222').call())
+		`, []interface{}{int64(413), "Request Entity Too Large\n"}},
+		{"RootMiddlewareSeesAllRequests", `
+			client.get('/api/files/x')
+			client.post('/users/42')
+			export result := logs
+		`, []interface{}{"root GET /api/files/x", "root POST /users/42"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testHttpRouterSetup + tc.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+const testHttpRouterShutdownCode = `
+http := import('http')
+concurrent := import('concurrent')
+server := http.createServer()
+closed := []
+server.onShutdown(() => closed.push(true))
+server.get('/stop', ctx => {
+	ctx.write(200, 'running=' + str(server.shutdown(0)))
+})
+reply := concurrent.Chan(1)
+concurrent.start(() => {
+	for i := 0; i < 100; i++ {
+		try {
+			reply.send(http.getText('http://{addr}/stop'))
+			return
+		} catch (e) {
+			import('time').sleep(0.02)
+		}
+	}
+	reply.send('unreachable')
+})
+server.serve('{addr}')
+export result := [reply.recv(), closed]
+`
+
+func TestHttpRouterShutdownInHandler(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	done := make(chan map[string]interface{}, 1)
+	go func() {
+		exported, err := zgg.RunCode(strings.ReplaceAll(testHttpRouterShutdownCode, "{addr}", addr))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- exported
+	}()
+	select {
+	case exported := <-done:
+		expected := []interface{}{"running=true", []interface{}{true}}
+		if actual := exported["result"]; !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected %#v, got %#v", expected, actual)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown in handler blocks")
+	}
+}
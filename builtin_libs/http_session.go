@@ -0,0 +1,368 @@
+package builtin_libs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// httpSession 保存cookie、默认header、代理、重试及限流等设置，session创建的请求共用同一个连接池
+	httpSession struct {
+		client          *http.Client
+		transport       *http.Transport
+		baseUrl         *url.URL
+		headers         http.Header
+		retry           httpRetryPolicy
+		limiter         *httpRateLimiter
+		maxResponseSize int64
+	}
+	// httpRetryPolicy 重试策略，出现连接错误或返回statusCodes中的状态码时重试
+	httpRetryPolicy struct {
+		maxAttempts int
+		statusCodes map[int]bool
+		methods     map[string]bool
+		backoff     time.Duration
+		maxBackoff  time.Duration
+	}
+	// httpRateLimiter 按固定间隔放行请求
+	httpRateLimiter struct {
+		mu       sync.Mutex
+		interval time.Duration
+		next     time.Time
+	}
+	// httpLimitedBody 读取超过limit字节时返回错误
+	httpLimitedBody struct {
+		io.ReadCloser
+		limit     int64
+		remaining int64
+	}
+)
+
+var (
+	httpRetryDefaultStatusCodes = []int{429, 500, 502, 503, 504}
+	httpRetryDefaultMethods     = []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE"}
+)
+
+func (l *httpRateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return httpSleep(ctx, delay)
+}
+
+func httpSleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *httpLimitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// 恰好读完limit字节时，再确认一下是否还有剩余数据
+		var one [1]byte
+		if n, _ := b.ReadCloser.Read(one[:]); n > 0 {
+			return 0, fmt.Errorf("http: response body exceeds limit of %d bytes", b.limit)
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// delay 第attempt次请求失败后的等待时长。响应带有Retry-After时以其为准，超过maxBackoff时返回false不再重试
+func (p *httpRetryPolicy) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			var d time.Duration
+			if secs, err := strconv.Atoi(after); err == nil {
+				d = time.Duration(secs) * time.Second
+			} else if t, err := http.ParseTime(after); err == nil {
+				d = time.Until(t)
+			}
+			if p.maxBackoff > 0 && d > p.maxBackoff {
+				return 0, false
+			}
+			return d, true
+		}
+	}
+	d := p.backoff << (attempt - 1)
+	if d <= 0 || (p.maxBackoff > 0 && d > p.maxBackoff) {
+		d = p.maxBackoff
+	}
+	if d > 0 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	return d, true
+}
+
+func (s *httpSession) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+	for key, values := range s.headers {
+		if _, found := req.Header[key]; !found {
+			req.Header[key] = values
+		}
+	}
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 1; ; attempt++ {
+		if s.limiter != nil {
+			if err := s.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := s.transport.RoundTrip(req)
+		if attempt >= s.retry.maxAttempts || !rewindable || !s.retry.methods[req.Method] ||
+			(err == nil && !s.retry.statusCodes[resp.StatusCode]) {
+			if err != nil {
+				return nil, err
+			}
+			return s.limitResponse(resp)
+		}
+		wait, ok := s.retry.delay(attempt, resp)
+		if !ok {
+			return s.limitResponse(resp)
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 65536))
+			resp.Body.Close()
+		}
+		if err := httpSleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *httpSession) limitResponse(resp *http.Response) (*http.Response, error) {
+	if s.maxResponseSize <= 0 {
+		return resp, nil
+	}
+	if resp.ContentLength > s.maxResponseSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http: response body size %d exceeds limit of %d bytes", resp.ContentLength, s.maxResponseSize)
+	}
+	resp.Body = &httpLimitedBody{ReadCloser: resp.Body, limit: s.maxResponseSize, remaining: s.maxResponseSize}
+	return resp, nil
+}
+
+func (s *httpSession) resolveUrl(c *Context, rawUrl string) string {
+	if s.baseUrl == nil || strings.Contains(rawUrl, "://") {
+		return rawUrl
+	}
+	ref, err := url.Parse(rawUrl)
+	if err != nil {
+		c.RaiseRuntimeError("http.Session: invalid url %s: %s", rawUrl, err)
+	}
+	return s.baseUrl.ResolveReference(ref).String()
+}
+
+func newHttpSession(c *Context, opts ValueObject) *httpSession {
+	jar, _ := cookiejar.New(nil)
+	s := &httpSession{
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+		headers:   http.Header{},
+		retry: httpRetryPolicy{
+			maxAttempts: 1,
+			statusCodes: map[int]bool{},
+			methods:     map[string]bool{},
+		},
+	}
+	s.client = &http.Client{Transport: s, Jar: jar}
+	if baseUrl, ok := opts.GetMember("baseUrl", c).(ValueStr); ok {
+		u, err := url.Parse(baseUrl.Value())
+		if err != nil {
+			c.RaiseRuntimeError("http.Session: invalid baseUrl %s: %s", baseUrl.Value(), err)
+		}
+		s.baseUrl = u
+	}
+	if headers, ok := opts.GetMember("headers", c).(ValueObject); ok {
+		headers.Iterate(func(key string, val Value) {
+			s.headers.Add(key, val.ToString(c))
+		})
+	}
+	if proxy, ok := opts.GetMember("proxy", c).(ValueStr); ok {
+		u, err := url.Parse(proxy.Value())
+		if err != nil {
+			c.RaiseRuntimeError("http.Session: invalid proxy %s: %s", proxy.Value(), err)
+		}
+		s.transport.Proxy = http.ProxyURL(u)
+	}
+	s.client.Timeout = httpOptionDuration(c, opts, "timeout")
+	if follow, ok := opts.GetMember("followRedirect", c).(ValueBool); ok && !follow.Value() {
+		s.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if insecure, ok := opts.GetMember("insecureSkipVerify", c).(ValueBool); ok && insecure.Value() {
+		s.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	// 连接池
+	if n, ok := opts.GetMember("maxIdleConns", c).(ValueInt); ok {
+		s.transport.MaxIdleConns = n.AsInt()
+	}
+	if n, ok := opts.GetMember("maxIdleConnsPerHost", c).(ValueInt); ok {
+		s.transport.MaxIdleConnsPerHost = n.AsInt()
+	}
+	if n, ok := opts.GetMember("maxConnsPerHost", c).(ValueInt); ok {
+		s.transport.MaxConnsPerHost = n.AsInt()
+	}
+	if d := httpOptionDuration(c, opts, "idleConnTimeout"); d > 0 {
+		s.transport.IdleConnTimeout = d
+	}
+	// 限流，每秒最多rateLimit个请求
+	if rate, ok := opts.GetMember("rateLimit", c).(ValueFloat); ok && rate.Value() > 0 {
+		s.limiter = &httpRateLimiter{interval: time.Duration(float64(time.Second) / rate.Value())}
+	} else if rate, ok := opts.GetMember("rateLimit", c).(ValueInt); ok && rate.Value() > 0 {
+		s.limiter = &httpRateLimiter{interval: time.Second / time.Duration(rate.Value())}
+	}
+	if n, ok := opts.GetMember("maxResponseSize", c).(ValueInt); ok {
+		s.maxResponseSize = n.Value()
+	}
+	if retry, ok := opts.GetMember("retry", c).(ValueObject); ok {
+		s.retry.maxAttempts = 3
+		if n, ok := retry.GetMember("maxAttempts", c).(ValueInt); ok {
+			s.retry.maxAttempts = n.AsInt()
+		}
+		if codes, ok := retry.GetMember("statusCodes", c).(ValueArray); ok {
+			for i := 0; i < codes.Len(); i++ {
+				s.retry.statusCodes[int(c.MustInt(codes.GetIndex(i, c), "http.Session retry.statusCodes"))] = true
+			}
+		} else {
+			for _, code := range httpRetryDefaultStatusCodes {
+				s.retry.statusCodes[code] = true
+			}
+		}
+		if methods, ok := retry.GetMember("methods", c).(ValueArray); ok {
+			for i := 0; i < methods.Len(); i++ {
+				s.retry.methods[strings.ToUpper(methods.GetIndex(i, c).ToString(c))] = true
+			}
+		} else {
+			for _, method := range httpRetryDefaultMethods {
+				s.retry.methods[method] = true
+			}
+		}
+		s.retry.backoff = 200 * time.Millisecond
+		if d := httpOptionDuration(c, retry, "backoff"); d > 0 {
+			s.retry.backoff = d
+		}
+		s.retry.maxBackoff = 10 * time.Second
+		if d := httpOptionDuration(c, retry, "maxBackoff"); d > 0 {
+			s.retry.maxBackoff = d
+		}
+	}
+	return s
+}
+
+func initHttpSessionClass() ValueType {
+	className := "http.Session"
+	newRequest := func(c *Context, this ValueObject, method, url string) ValueObject {
+		s := this.Reserved.(*httpSession)
+		req := NewObjectAndInit(httpRequestClass, c, NewStr(method), NewStr(s.resolveUrl(c, url)))
+		req.SetMember("__goHttpClient", NewGoValue(s.client), c)
+		return req
+	}
+	cookieUrl := func(c *Context, this ValueObject, args []Value) *url.URL {
+		var rawUrl ValueStr
+		EnsureFuncParams(c, className+".cookies", args,
+			ArgRuleOptional("url", TypeStr, &rawUrl, NewStr("")),
+		)
+		s := this.Reserved.(*httpSession)
+		u, err := url.Parse(s.resolveUrl(c, rawUrl.Value()))
+		if err != nil || u.Host == "" {
+			c.RaiseRuntimeError("%s: invalid cookie url %s", className, rawUrl.Value())
+		}
+		return u
+	}
+	return NewClassBuilder("Session").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var opts ValueObject
+			EnsureFuncParams(c, className+".__init__", args,
+				ArgRuleOptional("options", TypeObject, &opts, NewObject()),
+			)
+			this.Reserved = newHttpSession(c, opts)
+		}).
+		Method("request", func(c *Context, this ValueObject, args []Value) Value {
+			method, url := httpRequestArgs(c, className+".request", args)
+			return newRequest(c, this, method, url)
+		}, "method", "url").
+		Method("get", httpShortcutMethod(className, "GET", newRequest), "url", "body", "headers").
+		Method("post", httpShortcutMethod(className, "POST", newRequest), "url", "body", "headers").
+		Method("put", httpShortcutMethod(className, "PUT", newRequest), "url", "body", "headers").
+		Method("patch", httpShortcutMethod(className, "PATCH", newRequest), "url", "body", "headers").
+		Method("delete", httpShortcutMethod(className, "DELETE", newRequest), "url", "body", "headers").
+		Method("header", func(c *Context, this ValueObject, args []Value) Value {
+			var name, value ValueStr
+			EnsureFuncParams(c, className+".header", args,
+				ArgRuleRequired("name", TypeStr, &name),
+				ArgRuleRequired("value", TypeStr, &value),
+			)
+			this.Reserved.(*httpSession).headers.Set(name.Value(), value.Value())
+			return this
+		}, "name", "value").
+		Method("cookies", func(c *Context, this ValueObject, args []Value) Value {
+			u := cookieUrl(c, this, args)
+			rv := NewObject()
+			for _, cookie := range this.Reserved.(*httpSession).client.Jar.Cookies(u) {
+				rv.SetMember(cookie.Name, NewStr(cookie.Value), c)
+			}
+			return rv
+		}, "url").
+		Method("setCookie", func(c *Context, this ValueObject, args []Value) Value {
+			var name, value ValueStr
+			if len(args) != 3 {
+				c.RaiseRuntimeError("%s.setCookie requires 3 arguments", className)
+			}
+			EnsureFuncParams(c, className+".setCookie", args[1:],
+				ArgRuleRequired("name", TypeStr, &name),
+				ArgRuleRequired("value", TypeStr, &value),
+			)
+			u := cookieUrl(c, this, args[:1])
+			this.Reserved.(*httpSession).client.Jar.SetCookies(u, []*http.Cookie{{Name: name.Value(), Value: value.Value()}})
+			return this
+		}, "url", "name", "value").
+		Method("clearCookies", func(c *Context, this ValueObject, args []Value) Value {
+			jar, _ := cookiejar.New(nil)
+			this.Reserved.(*httpSession).client.Jar = jar
+			return this
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*httpSession).transport.CloseIdleConnections()
+			return Undefined()
+		}).
+		Build()
+}
@@ -0,0 +1,159 @@
+package builtin_libs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testHttpSessionSetup = `
+http := import('http')
+errorOf := (f, expect) => {
+	try {
+		f()
+		return 'no error'
+	} catch (e) {
+		return e.message.contains(expect) ? 'error' : e.message
+	}
+}
+s := http.Session({
+	baseUrl: '{url}',
+	headers: {'x-app': 'zgg'},
+	retry: {maxAttempts: 3, backoff: 0.01},
+	maxResponseSize: 40,
+})
+`
+
+func TestHttpSession(t *testing.T) {
+	var (
+		flakyCalls int32
+		ticksLock  sync.Mutex
+		ticks      []time.Time
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&flakyCalls, 1) < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok after retries"))
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s1", Path: "/"})
+	})
+	mux.HandleFunc("/cookie", func(w http.ResponseWriter, r *http.Request) {
+		sid := ""
+		if cookie, err := r.Cookie("sid"); err == nil {
+			sid = cookie.Value
+		}
+		w.Write([]byte(r.Host + " sid=" + sid + " app=" + r.Header.Get("x-app")))
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		// 不设置Content-Length，读取时才能发现超出限制
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("x", 64)))
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("small"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/small", http.StatusFound)
+	})
+	mux.HandleFunc("/tick", func(w http.ResponseWriter, r *http.Request) {
+		ticksLock.Lock()
+		ticks = append(ticks, time.Now())
+		ticksLock.Unlock()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+		check    func(t *testing.T)
+	}{
+		{"Retry", `
+			r := s.get('/flaky')
+			export result := [r.statusCode, r.text()]
+		`, []interface{}{int64(200), "ok after retries"}, func(t *testing.T) {
+			if n := atomic.LoadInt32(&flakyCalls); n != 3 {
+				t.Fatalf("expected 3 attempts, got %d", n)
+			}
+		}},
+		{"Cookies", `
+			s.get('/login')
+			export result := [s.get('/cookie').text(), s.cookies()]
+		`, []interface{}{addr + " sid=s1 app=zgg", map[string]interface{}{"sid": "s1"}}, nil},
+		{"MaxResponseSize", `
+			big := s.get('/big')
+			export result := [errorOf(() => big.text(), 'exceeds limit'), s.get('/small').text()]
+		`, []interface{}{"error", "small"}, nil},
+		{"Timeout", `
+			export result := errorOf(() => s.request('/slow').timeout(0.05).call(), 'context deadline exceeded')
+		`, "error", nil},
+		{"TimeoutLongEnough", `
+			export result := s.request('/slow').timeout(5).call().text()
+		`, "slow", nil},
+		{"NoFollowRedirect", `
+			export result := s.request('/redirect').followRedirect(false).call().statusCode
+		`, int64(302), nil},
+		{"FollowRedirect", `
+			export result := s.get('/redirect').text()
+		`, "small", nil},
+		{"HostsKeepCookiesPerHost", `
+			s.get('/login')
+			export result := [
+				s.request('http://fake.host/cookie').hosts({'fake.host:80': '{addr}'}).call().text(),
+				s.get('/cookie').text(),
+			]
+		`, []interface{}{"fake.host sid= app=zgg", addr + " sid=s1 app=zgg"}, nil},
+		{"RateLimit", `
+			limited := http.Session({baseUrl: '{url}', rateLimit: 20})
+			for i := 0; i < 4; i++ {
+				limited.get('/tick').text()
+			}
+			export result := nil
+		`, nil, func(t *testing.T) {
+			ticksLock.Lock()
+			defer ticksLock.Unlock()
+			if len(ticks) != 4 {
+				t.Fatalf("expected 4 requests, got %d", len(ticks))
+			}
+			for i := 1; i < len(ticks); i++ {
+				if gap := ticks[i].Sub(ticks[i-1]); gap < 40*time.Millisecond {
+					t.Fatalf("rate limit not applied, gap %s", gap)
+				}
+			}
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := strings.NewReplacer("{url}", server.URL, "{addr}", addr).Replace(testHttpSessionSetup + tc.code)
+			exported, err := zgg.RunCode(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+			if tc.check != nil {
+				tc.check(t)
+			}
+		})
+	}
+}
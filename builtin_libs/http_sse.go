@@ -0,0 +1,407 @@
+package builtin_libs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// httpSSEStream 服务端的Server-Sent Events输出流，只能在请求处理函数返回前使用
+	httpSSEStream struct {
+		mu     sync.Mutex
+		w      http.ResponseWriter
+		rc     *http.ResponseController
+		ctx    context.Context
+		closed bool
+		stop   chan struct{}
+		wg     sync.WaitGroup
+	}
+	// httpEventSource 客户端的EventSource，断开后自动重连并带上Last-Event-ID
+	httpEventSource struct {
+		url           string
+		headers       http.Header
+		client        *http.Client
+		retry         time.Duration
+		maxReconnects int
+		reconnects    int
+		lastEventId   string
+		resp          *http.Response
+		reader        *bufio.Reader
+		closed        bool
+	}
+)
+
+func newHttpSSEStream(w http.ResponseWriter, r *http.Request, keepAlive time.Duration, retry int64) (*httpSSEStream, error) {
+	s := &httpSSEStream{
+		w:    w,
+		rc:   http.NewResponseController(w),
+		ctx:  r.Context(),
+		stop: make(chan struct{}),
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	// 事件流是长连接，不受服务端writeTimeout的限制
+	s.rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", retry)
+	}
+	if err := s.rc.Flush(); err != nil {
+		return nil, err
+	}
+	if keepAlive > 0 {
+		s.wg.Add(1)
+		go s.keepAlive(keepAlive)
+	}
+	return s, nil
+}
+
+func (s *httpSSEStream) keepAlive(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.write(": keep-alive\n\n")
+		}
+	}
+}
+
+// write 写入并立即flush，客户端已断开或流已关闭时返回false
+func (s *httpSSEStream) write(text string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.ctx.Err() != nil {
+		return false
+	}
+	if _, err := io.WriteString(s.w, text); err != nil {
+		return false
+	}
+	return s.rc.Flush() == nil
+}
+
+func (s *httpSSEStream) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// httpCloseSSE 关闭RequestContext上通过sse()创建的流
+func httpCloseSSE(c *Context, ctx ValueObject) {
+	if stream, ok := ctx.GetMember("__sse", c).(ValueObject); ok {
+		stream.Reserved.(*httpSSEStream).close()
+	}
+}
+
+func httpSSEFormat(event, data, id string) string {
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func initHttpSSEStreamClass() ValueType {
+	className := "http.SSEStream"
+	valueToData := func(c *Context, v Value) string {
+		switch val := v.(type) {
+		case ValueStr:
+			return val.Value()
+		case ValueBytes:
+			return string(val.Value())
+		}
+		bs, err := json.Marshal(v.ToGoValue(c))
+		if err != nil {
+			c.RaiseRuntimeError("%s.send: encode data to json error %s", className, err)
+		}
+		return string(bs)
+	}
+	return NewClassBuilder("SSEStream").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = args[0].ToGoValue(c).(*httpSSEStream)
+		}).
+		Method("send", func(c *Context, this ValueObject, args []Value) Value {
+			var event, data, id string
+			switch len(args) {
+			case 1:
+				data = valueToData(c, args[0])
+			case 2, 3:
+				if !IsUndefined(args[0]) {
+					if _, isNil := args[0].(ValueNil); !isNil {
+						event = args[0].ToString(c)
+					}
+				}
+				data = valueToData(c, args[1])
+				if len(args) == 3 && !IsUndefined(args[2]) {
+					id = args[2].ToString(c)
+				}
+			default:
+				c.RaiseRuntimeError("%s.send requires 1 to 3 arguments", className)
+			}
+			s := this.Reserved.(*httpSSEStream)
+			return NewBool(s.write(httpSSEFormat(event, data, id)))
+		}, "event", "data", "id").
+		Method("comment", func(c *Context, this ValueObject, args []Value) Value {
+			var text ValueStr
+			EnsureFuncParams(c, className+".comment", args,
+				ArgRuleOptional("text", TypeStr, &text, NewStr("")),
+			)
+			var b strings.Builder
+			for _, line := range strings.Split(text.Value(), "\n") {
+				b.WriteString(": " + line + "\n")
+			}
+			b.WriteString("\n")
+			return NewBool(this.Reserved.(*httpSSEStream).write(b.String()))
+		}, "text").
+		Method("closed", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*httpSSEStream)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return NewBool(s.closed || s.ctx.Err() != nil)
+		}).
+		Method("wait", func(c *Context, this ValueObject, args []Value) Value {
+			var timeout timeDurationArg
+			EnsureFuncParams(c, className+".wait", args,
+				timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(0)))),
+			)
+			s := this.Reserved.(*httpSSEStream)
+			var timer <-chan time.Time
+			if d := timeout.GetDuration(c); d > 0 {
+				t := time.NewTimer(d)
+				defer t.Stop()
+				timer = t.C
+			}
+			select {
+			case <-s.ctx.Done():
+				return NewBool(true)
+			case <-s.stop:
+				return NewBool(true)
+			case <-timer:
+				return NewBool(false)
+			}
+		}, "timeout").
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*httpSSEStream).close()
+			return Undefined()
+		}).
+		Build()
+}
+
+// connect 建立连接，返回false表示不应再重连
+func (es *httpEventSource) connect(c *Context) (bool, error) {
+	req, err := http.NewRequestWithContext(c.Ctx, "GET", es.url, nil)
+	if err != nil {
+		return false, err
+	}
+	for key, values := range es.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if es.lastEventId != "" {
+		req.Header.Set("Last-Event-ID", es.lastEventId)
+	}
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		resp.Body.Close()
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		resp.Body.Close()
+		return false, fmt.Errorf("unexpected content type %s", ct)
+	}
+	es.resp = resp
+	es.reader = bufio.NewReader(resp.Body)
+	return true, nil
+}
+
+func (es *httpEventSource) disconnect() {
+	if es.resp != nil {
+		es.resp.Body.Close()
+		es.resp = nil
+		es.reader = nil
+	}
+}
+
+// next 读取下一个事件，连接断开时按retry间隔重连。返回nil表示事件流已结束
+func (es *httpEventSource) next(c *Context) Value {
+	var (
+		event string
+		data  strings.Builder
+	)
+	for !es.closed {
+		if es.reader == nil {
+			if es.reconnects > 0 {
+				if es.maxReconnects >= 0 && es.reconnects > es.maxReconnects {
+					es.closed = true
+					return nil
+				}
+				if err := httpSleep(c.Ctx, es.retry); err != nil {
+					es.closed = true
+					return nil
+				}
+			}
+			es.reconnects++
+			shouldRetry, err := es.connect(c)
+			if err != nil && !shouldRetry {
+				es.closed = true
+				c.RaiseRuntimeError("http.EventSource: connect %s error %s", es.url, err)
+			}
+			if !shouldRetry {
+				es.closed = true
+				return nil
+			}
+			if err != nil {
+				continue
+			}
+			es.reconnects = 1
+			event = ""
+			data.Reset()
+		}
+		line, err := es.reader.ReadString('\n')
+		if err != nil {
+			es.disconnect()
+			continue
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if data.Len() == 0 {
+				event = ""
+				continue
+			}
+			rv := NewObject()
+			if event == "" {
+				event = "message"
+			}
+			rv.SetMember("event", NewStr(event), c)
+			rv.SetMember("data", NewStr(strings.TrimSuffix(data.String(), "\n")), c)
+			rv.SetMember("id", NewStr(es.lastEventId), c)
+			return rv
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				es.lastEventId = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				es.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return nil
+}
+
+func initHttpEventSourceClass() ValueType {
+	className := "http.EventSource"
+	return NewClassBuilder("EventSource").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var (
+				url  ValueStr
+				opts ValueObject
+			)
+			EnsureFuncParams(c, className+".__init__", args,
+				ArgRuleRequired("url", TypeStr, &url),
+				ArgRuleOptional("options", TypeObject, &opts, NewObject()),
+			)
+			es := &httpEventSource{
+				url:           url.Value(),
+				headers:       http.Header{},
+				client:        &http.Client{},
+				retry:         3 * time.Second,
+				maxReconnects: -1,
+			}
+			if headers, ok := opts.GetMember("headers", c).(ValueObject); ok {
+				headers.Iterate(func(key string, val Value) {
+					es.headers.Add(key, val.ToString(c))
+				})
+			}
+			if d := httpOptionDuration(c, opts, "retry"); d > 0 {
+				es.retry = d
+			}
+			if n, ok := opts.GetMember("maxReconnects", c).(ValueInt); ok {
+				es.maxReconnects = n.AsInt()
+			}
+			if id, ok := opts.GetMember("lastEventId", c).(ValueStr); ok {
+				es.lastEventId = id.Value()
+			}
+			if session, ok := opts.GetMember("session", c).(ValueObject); ok {
+				s, ok := session.Reserved.(*httpSession)
+				if !ok {
+					c.RaiseRuntimeError("%s: session must be a http.Session", className)
+				}
+				es.url = s.resolveUrl(c, es.url)
+				es.client = s.client
+			}
+			this.Reserved = es
+		}).
+		Method("next", func(c *Context, this ValueObject, args []Value) Value {
+			if ev := this.Reserved.(*httpEventSource).next(c); ev != nil {
+				return ev
+			}
+			return Nil()
+		}).
+		Method("__iter__", func(c *Context, this ValueObject, args []Value) Value {
+			es := this.Reserved.(*httpEventSource)
+			iter := MakeIterator(c, func() Value { return es.next(c) }, func() {})
+			return c.InvokeMethod(iter, "__iter__", NoArgs)
+		}).
+		Method("lastEventId", func(c *Context, this ValueObject, args []Value) Value {
+			return NewStr(this.Reserved.(*httpEventSource).lastEventId)
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			es := this.Reserved.(*httpEventSource)
+			es.closed = true
+			es.disconnect()
+			return Undefined()
+		}).
+		Build()
+}
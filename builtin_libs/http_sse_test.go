@@ -0,0 +1,143 @@
+package builtin_libs_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testHttpSSEStreamCode = `
+http := import('http')
+server := http.createServer()
+server.get('/events', ctx => {
+	{send}
+})
+export handler := server.__handler
+`
+
+func TestHttpSSEStream(t *testing.T) {
+	cases := []struct {
+		name     string
+		send     string
+		expected string
+	}{
+		{"Retry", `ctx.sse(0, 2000)`, "retry: 2000\n\n"},
+		{"Data", `ctx.sse(0).send('hello')`, "data: hello\n\n"},
+		{"EventAndId", `ctx.sse(0).send('update', {n: 1}, '7')`, "id: 7\nevent: update\ndata: {\"n\":1}\n\n"},
+		{"MultilineData", `ctx.sse(0).send(nil, 'line1\nline2')`, "data: line1\ndata: line2\n\n"},
+		{"Comment", `ctx.sse(0).comment('note')`, ": note\n\n"},
+		{"SendAfterClose", `
+			s := ctx.sse(0)
+			s.close()
+			s.send('dropped')
+		`, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(strings.ReplaceAll(testHttpSSEStreamCode, "{send}", tc.send))
+			if err != nil {
+				t.Fatal(err)
+			}
+			server := httptest.NewServer(exported["handler"].(http.Handler))
+			defer server.Close()
+			resp, err := http.Get(server.URL + "/events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("unexpected content type %s", ct)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.expected {
+				t.Fatalf("expected %q, got %q", tc.expected, body)
+			}
+		})
+	}
+}
+
+const testHttpEventSourceCode = `
+http := import('http')
+es := http.EventSource('{url}', {headers: {'x-client': 'zgg'}})
+events := []
+for ev in es {
+	events.push([ev.event, ev.data, ev.id])
+}
+export result := [events, es.lastEventId()]
+`
+
+func TestHttpEventSource(t *testing.T) {
+	cases := []struct {
+		name string
+		// 依次作为每次连接的响应，之后的连接返回204表示不要再重连
+		responses []string
+		expected  interface{}
+		// 每次连接时请求头中的Last-Event-ID和x-client
+		requests []string
+	}{
+		{"ParseEvents", []string{
+			"retry: 10\n\n" +
+				": comment\n" +
+				"id: 1\ndata: first\ndata: second\n\n" +
+				"event: custom\nid: 2\ndata:{\"a\":1}\n\n" +
+				"data: incomplete",
+		}, []interface{}{
+			[]interface{}{
+				[]interface{}{"message", "first\nsecond", "1"},
+				[]interface{}{"custom", "{\"a\":1}", "2"},
+			},
+			"2",
+		}, []string{"|zgg", "2|zgg"}},
+		{"ReconnectWithLastEventId", []string{
+			"retry: 10\n\nid: 1\ndata: first\n\n",
+			"id: 3\r\ndata: after reconnect\r\n\r\n",
+		}, []interface{}{
+			[]interface{}{
+				[]interface{}{"message", "first", "1"},
+				[]interface{}{"message", "after reconnect", "3"},
+			},
+			"3",
+		}, []string{"|zgg", "1|zgg", "3|zgg"}},
+		{"NoContentStops", nil, []interface{}{[]interface{}{}, ""}, []string{"|zgg"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				lock     sync.Mutex
+				requests []string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				requests = append(requests, r.Header.Get("Last-Event-ID")+"|"+r.Header.Get("x-client"))
+				n := len(requests)
+				lock.Unlock()
+				w.Header().Set("Content-Type", "text/event-stream")
+				if n > len(tc.responses) {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				io.WriteString(w, tc.responses[n-1])
+			}))
+			defer server.Close()
+			exported, err := zgg.RunCode(strings.ReplaceAll(testHttpEventSourceCode, "{url}", server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+			if !reflect.DeepEqual(requests, tc.requests) {
+				t.Fatalf("expected requests %v, got %v", tc.requests, requests)
+			}
+		})
+	}
+}
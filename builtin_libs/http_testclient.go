@@ -0,0 +1,103 @@
+package builtin_libs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// httpTestBaseUrl 测试客户端使用相对路径时补全的地址
+const httpTestBaseUrl = "http://zgg.test"
+
+// httpHandlerTransport 不经过网络，直接把请求交给handler处理
+type httpHandlerTransport struct {
+	handler http.Handler
+}
+
+func (t httpHandlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverReq := req.Clone(req.Context())
+	serverReq.RequestURI = req.URL.RequestURI()
+	serverReq.RemoteAddr = "192.0.2.1:1234"
+	if serverReq.Body == nil {
+		serverReq.Body = http.NoBody
+	}
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, serverReq)
+	resp := recorder.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// httpRequestArgs 解析(url)或(method, url)形式的参数，与http.Request的构造参数相同
+func httpRequestArgs(c *Context, funcName string, args []Value) (string, string) {
+	var (
+		method ValueStr
+		url    ValueStr
+	)
+	if len(args) == 1 {
+		EnsureFuncParams(c, funcName, args,
+			ArgRuleRequired("url", TypeStr, &url),
+		)
+		return "GET", url.Value()
+	}
+	EnsureFuncParams(c, funcName, args,
+		ArgRuleRequired("method", TypeStr, &method),
+		ArgRuleRequired("url", TypeStr, &url),
+	)
+	return method.Value(), url.Value()
+}
+
+// httpShortcutMethod 生成get/post等快捷方法：第二个参数为请求体，对象以json格式发送；第三个参数为headers
+func httpShortcutMethod(className, method string, newRequest func(c *Context, this ValueObject, method, url string) ValueObject) func(*Context, ValueObject, []Value) Value {
+	return func(c *Context, this ValueObject, args []Value) Value {
+		var (
+			url     ValueStr
+			body    Value
+			headers ValueObject
+		)
+		EnsureFuncParams(c, className+"."+strings.ToLower(method), args,
+			ArgRuleRequired("url", TypeStr, &url),
+			ArgRuleOptional("body", TypeAny, &body, Undefined()),
+			ArgRuleOptional("headers", TypeObject, &headers, NewObject()),
+		)
+		req := newRequest(c, this, method, url.Value())
+		switch body.(type) {
+		case ValueUndefined, ValueNil:
+		case ValueObject, ValueArray:
+			c.InvokeMethod(req, "json", Args(body))
+		default:
+			c.InvokeMethod(req, "data", Args(body))
+		}
+		c.InvokeMethod(req, "header", Args(headers))
+		return c.InvokeMethod(req, "call", NoArgs)
+	}
+}
+
+func initHttpTestClientClass() ValueType {
+	className := "http.TestClient"
+	newRequest := func(c *Context, this ValueObject, method, url string) ValueObject {
+		if !strings.Contains(url, "://") {
+			url = httpTestBaseUrl + url
+		}
+		req := NewObjectAndInit(httpRequestClass, c, NewStr(method), NewStr(url))
+		req.SetMember("__goTransport", this.GetMember("__transport", c), c)
+		return req
+	}
+	return NewClassBuilder("TestClient").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			handler := args[0].ToGoValue(c).(http.Handler)
+			this.SetMember("__transport", NewGoValue(http.RoundTripper(httpHandlerTransport{handler})), c)
+		}).
+		Method("request", func(c *Context, this ValueObject, args []Value) Value {
+			method, url := httpRequestArgs(c, className+".request", args)
+			return newRequest(c, this, method, url)
+		}, "method", "url").
+		Method("get", httpShortcutMethod(className, "GET", newRequest), "url", "body", "headers").
+		Method("post", httpShortcutMethod(className, "POST", newRequest), "url", "body", "headers").
+		Method("put", httpShortcutMethod(className, "PUT", newRequest), "url", "body", "headers").
+		Method("patch", httpShortcutMethod(className, "PATCH", newRequest), "url", "body", "headers").
+		Method("delete", httpShortcutMethod(className, "DELETE", newRequest), "url", "body", "headers").
+		Build()
+}
@@ -0,0 +1,92 @@
+package builtin_libs_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testHttpTestClientSetup = `
+http := import('http')
+server := http.createServer()
+server.any('/echo', ctx => ctx.writeJson({
+	method: ctx.method,
+	query: ctx.query('q'),
+	token: ctx.getHeader('x-token'),
+	body: ctx.getBodyStr(),
+}))
+server.get('/old', ctx => ctx.redirect('/echo?q=moved'))
+client := http.testClient(server)
+`
+
+func TestHttpTestClient(t *testing.T) {
+	goHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.RemoteAddr))
+	})
+	unsupported := "http.Request.call: certFile, hosts and tlsConfig are not supported by test client"
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"GetWithQueryAndHeader", `
+			export result := client.get('/echo?q=1', nil, {'x-token': 't'}).json()
+		`, map[string]interface{}{"method": "GET", "query": "1", "token": "t", "body": ""}},
+		{"PostJson", `
+			export result := client.post('/echo', {a: 1}).json()
+		`, map[string]interface{}{"method": "POST", "query": nil, "token": "", "body": "{\"a\":1}"}},
+		{"PutText", `
+			export result := client.put('/echo', 'text').json().body
+		`, "text"},
+		{"Patch", `
+			export result := client.patch('/echo').json().method
+		`, "PATCH"},
+		{"Delete", `
+			export result := client.delete('/echo').json().method
+		`, "DELETE"},
+		{"Head", `
+			export result := client.request('HEAD', '/echo').call().statusCode
+		`, int64(200)},
+		{"FollowsRedirect", `
+			export result := client.get('/old').json().query
+		`, "moved"},
+		{"NoFollowRedirect", `
+			r := client.request('/old').followRedirect(false).call()
+			export result := [r.statusCode, r.header('Location')]
+		`, []interface{}{int64(302), "/echo?q=moved"}},
+		{"AbsoluteUrl", `
+			export result := server.test().get('http://other.host/echo?q=abs').json().query
+		`, "abs"},
+		{"RejectsNetworkOptions", `
+			rejected := []
+			for name, setup in {
+				hosts: r => r.hosts({'zgg.test:80': '127.0.0.1:1'}),
+				certFile: r => r.certFile('cert.pem', 'key.pem'),
+				tlsConfig: r => r.tlsConfig({InsecureSkipVerify: true}),
+			} {
+				try {
+					setup(client.request('/echo')).call()
+				} catch (e) {
+					rejected.push(name + ': ' + e.message)
+				}
+			}
+			export result := rejected.sort()
+		`, []interface{}{"certFile: " + unsupported, "hosts: " + unsupported, "tlsConfig: " + unsupported}},
+		{"GoHandler", `
+			export result := http.testClient(goHandler).get('/any').text()
+		`, "zgg.test 192.0.2.1:1234"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testHttpTestClientSetup+tc.code, zgg.Var{Name: "goHandler", Value: goHandler})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+package builtin_libs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	httpWsMessage struct {
+		typ  int
+		data []byte
+	}
+	// httpWsHub 管理websocket连接及房间，每个连接有独立的发送队列，发送不会阻塞调用方
+	httpWsHub struct {
+		mu             sync.RWMutex
+		conns          map[*httpWsConn]struct{}
+		rooms          map[string]map[*httpWsConn]struct{}
+		nextId         int64
+		onOpen         Value
+		onMessage      Value
+		onClose        Value
+		queueSize      int
+		pingInterval   time.Duration
+		pongTimeout    time.Duration
+		writeTimeout   time.Duration
+		maxMessageSize int64
+		dropOnOverflow bool
+		parseJson      bool
+		closing        bool
+	}
+	httpWsConn struct {
+		hub       *httpWsHub
+		conn      *websocket.Conn
+		id        int64
+		send      chan httpWsMessage
+		done      chan struct{}
+		finished  chan struct{}
+		c         *Context
+		closeOnce sync.Once
+		closeCode int
+		closeText string
+		rooms     map[string]struct{}
+		obj       ValueObject
+	}
+)
+
+var (
+	httpWebsocketHubClass  ValueType
+	httpWebsocketConnClass ValueType
+)
+
+func newHttpWsHub(c *Context, options ValueObject) *httpWsHub {
+	h := &httpWsHub{
+		conns:        map[*httpWsConn]struct{}{},
+		rooms:        map[string]map[*httpWsConn]struct{}{},
+		queueSize:    64,
+		pingInterval: 30 * time.Second,
+		writeTimeout: 10 * time.Second,
+	}
+	if n, ok := options.GetMember("sendQueueSize", c).(ValueInt); ok && n.Value() > 0 {
+		h.queueSize = n.AsInt()
+	}
+	if d := httpOptionDuration(c, options, "pingInterval"); d > 0 {
+		h.pingInterval = d
+	}
+	h.pongTimeout = h.pingInterval * 2
+	if d := httpOptionDuration(c, options, "pongTimeout"); d > 0 {
+		h.pongTimeout = d
+	}
+	if d := httpOptionDuration(c, options, "writeTimeout"); d > 0 {
+		h.writeTimeout = d
+	}
+	if n, ok := options.GetMember("maxMessageSize", c).(ValueInt); ok {
+		h.maxMessageSize = n.Value()
+	}
+	if overflow, ok := options.GetMember("onOverflow", c).(ValueStr); ok {
+		switch overflow.Value() {
+		case "drop":
+			h.dropOnOverflow = true
+		case "close":
+		default:
+			c.RaiseRuntimeError("http.WebsocketHub: onOverflow must be drop or close")
+		}
+	}
+	h.parseJson = options.GetMember("json", c).IsTrue()
+	return h
+}
+
+// httpWsEncode bytes以二进制消息发送，字符串以文本消息发送，其他值编码为json文本
+func httpWsEncode(c *Context, v Value) httpWsMessage {
+	switch msg := v.(type) {
+	case ValueBytes:
+		return httpWsMessage{websocket.BinaryMessage, msg.Value()}
+	case ValueStr:
+		return httpWsMessage{websocket.TextMessage, []byte(msg.Value())}
+	}
+	bs, err := jsonMarshal(v.ToGoValue(c))
+	if err != nil {
+		c.RaiseRuntimeError("websocket encode message error %s", err)
+	}
+	return httpWsMessage{websocket.TextMessage, bytes.TrimRight(bs, "\n")}
+}
+
+func (h *httpWsHub) invoke(c *Context, callback Value, args ...Value) {
+	if callback == nil {
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if ex, ok := e.(Exception); ok {
+				fmt.Fprint(c.Stderr, ex.MessageWithStack())
+			} else {
+				fmt.Fprintf(c.Stderr, "websocket hub callback error %v\n", e)
+			}
+		}
+	}()
+	c.Invoke(callback, nil, Args(args...))
+}
+
+// serve 接管连接直到断开，回调都在当前协程中依次执行
+func (h *httpWsHub) serve(c *Context, conn *websocket.Conn, r *http.Request) {
+	wc := &httpWsConn{
+		hub:      h,
+		conn:     conn,
+		id:       atomic.AddInt64(&h.nextId, 1),
+		send:     make(chan httpWsMessage, h.queueSize),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		c:        c,
+		rooms:    map[string]struct{}{},
+	}
+	wc.obj = NewObjectAndInit(httpWebsocketConnClass, c, NewGoValue(wc))
+	wc.obj.SetMember("id", NewInt(wc.id), c)
+	wc.obj.SetMember("remoteAddr", NewStr(r.RemoteAddr), c)
+	wc.obj.SetMember("path", NewStr(r.URL.Path), c)
+	query := NewObject()
+	for key, values := range r.URL.Query() {
+		query.SetMember(key, NewStr(values[0]), c)
+	}
+	wc.obj.SetMember("query", query, c)
+	wc.obj.SetMember("data", NewObject(), c)
+	h.mu.Lock()
+	h.conns[wc] = struct{}{}
+	closing := h.closing
+	h.mu.Unlock()
+	if closing {
+		wc.close(websocket.CloseGoingAway, "server closing")
+	}
+
+	writerDone := make(chan struct{})
+	go wc.writeLoop(writerDone)
+	peerCode, peerText := websocket.CloseNormalClosure, ""
+	defer close(wc.finished)
+	defer func() {
+		// 服务端先关闭时保留服务端的关闭原因，否则记录对方的
+		wc.close(peerCode, peerText)
+		<-writerDone
+		conn.Close()
+		h.remove(wc)
+		info := NewObject()
+		info.SetMember("code", NewInt(int64(wc.closeCode)), c)
+		info.SetMember("reason", NewStr(wc.closeText), c)
+		h.invoke(c, h.onClose, wc.obj, info)
+	}()
+	h.invoke(c, h.onOpen, wc.obj)
+
+	if h.maxMessageSize > 0 {
+		conn.SetReadLimit(h.maxMessageSize)
+	}
+	conn.SetReadDeadline(time.Now().Add(h.pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(h.pongTimeout))
+	})
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok {
+				peerCode, peerText = ce.Code, ce.Text
+			} else {
+				peerCode, peerText = websocket.CloseAbnormalClosure, err.Error()
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(h.pongTimeout))
+		var msg Value
+		if mt == websocket.BinaryMessage {
+			msg = NewBytes(data)
+		} else if h.parseJson {
+			var j interface{}
+			if err := json.Unmarshal(data, &j); err != nil {
+				msg = NewStr(string(data))
+			} else {
+				msg = jsonToValue(j, c)
+			}
+		} else {
+			msg = NewStr(string(data))
+		}
+		h.invoke(c, h.onMessage, wc.obj, msg)
+	}
+}
+
+// close 同时关闭所有连接，总共最多等待timeout，仍未断开的连接直接关闭底层连接。
+// 在hub回调中调用时不等待当前回调所属的连接
+func (h *httpWsHub) close(c *Context, timeout time.Duration) {
+	h.mu.Lock()
+	h.closing = true
+	h.mu.Unlock()
+	conns := h.members("")
+	for _, wc := range conns {
+		wc.close(websocket.CloseGoingAway, "server closing")
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for i, wc := range conns {
+		if wc.c == c {
+			continue
+		}
+		select {
+		case <-wc.finished:
+		case <-deadline.C:
+			for _, rest := range conns[i:] {
+				rest.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+func (h *httpWsHub) remove(wc *httpWsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, wc)
+	for room := range wc.rooms {
+		h.leaveLocked(wc, room)
+	}
+}
+
+func (h *httpWsHub) join(wc *httpWsConn, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, alive := h.conns[wc]; !alive {
+		return
+	}
+	members, found := h.rooms[room]
+	if !found {
+		members = map[*httpWsConn]struct{}{}
+		h.rooms[room] = members
+	}
+	members[wc] = struct{}{}
+	wc.rooms[room] = struct{}{}
+}
+
+func (h *httpWsHub) leave(wc *httpWsConn, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leaveLocked(wc, room)
+}
+
+func (h *httpWsHub) leaveLocked(wc *httpWsConn, room string) {
+	delete(wc.rooms, room)
+	if members, found := h.rooms[room]; found {
+		delete(members, wc)
+		if len(members) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// members 返回房间内的连接，room为空时返回全部连接
+func (h *httpWsHub) members(room string) []*httpWsConn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	set := h.conns
+	if room != "" {
+		set = h.rooms[room]
+	}
+	rv := make([]*httpWsConn, 0, len(set))
+	for wc := range set {
+		rv = append(rv, wc)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].id < rv[j].id })
+	return rv
+}
+
+func (h *httpWsHub) broadcast(room string, msg httpWsMessage, except *httpWsConn) int {
+	sent := 0
+	for _, wc := range h.members(room) {
+		if wc != except && wc.enqueue(msg) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// enqueue 放入发送队列，队列满时按配置丢弃消息或断开这个过慢的连接
+func (wc *httpWsConn) enqueue(msg httpWsMessage) bool {
+	select {
+	case <-wc.done:
+		return false
+	default:
+	}
+	select {
+	case wc.send <- msg:
+		return true
+	default:
+		if !wc.hub.dropOnOverflow {
+			wc.close(websocket.ClosePolicyViolation, "send queue overflow")
+		}
+		return false
+	}
+}
+
+func (wc *httpWsConn) close(code int, text string) {
+	wc.closeOnce.Do(func() {
+		wc.closeCode, wc.closeText = code, text
+		close(wc.done)
+	})
+}
+
+func (wc *httpWsConn) writeLoop(writerDone chan struct{}) {
+	defer close(writerDone)
+	ticker := time.NewTicker(wc.hub.pingInterval)
+	defer ticker.Stop()
+	timeout := wc.hub.writeTimeout
+	for {
+		select {
+		case msg := <-wc.send:
+			wc.conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err := wc.conn.WriteMessage(msg.typ, msg.data); err != nil {
+				wc.close(websocket.CloseAbnormalClosure, err.Error())
+				wc.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				wc.close(websocket.CloseAbnormalClosure, err.Error())
+				wc.conn.Close()
+				return
+			}
+		case <-wc.done:
+			payload := websocket.FormatCloseMessage(wc.closeCode, wc.closeText)
+			wc.conn.WriteControl(websocket.CloseMessage, payload, time.Now().Add(timeout))
+			// 对方收到关闭消息后也会关闭，等待读协程结束，超时则直接断开
+			time.AfterFunc(timeout, func() { wc.conn.Close() })
+			return
+		}
+	}
+}
+
+func httpWsConnsToValue(conns []*httpWsConn) Value {
+	rv := NewArray(len(conns))
+	for _, wc := range conns {
+		rv.PushBack(wc.obj)
+	}
+	return rv
+}
+
+func initHttpWebsocketHubClass() ValueType {
+	setCallback := func(name string, get func(*httpWsHub) *Value) func(*Context, ValueObject, []Value) Value {
+		return func(c *Context, this ValueObject, args []Value) Value {
+			var callback ValueCallable
+			EnsureFuncParams(c, "http.WebsocketHub."+name, args, ArgRuleRequired("callback", TypeCallable, &callback))
+			*get(this.Reserved.(*httpWsHub)) = callback
+			return this
+		}
+	}
+	broadcast := func(funcName string, inRoom bool) func(*Context, ValueObject, []Value) Value {
+		return func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				room   ValueStr
+				msg    Value
+				except ValueObject
+			)
+			rules := []ArgRule{
+				ArgRuleRequired("message", TypeAny, &msg),
+				ArgRuleOptional("except", TypeObject, &except, nil),
+			}
+			if inRoom {
+				rules = append([]ArgRule{ArgRuleRequired("room", TypeStr, &room)}, rules...)
+			}
+			EnsureFuncParams(c, funcName, args, rules...)
+			var exceptConn *httpWsConn
+			if except != nil {
+				exceptConn, _ = except.Reserved.(*httpWsConn)
+			}
+			hub := this.Reserved.(*httpWsHub)
+			if inRoom && room.Value() == "" {
+				return NewInt(0)
+			}
+			return NewInt(int64(hub.broadcast(room.Value(), httpWsEncode(c, msg), exceptConn)))
+		}
+	}
+	return NewClassBuilder("WebsocketHub").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var options ValueObject
+			EnsureFuncParams(c, "http.WebsocketHub.__init__", args,
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			)
+			this.Reserved = newHttpWsHub(c, options)
+		}).
+		Method("onOpen", setCallback("onOpen", func(h *httpWsHub) *Value { return &h.onOpen }), "callback").
+		Method("onMessage", setCallback("onMessage", func(h *httpWsHub) *Value { return &h.onMessage }), "callback").
+		Method("onClose", setCallback("onClose", func(h *httpWsHub) *Value { return &h.onClose }), "callback").
+		Method("accept", func(c *Context, this ValueObject, args []Value) Value {
+			var ws ValueObject
+			EnsureFuncParams(c, "http.WebsocketHub.accept", args, ArgRuleRequired("ws", TypeObject, &ws))
+			conn, ok := ws.GetMember("_conn", c).ToGoValue(c).(*websocket.Conn)
+			if !ok {
+				c.RaiseRuntimeError("http.WebsocketHub.accept: not a websocket context")
+			}
+			r := ws.GetMember("r", c).ToGoValue(c).(*http.Request)
+			this.Reserved.(*httpWsHub).serve(c, conn, r)
+			return Undefined()
+		}, "ws").
+		Method("broadcast", broadcast("http.WebsocketHub.broadcast", false), "message", "except").
+		Method("broadcastTo", broadcast("http.WebsocketHub.broadcastTo", true), "room", "message", "except").
+		Method("connections", func(c *Context, this ValueObject, args []Value) Value {
+			var room ValueStr
+			EnsureFuncParams(c, "http.WebsocketHub.connections", args, ArgRuleOptional("room", TypeStr, &room, NewStr("")))
+			return httpWsConnsToValue(this.Reserved.(*httpWsHub).members(room.Value()))
+		}, "room").
+		Method("count", func(c *Context, this ValueObject, args []Value) Value {
+			var room ValueStr
+			EnsureFuncParams(c, "http.WebsocketHub.count", args, ArgRuleOptional("room", TypeStr, &room, NewStr("")))
+			return NewInt(int64(len(this.Reserved.(*httpWsHub).members(room.Value()))))
+		}, "room").
+		Method("rooms", func(c *Context, this ValueObject, args []Value) Value {
+			hub := this.Reserved.(*httpWsHub)
+			hub.mu.RLock()
+			names := make([]string, 0, len(hub.rooms))
+			for room := range hub.rooms {
+				names = append(names, room)
+			}
+			hub.mu.RUnlock()
+			sort.Strings(names)
+			rv := NewArray(len(names))
+			for _, name := range names {
+				rv.PushBack(NewStr(name))
+			}
+			return rv
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			hub := this.Reserved.(*httpWsHub)
+			var timeout timeDurationArg
+			EnsureFuncParams(c, "http.WebsocketHub.close", args,
+				timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(hub.writeTimeout))),
+			)
+			hub.close(c, timeout.GetDuration(c))
+			return Undefined()
+		}, "timeout").
+		Build()
+}
+
+func initHttpWebsocketConnClass() ValueType {
+	room := func(funcName string, join bool) func(*Context, ValueObject, []Value) Value {
+		return func(c *Context, this ValueObject, args []Value) Value {
+			wc := this.Reserved.(*httpWsConn)
+			for _, arg := range args {
+				if join {
+					wc.hub.join(wc, arg.ToString(c))
+				} else {
+					wc.hub.leave(wc, arg.ToString(c))
+				}
+			}
+			return this
+		}
+	}
+	return NewClassBuilder("WebsocketConn").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.Reserved = args[0].ToGoValue(c).(*httpWsConn)
+		}).
+		Methods([]string{"send", "write"}, func(c *Context, this ValueObject, args []Value) Value {
+			var msg Value
+			EnsureFuncParams(c, "http.WebsocketConn.send", args, ArgRuleRequired("message", TypeAny, &msg))
+			return NewBool(this.Reserved.(*httpWsConn).enqueue(httpWsEncode(c, msg)))
+		}, "message").
+		Method("join", room("http.WebsocketConn.join", true), "room").
+		Method("leave", room("http.WebsocketConn.leave", false), "room").
+		Method("rooms", func(c *Context, this ValueObject, args []Value) Value {
+			wc := this.Reserved.(*httpWsConn)
+			wc.hub.mu.RLock()
+			names := make([]string, 0, len(wc.rooms))
+			for room := range wc.rooms {
+				names = append(names, room)
+			}
+			wc.hub.mu.RUnlock()
+			sort.Strings(names)
+			rv := NewArray(len(names))
+			for _, name := range names {
+				rv.PushBack(NewStr(name))
+			}
+			return rv
+		}).
+		Method("pending", func(c *Context, this ValueObject, args []Value) Value {
+			return NewInt(int64(len(this.Reserved.(*httpWsConn).send)))
+		}).
+		Method("closed", func(c *Context, this ValueObject, args []Value) Value {
+			select {
+			case <-this.Reserved.(*httpWsConn).done:
+				return NewBool(true)
+			default:
+				return NewBool(false)
+			}
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				code   ValueInt
+				reason ValueStr
+			)
+			EnsureFuncParams(c, "http.WebsocketConn.close", args,
+				ArgRuleOptional("code", TypeInt, &code, NewInt(websocket.CloseNormalClosure)),
+				ArgRuleOptional("reason", TypeStr, &reason, NewStr("")),
+			)
+			this.Reserved.(*httpWsConn).close(code.AsInt(), reason.Value())
+			return Undefined()
+		}, "code", "reason").
+		Build()
+}
@@ -0,0 +1,484 @@
+package builtin_libs_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+func testWsServer(t *testing.T, code string) *httptest.Server {
+	exported, err := zgg.RunCode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(exported["handler"].(http.Handler))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testWsDial(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testWsRead(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func testWsGet(t *testing.T, server *httptest.Server, path string) string {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// testWsWaitStats 轮询/stats直到满足条件
+func testWsWaitStats(t *testing.T, server *httptest.Server, ok func(stats map[string]interface{}) bool) map[string]interface{} {
+	var stats map[string]interface{}
+	for i := 0; i < 100; i++ {
+		if err := json.Unmarshal([]byte(testWsGet(t, server, "/stats")), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if ok(stats) {
+			return stats
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("unexpected stats %v", stats)
+	return nil
+}
+
+const testHttpWebsocketHubRoomsCode = `
+http := import('http')
+hub := http.WebsocketHub({json: true})
+hub.onOpen(conn => conn.join(conn.query.room))
+hub.onMessage((conn, msg) => {
+	if msg.type == 'room' {
+		hub.broadcastTo(conn.query.room, {from: conn.query.name, text: msg.text}, conn)
+	} else if msg.type == 'all' {
+		hub.broadcast(msg.text)
+	} else if msg.type == 'stats' {
+		conn.send([hub.count(), hub.rooms(), hub.count('a'), conn.rooms()])
+		return
+	}
+	conn.send('ok')
+})
+server := http.createServer()
+server.routeWebsocket('/ws', hub)
+export handler := server.__handler
+`
+
+func TestHttpWebsocketHubRooms(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     string
+		msg      string
+		expected map[string][]string
+	}{
+		{"BroadcastToRoomSkipsSender", "a1", `{"type":"room","text":"hi a"}`, map[string][]string{
+			"a1": {"ok"},
+			"a2": {`{"from":"a1","text":"hi a"}`},
+			"b1": {},
+		}},
+		{"Broadcast", "b1", `{"type":"all","text":"hey"}`, map[string][]string{
+			"a1": {"hey"},
+			"a2": {"hey"},
+			"b1": {"hey", "ok"},
+		}},
+		{"Stats", "a1", `{"type":"stats"}`, map[string][]string{
+			"a1": {`[3,["a","b"],2,["a"]]`},
+			"a2": {},
+			"b1": {},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := testWsServer(t, testHttpWebsocketHubRoomsCode)
+			conns := map[string]*websocket.Conn{
+				"a1": testWsDial(t, server, "/ws?room=a&name=a1"),
+				"a2": testWsDial(t, server, "/ws?room=a&name=a2"),
+				"b1": testWsDial(t, server, "/ws?room=b&name=b1"),
+			}
+			send := func(conn *websocket.Conn, msg string) {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// 收到ok说明onOpen已经执行完毕
+			for _, conn := range conns {
+				send(conn, `{"type":"hello"}`)
+				if msg := testWsRead(t, conn); msg != "ok" {
+					t.Fatalf("expected ok, got %q", msg)
+				}
+			}
+			send(conns[tc.from], tc.msg)
+			// 先读发送方的回复，此时onMessage已经执行完毕，其他连接的消息都已入队
+			names := []string{tc.from}
+			for name := range tc.expected {
+				if name != tc.from {
+					names = append(names, name)
+				}
+			}
+			for _, name := range names {
+				conn, expected := conns[name], tc.expected[name]
+				received := []string{}
+				for range expected {
+					received = append(received, testWsRead(t, conn))
+				}
+				// 之后的ok之前没有多余的消息
+				send(conn, `{"type":"hello"}`)
+				received = append(received, testWsRead(t, conn))
+				if expected := append(expected, "ok"); !reflect.DeepEqual(received, expected) {
+					t.Fatalf("%s expected %v, got %v", name, expected, received)
+				}
+			}
+		})
+	}
+}
+
+const testHttpWebsocketHubOverflowCode = `
+http := import('http')
+hub := http.WebsocketHub({sendQueueSize: 2, onOverflow: '{onOverflow}'})
+big := 'x' * 1048576
+stats := {sent: 0, code: 0}
+hub.onMessage((conn, msg) => {
+	for i in 0..<32 {
+		if conn.send(big) {
+			stats.sent += 1
+		}
+	}
+	stats.closedAfterSend = conn.closed()
+})
+hub.onClose((conn, info) => {
+	stats.code = info.code
+	stats.reason = info.reason
+})
+server := http.createServer()
+server.routeWebsocket('/ws', hub)
+server.get('/stats', ctx => ctx.writeJson(stats))
+export handler := server.__handler
+`
+
+func TestHttpWebsocketHubOverflow(t *testing.T) {
+	// 客户端不读取，写协程阻塞后发送队列很快被填满
+	t.Run("drop", func(t *testing.T) {
+		server := testWsServer(t, strings.ReplaceAll(testHttpWebsocketHubOverflowCode, "{onOverflow}", "drop"))
+		conn := testWsDial(t, server, "/ws")
+		conn.WriteMessage(websocket.TextMessage, []byte("go"))
+		stats := testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+			_, done := stats["closedAfterSend"]
+			return done
+		})
+		if sent := stats["sent"].(float64); sent < 2 || sent >= 32 {
+			t.Fatalf("expected some messages dropped, sent %v", sent)
+		}
+		if stats["closedAfterSend"] != false || stats["code"].(float64) != 0 {
+			t.Fatalf("connection should stay open: %v", stats)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		server := testWsServer(t, strings.ReplaceAll(testHttpWebsocketHubOverflowCode, "{onOverflow}", "close"))
+		conn := testWsDial(t, server, "/ws")
+		conn.WriteMessage(websocket.TextMessage, []byte("go"))
+		stats := testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+			_, done := stats["closedAfterSend"]
+			return done
+		})
+		if stats["closedAfterSend"] != true {
+			t.Fatalf("connection should be closed on overflow: %v", stats)
+		}
+		// 读出积压的消息后收到关闭原因
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+					t.Fatalf("expected policy violation close, got %v", err)
+				}
+				break
+			}
+		}
+		stats = testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+			return stats["code"].(float64) != 0
+		})
+		if stats["code"].(float64) != websocket.ClosePolicyViolation || stats["reason"] != "send queue overflow" {
+			t.Fatalf("unexpected close info %v", stats)
+		}
+	})
+}
+
+const testHttpWebsocketHubPingCode = `
+http := import('http')
+hub := http.WebsocketHub({pingInterval: 0.05, pongTimeout: 0.3})
+closed := {}
+hub.onClose((conn, info) => {
+	closed[conn.query.name] = info.code
+})
+server := http.createServer()
+server.routeWebsocket('/ws', hub)
+server.get('/stats', ctx => ctx.writeJson({count: hub.count(), closed: closed}))
+export handler := server.__handler
+`
+
+func TestHttpWebsocketHubPingTimeout(t *testing.T) {
+	server := testWsServer(t, testHttpWebsocketHubPingCode)
+	// 只有在读取时gorilla才会回复pong
+	alive := testWsDial(t, server, "/ws?name=alive")
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	testWsDial(t, server, "/ws?name=silent")
+	stats := testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+		return len(stats["closed"].(map[string]interface{})) > 0
+	})
+	time.Sleep(500 * time.Millisecond)
+	stats = testWsWaitStats(t, server, func(map[string]interface{}) bool { return true })
+	expected := map[string]interface{}{
+		"count":  float64(1),
+		"closed": map[string]interface{}{"silent": float64(websocket.CloseAbnormalClosure)},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected %v, got %v", expected, stats)
+	}
+}
+
+const testHttpWebsocketHubCloseCode = `
+http := import('http')
+time := import('time')
+hub := http.WebsocketHub()
+hub.onMessage((conn, msg) => {
+	start := time.now()
+	hub.close(5)
+	conn.send(str(time.since(start).seconds() < 1))
+})
+server := http.createServer()
+server.routeWebsocket('/ws', hub)
+server.get('/close', ctx => {
+	start := time.now()
+	hub.close(0.3)
+	ctx.write(200, str(time.since(start).seconds()))
+})
+server.get('/stats', ctx => ctx.writeJson({count: hub.count()}))
+export handler := server.__handler
+`
+
+func TestHttpWebsocketHubClose(t *testing.T) {
+	t.Run("bounded", func(t *testing.T) {
+		server := testWsServer(t, testHttpWebsocketHubCloseCode)
+		var readers []*websocket.Conn
+		closeErrs := make(chan error, 3)
+		for i := 0; i < 3; i++ {
+			conn := testWsDial(t, server, "/ws")
+			readers = append(readers, conn)
+			go func() {
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						closeErrs <- err
+						return
+					}
+				}
+			}()
+		}
+		// 不读取的连接不会回应关闭消息，只能等超时后强制断开
+		for i := 0; i < 3; i++ {
+			testWsDial(t, server, "/ws")
+		}
+		testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+			return stats["count"].(float64) == 6
+		})
+		elapsed, err := time.ParseDuration(testWsGet(t, server, "/close") + "s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+			t.Fatalf("hub.close should wait about 0.3s in total, waited %s", elapsed)
+		}
+		for range readers {
+			if err := <-closeErrs; !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Fatalf("expected going away close, got %v", err)
+			}
+		}
+		testWsWaitStats(t, server, func(stats map[string]interface{}) bool {
+			return stats["count"].(float64) == 0
+		})
+		// 关闭后新连接会被立即断开
+		conn := testWsDial(t, server, "/ws")
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Fatalf("expected going away close, got %v", err)
+		}
+	})
+	t.Run("in callback", func(t *testing.T) {
+		server := testWsServer(t, testHttpWebsocketHubCloseCode)
+		conn := testWsDial(t, server, "/ws")
+		conn.WriteMessage(websocket.TextMessage, []byte("close"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// 回调中的连接已关闭，之后的消息不再发送
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Fatalf("expected going away close, got %v", err)
+		}
+	})
+}
+
+const testHttpWebsocketRouteCode = `
+http := import('http')
+server := http.createServer()
+server.routeWebsocket('/echo', ws => {
+	while true {
+		msg := ws.read()
+		if msg == 'bye' {
+			ws.close(4000, 'done')
+			break
+		}
+		ws.write('echo: ' + msg)
+	}
+})
+export handler := server.__handler
+`
+
+func TestHttpRouteWebsocket(t *testing.T) {
+	server := testWsServer(t, testHttpWebsocketRouteCode)
+	conn := testWsDial(t, server, "/echo")
+	conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if msg := testWsRead(t, conn); msg != "echo: hello" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("bye"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != 4000 || ce.Text != "done" {
+		t.Fatalf("unexpected close %v", err)
+	}
+}
+
+const testHttpWebsocketReconnectCode = `
+http := import('http')
+attempts := []
+ws := http.connectWebsocket('{url}', {
+	reconnect: true,
+	reconnectDelay: 0.01,
+	onReconnect: (n, cause) => attempts.push(n),
+})
+msgs := [ws.read(), ws.read()]
+err := ''
+try {
+	ws.read()
+} catch (e) {
+	err = e.message
+}
+export result := [msgs, attempts, err]
+`
+
+func TestHttpWebsocketClientReconnect(t *testing.T) {
+	var conns int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if atomic.AddInt32(&conns, 1) == 1 {
+			// 第一个连接异常断开，客户端应重连
+			conn.WriteMessage(websocket.TextMessage, []byte("one"))
+			return
+		}
+		// 正常关闭后客户端不应再重连
+		conn.WriteMessage(websocket.TextMessage, []byte("two"))
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	exported, err := zgg.RunCode(strings.ReplaceAll(testHttpWebsocketReconnectCode, "{url}", url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := exported["result"].([]interface{})
+	if expected := []interface{}{"one", "two"}; !reflect.DeepEqual(result[0], expected) {
+		t.Fatalf("expected messages %v, got %v", expected, result[0])
+	}
+	if expected := []interface{}{int64(1)}; !reflect.DeepEqual(result[1], expected) {
+		t.Fatalf("expected reconnect attempts %v, got %v", expected, result[1])
+	}
+	if !strings.Contains(result[2].(string), "close 1000") {
+		t.Fatalf("expected normal close error, got %v", result[2])
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&conns); n != 2 {
+		t.Fatalf("expected 2 connections, got %d", n)
+	}
+}
+
+const testHttpWebsocketLocalCloseCode = `
+http := import('http')
+concurrent := import('concurrent')
+time := import('time')
+ws := http.connectWebsocket('{url}', {reconnect: true, reconnectDelay: 0.01})
+reader := concurrent.start(() => {
+	try {
+		return ws.read()
+	} catch (e) {
+		return 'closed'
+	}
+})
+time.sleep(0.1)
+ws.close()
+export result := reader.await()
+`
+
+func TestHttpWebsocketClientLocalClose(t *testing.T) {
+	var conns int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&conns, 1)
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	exported, err := zgg.RunCode(strings.ReplaceAll(testHttpWebsocketLocalCloseCode, "{url}", url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported["result"] != "closed" {
+		t.Fatalf("expected read to fail after close, got %v", exported["result"])
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("expected no reconnect after close, got %d connections", n)
+	}
+}
@@ -23,12 +23,14 @@ var (
 	StdLibMap = map[string]LibInfo{
 		"base64":     LibInfo{name: "base64", getter: libBase64},
 		"binary":     LibInfo{name: "binary", getter: libBinary},
+		"columnar":   LibInfo{name: "columnar", getter: libColumnar},
 		"compress":   LibInfo{name: "compress", getter: libCompress},
 		"concurrent": LibInfo{name: "concurrent", getter: libConcurrent},
 		"cron":       LibInfo{name: "cron", getter: libCron},
 		"db":         LibInfo{name: "db", getter: libDb},
 		"dbop":       LibInfo{name: "db/op", getter: libDbOp},
 		"db/op":      LibInfo{name: "db/op", getter: libDbOp},
+		"dns":        LibInfo{name: "dns", getter: libDns},
 		"dom":        LibInfo{name: "dom", getter: libDom},
 		"drawing":    LibInfo{name: "drawing", getter: libDrawing},
 		"etree":      LibInfo{name: "etree", getter: libEtree},
@@ -37,14 +39,18 @@ var (
 		"fsnotify":   LibInfo{name: "fsnotify", getter: libFsnotify},
 		"go":         LibInfo{name: "go", getter: libGo},
 		"graph":      LibInfo{name: "graph", getter: libGraph},
+		"grpc":       LibInfo{name: "grpc", getter: libGrpc},
 		"hash":       LibInfo{name: "hash", getter: libHash},
 		"http":       LibInfo{name: "http", getter: libHttp},
 		"ip2region":  LibInfo{name: "ip2region", getter: libIp2region},
 		"json":       LibInfo{name: "json", getter: libJson},
 		"kv":         LibInfo{name: "kv", getter: libKv},
+		"mail":       LibInfo{name: "mail", getter: libMail},
 		"msgpack":    LibInfo{name: "msgpack", getter: libMsgpack},
 		"math":       LibInfo{name: "math", getter: libMath},
+		"mq":         LibInfo{name: "mq", getter: libMq},
 		"nsq":        LibInfo{name: "nsq", getter: libNsq},
+		"parquet":    LibInfo{name: "columnar", getter: libColumnar},
 		"path":       LibInfo{name: "path", getter: libPath},
 		"psutil":     LibInfo{name: "psutil", getter: libPsutil},
 		"ptable":     LibInfo{name: "ptable", getter: libPtable},
@@ -1,13 +1,18 @@
 package builtin_libs
 
 import (
+	"io/fs"
 	"io/ioutil"
+	"math"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	. "github.com/zgg-lang/zgg-go/runtime"
 
@@ -16,18 +21,143 @@ import (
 
 var (
 	kvAdapterSchemeMap = map[string]ValueType{}
-	kvManagerClass     = getKvManagerClass()
+	// 通过kv.register注册的zgg适配器，构造时传入url字符串
+	kvUserAdapters  = map[string]bool{}
+	kvAdapterLock   sync.RWMutex
+	kvManagerClass  = getKvManagerClass()
+	kvManagerOps    = []string{"get", "set", "delete", "exists", "incr", "compareAndSwap", "expire", "ttl", "keys", "getMany", "setMany"}
+	kvEmulatableOps = map[string]bool{"exists": true, "getMany": true, "setMany": true}
 )
 
 func libKv(c *Context) ValueObject {
 	lib := NewObject()
 	lib.SetMember("Manager", kvManagerClass, c)
-	initKvFileSystemAdapter()
-	initKvRedisAdapter()
-	initKvRedisHashAdapter()
+	lib.SetMember("register", NewNativeFunction("kv.register", func(c *Context, this Value, args []Value) Value {
+		var (
+			scheme ValueStr
+			class  ValueType
+		)
+		EnsureFuncParams(c, "kv.register", args,
+			ArgRuleRequired("scheme", TypeStr, &scheme),
+			ArgRuleRequired("class", TypeType, &class),
+		)
+		kvAdapterLock.Lock()
+		defer kvAdapterLock.Unlock()
+		kvAdapterSchemeMap[scheme.Value()] = class
+		kvUserAdapters[scheme.Value()] = true
+		return Undefined()
+	}, "scheme", "class"), c)
+	lib.SetMember("schemes", NewNativeFunction("kv.schemes", func(c *Context, this Value, args []Value) Value {
+		kvAdapterLock.RLock()
+		defer kvAdapterLock.RUnlock()
+		names := make([]string, 0, len(kvAdapterSchemeMap))
+		for name := range kvAdapterSchemeMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rv := NewArray(len(names))
+		for _, name := range names {
+			rv.PushBack(NewStr(name))
+		}
+		return rv
+	}), c)
 	return lib
 }
 
+func kvValueBytes(v Value) []byte {
+	if b, ok := v.(ValueBytes); ok {
+		return b.Value()
+	}
+	return []byte(v.ToString(nil))
+}
+
+// kvTTLArg 解析可选的ttl参数：秒数、时长字符串或time.Duration，没有时返回0
+func kvTTLArg(c *Context, funcName string, args []Value, i int) time.Duration {
+	if i >= len(args) {
+		return 0
+	}
+	switch v := args[i].(type) {
+	case ValueNil, ValueUndefined:
+		return 0
+	case ValueInt:
+		return time.Duration(v.Value()) * time.Second
+	case ValueFloat:
+		return time.Duration(v.Value() * float64(time.Second))
+	case ValueStr:
+		d, err := time.ParseDuration(v.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: invalid ttl %s", funcName, v.Value())
+		}
+		return d
+	case ValueObject:
+		if d, ok := v.Reserved.(time.Duration); ok {
+			return d
+		}
+	}
+	c.RaiseRuntimeError("%s: invalid ttl %s", funcName, args[i].ToString(c))
+	return 0
+}
+
+// kvTTLResult 与redis的TTL一致：不存在返回-2，没有过期时间返回-1，否则返回剩余秒数
+func kvTTLResult(found bool, remaining time.Duration) Value {
+	if !found {
+		return NewInt(-2)
+	}
+	if remaining < 0 {
+		return NewInt(-1)
+	}
+	return NewInt(int64(math.Ceil(remaining.Seconds())))
+}
+
+func kvParseInt(c *Context, funcName string, bs []byte) int64 {
+	n, err := strconv.ParseInt(string(bs), 10, 64)
+	if err != nil {
+		c.RaiseRuntimeError("%s: value is not an integer", funcName)
+	}
+	return n
+}
+
+func kvAdapterOf(c *Context, this ValueObject) ValueObject {
+	return c.MustObject(this.GetMember("__adapter", c))
+}
+
+func kvTypeHasMember(t ValueType, name string) bool {
+	if t == TypeObject {
+		return false
+	}
+	if _, found := t.Members.Load(name); found {
+		return true
+	}
+	for _, base := range t.Bases {
+		if kvTypeHasMember(base, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// kvSupports 只看适配器自己或其类定义的方法，忽略对象通用的keys等内置方法
+func kvSupports(c *Context, adapter ValueObject, op string) bool {
+	defined := kvTypeHasMember(adapter.Type(), op)
+	if !defined {
+		adapter.Each(func(name string, _ Value) bool {
+			defined = name == op
+			return !defined
+		})
+	}
+	return defined && c.IsCallable(adapter.GetMember(op, c))
+}
+
+// kvForward 调用适配器的同名方法，适配器不支持时报错
+func kvForward(c *Context, this ValueObject, op string, args []Value) Value {
+	adapter := kvAdapterOf(c, this)
+	if !kvSupports(c, adapter, op) {
+		c.RaiseRuntimeError("kv adapter %s does not support %s", adapter.Type().Name, op)
+	}
+	c.InvokeMethod(adapter, op, Args(args...))
+	return c.RetVal
+}
+
 func getKvManagerClass() ValueType {
 	return NewClassBuilder("Manager").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
@@ -40,11 +170,18 @@ func getKvManagerClass() ValueType {
 				if err != nil {
 					c.RaiseRuntimeError("parse adapter scheme error %s", err)
 				}
+				kvAdapterLock.RLock()
 				adapterClass, found := kvAdapterSchemeMap[u.Scheme]
+				isUser := kvUserAdapters[u.Scheme]
+				kvAdapterLock.RUnlock()
 				if !found {
 					c.RaiseRuntimeError("unknown adapter scheme %s", u.Scheme)
 				}
-				adapter = NewObjectAndInit(adapterClass, c, NewGoValue(u))
+				if isUser {
+					adapter = NewObjectAndInit(adapterClass, c, adapterScheme)
+				} else {
+					adapter = NewObjectAndInit(adapterClass, c, NewGoValue(u))
+				}
 			}
 			this.SetMember("__adapter", adapter, c)
 			if onOpen := adapter.GetMember("onOpen", c); c.IsCallable(onOpen) {
@@ -52,17 +189,94 @@ func getKvManagerClass() ValueType {
 			}
 		}).
 		Method("get", func(c *Context, this ValueObject, args []Value) Value {
-			adapter := c.MustObject(this.GetMember("__adapter", c))
-			c.InvokeMethod(adapter, "get", Args(args...))
-			return c.RetVal
+			return kvForward(c, this, "get", args)
 		}).
 		Method("set", func(c *Context, this ValueObject, args []Value) Value {
-			adapter := c.MustObject(this.GetMember("__adapter", c))
-			c.InvokeMethod(adapter, "set", Args(args...))
+			kvForward(c, this, "set", args)
+			return this
+		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			return kvForward(c, this, "delete", args)
+		}).
+		Method("exists", func(c *Context, this ValueObject, args []Value) Value {
+			if kvSupports(c, kvAdapterOf(c, this), "exists") {
+				return kvForward(c, this, "exists", args)
+			}
+			return NewBool(!dbIsNil(kvForward(c, this, "get", args)))
+		}).
+		Method("incr", func(c *Context, this ValueObject, args []Value) Value {
+			if len(args) < 2 {
+				args = append(args, NewInt(1))
+			}
+			return kvForward(c, this, "incr", args)
+		}).
+		Method("compareAndSwap", func(c *Context, this ValueObject, args []Value) Value {
+			return kvForward(c, this, "compareAndSwap", args)
+		}).
+		Method("expire", func(c *Context, this ValueObject, args []Value) Value {
+			return kvForward(c, this, "expire", args)
+		}).
+		Method("ttl", func(c *Context, this ValueObject, args []Value) Value {
+			return kvForward(c, this, "ttl", args)
+		}).
+		Method("keys", func(c *Context, this ValueObject, args []Value) Value {
+			if len(args) < 1 {
+				args = append(args, NewStr(""))
+			}
+			return kvForward(c, this, "keys", args)
+		}).
+		Method("getMany", func(c *Context, this ValueObject, args []Value) Value {
+			if kvSupports(c, kvAdapterOf(c, this), "getMany") {
+				return kvForward(c, this, "getMany", args)
+			}
+			var keys ValueArray
+			EnsureFuncParams(c, "Manager.getMany", args, ArgRuleRequired("keys", TypeArray, &keys))
+			rv := NewArray(keys.Len())
+			for i := 0; i < keys.Len(); i++ {
+				if v := kvForward(c, this, "get", []Value{keys.GetIndex(i, c)}); dbIsNil(v) {
+					rv.PushBack(Nil())
+				} else {
+					rv.PushBack(v)
+				}
+			}
+			return rv
+		}).
+		Method("setMany", func(c *Context, this ValueObject, args []Value) Value {
+			if kvSupports(c, kvAdapterOf(c, this), "setMany") {
+				kvForward(c, this, "setMany", args)
+				return this
+			}
+			var items ValueObject
+			EnsureFuncParams(c, "Manager.setMany", args, ArgRuleRequired("items", TypeObject, &items))
+			items.Iterate(func(key string, value Value) {
+				setArgs := []Value{NewStr(key), value}
+				if len(args) > 1 {
+					setArgs = append(setArgs, args[1])
+				}
+				kvForward(c, this, "set", setArgs)
+			})
 			return this
 		}).
+		Method("capabilities", func(c *Context, this ValueObject, args []Value) Value {
+			adapter := kvAdapterOf(c, this)
+			rv := NewArray(len(kvManagerOps))
+			for _, op := range kvManagerOps {
+				if kvEmulatableOps[op] || kvSupports(c, adapter, op) {
+					rv.PushBack(NewStr(op))
+				}
+			}
+			return rv
+		}).
+		Method("supports", func(c *Context, this ValueObject, args []Value) Value {
+			var op ValueStr
+			EnsureFuncParams(c, "Manager.supports", args, ArgRuleRequired("op", TypeStr, &op))
+			if kvEmulatableOps[op.Value()] {
+				return NewBool(true)
+			}
+			return NewBool(kvSupports(c, kvAdapterOf(c, this), op.Value()))
+		}).
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
-			adapter := c.MustObject(this.GetMember("__adapter", c))
+			adapter := kvAdapterOf(c, this)
 			if close := adapter.GetMember("close", c); c.IsCallable(close) {
 				c.Invoke(close, adapter, NoArgs)
 			}
@@ -72,92 +286,346 @@ func getKvManagerClass() ValueType {
 }
 
 func initKvFileSystemAdapter() {
+	type fsInfo struct {
+		root   string
+		prefix string
+		// 读改写操作只在当前进程内保证原子性
+		lock sync.Mutex
+	}
+	getInfo := func(this ValueObject) *fsInfo {
+		return this.Reserved.(*fsInfo)
+	}
+	writeFile := func(c *Context, funcName, filename string, bs []byte) {
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			c.RaiseRuntimeError("%s: create dir for %s error %s", funcName, filename, err)
+		}
+		tmp := filename + ".tmp"
+		if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+			c.RaiseRuntimeError("%s: write file %s error %s", funcName, filename, err)
+		}
+		if err := os.Rename(tmp, filename); err != nil {
+			os.Remove(tmp)
+			c.RaiseRuntimeError("%s: write file %s error %s", funcName, filename, err)
+		}
+	}
+	readFile := func(c *Context, funcName, filename string) ([]byte, bool) {
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, false
+			}
+			c.RaiseRuntimeError("%s: read file %s error %s", funcName, filename, err)
+		}
+		return bs, true
+	}
+	noTTL := func(c *Context, funcName string, args []Value, i int) {
+		if kvTTLArg(c, funcName, args, i) > 0 {
+			c.RaiseRuntimeError("%s: ttl is not supported", funcName)
+		}
+	}
 	t := NewClassBuilder("FileSystemAdapter").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
 			u := args[0].ToGoValue(c).(*url.URL)
 			q := u.Query()
+			this.Reserved = &fsInfo{root: u.Path, prefix: q.Get("prefix")}
 			this.SetMember("__root", NewStr(u.Path), c)
 			this.SetMember("__prefix", NewStr(q.Get("prefix")), c)
 		}).
 		Method("get", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				root   = c.MustStr(this.GetMember("__root", c))
-				prefix = c.MustStr(this.GetMember("__prefix", c))
-				key    ValueStr
+				info = getInfo(this)
+				key  ValueStr
 			)
 			EnsureFuncParams(c, "FileSystemAdapter.get", args, ArgRuleRequired("key", TypeStr, &key))
-			filename := filepath.Join(root, prefix+key.Value())
-			bs, err := ioutil.ReadFile(filename)
-			if err != nil {
-				if os.IsNotExist(err) {
-					return Nil()
-				}
-				c.RaiseRuntimeError("FileSystemAdapter.get: read file %s error %s", filename, err)
+			bs, found := readFile(c, "FileSystemAdapter.get", filepath.Join(info.root, info.prefix+key.Value()))
+			if !found {
+				return Nil()
 			}
 			return NewBytes(bs)
 		}).
 		Method("set", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				root   = c.MustStr(this.GetMember("__root", c))
-				prefix = c.MustStr(this.GetMember("__prefix", c))
-				key    ValueStr
-				data   Value
+				info = getInfo(this)
+				key  ValueStr
+				data Value
 			)
 			EnsureFuncParams(c, "FileSystemAdapter.set", args,
 				ArgRuleRequired("key", TypeStr, &key),
 				ArgRuleRequired("value", TypeAny, &data),
 			)
-			filename := filepath.Join(root, prefix+key.Value())
-			var bs []byte
-			if b, ok := data.(ValueBytes); ok {
-				bs = b.Value()
-			} else {
-				bs = []byte(data.ToString(c))
+			noTTL(c, "FileSystemAdapter.set", args, 2)
+			info.lock.Lock()
+			defer info.lock.Unlock()
+			writeFile(c, "FileSystemAdapter.set", filepath.Join(info.root, info.prefix+key.Value()), kvValueBytes(data))
+			return Undefined()
+		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info = getInfo(this)
+				key  ValueStr
+			)
+			EnsureFuncParams(c, "FileSystemAdapter.delete", args, ArgRuleRequired("key", TypeStr, &key))
+			info.lock.Lock()
+			defer info.lock.Unlock()
+			filename := filepath.Join(info.root, info.prefix+key.Value())
+			if err := os.Remove(filename); err != nil {
+				if os.IsNotExist(err) {
+					return NewBool(false)
+				}
+				c.RaiseRuntimeError("FileSystemAdapter.delete: remove file %s error %s", filename, err)
 			}
-			if err := ioutil.WriteFile(filename, bs, 0600); err != nil {
-				c.RaiseRuntimeError("FileSystemAdapter.set: write file %s error %s", filename, err)
+			return NewBool(true)
+		}).
+		Method("exists", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info = getInfo(this)
+				key  ValueStr
+			)
+			EnsureFuncParams(c, "FileSystemAdapter.exists", args, ArgRuleRequired("key", TypeStr, &key))
+			st, err := os.Stat(filepath.Join(info.root, info.prefix+key.Value()))
+			return NewBool(err == nil && !st.IsDir())
+		}).
+		Method("incr", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info  = getInfo(this)
+				key   ValueStr
+				delta ValueInt
+			)
+			EnsureFuncParams(c, "FileSystemAdapter.incr", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleOptional("delta", TypeInt, &delta, NewInt(1)),
+			)
+			info.lock.Lock()
+			defer info.lock.Unlock()
+			filename := filepath.Join(info.root, info.prefix+key.Value())
+			n := delta.Value()
+			if bs, found := readFile(c, "FileSystemAdapter.incr", filename); found {
+				n += kvParseInt(c, "FileSystemAdapter.incr", bs)
 			}
-			return Undefined()
+			writeFile(c, "FileSystemAdapter.incr", filename, []byte(strconv.FormatInt(n, 10)))
+			return NewInt(n)
+		}).
+		Method("compareAndSwap", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info     = getInfo(this)
+				key      ValueStr
+				expected Value
+				data     Value
+			)
+			EnsureFuncParams(c, "FileSystemAdapter.compareAndSwap", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("expected", TypeAny, &expected),
+				ArgRuleRequired("value", TypeAny, &data),
+			)
+			noTTL(c, "FileSystemAdapter.compareAndSwap", args, 3)
+			info.lock.Lock()
+			defer info.lock.Unlock()
+			filename := filepath.Join(info.root, info.prefix+key.Value())
+			bs, found := readFile(c, "FileSystemAdapter.compareAndSwap", filename)
+			if dbIsNil(expected) {
+				if found {
+					return NewBool(false)
+				}
+			} else if !found || string(bs) != string(kvValueBytes(expected)) {
+				return NewBool(false)
+			}
+			writeFile(c, "FileSystemAdapter.compareAndSwap", filename, kvValueBytes(data))
+			return NewBool(true)
+		}).
+		Method("keys", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info   = getInfo(this)
+				prefix ValueStr
+			)
+			EnsureFuncParams(c, "FileSystemAdapter.keys", args,
+				ArgRuleOptional("prefix", TypeStr, &prefix, NewStr("")),
+			)
+			full := info.prefix + prefix.Value()
+			rv := NewArray()
+			err := filepath.WalkDir(info.root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+					return err
+				}
+				rel, err := filepath.Rel(info.root, path)
+				if err != nil {
+					return err
+				}
+				if rel = filepath.ToSlash(rel); strings.HasPrefix(rel, full) {
+					rv.PushBack(NewStr(rel[len(info.prefix):]))
+				}
+				return nil
+			})
+			if err != nil && !os.IsNotExist(err) {
+				c.RaiseRuntimeError("FileSystemAdapter.keys: %s", err)
+			}
+			return rv
 		}).
 		Build()
 	kvAdapterSchemeMap["fs"] = t
+	kvAdapterSchemeMap["file"] = t
+}
+
+// kvRedisPool 根据url创建redis连接池
+func kvRedisPool(c *Context, funcName string, u *url.URL) *redis.Pool {
+	q := u.Query()
+	var host string
+	if h, p, err := net.SplitHostPort(u.Host); err != nil {
+		c.RaiseRuntimeError("%s: invalid url %s: %s", funcName, u, err)
+	} else {
+		if p == "" {
+			p = "6379"
+		}
+		if h == "" {
+			h = "localhost"
+		}
+		host = h + ":" + p
+	}
+	opts := []redis.DialOption{}
+	if db, err := strconv.Atoi(q.Get("db")); err == nil && db != 0 {
+		opts = append(opts, redis.DialDatabase(db))
+	}
+	if ui := u.User; ui != nil {
+		opts = append(opts, redis.DialUsername(ui.Username()))
+		if p, has := ui.Password(); has {
+			opts = append(opts, redis.DialPassword(p))
+		}
+	}
+	maxIdle := 10
+	if mi, err := strconv.Atoi(q.Get("maxIdle")); err == nil {
+		maxIdle = mi
+	}
+	return redis.NewPool(func() (redis.Conn, error) {
+		return redis.Dial("tcp", host, opts...)
+	}, maxIdle)
+}
+
+// kvRedisEscape 转义SCAN MATCH中的通配符
+func kvRedisEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// kvRedisScan 返回逐批SCAN/HSCAN的可迭代对象，key数量可能很大，不一次性取出，因此也不排序
+func kvRedisScan(c *Context, funcName string, pool *redis.Pool, cmd string, cmdKey string, match string, strip int) Value {
+	var (
+		cursor  = "0"
+		started = false
+		buf     []string
+	)
+	return MakeIterator(c, func() Value {
+		for len(buf) == 0 {
+			if started && cursor == "0" {
+				return nil
+			}
+			started = true
+			conn := pool.Get()
+			args := redis.Args{}
+			if cmdKey != "" {
+				args = args.Add(cmdKey)
+			}
+			reply, err := redis.Values(conn.Do(cmd, args.Add(cursor, "MATCH", match, "COUNT", 100)...))
+			conn.Close()
+			if err != nil {
+				c.RaiseRuntimeError("%s: %s", funcName, err)
+			}
+			cursor, _ = redis.String(reply[0], nil)
+			items, _ := redis.Strings(reply[1], nil)
+			if cmd == "HSCAN" {
+				// HSCAN返回field和value交替的列表
+				for i := 0; i < len(items); i += 2 {
+					buf = append(buf, items[i])
+				}
+			} else {
+				buf = items
+			}
+		}
+		key := buf[0]
+		buf = buf[1:]
+		return NewStr(key[strip:])
+	}, func() {})
+}
+
+// KEYS[1]为key，ARGV依次为：期望不存在、期望值、新值、过期毫秒数、hash中的field（为空时操作普通key）
+const kvRedisCASScript = `
+local cur
+if ARGV[5] == '' then
+	cur = redis.call('GET', KEYS[1])
+else
+	cur = redis.call('HGET', KEYS[1], ARGV[5])
+end
+if ARGV[1] == '1' then
+	if cur then return 0 end
+elseif cur ~= ARGV[2] then
+	return 0
+end
+if ARGV[5] ~= '' then
+	redis.call('HSET', KEYS[1], ARGV[5], ARGV[3])
+elseif tonumber(ARGV[4]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[3])
+end
+return 1
+`
+
+var kvRedisCAS = redis.NewScript(1, kvRedisCASScript)
+
+// kvRedisCompareAndSwap field为空时操作普通key，否则操作hash中的field
+func kvRedisCompareAndSwap(c *Context, funcName string, pool *redis.Pool, key, field string, expected, data Value, ttl time.Duration) Value {
+	isNil, expectedBytes := "0", []byte{}
+	if dbIsNil(expected) {
+		isNil = "1"
+	} else {
+		expectedBytes = kvValueBytes(expected)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	n, err := redis.Int(kvRedisCAS.Do(conn, key, isNil, expectedBytes, kvValueBytes(data), ttl.Milliseconds(), field))
+	if err != nil {
+		c.RaiseRuntimeError("%s: %s", funcName, err)
+	}
+	return NewBool(n == 1)
+}
+
+func kvRedisBytesArray(c *Context, funcName string, reply interface{}, err error) Value {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		c.RaiseRuntimeError("%s: %s", funcName, err)
+	}
+	rv := NewArray(len(values))
+	for _, v := range values {
+		if bs, ok := v.([]byte); ok {
+			rv.PushBack(NewBytes(bs))
+		} else {
+			rv.PushBack(Nil())
+		}
+	}
+	return rv
 }
 
 func initKvRedisAdapter() {
+	getPool := func(c *Context, this ValueObject) *redis.Pool {
+		return this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+	}
+	// 没有指定ttl时使用url中的默认ttl
+	getTTL := func(c *Context, this ValueObject, funcName string, args []Value, i int) time.Duration {
+		if ttl := kvTTLArg(c, funcName, args, i); ttl > 0 {
+			return ttl
+		}
+		return time.Duration(c.MustInt(this.GetMember("__ttl", c))) * time.Second
+	}
 	t := NewClassBuilder("RedisAdapter").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
 			u := args[0].ToGoValue(c).(*url.URL)
 			q := u.Query()
-			var host string
-			if h, p, err := net.SplitHostPort(u.Host); err != nil {
-				c.RaiseRuntimeError("RedisAdapter.__init__: invalid url %s: %s", u, err)
-			} else {
-				if p == "" {
-					p = "6379"
-				}
-				if h == "" {
-					h = "localhost"
-				}
-				host = h + ":" + p
-			}
-			opts := []redis.DialOption{}
-			if db, err := strconv.Atoi(q.Get("db")); err == nil && db != 0 {
-				opts = append(opts, redis.DialDatabase(db))
-			}
-			if ui := u.User; ui != nil {
-				opts = append(opts, redis.DialUsername(ui.Username()))
-				if p, has := ui.Password(); has {
-					opts = append(opts, redis.DialPassword(p))
-				}
-			}
-			maxIdle := 10
-			if mi, err := strconv.Atoi(q.Get("maxIdle")); err == nil {
-				maxIdle = mi
-			}
-			pool := redis.NewPool(func() (redis.Conn, error) {
-				return redis.Dial("tcp", host, opts...)
-			}, maxIdle)
-			this.SetMember("__pool", NewGoValue(pool), c)
+			this.SetMember("__pool", NewGoValue(kvRedisPool(c, "RedisAdapter.__init__", u)), c)
 			this.SetMember("__prefix", NewStr(q.Get("prefix")), c)
 			if ttl, err := strconv.Atoi(q.Get("ttl")); err == nil && ttl > 0 {
 				this.SetMember("__ttl", NewInt(int64(ttl)), c)
@@ -167,7 +635,7 @@ func initKvRedisAdapter() {
 		}).
 		Method("get", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				pool   = this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+				pool   = getPool(c, this)
 				prefix = c.MustStr(this.GetMember("__prefix", c))
 				key    ValueStr
 			)
@@ -185,9 +653,8 @@ func initKvRedisAdapter() {
 		}).
 		Method("set", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				pool   = this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+				pool   = getPool(c, this)
 				prefix = c.MustStr(this.GetMember("__prefix", c))
-				ttl    = c.MustInt(this.GetMember("__ttl", c))
 				key    ValueStr
 				data   Value
 			)
@@ -195,31 +662,179 @@ func initKvRedisAdapter() {
 				ArgRuleRequired("key", TypeStr, &key),
 				ArgRuleRequired("value", TypeAny, &data),
 			)
-			var bs []byte
-			if b, ok := data.(ValueBytes); ok {
-				bs = b.Value()
-			} else {
-				bs = []byte(data.ToString(c))
-			}
+			ttl := getTTL(c, this, "RedisAdapter.set", args, 2)
 			conn := pool.Get()
 			defer conn.Close()
 			redisKey := prefix + key.Value()
 			var err error
 			if ttl > 0 {
-				_, err = conn.Do("SET", redisKey, bs, "EX", ttl)
+				_, err = conn.Do("SET", redisKey, kvValueBytes(data), "PX", ttl.Milliseconds())
 			} else {
-				_, err = conn.Do("SET", redisKey, bs)
+				_, err = conn.Do("SET", redisKey, kvValueBytes(data))
 			}
 			if err != nil {
 				c.RaiseRuntimeError("RedisAdapter.set: %s", err)
 			}
 			return Undefined()
 		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, "RedisAdapter.delete", args, ArgRuleRequired("key", TypeStr, &key))
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int(conn.Do("DEL", c.MustStr(this.GetMember("__prefix", c))+key.Value()))
+			if err != nil {
+				c.RaiseRuntimeError("RedisAdapter.delete: %s", err)
+			}
+			return NewBool(n > 0)
+		}).
+		Method("exists", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, "RedisAdapter.exists", args, ArgRuleRequired("key", TypeStr, &key))
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int(conn.Do("EXISTS", c.MustStr(this.GetMember("__prefix", c))+key.Value()))
+			if err != nil {
+				c.RaiseRuntimeError("RedisAdapter.exists: %s", err)
+			}
+			return NewBool(n > 0)
+		}).
+		Method("incr", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				key   ValueStr
+				delta ValueInt
+			)
+			EnsureFuncParams(c, "RedisAdapter.incr", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleOptional("delta", TypeInt, &delta, NewInt(1)),
+			)
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int64(conn.Do("INCRBY", c.MustStr(this.GetMember("__prefix", c))+key.Value(), delta.Value()))
+			if err != nil {
+				c.RaiseRuntimeError("RedisAdapter.incr: %s", err)
+			}
+			return NewInt(n)
+		}).
+		Method("compareAndSwap", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				key      ValueStr
+				expected Value
+				data     Value
+			)
+			EnsureFuncParams(c, "RedisAdapter.compareAndSwap", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("expected", TypeAny, &expected),
+				ArgRuleRequired("value", TypeAny, &data),
+			)
+			ttl := getTTL(c, this, "RedisAdapter.compareAndSwap", args, 3)
+			redisKey := c.MustStr(this.GetMember("__prefix", c)) + key.Value()
+			return kvRedisCompareAndSwap(c, "RedisAdapter.compareAndSwap", getPool(c, this), redisKey, "", expected, data, ttl)
+		}).
+		Method("expire", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				key ValueStr
+				ttl Value
+			)
+			EnsureFuncParams(c, "RedisAdapter.expire", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("ttl", TypeAny, &ttl),
+			)
+			redisKey := c.MustStr(this.GetMember("__prefix", c)) + key.Value()
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			var (
+				n   int
+				err error
+			)
+			if d := kvTTLArg(c, "RedisAdapter.expire", args, 1); d > 0 {
+				n, err = redis.Int(conn.Do("PEXPIRE", redisKey, d.Milliseconds()))
+			} else if n, err = redis.Int(conn.Do("EXISTS", redisKey)); err == nil && n > 0 {
+				_, err = conn.Do("PERSIST", redisKey)
+			}
+			if err != nil {
+				c.RaiseRuntimeError("RedisAdapter.expire: %s", err)
+			}
+			return NewBool(n > 0)
+		}).
+		Method("ttl", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, "RedisAdapter.ttl", args, ArgRuleRequired("key", TypeStr, &key))
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			ms, err := redis.Int64(conn.Do("PTTL", c.MustStr(this.GetMember("__prefix", c))+key.Value()))
+			if err != nil {
+				c.RaiseRuntimeError("RedisAdapter.ttl: %s", err)
+			}
+			return kvTTLResult(ms != -2, time.Duration(ms)*time.Millisecond)
+		}).
+		Method("keys", func(c *Context, this ValueObject, args []Value) Value {
+			var prefix ValueStr
+			EnsureFuncParams(c, "RedisAdapter.keys", args,
+				ArgRuleOptional("prefix", TypeStr, &prefix, NewStr("")),
+			)
+			keyPrefix := c.MustStr(this.GetMember("__prefix", c))
+			match := kvRedisEscape(keyPrefix+prefix.Value()) + "*"
+			return kvRedisScan(c, "RedisAdapter.keys", getPool(c, this), "SCAN", "", match, len(keyPrefix))
+		}).
+		Method("getMany", func(c *Context, this ValueObject, args []Value) Value {
+			var keys ValueArray
+			EnsureFuncParams(c, "RedisAdapter.getMany", args, ArgRuleRequired("keys", TypeArray, &keys))
+			if keys.Len() == 0 {
+				return NewArray()
+			}
+			prefix := c.MustStr(this.GetMember("__prefix", c))
+			cmdArgs := redis.Args{}
+			for i := 0; i < keys.Len(); i++ {
+				cmdArgs = cmdArgs.Add(prefix + keys.GetIndex(i, c).ToString(c))
+			}
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			reply, err := conn.Do("MGET", cmdArgs...)
+			return kvRedisBytesArray(c, "RedisAdapter.getMany", reply, err)
+		}).
+		Method("setMany", func(c *Context, this ValueObject, args []Value) Value {
+			var items ValueObject
+			EnsureFuncParams(c, "RedisAdapter.setMany", args, ArgRuleRequired("items", TypeObject, &items))
+			var (
+				ttl    = getTTL(c, this, "RedisAdapter.setMany", args, 1)
+				prefix = c.MustStr(this.GetMember("__prefix", c))
+				conn   = getPool(c, this).Get()
+			)
+			defer conn.Close()
+			conn.Send("MULTI")
+			items.Iterate(func(key string, value Value) {
+				if ttl > 0 {
+					conn.Send("SET", prefix+key, kvValueBytes(value), "PX", ttl.Milliseconds())
+				} else {
+					conn.Send("SET", prefix+key, kvValueBytes(value))
+				}
+			})
+			if _, err := conn.Do("EXEC"); err != nil {
+				c.RaiseRuntimeError("RedisAdapter.setMany: %s", err)
+			}
+			return Undefined()
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			getPool(c, this).Close()
+			return Undefined()
+		}).
 		Build()
 	kvAdapterSchemeMap["redis"] = t
 }
 
 func initKvRedisHashAdapter() {
+	getPool := func(c *Context, this ValueObject) *redis.Pool {
+		return this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+	}
+	fieldOf := func(c *Context, this ValueObject, key ValueStr) string {
+		return c.MustStr(this.GetMember("__prefix", c)) + key.Value()
+	}
+	noTTL := func(c *Context, funcName string, args []Value, i int) {
+		if kvTTLArg(c, funcName, args, i) > 0 {
+			c.RaiseRuntimeError("%s: ttl is not supported", funcName)
+		}
+	}
 	t := NewClassBuilder("RedisHashAdapter").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
 			u := args[0].ToGoValue(c).(*url.URL)
@@ -231,50 +846,20 @@ func initKvRedisHashAdapter() {
 			if hashkey == "" {
 				c.RaiseRuntimeError("RedisHashAdapter.__init__: hashkey cannot be empty")
 			}
-			var host string
-			if h, p, err := net.SplitHostPort(u.Host); err != nil {
-				c.RaiseRuntimeError("RedisHashAdapter.__init__: invalid url %s: %s", u, err)
-			} else {
-				if p == "" {
-					p = "6379"
-				}
-				if h == "" {
-					h = "localhost"
-				}
-				host = h + ":" + p
-			}
-			opts := []redis.DialOption{}
-			if db, err := strconv.Atoi(q.Get("db")); err == nil && db != 0 {
-				opts = append(opts, redis.DialDatabase(db))
-			}
-			if ui := u.User; ui != nil {
-				opts = append(opts, redis.DialUsername(ui.Username()))
-				if p, has := ui.Password(); has {
-					opts = append(opts, redis.DialPassword(p))
-				}
-			}
-			maxIdle := 10
-			if mi, err := strconv.Atoi(q.Get("maxIdle")); err == nil {
-				maxIdle = mi
-			}
-			pool := redis.NewPool(func() (redis.Conn, error) {
-				return redis.Dial("tcp", host, opts...)
-			}, maxIdle)
-			this.SetMember("__pool", NewGoValue(pool), c)
+			this.SetMember("__pool", NewGoValue(kvRedisPool(c, "RedisHashAdapter.__init__", u)), c)
 			this.SetMember("__hashkey", NewStr(hashkey), c)
 			this.SetMember("__prefix", NewStr(q.Get("prefix")), c)
 		}).
 		Method("get", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				pool    = this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+				pool    = getPool(c, this)
 				hashkey = this.GetMember("__hashkey", c)
-				prefix  = c.MustStr(this.GetMember("__prefix", c))
 				key     ValueStr
 			)
 			EnsureFuncParams(c, "RedisHashAdapter.get", args, ArgRuleRequired("key", TypeStr, &key))
 			conn := pool.Get()
 			defer conn.Close()
-			bs, err := redis.Bytes(conn.Do("HGET", hashkey.ToString(c), prefix+key.Value()))
+			bs, err := redis.Bytes(conn.Do("HGET", hashkey.ToString(c), fieldOf(c, this, key)))
 			if err != nil {
 				if err == redis.ErrNil {
 					return Nil()
@@ -285,9 +870,8 @@ func initKvRedisHashAdapter() {
 		}).
 		Method("set", func(c *Context, this ValueObject, args []Value) Value {
 			var (
-				pool    = this.GetMember("__pool", c).ToGoValue(c).(*redis.Pool)
+				pool    = getPool(c, this)
 				hashkey = this.GetMember("__hashkey", c)
-				prefix  = c.MustStr(this.GetMember("__prefix", c))
 				key     ValueStr
 				data    Value
 			)
@@ -295,19 +879,126 @@ func initKvRedisHashAdapter() {
 				ArgRuleRequired("key", TypeStr, &key),
 				ArgRuleRequired("value", TypeAny, &data),
 			)
-			var bs []byte
-			if b, ok := data.(ValueBytes); ok {
-				bs = b.Value()
-			} else {
-				bs = []byte(data.ToString(c))
-			}
+			noTTL(c, "RedisHashAdapter.set", args, 2)
 			conn := pool.Get()
 			defer conn.Close()
-			if _, err := conn.Do("HSET", hashkey.ToString(c), prefix+key.Value(), bs); err != nil {
+			if _, err := conn.Do("HSET", hashkey.ToString(c), fieldOf(c, this, key), kvValueBytes(data)); err != nil {
 				c.RaiseRuntimeError("RedisHashAdapter.set: %s", err)
 			}
 			return Undefined()
 		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, "RedisHashAdapter.delete", args, ArgRuleRequired("key", TypeStr, &key))
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int(conn.Do("HDEL", this.GetMember("__hashkey", c).ToString(c), fieldOf(c, this, key)))
+			if err != nil {
+				c.RaiseRuntimeError("RedisHashAdapter.delete: %s", err)
+			}
+			return NewBool(n > 0)
+		}).
+		Method("exists", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, "RedisHashAdapter.exists", args, ArgRuleRequired("key", TypeStr, &key))
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int(conn.Do("HEXISTS", this.GetMember("__hashkey", c).ToString(c), fieldOf(c, this, key)))
+			if err != nil {
+				c.RaiseRuntimeError("RedisHashAdapter.exists: %s", err)
+			}
+			return NewBool(n > 0)
+		}).
+		Method("incr", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				key   ValueStr
+				delta ValueInt
+			)
+			EnsureFuncParams(c, "RedisHashAdapter.incr", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleOptional("delta", TypeInt, &delta, NewInt(1)),
+			)
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			n, err := redis.Int64(conn.Do("HINCRBY", this.GetMember("__hashkey", c).ToString(c), fieldOf(c, this, key), delta.Value()))
+			if err != nil {
+				c.RaiseRuntimeError("RedisHashAdapter.incr: %s", err)
+			}
+			return NewInt(n)
+		}).
+		Method("compareAndSwap", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				key      ValueStr
+				expected Value
+				data     Value
+			)
+			EnsureFuncParams(c, "RedisHashAdapter.compareAndSwap", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("expected", TypeAny, &expected),
+				ArgRuleRequired("value", TypeAny, &data),
+			)
+			noTTL(c, "RedisHashAdapter.compareAndSwap", args, 3)
+			hashkey := this.GetMember("__hashkey", c).ToString(c)
+			return kvRedisCompareAndSwap(c, "RedisHashAdapter.compareAndSwap", getPool(c, this), hashkey, fieldOf(c, this, key), expected, data, 0)
+		}).
+		Method("keys", func(c *Context, this ValueObject, args []Value) Value {
+			var prefix ValueStr
+			EnsureFuncParams(c, "RedisHashAdapter.keys", args,
+				ArgRuleOptional("prefix", TypeStr, &prefix, NewStr("")),
+			)
+			keyPrefix := c.MustStr(this.GetMember("__prefix", c))
+			match := kvRedisEscape(keyPrefix+prefix.Value()) + "*"
+			hashkey := this.GetMember("__hashkey", c).ToString(c)
+			return kvRedisScan(c, "RedisHashAdapter.keys", getPool(c, this), "HSCAN", hashkey, match, len(keyPrefix))
+		}).
+		Method("getMany", func(c *Context, this ValueObject, args []Value) Value {
+			var keys ValueArray
+			EnsureFuncParams(c, "RedisHashAdapter.getMany", args, ArgRuleRequired("keys", TypeArray, &keys))
+			if keys.Len() == 0 {
+				return NewArray()
+			}
+			prefix := c.MustStr(this.GetMember("__prefix", c))
+			cmdArgs := redis.Args{}.Add(this.GetMember("__hashkey", c).ToString(c))
+			for i := 0; i < keys.Len(); i++ {
+				cmdArgs = cmdArgs.Add(prefix + keys.GetIndex(i, c).ToString(c))
+			}
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			reply, err := conn.Do("HMGET", cmdArgs...)
+			return kvRedisBytesArray(c, "RedisHashAdapter.getMany", reply, err)
+		}).
+		Method("setMany", func(c *Context, this ValueObject, args []Value) Value {
+			var items ValueObject
+			EnsureFuncParams(c, "RedisHashAdapter.setMany", args, ArgRuleRequired("items", TypeObject, &items))
+			noTTL(c, "RedisHashAdapter.setMany", args, 1)
+			if items.Len() == 0 {
+				return Undefined()
+			}
+			prefix := c.MustStr(this.GetMember("__prefix", c))
+			cmdArgs := redis.Args{}.Add(this.GetMember("__hashkey", c).ToString(c))
+			items.Iterate(func(key string, value Value) {
+				cmdArgs = cmdArgs.Add(prefix+key, kvValueBytes(value))
+			})
+			conn := getPool(c, this).Get()
+			defer conn.Close()
+			if _, err := conn.Do("HSET", cmdArgs...); err != nil {
+				c.RaiseRuntimeError("RedisHashAdapter.setMany: %s", err)
+			}
+			return Undefined()
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			getPool(c, this).Close()
+			return Undefined()
+		}).
 		Build()
 	kvAdapterSchemeMap["redishash"] = t
 }
+
+func init() {
+	initKvFileSystemAdapter()
+	initKvRedisAdapter()
+	initKvRedisHashAdapter()
+	initKvMemAdapter()
+	initKvSqliteAdapter()
+	initKvBoltAdapter()
+}
@@ -0,0 +1,149 @@
+package builtin_libs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/url"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// kvBoltStore 基于bbolt的B+树存储，值的前8字节为大端序的过期时间
+type kvBoltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func kvBoltDecode(raw []byte) ([]byte, int64) {
+	if len(raw) < 8 {
+		return raw, 0
+	}
+	value := make([]byte, len(raw)-8)
+	copy(value, raw[8:])
+	return value, int64(binary.BigEndian.Uint64(raw[:8]))
+}
+
+func kvBoltEncode(value []byte, expireAt int64) []byte {
+	raw := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(raw[:8], uint64(expireAt))
+	copy(raw[8:], value)
+	return raw
+}
+
+func (s *kvBoltStore) get(b *bolt.Bucket, key string, now int64) ([]byte, int64, bool) {
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return nil, 0, false
+	}
+	value, expireAt := kvBoltDecode(raw)
+	if !kvAlive(expireAt, now) {
+		return nil, 0, false
+	}
+	return value, expireAt, true
+}
+
+func (s *kvBoltStore) lookup(key string, now int64) (value []byte, expireAt int64, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value, expireAt, found = s.get(tx.Bucket(s.bucket), key, now)
+		return nil
+	})
+	return
+}
+
+func (s *kvBoltStore) update(key string, now int64, fn func([]byte, int64, bool) ([]byte, int64, bool, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		value, expireAt, write, err := fn(s.get(b, key, now))
+		if err != nil || !write {
+			return err
+		}
+		return b.Put([]byte(key), kvBoltEncode(value, expireAt))
+	})
+}
+
+func (s *kvBoltStore) put(entries []kvEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, e := range entries {
+			if err := b.Put([]byte(e.key), kvBoltEncode(e.value, e.expireAt)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *kvBoltStore) remove(key string, now int64) (found bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		_, _, found = s.get(b, key, now)
+		return b.Delete([]byte(key))
+	})
+	return
+}
+
+func (s *kvBoltStore) keys(prefix string, now int64) (keys []string, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(s.bucket).Cursor()
+		p := []byte(prefix)
+		for k, raw := cur.Seek(p); k != nil && bytes.HasPrefix(k, p); k, raw = cur.Next() {
+			if _, expireAt := kvBoltDecode(raw); kvAlive(expireAt, now) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (s *kvBoltStore) purge(now int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		var expired [][]byte
+		cur := b.Cursor()
+		for k, raw := cur.First(); k != nil; k, raw = cur.Next() {
+			if _, expireAt := kvBoltDecode(raw); !kvAlive(expireAt, now) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *kvBoltStore) close() error {
+	return s.db.Close()
+}
+
+func initKvBoltAdapter() {
+	kvAdapterSchemeMap["bolt"] = newKvStoreAdapterClass("BoltAdapter", func(c *Context, u *url.URL) kvStore {
+		path := u.Host + u.Path
+		if path == "" {
+			c.RaiseRuntimeError("BoltAdapter: path cannot be empty")
+		}
+		bucket := u.Query().Get("bucket")
+		if bucket == "" {
+			bucket = "kv"
+		}
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+		if err != nil {
+			c.RaiseRuntimeError("BoltAdapter: open %s error %s", path, err)
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			return err
+		})
+		if err != nil {
+			db.Close()
+			c.RaiseRuntimeError("BoltAdapter: create bucket %s error %s", bucket, err)
+		}
+		return &kvBoltStore{db: db, bucket: []byte(bucket)}
+	})
+}
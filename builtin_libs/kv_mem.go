@@ -0,0 +1,118 @@
+package builtin_libs
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type kvMemItem struct {
+	value    []byte
+	expireAt int64
+}
+
+type kvMemStore struct {
+	lock  sync.Mutex
+	items map[string]kvMemItem
+	// 有名字的store在进程内共享，close时不清空
+	shared bool
+}
+
+// mem://name 打开进程内共享的store，mem:// 打开私有store
+var kvMemStores sync.Map
+
+func newKvMemStore(shared bool) *kvMemStore {
+	return &kvMemStore{items: map[string]kvMemItem{}, shared: shared}
+}
+
+func (s *kvMemStore) get(key string, now int64) (kvMemItem, bool) {
+	item, found := s.items[key]
+	if found && !kvAlive(item.expireAt, now) {
+		delete(s.items, key)
+		return item, false
+	}
+	return item, found
+}
+
+func (s *kvMemStore) lookup(key string, now int64) ([]byte, int64, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item, found := s.get(key, now)
+	return item.value, item.expireAt, found, nil
+}
+
+func (s *kvMemStore) update(key string, now int64, fn func([]byte, int64, bool) ([]byte, int64, bool, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	item, found := s.get(key, now)
+	value, expireAt, write, err := fn(item.value, item.expireAt, found)
+	if err != nil || !write {
+		return err
+	}
+	s.items[key] = kvMemItem{value: value, expireAt: expireAt}
+	return nil
+}
+
+func (s *kvMemStore) put(entries []kvEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, e := range entries {
+		s.items[e.key] = kvMemItem{value: e.value, expireAt: e.expireAt}
+	}
+	return nil
+}
+
+func (s *kvMemStore) remove(key string, now int64) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, found := s.get(key, now)
+	delete(s.items, key)
+	return found, nil
+}
+
+func (s *kvMemStore) keys(prefix string, now int64) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var keys []string
+	for key, item := range s.items {
+		if strings.HasPrefix(key, prefix) && kvAlive(item.expireAt, now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *kvMemStore) purge(now int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, item := range s.items {
+		if !kvAlive(item.expireAt, now) {
+			delete(s.items, key)
+		}
+	}
+	return nil
+}
+
+func (s *kvMemStore) close() error {
+	if !s.shared {
+		s.lock.Lock()
+		s.items = map[string]kvMemItem{}
+		s.lock.Unlock()
+	}
+	return nil
+}
+
+func initKvMemAdapter() {
+	kvAdapterSchemeMap["mem"] = newKvStoreAdapterClass("MemAdapter", func(c *Context, u *url.URL) kvStore {
+		name := u.Host + strings.TrimPrefix(u.Path, "/")
+		if name == "" {
+			return newKvMemStore(false)
+		}
+		store, _ := kvMemStores.LoadOrStore(name, newKvMemStore(true))
+		return store.(*kvMemStore)
+	})
+}
@@ -0,0 +1,174 @@
+package builtin_libs
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type kvSqliteStore struct {
+	db    *sql.DB
+	table string
+}
+
+var kvSqliteTableRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *kvSqliteStore) lookup(key string, now int64) ([]byte, int64, bool, error) {
+	var (
+		value    []byte
+		expireAt int64
+	)
+	err := s.db.QueryRow(
+		"SELECT value, expire_at FROM "+s.table+" WHERE key = ? AND (expire_at = 0 OR expire_at > ?)",
+		key, now,
+	).Scan(&value, &expireAt)
+	if err == sql.ErrNoRows {
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return value, expireAt, true, nil
+}
+
+func (s *kvSqliteStore) update(key string, now int64, fn func([]byte, int64, bool) ([]byte, int64, bool, error)) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var (
+		value    []byte
+		expireAt int64
+		found    = true
+	)
+	err = tx.QueryRow(
+		"SELECT value, expire_at FROM "+s.table+" WHERE key = ? AND (expire_at = 0 OR expire_at > ?)",
+		key, now,
+	).Scan(&value, &expireAt)
+	if err == sql.ErrNoRows {
+		found = false
+	} else if err != nil {
+		return err
+	}
+	value, expireAt, write, err := fn(value, expireAt, found)
+	if err != nil || !write {
+		return err
+	}
+	if err := s.upsert(tx, kvEntry{key: key, value: value, expireAt: expireAt}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *kvSqliteStore) upsert(tx *sql.Tx, e kvEntry) error {
+	if e.value == nil {
+		e.value = []byte{}
+	}
+	_, err := tx.Exec(
+		"INSERT INTO "+s.table+" (key, value, expire_at) VALUES (?, ?, ?) "+
+			"ON CONFLICT(key) DO UPDATE SET value = excluded.value, expire_at = excluded.expire_at",
+		e.key, e.value, e.expireAt,
+	)
+	return err
+}
+
+func (s *kvSqliteStore) put(entries []kvEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		if err := s.upsert(tx, e); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *kvSqliteStore) remove(key string, now int64) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM "+s.table+" WHERE key = ? AND (expire_at = 0 OR expire_at > ?)",
+		key, now,
+	).Scan(&n); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM "+s.table+" WHERE key = ?", key); err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+func (s *kvSqliteStore) keys(prefix string, now int64) ([]string, error) {
+	// 用区间查询代替LIKE，避免转义通配符
+	query := "SELECT key FROM " + s.table + " WHERE key >= ? AND (expire_at = 0 OR expire_at > ?) ORDER BY key"
+	rows, err := s.db.Query(query, prefix, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *kvSqliteStore) purge(now int64) error {
+	_, err := s.db.Exec("DELETE FROM "+s.table+" WHERE expire_at > 0 AND expire_at <= ?", now)
+	return err
+}
+
+func (s *kvSqliteStore) close() error {
+	return s.db.Close()
+}
+
+func initKvSqliteAdapter() {
+	kvAdapterSchemeMap["sqlite"] = newKvStoreAdapterClass("SqliteAdapter", func(c *Context, u *url.URL) kvStore {
+		path := u.Host + u.Path
+		if path == "" {
+			path = ":memory:"
+		}
+		table := u.Query().Get("table")
+		if table == "" {
+			table = "kv"
+		}
+		if !kvSqliteTableRe.MatchString(table) {
+			c.RaiseRuntimeError("SqliteAdapter: invalid table name %s", table)
+		}
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			c.RaiseRuntimeError("SqliteAdapter: open %s error %s", path, err)
+		}
+		// sqlite只允许一个写者，共用一个连接也让:memory:库在各操作间保持一致
+		db.SetMaxOpenConns(1)
+		for _, stmt := range []string{
+			"PRAGMA busy_timeout = 5000",
+			fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value BLOB NOT NULL, expire_at INTEGER NOT NULL DEFAULT 0)", table),
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				db.Close()
+				c.RaiseRuntimeError("SqliteAdapter: init %s error %s", path, err)
+			}
+		}
+		return &kvSqliteStore{db: db, table: table}
+	})
+}
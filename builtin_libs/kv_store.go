@@ -0,0 +1,297 @@
+package builtin_libs
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// kvEntry 过期时间为unix毫秒，0表示不过期
+type kvEntry struct {
+	key      string
+	value    []byte
+	expireAt int64
+}
+
+// kvStore 是mem、sqlite、bolt适配器共用的底层存储，已过期的key视为不存在
+type kvStore interface {
+	lookup(key string, now int64) (value []byte, expireAt int64, found bool, err error)
+	// update 在同一事务中读出旧值并由fn决定是否写入新值
+	update(key string, now int64, fn func(value []byte, expireAt int64, found bool) ([]byte, int64, bool, error)) error
+	put(entries []kvEntry) error
+	remove(key string, now int64) (bool, error)
+	keys(prefix string, now int64) ([]string, error)
+	// purge 清理已过期的key
+	purge(now int64) error
+	close() error
+}
+
+type kvStoreInfo struct {
+	store  kvStore
+	ttl    time.Duration
+	writes int64
+}
+
+// 每写入这么多次清理一次过期key
+const kvStorePurgeInterval = 1000
+
+var errKvNotInteger = errors.New("value is not an integer")
+
+func kvNow() int64 {
+	return time.Now().UnixMilli()
+}
+
+func kvExpireAt(now int64, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now + ttl.Milliseconds()
+}
+
+func kvAlive(expireAt, now int64) bool {
+	return expireAt == 0 || expireAt > now
+}
+
+// newKvStoreAdapterClass 基于kvStore实现Manager需要的全部操作
+func newKvStoreAdapterClass(name string, open func(c *Context, u *url.URL) kvStore) ValueType {
+	getInfo := func(this ValueObject) *kvStoreInfo {
+		return this.Reserved.(*kvStoreInfo)
+	}
+	getTTL := func(c *Context, info *kvStoreInfo, funcName string, args []Value, i int) time.Duration {
+		if ttl := kvTTLArg(c, funcName, args, i); ttl > 0 {
+			return ttl
+		}
+		return info.ttl
+	}
+	wrote := func(c *Context, info *kvStoreInfo, funcName string, n int) {
+		before := atomic.AddInt64(&info.writes, int64(n)) - int64(n)
+		if before/kvStorePurgeInterval != (before+int64(n))/kvStorePurgeInterval {
+			if err := info.store.purge(kvNow()); err != nil {
+				c.RaiseRuntimeError("%s: purge expired keys error %s", funcName, err)
+			}
+		}
+	}
+	return NewClassBuilder(name).
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			u := args[0].ToGoValue(c).(*url.URL)
+			info := &kvStoreInfo{store: open(c, u)}
+			if ttl, err := strconv.Atoi(u.Query().Get("ttl")); err == nil && ttl > 0 {
+				info.ttl = time.Duration(ttl) * time.Second
+			}
+			this.Reserved = info
+		}).
+		Method("get", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, name+".get", args, ArgRuleRequired("key", TypeStr, &key))
+			bs, _, found, err := getInfo(this).store.lookup(key.Value(), kvNow())
+			if err != nil {
+				c.RaiseRuntimeError("%s.get: %s", name, err)
+			}
+			if !found {
+				return Nil()
+			}
+			return NewBytes(bs)
+		}).
+		Method("set", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info = getInfo(this)
+				key  ValueStr
+				data Value
+			)
+			EnsureFuncParams(c, name+".set", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("value", TypeAny, &data),
+			)
+			ttl := getTTL(c, info, name+".set", args, 2)
+			entry := kvEntry{key: key.Value(), value: kvValueBytes(data), expireAt: kvExpireAt(kvNow(), ttl)}
+			if err := info.store.put([]kvEntry{entry}); err != nil {
+				c.RaiseRuntimeError("%s.set: %s", name, err)
+			}
+			wrote(c, info, name+".set", 1)
+			return Undefined()
+		}).
+		Method("delete", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, name+".delete", args, ArgRuleRequired("key", TypeStr, &key))
+			deleted, err := getInfo(this).store.remove(key.Value(), kvNow())
+			if err != nil {
+				c.RaiseRuntimeError("%s.delete: %s", name, err)
+			}
+			return NewBool(deleted)
+		}).
+		Method("exists", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, name+".exists", args, ArgRuleRequired("key", TypeStr, &key))
+			_, _, found, err := getInfo(this).store.lookup(key.Value(), kvNow())
+			if err != nil {
+				c.RaiseRuntimeError("%s.exists: %s", name, err)
+			}
+			return NewBool(found)
+		}).
+		Method("incr", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info  = getInfo(this)
+				key   ValueStr
+				delta ValueInt
+				n     int64
+			)
+			EnsureFuncParams(c, name+".incr", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleOptional("delta", TypeInt, &delta, NewInt(1)),
+			)
+			now := kvNow()
+			err := info.store.update(key.Value(), now, func(value []byte, expireAt int64, found bool) ([]byte, int64, bool, error) {
+				n = delta.Value()
+				if !found {
+					return []byte(strconv.FormatInt(n, 10)), kvExpireAt(now, info.ttl), true, nil
+				}
+				old, err := strconv.ParseInt(string(value), 10, 64)
+				if err != nil {
+					return nil, 0, false, errKvNotInteger
+				}
+				n += old
+				return []byte(strconv.FormatInt(n, 10)), expireAt, true, nil
+			})
+			if err != nil {
+				c.RaiseRuntimeError("%s.incr: %s", name, err)
+			}
+			wrote(c, info, name+".incr", 1)
+			return NewInt(n)
+		}).
+		Method("compareAndSwap", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info     = getInfo(this)
+				key      ValueStr
+				expected Value
+				data     Value
+				swapped  bool
+			)
+			EnsureFuncParams(c, name+".compareAndSwap", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("expected", TypeAny, &expected),
+				ArgRuleRequired("value", TypeAny, &data),
+			)
+			var (
+				ttl           = getTTL(c, info, name+".compareAndSwap", args, 3)
+				now           = kvNow()
+				expectMissing = dbIsNil(expected)
+				expectedBytes []byte
+			)
+			if !expectMissing {
+				expectedBytes = kvValueBytes(expected)
+			}
+			err := info.store.update(key.Value(), now, func(value []byte, expireAt int64, found bool) ([]byte, int64, bool, error) {
+				if expectMissing == found || (found && string(value) != string(expectedBytes)) {
+					return nil, 0, false, nil
+				}
+				swapped = true
+				return kvValueBytes(data), kvExpireAt(now, ttl), true, nil
+			})
+			if err != nil {
+				c.RaiseRuntimeError("%s.compareAndSwap: %s", name, err)
+			}
+			if swapped {
+				wrote(c, info, name+".compareAndSwap", 1)
+			}
+			return NewBool(swapped)
+		}).
+		Method("expire", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info  = getInfo(this)
+				key   ValueStr
+				ttl   Value
+				found bool
+			)
+			EnsureFuncParams(c, name+".expire", args,
+				ArgRuleRequired("key", TypeStr, &key),
+				ArgRuleRequired("ttl", TypeAny, &ttl),
+			)
+			d := kvTTLArg(c, name+".expire", args, 1)
+			now := kvNow()
+			err := info.store.update(key.Value(), now, func(value []byte, expireAt int64, exists bool) ([]byte, int64, bool, error) {
+				found = exists
+				return value, kvExpireAt(now, d), exists, nil
+			})
+			if err != nil {
+				c.RaiseRuntimeError("%s.expire: %s", name, err)
+			}
+			return NewBool(found)
+		}).
+		Method("ttl", func(c *Context, this ValueObject, args []Value) Value {
+			var key ValueStr
+			EnsureFuncParams(c, name+".ttl", args, ArgRuleRequired("key", TypeStr, &key))
+			now := kvNow()
+			_, expireAt, found, err := getInfo(this).store.lookup(key.Value(), now)
+			if err != nil {
+				c.RaiseRuntimeError("%s.ttl: %s", name, err)
+			}
+			if expireAt == 0 {
+				return kvTTLResult(found, -1)
+			}
+			return kvTTLResult(found, time.Duration(expireAt-now)*time.Millisecond)
+		}).
+		Method("keys", func(c *Context, this ValueObject, args []Value) Value {
+			var prefix ValueStr
+			EnsureFuncParams(c, name+".keys", args,
+				ArgRuleOptional("prefix", TypeStr, &prefix, NewStr("")),
+			)
+			keys, err := getInfo(this).store.keys(prefix.Value(), kvNow())
+			if err != nil {
+				c.RaiseRuntimeError("%s.keys: %s", name, err)
+			}
+			rv := NewArray(len(keys))
+			for _, k := range keys {
+				rv.PushBack(NewStr(k))
+			}
+			return rv
+		}).
+		Method("getMany", func(c *Context, this ValueObject, args []Value) Value {
+			var keys ValueArray
+			EnsureFuncParams(c, name+".getMany", args, ArgRuleRequired("keys", TypeArray, &keys))
+			var (
+				store = getInfo(this).store
+				now   = kvNow()
+				rv    = NewArray(keys.Len())
+			)
+			for i := 0; i < keys.Len(); i++ {
+				bs, _, found, err := store.lookup(keys.GetIndex(i, c).ToString(c), now)
+				if err != nil {
+					c.RaiseRuntimeError("%s.getMany: %s", name, err)
+				}
+				if found {
+					rv.PushBack(NewBytes(bs))
+				} else {
+					rv.PushBack(Nil())
+				}
+			}
+			return rv
+		}).
+		Method("setMany", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info  = getInfo(this)
+				items ValueObject
+			)
+			EnsureFuncParams(c, name+".setMany", args, ArgRuleRequired("items", TypeObject, &items))
+			expireAt := kvExpireAt(kvNow(), getTTL(c, info, name+".setMany", args, 1))
+			entries := make([]kvEntry, 0, items.Len())
+			items.Iterate(func(key string, value Value) {
+				entries = append(entries, kvEntry{key: key, value: kvValueBytes(value), expireAt: expireAt})
+			})
+			if err := info.store.put(entries); err != nil {
+				c.RaiseRuntimeError("%s.setMany: %s", name, err)
+			}
+			wrote(c, info, name+".setMany", len(entries))
+			return Undefined()
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			if err := getInfo(this).store.close(); err != nil {
+				c.RaiseRuntimeError("%s.close: %s", name, err)
+			}
+			return Undefined()
+		}).
+		Build()
+}
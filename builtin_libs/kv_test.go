@@ -0,0 +1,150 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testKvSetup = `
+kv := import('kv')
+time := import('time')
+m := kv.Manager('{url}')
+`
+
+type testKvCase struct {
+	name     string
+	code     string
+	expected interface{}
+}
+
+// testKvStores 对每个存储运行所有用例，{url}替换为存储地址
+func testKvStores(t *testing.T, stores map[string]string, cases []testKvCase) {
+	for store, url := range stores {
+		t.Run(store, func(t *testing.T) {
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					code := strings.ReplaceAll(testKvSetup+tc.code+"\nm.close()\n", "{url}", url)
+					code = strings.ReplaceAll(code, "{dir}", t.TempDir())
+					exported, err := zgg.RunCode(code)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+						t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestKv(t *testing.T) {
+	testKvStores(t, map[string]string{
+		"mem":    "mem://",
+		"sqlite": "sqlite://{dir}/kv.db",
+		"bolt":   "bolt://{dir}/kv.bolt",
+	}, []testKvCase{
+		{"GetSet", `
+			m.set('a', 'x')
+			export result := [str(m.get('a')), m.exists('a'), m.exists('b')]
+		`, []interface{}{"x", true, false}},
+		{"Incr", `
+			export result := [m.incr('n'), m.incr('n', 5)]
+		`, []interface{}{int64(1), int64(6)}},
+		{"CompareAndSwap", `
+			export result := [m.compareAndSwap('c', nil, '1'), m.compareAndSwap('c', nil, '2'), m.compareAndSwap('c', '1', '3'), str(m.get('c'))]
+		`, []interface{}{true, false, true, "3"}},
+		{"ManyKeysDelete", `
+			m.setMany({'p/1': 1, 'p/2': 2, 'q/1': 3})
+			export result := [m.keys('p/'), m.getMany(['p/2', 'b']).map(v => v == nil ? nil : str(v)), m.delete('p/1'), m.delete('p/1')]
+		`, []interface{}{[]interface{}{"p/1", "p/2"}, []interface{}{"2", nil}, true, false}},
+		{"Ttl", `
+			m.set('a', 'x').set('t', 'v', 0.05)
+			export result := [m.ttl('t'), m.ttl('a'), m.ttl('b')]
+		`, []interface{}{int64(1), int64(-1), int64(-2)}},
+		{"Expire", `
+			m.set('a', 'x').set('t', 'v', 0.05)
+			time.sleep(0.1)
+			export result := [m.get('t'), m.expire('a', 10), m.ttl('a'), m.expire('b', 10)]
+		`, []interface{}{nil, true, int64(10), false}},
+	})
+}
+
+const testKvAdapterSetup = `
+kv := import('kv')
+class ArrayAdapter {
+	__init__(url) { this.url = url; this.data = {} }
+	get(k) { return this.data[k] }
+	set(k, v) { this.data[k] = v }
+}
+kv.register('arr', ArrayAdapter)
+`
+
+func TestKvAdapters(t *testing.T) {
+	cases := []testKvCase{
+		{"Custom", `
+			m := kv.Manager('arr://x')
+			m.set('a', 1)
+			export result := [m.exists('a'), m.getMany(['a', 'b']), m.capabilities(), m.supports('incr')]
+		`, []interface{}{true, []interface{}{int64(1), nil}, []interface{}{"get", "set", "exists", "getMany", "setMany"}, false}},
+		{"CustomUnsupported", `
+			msg := nil
+			try {
+				kv.Manager('arr://x').incr('a')
+			} catch (e) {
+				msg = e.message
+			}
+			export result := msg
+		`, "kv adapter ArrayAdapter does not support incr"},
+		{"Fs", `
+			fm := kv.Manager('fs://{dir}/fs')
+			fm.set('d/a', 1).set('d/b', 2)
+			export result := [fm.keys('d/'), fm.incr('n', 2), fm.supports('ttl')]
+		`, []interface{}{[]interface{}{"d/a", "d/b"}, int64(2), false}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(strings.ReplaceAll(testKvAdapterSetup+tc.code, "{dir}", t.TempDir()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestKvRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.Set("other", "x")
+	testKvStores(t, map[string]string{
+		"redis":     "redis://" + server.Addr() + "?prefix=k:",
+		"redishash": "redishash://" + server.Addr() + "/h?prefix=k:",
+	}, []testKvCase{
+		{"CompareAndSwap", `
+			export result := [m.compareAndSwap('c', nil, '1'), m.compareAndSwap('c', nil, '2'), m.compareAndSwap('c', '2', '3'), m.compareAndSwap('c', '1', '3'), str(m.get('c'))]
+			m.delete('c')
+		`, []interface{}{true, false, false, true, "3"}},
+		{"ScanKeys", `
+			for i in 0..<250 {
+				m.set('p/' + str(i), i)
+			}
+			m.set('q/1', 1)
+			n := 0
+			for k in m.keys('p/') {
+				assert k.startsWith('p/'), 'unexpected key ' + k
+				n += 1
+			}
+			seen := {}
+			for k in m.keys() {
+				seen[k] = true
+			}
+			export result := [n, seen['q/1'], seen['other']]
+		`, []interface{}{int64(250), true, nil}},
+	})
+}
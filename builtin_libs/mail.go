@@ -0,0 +1,874 @@
+package builtin_libs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	mailAttachment struct {
+		filename    string
+		contentType string
+		data        []byte
+		cid         string
+		inline      bool
+	}
+	mailMessage struct {
+		from        *mail.Address
+		to          []*mail.Address
+		cc          []*mail.Address
+		bcc         []*mail.Address
+		replyTo     []*mail.Address
+		subject     string
+		text        string
+		html        string
+		headers     [][2]string
+		messageId   string
+		date        time.Time
+		attachments []*mailAttachment
+	}
+	mailClient struct {
+		host      string
+		port      int
+		username  string
+		password  string
+		auth      string
+		tlsMode   string
+		tlsConfig *tls.Config
+		timeout   time.Duration
+		localName string
+	}
+	// mailParsed 解析后的邮件，测试服务器收到的邮件也以这种形式保存
+	mailParsed struct {
+		envelopeFrom string
+		envelopeTo   []string
+		header       mail.Header
+		text         string
+		html         string
+		attachments  []*mailAttachment
+		raw          []byte
+	}
+)
+
+var (
+	mailMessageClass    ValueType
+	mailClientClass     ValueType
+	mailTestServerClass ValueType
+)
+
+func libMail(c *Context) ValueObject {
+	lib := NewObject()
+	lib.SetMember("Message", mailMessageClass, c)
+	lib.SetMember("Client", mailClientClass, c)
+	lib.SetMember("TestServer", mailTestServerClass, c)
+	lib.SetMember("send", NewNativeFunction("send", func(c *Context, this Value, args []Value) Value {
+		var (
+			message Value
+			options ValueObject
+		)
+		EnsureFuncParams(c, "mail.send", args,
+			ArgRuleRequired("message", TypeAny, &message),
+			ArgRuleRequired("options", TypeObject, &options),
+		)
+		msg := mailMessageArg(c, "mail.send", message)
+		return NewStr(newMailClient(c, "mail.send", options).send(c, msg))
+	}, "message", "options"), c)
+	lib.SetMember("parse", NewNativeFunction("parse", func(c *Context, this Value, args []Value) Value {
+		var raw Value
+		EnsureFuncParams(c, "mail.parse", args, ArgRuleRequired("raw", TypeAny, &raw))
+		var data []byte
+		if bs, ok := raw.(ValueBytes); ok {
+			data = bs.Value()
+		} else {
+			data = []byte(raw.ToString(c))
+		}
+		parsed, err := mailParse(data)
+		if err != nil {
+			c.RaiseRuntimeError("mail.parse: %s", err)
+		}
+		return parsed.toValue(c)
+	}, "raw"), c)
+	lib.SetMember("testServer", NewNativeFunction("testServer", func(c *Context, this Value, args []Value) Value {
+		var options ValueObject
+		EnsureFuncParams(c, "mail.testServer", args,
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+		)
+		return NewObjectAndInit(mailTestServerClass, c, options)
+	}, "options"), c)
+	return lib
+}
+
+func mailRandomId() string {
+	var buf [12]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// mailAddresses 解析地址，支持字符串(逗号分隔)或字符串数组
+func mailAddresses(c *Context, funcName, field string, v Value) []*mail.Address {
+	var items []string
+	switch val := v.(type) {
+	case ValueArray:
+		for i := 0; i < val.Len(); i++ {
+			items = append(items, val.GetIndex(i, c).ToString(c))
+		}
+	case ValueStr:
+		items = []string{val.Value()}
+	default:
+		if !IsUndefined(v) {
+			c.RaiseRuntimeError("%s: %s must be a str or an array", funcName, field)
+		}
+	}
+	var rv []*mail.Address
+	for _, item := range items {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		list, err := mail.ParseAddressList(item)
+		if err != nil {
+			c.RaiseRuntimeError("%s: invalid %s address %s: %s", funcName, field, item, err)
+		}
+		rv = append(rv, list...)
+	}
+	return rv
+}
+
+func mailAttachmentContent(c *Context, v Value) []byte {
+	if bs, ok := v.(ValueBytes); ok {
+		return bs.Value()
+	}
+	return []byte(v.ToString(c))
+}
+
+func newMailAttachment(c *Context, filename string, content Value, contentType string) *mailAttachment {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &mailAttachment{filename: filename, contentType: contentType, data: mailAttachmentContent(c, content)}
+}
+
+func newMailMessage(c *Context, funcName string, options ValueObject) *mailMessage {
+	m := &mailMessage{date: time.Now()}
+	if from := mailAddresses(c, funcName, "from", options.GetMember("from", c)); len(from) > 0 {
+		m.from = from[0]
+	}
+	m.to = mailAddresses(c, funcName, "to", options.GetMember("to", c))
+	m.cc = mailAddresses(c, funcName, "cc", options.GetMember("cc", c))
+	m.bcc = mailAddresses(c, funcName, "bcc", options.GetMember("bcc", c))
+	m.replyTo = mailAddresses(c, funcName, "replyTo", options.GetMember("replyTo", c))
+	if v := options.GetMember("subject", c); !IsUndefined(v) {
+		m.subject = v.ToString(c)
+	}
+	if v := options.GetMember("text", c); !IsUndefined(v) {
+		m.text = v.ToString(c)
+	}
+	if v := options.GetMember("html", c); !IsUndefined(v) {
+		m.html = v.ToString(c)
+	}
+	if headers, ok := options.GetMember("headers", c).(ValueObject); ok {
+		headers.Iterate(func(key string, val Value) {
+			m.headers = append(m.headers, [2]string{textproto.CanonicalMIMEHeaderKey(key), val.ToString(c)})
+		})
+	}
+	domain := "localhost"
+	if m.from != nil {
+		if at := strings.LastIndexByte(m.from.Address, '@'); at >= 0 {
+			domain = m.from.Address[at+1:]
+		}
+	}
+	m.messageId = "<" + mailRandomId() + "@" + domain + ">"
+	addFiles := func(field string, inline bool) {
+		arr, ok := options.GetMember(field, c).(ValueArray)
+		if !ok {
+			return
+		}
+		for i := 0; i < arr.Len(); i++ {
+			item, ok := arr.GetIndex(i, c).(ValueObject)
+			if !ok {
+				c.RaiseRuntimeError("%s: %s item must be an object", funcName, field)
+			}
+			var filename, contentType string
+			if v, ok := item.GetMember("filename", c).(ValueStr); ok {
+				filename = v.Value()
+			}
+			if v, ok := item.GetMember("contentType", c).(ValueStr); ok {
+				contentType = v.Value()
+			}
+			att := newMailAttachment(c, filename, item.GetMember("content", c), contentType)
+			if inline {
+				cid, ok := item.GetMember("cid", c).(ValueStr)
+				if !ok {
+					c.RaiseRuntimeError("%s: inline item requires cid", funcName)
+				}
+				att.cid, att.inline = cid.Value(), true
+			}
+			m.attachments = append(m.attachments, att)
+		}
+	}
+	addFiles("attachments", false)
+	addFiles("inlines", true)
+	return m
+}
+
+// mailMessageArg 发送时既可以传Message对象，也可以直接传构造Message用的对象
+func mailMessageArg(c *Context, funcName string, v Value) *mailMessage {
+	obj, ok := v.(ValueObject)
+	if !ok {
+		c.RaiseRuntimeError("%s: message must be a mail.Message or an object", funcName)
+	}
+	if m, ok := obj.Reserved.(*mailMessage); ok {
+		return m
+	}
+	return newMailMessage(c, funcName, obj)
+}
+
+func (m *mailMessage) recipients() []string {
+	var rv []string
+	for _, list := range [][]*mail.Address{m.to, m.cc, m.bcc} {
+		for _, addr := range list {
+			rv = append(rv, addr.Address)
+		}
+	}
+	return rv
+}
+
+func mailFormatAddresses(list []*mail.Address) string {
+	items := make([]string, len(list))
+	for i, addr := range list {
+		items[i] = addr.String()
+	}
+	return strings.Join(items, ", ")
+}
+
+func mailWriteHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
+
+func mailPartHeader(contentType string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+	return h
+}
+
+func mailWriteText(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// mailWriteBase64 按每行76个字符输出base64内容
+func mailWriteBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+type mailPartWriter func(h textproto.MIMEHeader) (io.Writer, error)
+
+func mailWriteTextPart(create mailPartWriter, subtype, text string) error {
+	h := mailPartHeader("text/" + subtype + "; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	w, err := create(h)
+	if err != nil {
+		return err
+	}
+	return mailWriteText(w, text)
+}
+
+func mailWriteAttachment(create mailPartWriter, att *mailAttachment) error {
+	h := mailPartHeader(att.contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	disposition := "attachment"
+	if att.inline {
+		disposition = "inline"
+		h.Set("Content-Id", "<"+att.cid+">")
+	}
+	if att.filename != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": att.filename})
+	}
+	h.Set("Content-Disposition", disposition)
+	w, err := create(h)
+	if err != nil {
+		return err
+	}
+	return mailWriteBase64(w, att.data)
+}
+
+// mailWriteMultipart 先在缓冲区中写好各子节点，再以multipart节点写入上层
+func mailWriteMultipart(create mailPartWriter, subtype string, body func(mailPartWriter) error) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := body(mw.CreatePart); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	w, err := create(mailPartHeader("multipart/" + subtype + "; boundary=" + mw.Boundary()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// bytes 生成完整邮件。结构为 mixed(附件) > related(内嵌图片) > alternative(文本与html)
+func (m *mailMessage) bytes() ([]byte, error) {
+	var attachments, inlines []*mailAttachment
+	for _, att := range m.attachments {
+		if att.inline {
+			inlines = append(inlines, att)
+		} else {
+			attachments = append(attachments, att)
+		}
+	}
+	writeBody := func(create mailPartWriter) error {
+		switch {
+		case m.html != "" && m.text != "":
+			return mailWriteMultipart(create, "alternative", func(create mailPartWriter) error {
+				if err := mailWriteTextPart(create, "plain", m.text); err != nil {
+					return err
+				}
+				return mailWriteTextPart(create, "html", m.html)
+			})
+		case m.html != "":
+			return mailWriteTextPart(create, "html", m.html)
+		default:
+			return mailWriteTextPart(create, "plain", m.text)
+		}
+	}
+	writeRelated := writeBody
+	if len(inlines) > 0 {
+		writeRelated = func(create mailPartWriter) error {
+			return mailWriteMultipart(create, "related", func(create mailPartWriter) error {
+				if err := writeBody(create); err != nil {
+					return err
+				}
+				for _, att := range inlines {
+					if err := mailWriteAttachment(create, att); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	}
+	writeMixed := writeRelated
+	if len(attachments) > 0 {
+		writeMixed = func(create mailPartWriter) error {
+			return mailWriteMultipart(create, "mixed", func(create mailPartWriter) error {
+				if err := writeRelated(create); err != nil {
+					return err
+				}
+				for _, att := range attachments {
+					if err := mailWriteAttachment(create, att); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if m.from != nil {
+		mailWriteHeader(&buf, "From", m.from.String())
+	}
+	if len(m.to) > 0 {
+		mailWriteHeader(&buf, "To", mailFormatAddresses(m.to))
+	}
+	if len(m.cc) > 0 {
+		mailWriteHeader(&buf, "Cc", mailFormatAddresses(m.cc))
+	}
+	if len(m.replyTo) > 0 {
+		mailWriteHeader(&buf, "Reply-To", mailFormatAddresses(m.replyTo))
+	}
+	mailWriteHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.subject))
+	mailWriteHeader(&buf, "Date", m.date.Format(time.RFC1123Z))
+	mailWriteHeader(&buf, "Message-Id", m.messageId)
+	mailWriteHeader(&buf, "Mime-Version", "1.0")
+	for _, kv := range m.headers {
+		mailWriteHeader(&buf, kv[0], mime.QEncoding.Encode("utf-8", kv[1]))
+	}
+	// 顶层节点的头直接写进邮件头中
+	err := writeMixed(func(h textproto.MIMEHeader) (io.Writer, error) {
+		for _, key := range []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id"} {
+			if v := h.Get(key); v != "" {
+				mailWriteHeader(&buf, key, v)
+			}
+		}
+		buf.WriteString("\r\n")
+		return &buf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 单个文本节点时以软换行结尾，避免传输时补上的换行被当作正文
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\r\n")) {
+		buf.WriteString("=\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func newMailClient(c *Context, funcName string, options ValueObject) *mailClient {
+	cl := &mailClient{
+		port:    25,
+		auth:    "plain",
+		tlsMode: "auto",
+		timeout: 30 * time.Second,
+	}
+	host, ok := options.GetMember("host", c).(ValueStr)
+	if !ok {
+		c.RaiseRuntimeError("%s: host is required", funcName)
+	}
+	cl.host = host.Value()
+	if v, ok := options.GetMember("tls", c).(ValueStr); ok {
+		cl.tlsMode = v.Value()
+	} else if v, ok := options.GetMember("tls", c).(ValueBool); ok && !v.Value() {
+		cl.tlsMode = "none"
+	}
+	switch cl.tlsMode {
+	case "auto", "starttls", "none":
+	case "tls":
+		cl.port = 465
+	default:
+		c.RaiseRuntimeError("%s: tls must be one of auto, starttls, tls, none", funcName)
+	}
+	if v, ok := options.GetMember("port", c).(ValueInt); ok {
+		cl.port = v.AsInt()
+	}
+	if v, ok := options.GetMember("username", c).(ValueStr); ok {
+		cl.username = v.Value()
+	}
+	if v, ok := options.GetMember("password", c).(ValueStr); ok {
+		cl.password = v.Value()
+	}
+	if v, ok := options.GetMember("auth", c).(ValueStr); ok {
+		cl.auth = strings.ToLower(v.Value())
+	}
+	switch cl.auth {
+	case "plain", "login", "cram-md5":
+	default:
+		c.RaiseRuntimeError("%s: auth must be one of plain, login, cram-md5", funcName)
+	}
+	if d := httpOptionDuration(c, options, "timeout"); d > 0 {
+		cl.timeout = d
+	}
+	if v, ok := options.GetMember("localName", c).(ValueStr); ok {
+		cl.localName = v.Value()
+	}
+	cl.tlsConfig = tlsConfigFromOptions(c, funcName, options, false)
+	if cl.tlsConfig.ServerName == "" {
+		cl.tlsConfig.ServerName = cl.host
+	}
+	return cl
+}
+
+// mailLoginAuth 实现net/smtp未提供的AUTH LOGIN
+type mailLoginAuth struct {
+	username, password string
+}
+
+func (a *mailLoginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !mailIsLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *mailLoginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func mailIsLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+func (cl *mailClient) send(c *Context, m *mailMessage) string {
+	if m.from == nil {
+		c.RaiseRuntimeError("mail.send: from is required")
+	}
+	recipients := m.recipients()
+	if len(recipients) == 0 {
+		c.RaiseRuntimeError("mail.send: no recipients")
+	}
+	data, err := m.bytes()
+	if err != nil {
+		c.RaiseRuntimeError("mail.send: compose message error %s", err)
+	}
+	if err := cl.deliver(c, m.from.Address, recipients, data); err != nil {
+		c.RaiseRuntimeError("mail.send: %s", err)
+	}
+	return m.messageId
+}
+
+func (cl *mailClient) deliver(c *Context, from string, recipients []string, data []byte) error {
+	addr := net.JoinHostPort(cl.host, strconv.Itoa(cl.port))
+	dialer := &net.Dialer{Timeout: cl.timeout}
+	conn, err := dialer.DialContext(c.Ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("dial %s error %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(cl.timeout))
+	if cl.tlsMode == "tls" {
+		tlsConn := tls.Client(conn, cl.tlsConfig)
+		if err := tlsConn.HandshakeContext(c.Ctx); err != nil {
+			return fmt.Errorf("tls handshake error %w", err)
+		}
+		conn = tlsConn
+	}
+	client, err := smtp.NewClient(conn, cl.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if cl.localName != "" {
+		if err := client.Hello(cl.localName); err != nil {
+			return err
+		}
+	}
+	if cl.tlsMode == "starttls" || cl.tlsMode == "auto" {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(cl.tlsConfig); err != nil {
+				return fmt.Errorf("starttls error %w", err)
+			}
+		} else if cl.tlsMode == "starttls" {
+			return errors.New("server does not support STARTTLS")
+		}
+	}
+	if cl.username != "" {
+		var auth smtp.Auth
+		switch cl.auth {
+		case "login":
+			auth = &mailLoginAuth{username: cl.username, password: cl.password}
+		case "cram-md5":
+			auth = smtp.CRAMMD5Auth(cl.username, cl.password)
+		default:
+			auth = smtp.PlainAuth("", cl.username, cl.password, cl.host)
+		}
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("auth error %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("rcpt %s error %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func mailDecodeHeader(s string) string {
+	dec := &mime.WordDecoder{}
+	if decoded, err := dec.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+func mailDecodeBody(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, mailStripNewlines{body}))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	}
+	return io.ReadAll(body)
+}
+
+// mailStripNewlines 去掉base64内容中的换行
+type mailStripNewlines struct {
+	r io.Reader
+}
+
+func (s mailStripNewlines) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[j] = b
+			j++
+		}
+	}
+	return j, err
+}
+
+func mailParse(raw []byte) (*mailParsed, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	p := &mailParsed{header: msg.Header, raw: raw}
+	if err := p.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *mailParsed) walk(h textproto.MIMEHeader, body io.Reader) error {
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", nil
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := p.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+	data, err := mailDecodeBody(h.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return err
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	cid := strings.Trim(h.Get("Content-Id"), "<>")
+	if disposition == "" && filename == "" && cid == "" {
+		switch {
+		case mediaType == "text/plain" && p.text == "":
+			p.text = strings.ReplaceAll(string(data), "\r\n", "\n")
+			return nil
+		case mediaType == "text/html" && p.html == "":
+			p.html = strings.ReplaceAll(string(data), "\r\n", "\n")
+			return nil
+		}
+	}
+	p.attachments = append(p.attachments, &mailAttachment{
+		filename:    mailDecodeHeader(filename),
+		contentType: mediaType,
+		data:        data,
+		cid:         cid,
+		inline:      disposition == "inline" || (disposition == "" && cid != ""),
+	})
+	return nil
+}
+
+func mailAddressesToValue(h mail.Header, key string) Value {
+	rv := NewArray()
+	if list, err := h.AddressList(key); err == nil {
+		for _, addr := range list {
+			rv.PushBack(NewStr(addr.Address))
+		}
+	}
+	return rv
+}
+
+func (p *mailParsed) toValue(c *Context) Value {
+	rv := NewObject()
+	from := Value(Nil())
+	if list, err := p.header.AddressList("From"); err == nil && len(list) > 0 {
+		from = NewStr(list[0].Address)
+	}
+	rv.SetMember("from", from, c)
+	rv.SetMember("to", mailAddressesToValue(p.header, "To"), c)
+	rv.SetMember("cc", mailAddressesToValue(p.header, "Cc"), c)
+	rv.SetMember("replyTo", mailAddressesToValue(p.header, "Reply-To"), c)
+	rv.SetMember("subject", NewStr(mailDecodeHeader(p.header.Get("Subject"))), c)
+	rv.SetMember("messageId", NewStr(p.header.Get("Message-Id")), c)
+	if date, err := p.header.Date(); err == nil {
+		rv.SetMember("date", NewInt(date.Unix()), c)
+	}
+	headers := NewObject()
+	for key, values := range p.header {
+		if len(values) > 0 {
+			headers.SetMember(key, NewStr(mailDecodeHeader(values[0])), c)
+		}
+	}
+	rv.SetMember("headers", headers, c)
+	rv.SetMember("text", NewStr(p.text), c)
+	rv.SetMember("html", NewStr(p.html), c)
+	attachments := NewArray(len(p.attachments))
+	for _, att := range p.attachments {
+		item := NewObject()
+		item.SetMember("filename", NewStr(att.filename), c)
+		item.SetMember("contentType", NewStr(att.contentType), c)
+		item.SetMember("content", NewBytes(att.data), c)
+		item.SetMember("size", NewInt(int64(len(att.data))), c)
+		item.SetMember("cid", NewStr(att.cid), c)
+		item.SetMember("inline", NewBool(att.inline), c)
+		attachments.PushBack(item)
+	}
+	rv.SetMember("attachments", attachments, c)
+	if p.envelopeTo != nil {
+		envelope := NewObject()
+		envelope.SetMember("from", NewStr(p.envelopeFrom), c)
+		to := NewArray(len(p.envelopeTo))
+		for _, rcpt := range p.envelopeTo {
+			to.PushBack(NewStr(rcpt))
+		}
+		envelope.SetMember("to", to, c)
+		rv.SetMember("envelope", envelope, c)
+	}
+	rv.SetMember("raw", NewBytes(p.raw), c)
+	return rv
+}
+
+func initMailMessageClass() ValueType {
+	addFile := func(funcName string, inline bool) func(*Context, ValueObject, []Value) Value {
+		return func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				name        ValueStr
+				content     Value
+				contentType ValueStr
+			)
+			EnsureFuncParams(c, funcName, args,
+				ArgRuleRequired("name", TypeStr, &name),
+				ArgRuleRequired("content", TypeAny, &content),
+				ArgRuleOptional("contentType", TypeStr, &contentType, NewStr("")),
+			)
+			m := this.Reserved.(*mailMessage)
+			if inline {
+				att := newMailAttachment(c, "", content, contentType.Value())
+				att.cid, att.inline = name.Value(), true
+				m.attachments = append(m.attachments, att)
+			} else {
+				m.attachments = append(m.attachments, newMailAttachment(c, name.Value(), content, contentType.Value()))
+			}
+			return this
+		}
+	}
+	return NewClassBuilder("Message").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var options ValueObject
+			EnsureFuncParams(c, "mail.Message.__init__", args,
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			)
+			m := newMailMessage(c, "mail.Message", options)
+			this.Reserved = m
+			this.SetMember("messageId", NewStr(m.messageId), c)
+		}).
+		Method("attach", addFile("mail.Message.attach", false), "filename", "content", "contentType").
+		Method("attachFile", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				path        ValueStr
+				contentType ValueStr
+			)
+			EnsureFuncParams(c, "mail.Message.attachFile", args,
+				ArgRuleRequired("path", TypeStr, &path),
+				ArgRuleOptional("contentType", TypeStr, &contentType, NewStr("")),
+			)
+			data, err := os.ReadFile(path.Value())
+			if err != nil {
+				c.RaiseRuntimeError("mail.Message.attachFile: read file error %s", err)
+			}
+			m := this.Reserved.(*mailMessage)
+			m.attachments = append(m.attachments,
+				newMailAttachment(c, filepath.Base(path.Value()), NewBytes(data), contentType.Value()))
+			return this
+		}, "path", "contentType").
+		Method("inline", addFile("mail.Message.inline", true), "cid", "content", "contentType").
+		Method("recipients", func(c *Context, this ValueObject, args []Value) Value {
+			recipients := this.Reserved.(*mailMessage).recipients()
+			rv := NewArray(len(recipients))
+			for _, r := range recipients {
+				rv.PushBack(NewStr(r))
+			}
+			return rv
+		}).
+		Method("bytes", func(c *Context, this ValueObject, args []Value) Value {
+			data, err := this.Reserved.(*mailMessage).bytes()
+			if err != nil {
+				c.RaiseRuntimeError("mail.Message.bytes: %s", err)
+			}
+			return NewBytes(data)
+		}).
+		Build()
+}
+
+func initMailClientClass() ValueType {
+	return NewClassBuilder("Client").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var options ValueObject
+			EnsureFuncParams(c, "mail.Client.__init__", args,
+				ArgRuleRequired("options", TypeObject, &options),
+			)
+			this.Reserved = newMailClient(c, "mail.Client", options)
+		}).
+		Method("send", func(c *Context, this ValueObject, args []Value) Value {
+			var message Value
+			EnsureFuncParams(c, "mail.Client.send", args,
+				ArgRuleRequired("message", TypeAny, &message),
+			)
+			msg := mailMessageArg(c, "mail.Client.send", message)
+			return NewStr(this.Reserved.(*mailClient).send(c, msg))
+		}, "message").
+		Build()
+}
+
+func init() {
+	mailMessageClass = initMailMessageClass()
+	mailClientClass = initMailClientClass()
+	mailTestServerClass = initMailTestServerClass()
+}
@@ -0,0 +1,397 @@
+package builtin_libs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// mailTestServer 本地SMTP收件服务，把收到的邮件保存在内存中供脚本检查
+type mailTestServer struct {
+	listener  net.Listener
+	hostname  string
+	username  string
+	password  string
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	messages  []*mailParsed
+	// notify 每收到一封邮件就关闭并替换，用于唤醒waitFor
+	notify chan struct{}
+	closed bool
+}
+
+type mailSession struct {
+	s      *mailTestServer
+	conn   net.Conn
+	text   *textproto.Conn
+	isTLS  bool
+	authed bool
+	from   string
+	to     []string
+	inMail bool
+}
+
+func (s *mailTestServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, isTLS := conn.(*tls.Conn)
+			sess := &mailSession{s: s, conn: conn, text: textproto.NewConn(conn), isTLS: isTLS}
+			sess.run()
+		}()
+	}
+}
+
+func (s *mailTestServer) add(p *mailParsed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, p)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+func (s *mailTestServer) waitFor(c *Context, n int, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		count, notify := len(s.messages), s.notify
+		s.mu.Unlock()
+		if count >= n {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-timer.C:
+			return false
+		case <-c.Ctx.Done():
+			return false
+		}
+	}
+}
+
+func (s *mailTestServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.listener.Close()
+	}
+}
+
+func (sess *mailSession) reply(code int, lines ...string) {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		sess.text.PrintfLine("%d%s%s", code, sep, line)
+	}
+}
+
+func (sess *mailSession) reset() {
+	sess.from, sess.to, sess.inMail = "", nil, false
+}
+
+func (sess *mailSession) run() {
+	s := sess.s
+	sess.reply(220, s.hostname+" ESMTP zgg test server")
+	for {
+		sess.conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			sess.reset()
+			sess.reply(250, s.hostname)
+		case "EHLO":
+			sess.reset()
+			lines := []string{s.hostname, "8BITMIME"}
+			if s.tlsConfig != nil && !sess.isTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if s.username != "" {
+				lines = append(lines, "AUTH PLAIN LOGIN")
+			}
+			sess.reply(250, append(lines, "HELP")...)
+		case "STARTTLS":
+			if s.tlsConfig == nil || sess.isTLS {
+				sess.reply(502, "5.5.1 STARTTLS not available")
+				continue
+			}
+			sess.reply(220, "2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(sess.conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			sess.conn, sess.text, sess.isTLS = tlsConn, textproto.NewConn(tlsConn), true
+			sess.reset()
+		case "AUTH":
+			sess.auth(arg)
+		case "MAIL":
+			if s.username != "" && !sess.authed {
+				sess.reply(530, "5.7.0 Authentication required")
+				continue
+			}
+			addr, ok := mailPathArg(arg, "FROM:")
+			if !ok {
+				sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+				continue
+			}
+			sess.reset()
+			sess.from, sess.inMail = addr, true
+			sess.reply(250, "2.1.0 OK")
+		case "RCPT":
+			if !sess.inMail {
+				sess.reply(503, "5.5.1 Error: need MAIL command")
+				continue
+			}
+			addr, ok := mailPathArg(arg, "TO:")
+			if !ok || addr == "" {
+				sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+				continue
+			}
+			sess.to = append(sess.to, addr)
+			sess.reply(250, "2.1.5 OK")
+		case "DATA":
+			if len(sess.to) == 0 {
+				sess.reply(503, "5.5.1 Error: need RCPT command")
+				continue
+			}
+			sess.reply(354, "End data with <CR><LF>.<CR><LF>")
+			data, err := sess.text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			parsed, err := mailParse(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")))
+			if err != nil {
+				sess.reply(554, "5.6.0 Malformed message: "+err.Error())
+			} else {
+				parsed.envelopeFrom, parsed.envelopeTo = sess.from, sess.to
+				s.add(parsed)
+				sess.reply(250, "2.0.0 OK queued")
+			}
+			sess.reset()
+		case "RSET":
+			sess.reset()
+			sess.reply(250, "2.0.0 OK")
+		case "NOOP":
+			sess.reply(250, "2.0.0 OK")
+		case "VRFY":
+			sess.reply(252, "2.5.0 Cannot VRFY user")
+		case "QUIT":
+			sess.reply(221, "2.0.0 Bye")
+			return
+		default:
+			sess.reply(502, "5.5.2 Error: command not recognized")
+		}
+	}
+}
+
+func (sess *mailSession) auth(arg string) {
+	s := sess.s
+	if s.username == "" {
+		sess.reply(502, "5.5.1 AUTH not supported")
+		return
+	}
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	// readResponse 发出质询并读取客户端的base64回复
+	readResponse := func(challenge string) (string, bool) {
+		sess.reply(334, base64.StdEncoding.EncodeToString([]byte(challenge)))
+		line, err := sess.text.ReadLine()
+		if err != nil || line == "*" {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return string(decoded), err == nil
+	}
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		var resp string
+		if initial != "" {
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if err != nil {
+				sess.reply(501, "5.5.2 Cannot decode response")
+				return
+			}
+			resp = string(decoded)
+		} else {
+			var ok bool
+			if resp, ok = readResponse(""); !ok {
+				sess.reply(501, "5.5.2 Cannot decode response")
+				return
+			}
+		}
+		parts := strings.Split(resp, "\x00")
+		if len(parts) != 3 {
+			sess.reply(501, "5.5.2 Invalid PLAIN response")
+			return
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		var ok bool
+		if username, ok = readResponse("Username:"); !ok {
+			sess.reply(501, "5.5.2 Cannot decode response")
+			return
+		}
+		if password, ok = readResponse("Password:"); !ok {
+			sess.reply(501, "5.5.2 Cannot decode response")
+			return
+		}
+	default:
+		sess.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+	if username != s.username || password != s.password {
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+	sess.authed = true
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+// mailPathArg 解析 FROM:<addr> 形式的参数，忽略其后的SIZE等扩展参数
+func mailPathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
+
+func initMailTestServerClass() ValueType {
+	return NewClassBuilder("TestServer").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var options ValueObject
+			EnsureFuncParams(c, "mail.TestServer.__init__", args,
+				ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			)
+			s := &mailTestServer{hostname: "localhost", notify: make(chan struct{})}
+			addr := "127.0.0.1:0"
+			if v, ok := options.GetMember("addr", c).(ValueStr); ok {
+				addr = v.Value()
+			}
+			if v, ok := options.GetMember("username", c).(ValueStr); ok {
+				s.username = v.Value()
+			}
+			if v, ok := options.GetMember("password", c).(ValueStr); ok {
+				s.password = v.Value()
+			}
+			// tls: {certFile, keyFile, implicit}，implicit为true时直接使用TLS连接，否则通过STARTTLS升级
+			implicit := false
+			if tlsOpts, ok := options.GetMember("tls", c).(ValueObject); ok {
+				s.tlsConfig = tlsConfigFromOptions(c, "mail.TestServer", tlsOpts, true)
+				if len(s.tlsConfig.Certificates) == 0 {
+					c.RaiseRuntimeError("mail.TestServer: tls requires certFile and keyFile")
+				}
+				implicit = tlsOpts.GetMember("implicit", c).IsTrue()
+			}
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				c.RaiseRuntimeError("mail.TestServer: listen %s error %s", addr, err)
+			}
+			if implicit {
+				listener = tls.NewListener(listener, s.tlsConfig)
+			}
+			s.listener = listener
+			this.Reserved = s
+			host, port, _ := net.SplitHostPort(listener.Addr().String())
+			portNum, _ := strconv.Atoi(port)
+			this.SetMember("addr", NewStr(listener.Addr().String()), c)
+			this.SetMember("host", NewStr(host), c)
+			this.SetMember("port", NewInt(int64(portNum)), c)
+			// options 可直接传给mail.send或mail.Client
+			clientOpts := NewObject()
+			clientOpts.SetMember("host", NewStr(host), c)
+			clientOpts.SetMember("port", NewInt(int64(portNum)), c)
+			switch {
+			case implicit:
+				clientOpts.SetMember("tls", NewStr("tls"), c)
+			case s.tlsConfig != nil:
+				clientOpts.SetMember("tls", NewStr("starttls"), c)
+			default:
+				clientOpts.SetMember("tls", NewStr("none"), c)
+			}
+			if s.tlsConfig != nil {
+				clientOpts.SetMember("insecureSkipVerify", NewBool(true), c)
+			}
+			if s.username != "" {
+				clientOpts.SetMember("username", NewStr(s.username), c)
+				clientOpts.SetMember("password", NewStr(s.password), c)
+			}
+			this.SetMember("options", clientOpts, c)
+			go s.serve()
+		}).
+		Method("messages", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*mailTestServer)
+			s.mu.Lock()
+			messages := append([]*mailParsed(nil), s.messages...)
+			s.mu.Unlock()
+			rv := NewArray(len(messages))
+			for _, m := range messages {
+				rv.PushBack(m.toValue(c))
+			}
+			return rv
+		}).
+		Method("last", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*mailTestServer)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if len(s.messages) == 0 {
+				return Nil()
+			}
+			return s.messages[len(s.messages)-1].toValue(c)
+		}).
+		Method("count", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*mailTestServer)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return NewInt(int64(len(s.messages)))
+		}).
+		Method("waitFor", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				n       ValueInt
+				timeout timeDurationArg
+			)
+			EnsureFuncParams(c, "mail.TestServer.waitFor", args,
+				ArgRuleOptional("n", TypeInt, &n, NewInt(1)),
+				timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(5*time.Second))),
+			)
+			return NewBool(this.Reserved.(*mailTestServer).waitFor(c, n.AsInt(), timeout.GetDuration(c)))
+		}, "n", "timeout").
+		Method("clear", func(c *Context, this ValueObject, args []Value) Value {
+			s := this.Reserved.(*mailTestServer)
+			s.mu.Lock()
+			s.messages = nil
+			s.mu.Unlock()
+			return this
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*mailTestServer).close()
+			return Undefined()
+		}).
+		Build()
+}
@@ -0,0 +1,82 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testMailSetup = `
+mail := import('mail')
+srv := mail.testServer({username: 'ops', password: 'secret'})
+sendAlert := () => {
+	msg := mail.Message({
+		from: 'Alerts <alerts@example.com>',
+		to: ['bob@example.com', '"Ann" <ann@example.com>'],
+		bcc: 'hidden@example.com',
+		subject: '告警: disk full',
+		text: 'disk is full\nplease check',
+		html: '<img src="cid:chart">',
+	})
+	msg.attach('report.csv', 'host,usage\n')
+	msg.inline('chart', 'PNG', 'image/png')
+	mail.send(msg, srv.options)
+	return srv.waitFor(1, 2) ? srv.last() : nil
+}
+`
+
+const testMailTeardown = `
+srv.close()
+`
+
+func TestMailSendAndCapture(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Addresses", `
+			m := sendAlert()
+			export result := [m.from, m.to, m.envelope.to]
+		`, []interface{}{
+			"alerts@example.com",
+			[]interface{}{"bob@example.com", "ann@example.com"},
+			[]interface{}{"bob@example.com", "ann@example.com", "hidden@example.com"},
+		}},
+		{"Content", `
+			m := sendAlert()
+			export result := [m.subject, m.text, m.html]
+		`, []interface{}{"告警: disk full", "disk is full\nplease check", `<img src="cid:chart">`}},
+		{"Attachments", `
+			export result := sendAlert().attachments.map(a => [a.filename, a.contentType, a.cid, a.inline, str(a.content)])
+		`, []interface{}{
+			[]interface{}{"", "image/png", "chart", true, "PNG"},
+			[]interface{}{"report.csv", "text/csv", "", false, "host,usage\n"},
+		}},
+		{"LoginAuth", `
+			mail.Client({host: srv.host, port: srv.port, username: 'ops', password: 'secret', auth: 'login'}).send({from: 'a@x.com', to: 'b@x.com', text: 'hello'})
+			export result := [srv.count(), srv.last().text]
+		`, []interface{}{int64(1), "hello"}},
+		{"RequireAuth", `
+			rejected := false
+			try {
+				mail.send({from: 'a@x.com', to: 'b@x.com', text: 'x'}, {host: srv.host, port: srv.port})
+			} catch (e) {
+				rejected = true
+			}
+			export result := [rejected, srv.count()]
+		`, []interface{}{true, int64(0)}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testMailSetup + tc.code + testMailTeardown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
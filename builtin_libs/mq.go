@@ -0,0 +1,474 @@
+package builtin_libs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// mqDelivery 是broker投递给消费者的一条消息，attempts从1开始
+type mqDelivery struct {
+	id       string
+	topic    string
+	body     []byte
+	attempts int
+	ack      func() error
+	// retry 让消息在delay之后重新投递
+	retry func(delay time.Duration) error
+}
+
+// mqBroker 是各消息队列后端需要实现的接口
+type mqBroker interface {
+	publish(topic string, body []byte, delay time.Duration) error
+	// consume 开始消费，deliver可能因并发已满而阻塞；返回的stop执行后不再投递新消息，
+	// 最多等待timeout(不大于0时一直等待)，超时返回false
+	consume(topic, group string, concurrency int, deliver func(*mqDelivery)) (stop func(timeout time.Duration) bool, err error)
+	close() error
+}
+
+var mqBrokerSchemes = map[string]func(c *Context, u *url.URL) mqBroker{}
+
+var (
+	mqProducerClass ValueType
+	mqConsumerClass ValueType
+	mqMessageClass  ValueType
+)
+
+func libMq(c *Context) ValueObject {
+	lib := NewObject()
+	lib.SetMember("Producer", mqProducerClass, c)
+	lib.SetMember("Consumer", mqConsumerClass, c)
+	lib.SetMember("Message", mqMessageClass, c)
+	return lib
+}
+
+func mqOpenBroker(c *Context, funcName, rawurl string) mqBroker {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		c.RaiseRuntimeError("%s: parse url error %s", funcName, err)
+	}
+	open, found := mqBrokerSchemes[u.Scheme]
+	if !found {
+		c.RaiseRuntimeError("%s: unknown broker scheme %s", funcName, u.Scheme)
+	}
+	return open(c, u)
+}
+
+// mqWaitDone 等待done关闭，timeout不大于0时一直等待，超时返回false
+func mqWaitDone(done <-chan struct{}, timeout time.Duration) bool {
+	if timeout <= 0 {
+		<-done
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// mqOptionDuration 读取选项中的时长，可以是time.Duration、字符串或秒数
+func mqOptionDuration(c *Context, funcName string, opts ValueObject, name string, dv time.Duration) time.Duration {
+	v := opts.GetMember(name, c)
+	if IsUndefined(v) {
+		return dv
+	}
+	var d timeDurationArg
+	EnsureFuncParams(c, funcName, []Value{v}, d.Rule(c, name))
+	return d.GetDuration(c)
+}
+
+// mqPayload 字节和字符串原样发送，其他值编码为json
+func mqPayload(c *Context, funcName string, data Value) []byte {
+	switch v := data.(type) {
+	case ValueBytes:
+		return v.Value()
+	case ValueStr:
+		return []byte(v.Value())
+	}
+	payload, err := jsonMarshal(data.ToGoValue(c))
+	if err != nil {
+		c.RaiseRuntimeError("%s: marshal data error %s", funcName, err)
+	}
+	return payload
+}
+
+func initMqProducerClass() ValueType {
+	getBroker := func(this ValueObject) mqBroker {
+		return this.Reserved.(mqBroker)
+	}
+	return NewClassBuilder("mq.Producer").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var brokerUrl ValueStr
+			EnsureFuncParams(c, "mq.Producer.__init__", args,
+				ArgRuleRequired("url", TypeStr, &brokerUrl),
+			)
+			this.Reserved = mqOpenBroker(c, "mq.Producer.__init__", brokerUrl.Value())
+		}).
+		Method("publish", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				topic ValueStr
+				data  Value
+				delay timeDurationArg
+			)
+			EnsureFuncParams(c, "mq.Producer.publish", args,
+				ArgRuleRequired("topic", TypeStr, &topic),
+				ArgRuleRequired("data", TypeAny, &data),
+				delay.Rule(c, "delay", NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(0)))),
+			)
+			payload := mqPayload(c, "mq.Producer.publish", data)
+			if err := getBroker(this).publish(topic.Value(), payload, delay.GetDuration(c)); err != nil {
+				c.RaiseRuntimeError("mq.Producer.publish: %s", err)
+			}
+			return this
+		}, "topic", "data", "delay").
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			if err := getBroker(this).close(); err != nil {
+				c.RaiseRuntimeError("mq.Producer.close: %s", err)
+			}
+			return Undefined()
+		}).
+		Build()
+}
+
+type mqConsumerInfo struct {
+	broker      mqBroker
+	group       string
+	limiter     *concurrentLimiterInfo
+	concurrency int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	deadLetter  string
+	onError     Value
+
+	lock     sync.Mutex
+	stops    []func(time.Duration) bool
+	stopped  bool
+	done     chan struct{}
+	inFlight sync.WaitGroup
+
+	processed    int64
+	failed       int64
+	retried      int64
+	deadLettered int64
+	running      int64
+}
+
+// backoffOf 第n次失败后等待backoff*2^(n-1)，不超过maxBackoff
+func (info *mqConsumerInfo) backoffOf(attempts int) time.Duration {
+	d := info.backoff
+	for i := 1; i < attempts && d < info.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > info.maxBackoff {
+		d = info.maxBackoff
+	}
+	return d
+}
+
+// fail 处理一次失败：未达到最大次数时延迟重试，否则转入死信topic（如有）后确认掉
+func (info *mqConsumerInfo) fail(d *mqDelivery, delay time.Duration) error {
+	atomic.AddInt64(&info.failed, 1)
+	if info.maxAttempts <= 0 || d.attempts < info.maxAttempts {
+		if delay < 0 {
+			delay = info.backoffOf(d.attempts)
+		}
+		atomic.AddInt64(&info.retried, 1)
+		return d.retry(delay)
+	}
+	if info.deadLetter != "" {
+		if err := info.broker.publish(info.deadLetter, d.body, 0); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt64(&info.deadLettered, 1)
+	return d.ack()
+}
+
+type mqMessageState struct {
+	consumer *mqConsumerInfo
+	delivery *mqDelivery
+	settled  bool
+}
+
+func (s *mqMessageState) ack(c *Context) {
+	if s.settled {
+		c.RaiseRuntimeError("mq.Message: message already acked or nacked")
+	}
+	s.settled = true
+	if err := s.delivery.ack(); err != nil {
+		c.RaiseRuntimeError("mq.Message.ack: %s", err)
+	}
+	atomic.AddInt64(&s.consumer.processed, 1)
+}
+
+func (s *mqMessageState) nack(c *Context, delay time.Duration) {
+	if s.settled {
+		c.RaiseRuntimeError("mq.Message: message already acked or nacked")
+	}
+	s.settled = true
+	if err := s.consumer.fail(s.delivery, delay); err != nil {
+		c.RaiseRuntimeError("mq.Message.nack: %s", err)
+	}
+}
+
+// handle 调用handler处理一条消息，正常返回且未显式ack/nack时自动ack，抛出异常时自动nack
+func (info *mqConsumerInfo) handle(c *Context, handler Value, d *mqDelivery) {
+	atomic.AddInt64(&info.running, 1)
+	defer atomic.AddInt64(&info.running, -1)
+	state := &mqMessageState{consumer: info, delivery: d}
+	msg := NewObjectAndInit(mqMessageClass, c, NewGoValue(state))
+	var errMsg string
+	func() {
+		defer func() {
+			if e := recover(); e != nil {
+				if ex, ok := e.(Exception); ok {
+					errMsg = ex.GetMessage()
+				} else {
+					errMsg = fmt.Sprint(e)
+				}
+			}
+		}()
+		c.Invoke(handler, nil, Args(msg))
+	}()
+	if errMsg != "" && info.onError != nil {
+		func() {
+			defer c.Recover()
+			c.Invoke(info.onError, nil, Args(msg, NewStr(errMsg)))
+		}()
+	} else if errMsg != "" {
+		fmt.Fprintf(c.Stderr, "mq.Consumer: handle message %s of %s error %s\n", d.id, d.topic, errMsg)
+	}
+	if state.settled {
+		return
+	}
+	state.settled = true
+	var err error
+	if errMsg != "" {
+		err = info.fail(d, -1)
+	} else if err = d.ack(); err == nil {
+		atomic.AddInt64(&info.processed, 1)
+	}
+	if err != nil {
+		fmt.Fprintf(c.Stderr, "mq.Consumer: settle message %s of %s error %s\n", d.id, d.topic, err)
+	}
+}
+
+// stop 停止所有订阅并等待处理中的消息完成，总共最多等待timeout(不大于0时一直等待)
+func (info *mqConsumerInfo) stop(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	remaining := func() time.Duration {
+		if timeout <= 0 {
+			return 0
+		}
+		if d := time.Until(deadline); d > 0 {
+			return d
+		}
+		// 已经超时，只检查是否已经完成
+		return time.Nanosecond
+	}
+	stopped := true
+	info.lock.Lock()
+	if !info.stopped {
+		info.stopped = true
+		for _, stop := range info.stops {
+			if !stop(remaining()) {
+				stopped = false
+			}
+		}
+		close(info.done)
+	}
+	info.lock.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		info.inFlight.Wait()
+		close(drained)
+	}()
+	return mqWaitDone(drained, remaining()) && stopped
+}
+
+func initMqConsumerClass() ValueType {
+	getInfo := func(this ValueObject) *mqConsumerInfo {
+		return this.Reserved.(*mqConsumerInfo)
+	}
+	return NewClassBuilder("mq.Consumer").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var (
+				brokerUrl ValueStr
+				opts      ValueObject
+			)
+			EnsureFuncParams(c, "mq.Consumer.__init__", args,
+				ArgRuleRequired("url", TypeStr, &brokerUrl),
+				ArgRuleOptional("options", TypeObject, &opts, NewObject()),
+			)
+			info := &mqConsumerInfo{
+				group:       "default",
+				concurrency: 1,
+				maxAttempts: 5,
+				backoff:     time.Second,
+				done:        make(chan struct{}),
+			}
+			if v, ok := opts.GetMember("group", c).(ValueStr); ok {
+				info.group = v.Value()
+			}
+			if v, ok := opts.GetMember("maxAttempts", c).(ValueInt); ok {
+				info.maxAttempts = v.AsInt()
+			}
+			info.backoff = mqOptionDuration(c, "mq.Consumer.__init__", opts, "backoff", time.Second)
+			info.maxBackoff = mqOptionDuration(c, "mq.Consumer.__init__", opts, "maxBackoff", time.Minute)
+			if v, ok := opts.GetMember("deadLetter", c).(ValueStr); ok {
+				info.deadLetter = v.Value()
+			}
+			if v := opts.GetMember("onError", c); c.IsCallable(v) {
+				info.onError = v
+			}
+			// 传入concurrent.Limiter时与其共享并发额度
+			if v, ok := opts.GetMember("limiter", c).(ValueObject); ok {
+				limiter, ok := v.Reserved.(*concurrentLimiterInfo)
+				if !ok {
+					c.RaiseRuntimeError("mq.Consumer.__init__: limiter must be a concurrent.Limiter")
+				}
+				info.limiter = limiter
+				info.concurrency = cap(limiter.ch)
+			} else {
+				if v, ok := opts.GetMember("concurrency", c).(ValueInt); ok {
+					info.concurrency = v.AsInt()
+				}
+				if info.concurrency < 1 {
+					c.RaiseRuntimeError("mq.Consumer.__init__: concurrency must > 0")
+				}
+				info.limiter = &concurrentLimiterInfo{ch: make(chan struct{}, info.concurrency)}
+			}
+			info.broker = mqOpenBroker(c, "mq.Consumer.__init__", brokerUrl.Value())
+			this.Reserved = info
+		}).
+		Method("on", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info    = getInfo(this)
+				topic   ValueStr
+				handler ValueCallable
+			)
+			EnsureFuncParams(c, "mq.Consumer.on", args,
+				ArgRuleRequired("topic", TypeStr, &topic),
+				ArgRuleRequired("handler", TypeCallable, &handler),
+			)
+			info.lock.Lock()
+			defer info.lock.Unlock()
+			if info.stopped {
+				c.RaiseRuntimeError("mq.Consumer.on: consumer is stopped")
+			}
+			// 在当前协程复制一份上下文，处理消息的协程再从它复制，避免与脚本主线程竞争
+			base := c.Clone()
+			stop, err := info.broker.consume(topic.Value(), info.group, info.concurrency, func(d *mqDelivery) {
+				info.limiter.ch <- struct{}{}
+				info.limiter.wg.Add(1)
+				info.inFlight.Add(1)
+				go func() {
+					defer func() {
+						info.inFlight.Done()
+						info.limiter.wg.Done()
+						<-info.limiter.ch
+					}()
+					newC := base.Clone()
+					defer newC.Recover()
+					info.handle(newC, handler, d)
+				}()
+			})
+			if err != nil {
+				c.RaiseRuntimeError("mq.Consumer.on: %s", err)
+			}
+			info.stops = append(info.stops, stop)
+			return this
+		}).
+		Method("run", func(c *Context, this ValueObject, args []Value) Value {
+			info := getInfo(this)
+			select {
+			case <-info.done:
+			case <-c.Ctx.Done():
+				info.stop(0)
+				c.AbortIfCancelled()
+			}
+			return this
+		}).
+		Method("stop", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				info    = getInfo(this)
+				timeout timeDurationArg
+			)
+			EnsureFuncParams(c, "mq.Consumer.stop", args,
+				timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(0)))),
+			)
+			drained := info.stop(timeout.GetDuration(c))
+			if err := info.broker.close(); err != nil {
+				c.RaiseRuntimeError("mq.Consumer.stop: %s", err)
+			}
+			return NewBool(drained)
+		}, "timeout").
+		Method("stats", func(c *Context, this ValueObject, args []Value) Value {
+			info := getInfo(this)
+			rv := NewObject()
+			rv.SetMember("processed", NewInt(atomic.LoadInt64(&info.processed)), c)
+			rv.SetMember("failed", NewInt(atomic.LoadInt64(&info.failed)), c)
+			rv.SetMember("retried", NewInt(atomic.LoadInt64(&info.retried)), c)
+			rv.SetMember("deadLettered", NewInt(atomic.LoadInt64(&info.deadLettered)), c)
+			rv.SetMember("inFlight", NewInt(atomic.LoadInt64(&info.running)), c)
+			return rv
+		}).
+		Build()
+}
+
+func initMqMessageClass() ValueType {
+	getState := func(this ValueObject) *mqMessageState {
+		return this.Reserved.(*mqMessageState)
+	}
+	return NewClassBuilder("mq.Message").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			state := args[0].ToGoValue(c).(*mqMessageState)
+			this.Reserved = state
+			this.SetMember("id", NewStr(state.delivery.id), c)
+			this.SetMember("topic", NewStr(state.delivery.topic), c)
+			this.SetMember("body", NewBytes(state.delivery.body), c)
+			this.SetMember("attempts", NewInt(int64(state.delivery.attempts)), c)
+		}).
+		Method("text", func(c *Context, this ValueObject, args []Value) Value {
+			return NewStr(string(getState(this).delivery.body))
+		}).
+		Method("json", func(c *Context, this ValueObject, args []Value) Value {
+			var j interface{}
+			if err := json.Unmarshal(getState(this).delivery.body, &j); err != nil {
+				c.RaiseRuntimeError("mq.Message.json: %s", err)
+			}
+			return jsonToValue(j, c)
+		}).
+		Method("ack", func(c *Context, this ValueObject, args []Value) Value {
+			getState(this).ack(c)
+			return Undefined()
+		}).
+		Method("nack", func(c *Context, this ValueObject, args []Value) Value {
+			// 不传delay时按backoff延迟重试
+			delay := time.Duration(-1)
+			if len(args) > 0 && !dbIsNil(args[0]) {
+				var d timeDurationArg
+				EnsureFuncParams(c, "mq.Message.nack", args, d.Rule(c, "delay"))
+				delay = d.GetDuration(c)
+			}
+			getState(this).nack(c, delay)
+			return Undefined()
+		}, "delay").
+		Build()
+}
+
+func init() {
+	mqProducerClass = initMqProducerClass()
+	mqConsumerClass = initMqConsumerClass()
+	mqMessageClass = initMqMessageClass()
+}
@@ -0,0 +1,187 @@
+package builtin_libs
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+type mqMemMessage struct {
+	id       string
+	body     []byte
+	attempts int
+}
+
+// mqMemQueue 是一个消费组的消息队列，组内的消费者竞争消费
+type mqMemQueue struct {
+	lock   sync.Mutex
+	items  []mqMemMessage
+	signal chan struct{}
+}
+
+func newMqMemQueue() *mqMemQueue {
+	return &mqMemQueue{signal: make(chan struct{}, 1)}
+}
+
+func (q *mqMemQueue) push(msgs ...mqMemMessage) {
+	q.lock.Lock()
+	q.items = append(q.items, msgs...)
+	q.lock.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *mqMemQueue) pop() (mqMemMessage, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.items) == 0 {
+		return mqMemMessage{}, false
+	}
+	msg := q.items[0]
+	q.items = q.items[1:]
+	if len(q.items) > 0 {
+		// 唤醒同组的其他消费者
+		select {
+		case q.signal <- struct{}{}:
+		default:
+		}
+	}
+	return msg, true
+}
+
+// mqMemTopic 没有消费组时消息暂存在backlog，第一个消费组出现后全部转给它
+type mqMemTopic struct {
+	backlog []mqMemMessage
+	groups  map[string]*mqMemQueue
+}
+
+// mqMemBroker 进程内的broker，mem://name 在进程内共享，mem:// 为私有
+type mqMemBroker struct {
+	lock   sync.Mutex
+	topics map[string]*mqMemTopic
+	nextId int64
+}
+
+var mqMemBrokers sync.Map
+
+func newMqMemBroker() *mqMemBroker {
+	return &mqMemBroker{topics: map[string]*mqMemTopic{}}
+}
+
+func (b *mqMemBroker) topic(name string) *mqMemTopic {
+	t, found := b.topics[name]
+	if !found {
+		t = &mqMemTopic{groups: map[string]*mqMemQueue{}}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *mqMemBroker) enqueue(topic string, msg mqMemMessage) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.topic(topic)
+	if len(t.groups) == 0 {
+		t.backlog = append(t.backlog, msg)
+		return
+	}
+	for _, q := range t.groups {
+		q.push(msg)
+	}
+}
+
+func (b *mqMemBroker) publish(topic string, body []byte, delay time.Duration) error {
+	msg := mqMemMessage{
+		id:       strconv.FormatInt(atomic.AddInt64(&b.nextId, 1), 10),
+		body:     body,
+		attempts: 1,
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, func() { b.enqueue(topic, msg) })
+	} else {
+		b.enqueue(topic, msg)
+	}
+	return nil
+}
+
+func (b *mqMemBroker) consume(topic, group string, concurrency int, deliver func(*mqDelivery)) (func(time.Duration) bool, error) {
+	b.lock.Lock()
+	t := b.topic(topic)
+	q, found := t.groups[group]
+	if !found {
+		q = newMqMemQueue()
+		t.groups[group] = q
+		if len(t.backlog) > 0 {
+			q.push(t.backlog...)
+			t.backlog = nil
+		}
+	}
+	b.lock.Unlock()
+	var (
+		stopCh   = make(chan struct{})
+		loopDone = make(chan struct{})
+		stopOnce sync.Once
+	)
+	go func() {
+		defer close(loopDone)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			msg, ok := q.pop()
+			if !ok {
+				select {
+				case <-q.signal:
+				case <-stopCh:
+					return
+				}
+				continue
+			}
+			deliver(&mqDelivery{
+				id:       msg.id,
+				topic:    topic,
+				body:     msg.body,
+				attempts: msg.attempts,
+				ack:      func() error { return nil },
+				retry: func(delay time.Duration) error {
+					next := msg
+					next.attempts++
+					if delay > 0 {
+						time.AfterFunc(delay, func() { q.push(next) })
+					} else {
+						q.push(next)
+					}
+					return nil
+				},
+			})
+		}
+	}()
+	return func(timeout time.Duration) bool {
+		stopOnce.Do(func() { close(stopCh) })
+		return mqWaitDone(loopDone, timeout)
+	}, nil
+}
+
+func (b *mqMemBroker) close() error {
+	return nil
+}
+
+func init() {
+	mqBrokerSchemes["mem"] = func(c *Context, u *url.URL) mqBroker {
+		name := u.Host + strings.TrimPrefix(u.Path, "/")
+		if name == "" {
+			return newMqMemBroker()
+		}
+		b, _ := mqMemBrokers.LoadOrStore(name, newMqMemBroker())
+		return b.(*mqMemBroker)
+	}
+}
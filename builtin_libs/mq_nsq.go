@@ -0,0 +1,116 @@
+package builtin_libs
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// mqNsqBroker nsq://host:4150 直连nsqd，nsqlookupd://host:4161 通过lookupd发现（只能消费）
+type mqNsqBroker struct {
+	addr     string
+	lookupd  bool
+	lock     sync.Mutex
+	producer *nsq.Producer
+}
+
+func (b *mqNsqBroker) publish(topic string, body []byte, delay time.Duration) error {
+	if b.lookupd {
+		return errors.New("cannot publish through nsqlookupd")
+	}
+	b.lock.Lock()
+	if b.producer == nil {
+		p, err := nsq.NewProducer(b.addr, nsq.NewConfig())
+		if err != nil {
+			b.lock.Unlock()
+			return err
+		}
+		p.SetLogger(nsqDefaultLogger, nsq.LogLevelMax)
+		b.producer = p
+	}
+	p := b.producer
+	b.lock.Unlock()
+	if delay > 0 {
+		return p.DeferredPublish(topic, delay, body)
+	}
+	return p.Publish(topic, body)
+}
+
+func (b *mqNsqBroker) consume(topic, group string, concurrency int, deliver func(*mqDelivery)) (func(time.Duration) bool, error) {
+	conf := nsq.NewConfig()
+	conf.LookupdPollInterval = 60 * time.Second
+	conf.MaxInFlight = concurrency
+	// 重试次数由mq.Consumer控制
+	conf.MaxAttempts = 0
+	consumer, err := nsq.NewConsumer(topic, group, conf)
+	if err != nil {
+		return nil, err
+	}
+	consumer.SetLogger(nsqDefaultLogger, nsq.LogLevelMax)
+	consumer.AddHandler(nsq.HandlerFunc(func(msg *nsq.Message) error {
+		msg.DisableAutoResponse()
+		deliver(&mqDelivery{
+			id:       string(msg.ID[:]),
+			topic:    topic,
+			body:     msg.Body,
+			attempts: int(msg.Attempts),
+			ack: func() error {
+				msg.Finish()
+				return nil
+			},
+			retry: func(delay time.Duration) error {
+				msg.RequeueWithoutBackoff(delay)
+				return nil
+			},
+		})
+		return nil
+	}))
+	if b.lookupd {
+		err = consumer.ConnectToNSQLookupd(b.addr)
+	} else {
+		err = consumer.ConnectToNSQD(b.addr)
+	}
+	if err != nil {
+		consumer.Stop()
+		return nil, err
+	}
+	return func(timeout time.Duration) bool {
+		consumer.Stop()
+		if timeout <= 0 {
+			<-consumer.StopChan
+			return true
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-consumer.StopChan:
+			return true
+		case <-timer.C:
+			return false
+		}
+	}, nil
+}
+
+func (b *mqNsqBroker) close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.producer != nil {
+		b.producer.Stop()
+		b.producer = nil
+	}
+	return nil
+}
+
+func init() {
+	mqBrokerSchemes["nsq"] = func(c *Context, u *url.URL) mqBroker {
+		return &mqNsqBroker{addr: u.Host}
+	}
+	mqBrokerSchemes["nsqlookupd"] = func(c *Context, u *url.URL) mqBroker {
+		return &mqNsqBroker{addr: u.Host, lookupd: true}
+	}
+}
@@ -0,0 +1,148 @@
+package builtin_libs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// mqRedisBroker 基于Redis Streams，每个topic一个stream，消费组对应XGROUP。
+// 延迟发布和重试在当前进程内计时，进程退出时尚未重投的消息留在pending列表中
+type mqRedisBroker struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+var mqRedisConsumerSeq int64
+
+func (b *mqRedisBroker) add(topic string, body []byte, attempts int) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("XADD", b.prefix+topic, "*", "body", body, "attempts", attempts)
+	return err
+}
+
+func (b *mqRedisBroker) publish(topic string, body []byte, delay time.Duration) error {
+	if delay > 0 {
+		time.AfterFunc(delay, func() { b.add(topic, body, 1) })
+		return nil
+	}
+	return b.add(topic, body, 1)
+}
+
+func (b *mqRedisBroker) consume(topic, group string, concurrency int, deliver func(*mqDelivery)) (func(time.Duration) bool, error) {
+	key := b.prefix + topic
+	conn := b.pool.Get()
+	_, err := conn.Do("XGROUP", "CREATE", key, group, "0", "MKSTREAM")
+	conn.Close()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	consumerName := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), atomic.AddInt64(&mqRedisConsumerSeq, 1))
+	ack := func(id string) error {
+		conn := b.pool.Get()
+		defer conn.Close()
+		_, err := conn.Do("XACK", key, group, id)
+		return err
+	}
+	var (
+		stopCh   = make(chan struct{})
+		loopDone = make(chan struct{})
+		stopOnce sync.Once
+	)
+	go func() {
+		defer close(loopDone)
+		conn := b.pool.Get()
+		defer conn.Close()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			reply, err := redis.Values(conn.Do("XREADGROUP", "GROUP", group, consumerName,
+				"COUNT", concurrency, "BLOCK", 500, "STREAMS", key, ">"))
+			if err != nil {
+				if err != redis.ErrNil {
+					// 连接出错时换一个连接，稍后重试
+					conn.Close()
+					select {
+					case <-stopCh:
+						return
+					case <-time.After(time.Second):
+					}
+					conn = b.pool.Get()
+				}
+				continue
+			}
+			for _, stream := range reply {
+				parts, _ := redis.Values(stream, nil)
+				if len(parts) < 2 {
+					continue
+				}
+				entries, _ := redis.Values(parts[1], nil)
+				for _, entry := range entries {
+					item, _ := redis.Values(entry, nil)
+					if len(item) < 2 {
+						continue
+					}
+					id, _ := redis.String(item[0], nil)
+					fields, _ := redis.StringMap(item[1], nil)
+					attempts, _ := strconv.Atoi(fields["attempts"])
+					if attempts < 1 {
+						attempts = 1
+					}
+					body := []byte(fields["body"])
+					deliver(&mqDelivery{
+						id:       id,
+						topic:    topic,
+						body:     body,
+						attempts: attempts,
+						ack:      func() error { return ack(id) },
+						retry: func(delay time.Duration) error {
+							// 追加一条新消息后再确认旧消息
+							requeue := func() error {
+								if err := b.add(topic, body, attempts+1); err != nil {
+									return err
+								}
+								return ack(id)
+							}
+							if delay <= 0 {
+								return requeue()
+							}
+							time.AfterFunc(delay, func() { requeue() })
+							return nil
+						},
+					})
+				}
+			}
+		}
+	}()
+	return func(timeout time.Duration) bool {
+		stopOnce.Do(func() { close(stopCh) })
+		return mqWaitDone(loopDone, timeout)
+	}, nil
+}
+
+func (b *mqRedisBroker) close() error {
+	return b.pool.Close()
+}
+
+func init() {
+	mqBrokerSchemes["redis"] = func(c *Context, u *url.URL) mqBroker {
+		return &mqRedisBroker{
+			pool:   kvRedisPool(c, "mq.redis", u),
+			prefix: u.Query().Get("prefix"),
+		}
+	}
+}
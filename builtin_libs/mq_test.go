@@ -0,0 +1,377 @@
+package builtin_libs_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testMqSetup = `
+mq := import('mq')
+time := import('time')
+concurrent := import('concurrent')
+waitFor := (cons, n) => {
+	for i := 0; i < 200 && cons.stats().processed + cons.stats().deadLettered < n; i++ {
+		time.sleep(0.01)
+	}
+}
+p := mq.Producer('{url}')
+seen := []
+errors := []
+// 在发布消息之后调用
+consume := () => {
+	cons := mq.Consumer('{url}', {
+		group: 'g',
+		limiter: concurrent.Limiter(2),
+		maxAttempts: 3,
+		backoff: 0.01,
+		deadLetter: 'jobs.dlq',
+		onError: (m, e) => errors.push(e),
+	})
+	cons.on('jobs', m => {
+		if m.text() == 'bad' {
+			assert false, 'bad message'
+		} else if m.text() == 'retry' && m.attempts < 2 {
+			m.nack(0)
+		} else {
+			seen.push(m.text() == 'retry' ? 'retry@' + str(m.attempts) : 'n=' + str(m.json().n))
+		}
+	})
+	return cons
+}
+statsOf := cons => (s => [s.processed, s.failed, s.retried, s.deadLettered])(cons.stats())
+`
+
+func TestMq(t *testing.T) {
+	// 每个用例使用新的队列，互不影响
+	backends := map[string]func(t *testing.T) string{
+		"mem": func(t *testing.T) string {
+			return "mem://" + strings.ReplaceAll(t.Name(), "/", "_")
+		},
+		"redis": func(t *testing.T) string {
+			return "redis://" + miniredis.RunT(t).Addr() + "?prefix=mq:"
+		},
+	}
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Consume", `
+			p.publish('jobs', {n: 1})
+			cons := consume()
+			waitFor(cons, 1)
+			export result := [seen, errors, statsOf(cons), cons.stop(1)]
+		`, []interface{}{
+			[]interface{}{"n=1"},
+			[]interface{}{},
+			[]interface{}{int64(1), int64(0), int64(0), int64(0)},
+			true,
+		}},
+		{"NackRetries", `
+			p.publish('jobs', bytes('retry'))
+			cons := consume()
+			waitFor(cons, 1)
+			export result := [seen, statsOf(cons), cons.stop(1)]
+		`, []interface{}{
+			[]interface{}{"retry@2"},
+			[]interface{}{int64(1), int64(1), int64(1), int64(0)},
+			true,
+		}},
+		{"ErrorsGoToDeadLetter", `
+			p.publish('jobs', 'bad')
+			cons := consume()
+			waitFor(cons, 1)
+			dead := []
+			dlq := mq.Consumer('{url}', {group: 'g'})
+			dlq.on('jobs.dlq', m => {
+				dead.push(m.text())
+				m.ack()
+			})
+			waitFor(dlq, 1)
+			export result := [errors, dead, statsOf(cons), cons.stop(1), dlq.stop(1)]
+		`, []interface{}{
+			[]interface{}{"Assertion fail! bad message", "Assertion fail! bad message", "Assertion fail! bad message"},
+			[]interface{}{"bad"},
+			[]interface{}{int64(0), int64(3), int64(2), int64(1)},
+			true,
+			true,
+		}},
+	}
+	for backend, newUrl := range backends {
+		t.Run(backend, func(t *testing.T) {
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					exported, err := zgg.RunCode(strings.ReplaceAll(testMqSetup+tc.code+"\np.close()\n", "{url}", newUrl(t)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+						t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+					}
+				})
+			}
+		})
+	}
+}
+
+// testNsqd 是一个只支持PUB/DPUB/SUB/RDY/FIN/REQ/CLS的nsqd，不区分channel
+type testNsqd struct {
+	ln        net.Listener
+	ignoreCls bool
+	lock      sync.Mutex
+	seq       int
+	queues    map[string][]*testNsqMsg
+	subs      map[string][]*testNsqConn
+	conns     []net.Conn
+}
+
+type testNsqMsg struct {
+	id       string
+	topic    string
+	body     []byte
+	attempts uint16
+}
+
+type testNsqConn struct {
+	conn     net.Conn
+	rdy      int
+	inFlight map[string]*testNsqMsg
+}
+
+func newTestNsqd(t *testing.T, ignoreCls bool) *testNsqd {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testNsqd{
+		ln:        ln,
+		ignoreCls: ignoreCls,
+		queues:    map[string][]*testNsqMsg{},
+		subs:      map[string][]*testNsqConn{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			d.lock.Lock()
+			d.conns = append(d.conns, conn)
+			d.lock.Unlock()
+			go d.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		for _, conn := range d.conns {
+			conn.Close()
+		}
+	})
+	return d
+}
+
+func testNsqFrame(conn net.Conn, frameType uint32, data []byte) {
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(4+len(data)))
+	binary.BigEndian.PutUint32(buf[4:], frameType)
+	copy(buf[8:], data)
+	conn.Write(buf)
+}
+
+// dispatchLocked 把topic中排队的消息发给RDY未满的订阅者
+func (d *testNsqd) dispatchLocked(topic string) {
+	for _, sub := range d.subs[topic] {
+		for len(d.queues[topic]) > 0 && len(sub.inFlight) < sub.rdy {
+			msg := d.queues[topic][0]
+			d.queues[topic] = d.queues[topic][1:]
+			msg.attempts++
+			sub.inFlight[msg.id] = msg
+			data := make([]byte, 26+len(msg.body))
+			binary.BigEndian.PutUint64(data, uint64(time.Now().UnixNano()))
+			binary.BigEndian.PutUint16(data[8:], msg.attempts)
+			copy(data[10:], msg.id)
+			copy(data[26:], msg.body)
+			testNsqFrame(sub.conn, 2, data)
+		}
+	}
+}
+
+func (d *testNsqd) enqueue(msg *testNsqMsg, delay time.Duration) {
+	push := func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		d.queues[msg.topic] = append(d.queues[msg.topic], msg)
+		d.dispatchLocked(msg.topic)
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, push)
+	} else {
+		push()
+	}
+}
+
+func (d *testNsqd) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return
+	}
+	sub := &testNsqConn{conn: conn, inFlight: map[string]*testNsqMsg{}}
+	var topic string
+	readBody := func() []byte {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil
+		}
+		body := make([]byte, size)
+		io.ReadFull(r, body)
+		return body
+	}
+	ok := func() {
+		d.lock.Lock()
+		testNsqFrame(conn, 0, []byte("OK"))
+		d.lock.Unlock()
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		params := strings.Fields(line)
+		switch params[0] {
+		case "IDENTIFY":
+			readBody()
+			ok()
+		case "PUB", "DPUB":
+			body := readBody()
+			var delay time.Duration
+			if params[0] == "DPUB" {
+				ms, _ := strconv.Atoi(params[2])
+				delay = time.Duration(ms) * time.Millisecond
+			}
+			d.lock.Lock()
+			d.seq++
+			msg := &testNsqMsg{id: fmt.Sprintf("%016x", d.seq), topic: params[1], body: body}
+			d.lock.Unlock()
+			ok()
+			d.enqueue(msg, delay)
+		case "SUB":
+			topic = params[1]
+			d.lock.Lock()
+			d.subs[topic] = append(d.subs[topic], sub)
+			d.lock.Unlock()
+			ok()
+		case "RDY":
+			n, _ := strconv.Atoi(params[1])
+			d.lock.Lock()
+			sub.rdy = n
+			d.dispatchLocked(topic)
+			d.lock.Unlock()
+		case "FIN", "REQ":
+			d.lock.Lock()
+			msg := sub.inFlight[params[1]]
+			delete(sub.inFlight, params[1])
+			d.dispatchLocked(topic)
+			d.lock.Unlock()
+			if msg != nil && params[0] == "REQ" {
+				ms, _ := strconv.Atoi(params[2])
+				d.enqueue(msg, time.Duration(ms)*time.Millisecond)
+			}
+		case "CLS":
+			if d.ignoreCls {
+				continue
+			}
+			d.lock.Lock()
+			subs := d.subs[topic][:0]
+			for _, s := range d.subs[topic] {
+				if s != sub {
+					subs = append(subs, s)
+				}
+			}
+			d.subs[topic] = subs
+			testNsqFrame(conn, 0, []byte("CLOSE_WAIT"))
+			d.lock.Unlock()
+		}
+	}
+}
+
+const testMqNsqCode = `
+mq := import('mq')
+time := import('time')
+p := mq.Producer('nsq://{addr}')
+{publish}
+seen := []
+cons := mq.Consumer('nsq://{addr}', {group: 'g', backoff: '10ms'})
+cons.on('jobs', m => {
+	if m.text() == 'retry' && m.attempts < 2 {
+		m.nack(0)
+		return
+	}
+	seen.push(m.text() + '@' + str(m.attempts))
+})
+for i := 0; i < 200 && cons.stats().processed < 1; i++ {
+	time.sleep(0.01)
+}
+stats := cons.stats()
+export result := [seen, stats.processed, stats.retried, cons.stop(1)]
+p.close()
+`
+
+const testMqNsqStopTimeoutCode = `
+mq := import('mq')
+time := import('time')
+cons := mq.Consumer('nsq://{addr}')
+cons.on('jobs', m => nil)
+start := time.now()
+export result := [cons.stop(0.2), time.since(start).seconds() < 1]
+`
+
+func TestMqNsq(t *testing.T) {
+	cases := []struct {
+		name     string
+		publish  string
+		expected interface{}
+	}{
+		{"Consume", `p.publish('jobs', 'a')`, []interface{}{[]interface{}{"a@1"}, int64(1), int64(0), true}},
+		{"Nack", `p.publish('jobs', 'retry')`, []interface{}{[]interface{}{"retry@2"}, int64(1), int64(1), true}},
+		{"Deferred", `p.publish('jobs', 'late', '50ms')`, []interface{}{[]interface{}{"late@1"}, int64(1), int64(0), true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestNsqd(t, false)
+			code := strings.NewReplacer("{addr}", d.ln.Addr().String(), "{publish}", tc.publish).Replace(testMqNsqCode)
+			exported, err := zgg.RunCode(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+	t.Run("StopTimeout", func(t *testing.T) {
+		// nsqd不回应CLS时go-nsq要等30秒才退出，stop应在超时后返回false
+		d := newTestNsqd(t, true)
+		exported, err := zgg.RunCode(strings.ReplaceAll(testMqNsqStopTimeoutCode, "{addr}", d.ln.Addr().String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := exported["result"], []interface{}{false, true}; !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("expected %#v, got %#v", expected, actual)
+		}
+	})
+}
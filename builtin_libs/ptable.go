@@ -25,6 +25,8 @@ func libPtable(c *Context) ValueObject {
 	lib.SetMember("__call__", ptablePTableClass, c)
 	lib.SetMember("fromCsvFile", ptableFromCsvFile, c)
 	lib.SetMember("fromCsv", ptableFromCsv, c)
+	lib.SetMember("fromXlsx", ptableFromXlsx, c)
+	lib.SetMember("Workbook", ptableWorkbookClass, c)
 	lib.SetMember("query", NewNativeFunction("ptable.query", func(c *Context, this Value, args []Value) Value {
 		return c.InvokeMethod(ptablePTableClass, "query", Args(args...))
 	}), c)
@@ -114,7 +116,7 @@ func initPTableClass() {
 		if formatFn != nil {
 			c.Invoke(formatFn, nil, Args(item, NewInt(int64(i)), NewInt(int64(j))))
 			return c.RetVal.ToString(c)
-		} else if j < len(meta.colFormats) && meta.colFormats[j] != "" {
+		} else if _, isNil := item.(ValueNil); !isNil && j < len(meta.colFormats) && meta.colFormats[j] != "" {
 			return fmt.Sprintf(meta.colFormats[j], item.ToGoValue(c))
 		} else {
 			return item.ToString(c)
@@ -835,6 +837,16 @@ func initPTableClass() {
 			}
 			return this
 		}).
+		Method("xlsx", ptableXlsx, "path", "options").
+		Method("select", ptableSelect).
+		Method("where", ptableWhere, "fn").
+		Method("withColumn", ptableWithColumn, "name", "fn", "format").
+		Method("sortBy", ptableSortBy, "key", "desc").
+		Method("distinct", ptableDistinct).
+		Method("groupBy", ptableGroupBy).
+		Method("join", ptableJoin, "other", "on", "how").
+		Method("pivot", ptablePivot, "index", "columns", "values", "agg").
+		Method("melt", ptableMelt, "idCols", "valueCols", "varName", "valueName").
 		Method("__str__", func(c *Context, this ValueObject, args []Value) Value {
 			return c.InvokeMethod(this, "ascii", Args(args...))
 		}).
@@ -939,4 +951,6 @@ var ptableFromCsv = NewNativeFunction("ptable.fromCsv", func(c *Context, this Va
 
 func init() {
 	initPTableClass()
+	initPTableGroupClass()
+	initPTableWorkbookClass()
 }
@@ -0,0 +1,723 @@
+package builtin_libs
+
+import (
+	"sort"
+	"strings"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// PTable的原生数据操作，直接基于[][]Value进行，不经过sqlite
+
+var (
+	ptableGroupClass ValueType
+)
+
+func ptableData(c *Context, this Value) (*ptableMeta, [][]Value) {
+	meta := this.GetMember("_meta", c).ToGoValue(c).(*ptableMeta)
+	rows := this.GetMember("_rows", c).ToGoValue(c).([][]Value)
+	return meta, rows
+}
+
+func ptableMustTable(c *Context, v Value, fn string) ValueObject {
+	if !v.Type().IsSubOf(ptablePTableClass) {
+		c.RaiseRuntimeError("%s: argument must be a PTable", fn)
+	}
+	return v.(ValueObject)
+}
+
+// 用表头、列格式和数据行构造新的PTable
+func ptableNew(c *Context, headers, colFormats []string, rows [][]Value) ValueObject {
+	args := make([]Value, len(headers))
+	for i, h := range headers {
+		args[i] = NewStr(h)
+	}
+	rv := NewObjectAndInit(ptablePTableClass, c, args...)
+	meta := rv.GetMember("_meta", c).ToGoValue(c).(*ptableMeta)
+	for _, f := range colFormats {
+		if f != "" {
+			meta.colFormats = append([]string{}, colFormats...)
+			break
+		}
+	}
+	rv.SetMember("_rows", NewGoValue(rows), c)
+	return rv
+}
+
+func (m *ptableMeta) format(i int) string {
+	if i >= 0 && i < len(m.colFormats) {
+		return m.colFormats[i]
+	}
+	return ""
+}
+
+func (m *ptableMeta) findCol(name string) int {
+	for i, h := range m.headers {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 列可以用列名或下标指定
+func (m *ptableMeta) colIndex(c *Context, col Value, fn string) int {
+	switch cv := col.(type) {
+	case ValueInt:
+		i := cv.AsInt()
+		if i < 0 {
+			i += len(m.headers)
+		}
+		if i < 0 || i >= len(m.headers) {
+			c.RaiseRuntimeError("%s: column index %d out of range", fn, cv.AsInt())
+		}
+		return i
+	case ValueStr:
+		if i := m.findCol(cv.Value()); i >= 0 {
+			return i
+		}
+		c.RaiseRuntimeError("%s: column %s not found", fn, cv.Value())
+	default:
+		c.RaiseRuntimeError("%s: column must be a string or an integer", fn)
+	}
+	return -1
+}
+
+// 参数可以是多个列，也可以是一个列数组
+func (m *ptableMeta) colIndexes(c *Context, args []Value, fn string) []int {
+	if len(args) == 1 {
+		if arr, ok := args[0].(ValueArray); ok {
+			args = *arr.Values
+		}
+	}
+	rv := make([]int, len(args))
+	for i, arg := range args {
+		rv[i] = m.colIndex(c, arg, fn)
+	}
+	return rv
+}
+
+func ptableCell(row []Value, i int) Value {
+	if i < len(row) {
+		return row[i]
+	}
+	return Nil()
+}
+
+// 回调函数接收的行对象，以列名为key
+func ptableRowObject(c *Context, meta *ptableMeta, row []Value) ValueObject {
+	rv := NewObject()
+	for i, h := range meta.headers {
+		rv.SetMember(h, ptableCell(row, i), c)
+	}
+	return rv
+}
+
+func ptableCallRow(c *Context, fn ValueCallable, meta *ptableMeta, row []Value, i int) Value {
+	c.Invoke(fn, nil, Args(ptableRowObject(c, meta, row), NewInt(int64(i))))
+	return c.RetVal
+}
+
+// 生成用于分组、去重、关联的key
+func ptableKey(c *Context, row []Value, cols []int) string {
+	var b strings.Builder
+	for _, i := range cols {
+		v := ptableCell(row, i)
+		b.WriteString(v.Type().Name)
+		b.WriteByte(':')
+		b.WriteString(v.ToString(c))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func ptablePick(row []Value, cols []int) []Value {
+	rv := make([]Value, len(cols))
+	for i, j := range cols {
+		rv[i] = ptableCell(row, j)
+	}
+	return rv
+}
+
+func ptableCompare(c *Context, a, b []Value) int {
+	for i := range a {
+		if c.ValuesLess(a[i], b[i]) {
+			return -1
+		} else if c.ValuesLess(b[i], a[i]) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// 聚合一组值，how可以是sum/avg/min/max/count/first/last或函数
+func ptableAggregate(c *Context, how Value, values []Value, fn string) Value {
+	if f, ok := c.GetCallable(how); ok {
+		c.Invoke(f, nil, Args(NewArrayByValues(values...)))
+		return c.RetVal
+	}
+	nonNil := make([]Value, 0, len(values))
+	for _, v := range values {
+		if _, isNil := v.(ValueNil); !isNil {
+			nonNil = append(nonNil, v)
+		}
+	}
+	switch how.ToString(c) {
+	case "count":
+		return NewInt(int64(len(nonNil)))
+	case "sum":
+		var sum Value = NewInt(0)
+		for _, v := range nonNil {
+			sum = c.ValuesPlus(sum, v)
+		}
+		return sum
+	case "avg":
+		if len(nonNil) == 0 {
+			return Nil()
+		}
+		var sum Value = NewInt(0)
+		for _, v := range nonNil {
+			sum = c.ValuesPlus(sum, v)
+		}
+		return c.ValuesDiv(sum, NewFloat(float64(len(nonNil))))
+	case "min", "max":
+		if len(nonNil) == 0 {
+			return Nil()
+		}
+		rv := nonNil[0]
+		isMin := how.ToString(c) == "min"
+		for _, v := range nonNil[1:] {
+			if (isMin && c.ValuesLess(v, rv)) || (!isMin && c.ValuesLess(rv, v)) {
+				rv = v
+			}
+		}
+		return rv
+	case "first":
+		if len(values) == 0 {
+			return Nil()
+		}
+		return values[0]
+	case "last":
+		if len(values) == 0 {
+			return Nil()
+		}
+		return values[len(values)-1]
+	}
+	c.RaiseRuntimeError("%s: unsupported aggregation %s", fn, how.ToString(c))
+	return nil
+}
+
+// 聚合后的列沿用原列格式，count/avg/自定义函数的结果类型可能不同，不沿用
+func ptableAggFormat(c *Context, meta *ptableMeta, col int, how Value) string {
+	if s, ok := how.(ValueStr); ok {
+		switch s.Value() {
+		case "sum", "min", "max", "first", "last":
+			return meta.format(col)
+		}
+	}
+	return ""
+}
+
+type ptableGroup struct {
+	keys   []Value
+	rowIds []int
+}
+
+func ptableGroupRows(c *Context, rows [][]Value, cols []int) []*ptableGroup {
+	groups := make([]*ptableGroup, 0)
+	groupMap := map[string]*ptableGroup{}
+	for i, row := range rows {
+		key := ptableKey(c, row, cols)
+		g, found := groupMap[key]
+		if !found {
+			g = &ptableGroup{keys: ptablePick(row, cols)}
+			groupMap[key] = g
+			groups = append(groups, g)
+		}
+		g.rowIds = append(g.rowIds, i)
+	}
+	return groups
+}
+
+func ptableSelect(c *Context, this ValueObject, args []Value) Value {
+	meta, rows := ptableData(c, this)
+	cols := meta.colIndexes(c, args, "PTable.select")
+	headers := make([]string, len(cols))
+	formats := make([]string, len(cols))
+	for i, j := range cols {
+		headers[i] = meta.headers[j]
+		formats[i] = meta.format(j)
+	}
+	rvRows := make([][]Value, len(rows))
+	for i, row := range rows {
+		rvRows[i] = ptablePick(row, cols)
+	}
+	return ptableNew(c, headers, formats, rvRows)
+}
+
+func ptableWhere(c *Context, this ValueObject, args []Value) Value {
+	var fn ValueCallable
+	EnsureFuncParams(c, "PTable.where", args, ArgRuleRequired("fn", TypeCallable, &fn))
+	meta, rows := ptableData(c, this)
+	rvRows := make([][]Value, 0)
+	for i, row := range rows {
+		if ptableCallRow(c, fn, meta, row, i).IsTrue() {
+			rvRows = append(rvRows, row)
+		}
+	}
+	return ptableNew(c, meta.headers, meta.colFormats, rvRows)
+}
+
+func ptableWithColumn(c *Context, this ValueObject, args []Value) Value {
+	var (
+		name   ValueStr
+		fn     ValueCallable
+		format ValueStr
+	)
+	EnsureFuncParams(c, "PTable.withColumn", args,
+		ArgRuleRequired("name", TypeStr, &name),
+		ArgRuleRequired("fn", TypeCallable, &fn),
+		ArgRuleOptional("format", TypeStr, &format, NewStr("")),
+	)
+	meta, rows := ptableData(c, this)
+	headers := append([]string{}, meta.headers...)
+	formats := make([]string, len(headers))
+	for i := range formats {
+		formats[i] = meta.format(i)
+	}
+	col := meta.findCol(name.Value())
+	if col < 0 {
+		col = len(headers)
+		headers = append(headers, name.Value())
+		formats = append(formats, format.Value())
+	} else if format.Value() != "" {
+		formats[col] = format.Value()
+	}
+	rvRows := make([][]Value, len(rows))
+	for i, row := range rows {
+		newRow := make([]Value, len(headers))
+		for j := range newRow {
+			newRow[j] = ptableCell(row, j)
+		}
+		newRow[col] = ptableCallRow(c, fn, meta, row, i)
+		rvRows[i] = newRow
+	}
+	return ptableNew(c, headers, formats, rvRows)
+}
+
+func ptableSortBy(c *Context, this ValueObject, args []Value) Value {
+	var (
+		key  Value
+		desc ValueBool
+	)
+	EnsureFuncParams(c, "PTable.sortBy", args,
+		ArgRuleRequired("key", TypeAny, &key),
+		ArgRuleOptional("desc", TypeBool, &desc, NewBool(false)),
+	)
+	meta, rows := ptableData(c, this)
+	keys := make([][]Value, len(rows))
+	if fn, ok := c.GetCallable(key); ok {
+		for i, row := range rows {
+			keys[i] = []Value{ptableCallRow(c, fn, meta, row, i)}
+		}
+	} else {
+		cols := meta.colIndexes(c, []Value{key}, "PTable.sortBy")
+		for i, row := range rows {
+			keys[i] = ptablePick(row, cols)
+		}
+	}
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		r := ptableCompare(c, keys[order[i]], keys[order[j]])
+		if desc.Value() {
+			return r > 0
+		}
+		return r < 0
+	})
+	rvRows := make([][]Value, len(rows))
+	for i, j := range order {
+		rvRows[i] = rows[j]
+	}
+	return ptableNew(c, meta.headers, meta.colFormats, rvRows)
+}
+
+func ptableDistinct(c *Context, this ValueObject, args []Value) Value {
+	meta, rows := ptableData(c, this)
+	var cols []int
+	if len(args) > 0 {
+		cols = meta.colIndexes(c, args, "PTable.distinct")
+	} else {
+		cols = make([]int, len(meta.headers))
+		for i := range cols {
+			cols[i] = i
+		}
+	}
+	seen := map[string]bool{}
+	rvRows := make([][]Value, 0)
+	for _, row := range rows {
+		key := ptableKey(c, row, cols)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rvRows = append(rvRows, row)
+	}
+	return ptableNew(c, meta.headers, meta.colFormats, rvRows)
+}
+
+func ptableGroupBy(c *Context, this ValueObject, args []Value) Value {
+	meta, _ := ptableData(c, this)
+	cols := meta.colIndexes(c, args, "PTable.groupBy")
+	if len(cols) == 0 {
+		c.RaiseRuntimeError("PTable.groupBy: requires at least 1 column")
+	}
+	return NewObjectAndInit(ptableGroupClass, c, this, NewGoValue(cols))
+}
+
+// 关联条件：列名、列名数组，或{左表列: 右表列}
+func ptableJoinOn(c *Context, on Value, left, right *ptableMeta) ([]int, []int) {
+	var leftCols, rightCols []int
+	switch o := on.(type) {
+	case ValueStr:
+		leftCols = []int{left.colIndex(c, o, "PTable.join")}
+		rightCols = []int{right.colIndex(c, o, "PTable.join")}
+	case ValueArray:
+		for i := 0; i < o.Len(); i++ {
+			col := o.GetIndex(i, c)
+			leftCols = append(leftCols, left.colIndex(c, col, "PTable.join"))
+			rightCols = append(rightCols, right.colIndex(c, col, "PTable.join"))
+		}
+	case ValueObject:
+		names := make([]string, 0)
+		o.Iterate(func(k string, _ Value) {
+			names = append(names, k)
+		})
+		sort.Slice(names, func(i, j int) bool {
+			return left.findCol(names[i]) < left.findCol(names[j])
+		})
+		for _, k := range names {
+			leftCols = append(leftCols, left.colIndex(c, NewStr(k), "PTable.join"))
+			rightCols = append(rightCols, right.colIndex(c, o.GetMember(k, c), "PTable.join"))
+		}
+	default:
+		c.RaiseRuntimeError("PTable.join: on must be a string, an array or an object")
+	}
+	if len(leftCols) == 0 {
+		c.RaiseRuntimeError("PTable.join: requires at least 1 join column")
+	}
+	return leftCols, rightCols
+}
+
+func ptableJoin(c *Context, this ValueObject, args []Value) Value {
+	var (
+		otherArg Value
+		on       Value
+		how      ValueStr
+	)
+	EnsureFuncParams(c, "PTable.join", args,
+		ArgRuleRequired("other", TypeObject, &otherArg),
+		ArgRuleRequired("on", TypeAny, &on),
+		ArgRuleOptional("how", TypeStr, &how, NewStr("inner")),
+	)
+	other := ptableMustTable(c, otherArg, "PTable.join")
+	leftMeta, leftRows := ptableData(c, this)
+	rightMeta, rightRows := ptableData(c, other)
+	leftCols, rightCols := ptableJoinOn(c, on, leftMeta, rightMeta)
+	var keepLeft, keepRight bool
+	switch how.Value() {
+	case "inner":
+	case "left":
+		keepLeft = true
+	case "right":
+		keepRight = true
+	case "outer", "full":
+		keepLeft, keepRight = true, true
+	default:
+		c.RaiseRuntimeError("PTable.join: unsupported join type %s", how.Value())
+	}
+	// 右表中与左表同名的关联列合并，其余重名列加_right后缀
+	var (
+		headers   = append([]string{}, leftMeta.headers...)
+		formats   = make([]string, len(headers))
+		rightMap  = make([]int, 0, len(rightMeta.headers))
+		mergedCol = map[int]int{}
+	)
+	for i := range formats {
+		formats[i] = leftMeta.format(i)
+	}
+	for k, rc := range rightCols {
+		if rightMeta.headers[rc] == leftMeta.headers[leftCols[k]] {
+			mergedCol[rc] = leftCols[k]
+		}
+	}
+	for j, h := range rightMeta.headers {
+		if _, merged := mergedCol[j]; merged {
+			continue
+		}
+		if leftMeta.findCol(h) >= 0 {
+			h += "_right"
+		}
+		rightMap = append(rightMap, j)
+		headers = append(headers, h)
+		formats = append(formats, rightMeta.format(j))
+	}
+	index := map[string][]int{}
+	for j, row := range rightRows {
+		key := ptableKey(c, row, rightCols)
+		index[key] = append(index[key], j)
+	}
+	var (
+		rvRows       = make([][]Value, 0)
+		rightMatched = make([]bool, len(rightRows))
+		nLeft        = len(leftMeta.headers)
+	)
+	makeRow := func(left, right []Value) []Value {
+		row := make([]Value, len(headers))
+		for i := 0; i < nLeft; i++ {
+			if left != nil {
+				row[i] = ptableCell(left, i)
+			} else {
+				row[i] = Nil()
+			}
+		}
+		for i, j := range rightMap {
+			if right != nil {
+				row[nLeft+i] = ptableCell(right, j)
+			} else {
+				row[nLeft+i] = Nil()
+			}
+		}
+		if left == nil {
+			for rc, lc := range mergedCol {
+				row[lc] = ptableCell(right, rc)
+			}
+		}
+		return row
+	}
+	for _, left := range leftRows {
+		matches := index[ptableKey(c, left, leftCols)]
+		for _, j := range matches {
+			rightMatched[j] = true
+			rvRows = append(rvRows, makeRow(left, rightRows[j]))
+		}
+		if len(matches) == 0 && keepLeft {
+			rvRows = append(rvRows, makeRow(left, nil))
+		}
+	}
+	if keepRight {
+		for j, right := range rightRows {
+			if !rightMatched[j] {
+				rvRows = append(rvRows, makeRow(nil, right))
+			}
+		}
+	}
+	return ptableNew(c, headers, formats, rvRows)
+}
+
+func ptablePivot(c *Context, this ValueObject, args []Value) Value {
+	var (
+		indexArg   Value
+		columnsArg Value
+		valuesArg  Value
+		how        Value
+	)
+	EnsureFuncParams(c, "PTable.pivot", args,
+		ArgRuleRequired("index", TypeAny, &indexArg),
+		ArgRuleRequired("columns", TypeAny, &columnsArg),
+		ArgRuleRequired("values", TypeAny, &valuesArg),
+		ArgRuleOptional("agg", TypeAny, &how, NewStr("first")),
+	)
+	meta, rows := ptableData(c, this)
+	var (
+		indexCols = meta.colIndexes(c, []Value{indexArg}, "PTable.pivot")
+		colsCol   = meta.colIndex(c, columnsArg, "PTable.pivot")
+		valuesCol = meta.colIndex(c, valuesArg, "PTable.pivot")
+		pivotCols = make([]string, 0)
+		pivotMap  = map[string]int{}
+	)
+	for _, row := range rows {
+		name := ptableCell(row, colsCol).ToString(c)
+		if _, found := pivotMap[name]; !found {
+			pivotMap[name] = len(pivotCols)
+			pivotCols = append(pivotCols, name)
+		}
+	}
+	headers := make([]string, 0, len(indexCols)+len(pivotCols))
+	formats := make([]string, 0, len(indexCols)+len(pivotCols))
+	for _, i := range indexCols {
+		headers = append(headers, meta.headers[i])
+		formats = append(formats, meta.format(i))
+	}
+	for _, name := range pivotCols {
+		headers = append(headers, name)
+		formats = append(formats, ptableAggFormat(c, meta, valuesCol, how))
+	}
+	groups := ptableGroupRows(c, rows, indexCols)
+	rvRows := make([][]Value, len(groups))
+	for gi, g := range groups {
+		cells := make([][]Value, len(pivotCols))
+		for _, ri := range g.rowIds {
+			p := pivotMap[ptableCell(rows[ri], colsCol).ToString(c)]
+			cells[p] = append(cells[p], ptableCell(rows[ri], valuesCol))
+		}
+		row := append([]Value{}, g.keys...)
+		for _, values := range cells {
+			if len(values) == 0 {
+				row = append(row, Nil())
+			} else {
+				row = append(row, ptableAggregate(c, how, values, "PTable.pivot"))
+			}
+		}
+		rvRows[gi] = row
+	}
+	return ptableNew(c, headers, formats, rvRows)
+}
+
+func ptableMelt(c *Context, this ValueObject, args []Value) Value {
+	var (
+		idArg     Value
+		valuesArg Value
+		varName   ValueStr
+		valueName ValueStr
+	)
+	EnsureFuncParams(c, "PTable.melt", args,
+		ArgRuleRequired("idCols", TypeAny, &idArg),
+		ArgRuleOptional("valueCols", TypeAny, &valuesArg, Nil()),
+		ArgRuleOptional("varName", TypeStr, &varName, NewStr("variable")),
+		ArgRuleOptional("valueName", TypeStr, &valueName, NewStr("value")),
+	)
+	meta, rows := ptableData(c, this)
+	idCols := meta.colIndexes(c, []Value{idArg}, "PTable.melt")
+	var valueCols []int
+	if dbIsNil(valuesArg) {
+		isId := map[int]bool{}
+		for _, i := range idCols {
+			isId[i] = true
+		}
+		for i := range meta.headers {
+			if !isId[i] {
+				valueCols = append(valueCols, i)
+			}
+		}
+	} else {
+		valueCols = meta.colIndexes(c, []Value{valuesArg}, "PTable.melt")
+	}
+	headers := make([]string, 0, len(idCols)+2)
+	formats := make([]string, 0, len(idCols)+2)
+	for _, i := range idCols {
+		headers = append(headers, meta.headers[i])
+		formats = append(formats, meta.format(i))
+	}
+	headers = append(headers, varName.Value(), valueName.Value())
+	formats = append(formats, "", "")
+	rvRows := make([][]Value, 0, len(rows)*len(valueCols))
+	for _, row := range rows {
+		ids := ptablePick(row, idCols)
+		for _, j := range valueCols {
+			newRow := append(append([]Value{}, ids...), NewStr(meta.headers[j]), ptableCell(row, j))
+			rvRows = append(rvRows, newRow)
+		}
+	}
+	return ptableNew(c, headers, formats, rvRows)
+}
+
+type ptableAggSpec struct {
+	name string
+	col  int
+	how  Value
+}
+
+// 聚合配置：{输出列: 'sum'|'avg'|fn}，输出列即源列；或{输出列: [源列, 'sum'|'avg'|fn]}
+func ptableParseAggSpecs(c *Context, meta *ptableMeta, spec ValueObject) []ptableAggSpec {
+	rv := make([]ptableAggSpec, 0)
+	spec.Iterate(func(name string, v Value) {
+		s := ptableAggSpec{name: name, how: v}
+		if arr, ok := v.(ValueArray); ok {
+			if arr.Len() != 2 {
+				c.RaiseRuntimeError("PTableGroup.agg: %s must be [column, aggregation]", name)
+			}
+			s.col = meta.colIndex(c, arr.GetIndex(0, c), "PTableGroup.agg")
+			s.how = arr.GetIndex(1, c)
+		} else {
+			s.col = meta.colIndex(c, NewStr(name), "PTableGroup.agg")
+		}
+		rv = append(rv, s)
+	})
+	// 对象成员无序，按源列顺序输出
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].col != rv[j].col {
+			return rv[i].col < rv[j].col
+		}
+		return rv[i].name < rv[j].name
+	})
+	return rv
+}
+
+func initPTableGroupClass() {
+	ptableGroupClass = NewClassBuilder("PTableGroup").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			this.SetMember("_table", args[0], c)
+			this.SetMember("_cols", args[1], c)
+		}).
+		Method("agg", func(c *Context, this ValueObject, args []Value) Value {
+			var spec ValueObject
+			EnsureFuncParams(c, "PTableGroup.agg", args, ArgRuleRequired("spec", TypeObject, &spec))
+			var (
+				meta, rows = ptableData(c, this.GetMember("_table", c))
+				cols       = this.GetMember("_cols", c).ToGoValue(c).([]int)
+				specs      = ptableParseAggSpecs(c, meta, spec)
+				headers    = make([]string, 0, len(cols)+len(specs))
+				formats    = make([]string, 0, len(cols)+len(specs))
+			)
+			for _, i := range cols {
+				headers = append(headers, meta.headers[i])
+				formats = append(formats, meta.format(i))
+			}
+			for _, s := range specs {
+				headers = append(headers, s.name)
+				formats = append(formats, ptableAggFormat(c, meta, s.col, s.how))
+			}
+			groups := ptableGroupRows(c, rows, cols)
+			rvRows := make([][]Value, len(groups))
+			for gi, g := range groups {
+				row := append([]Value{}, g.keys...)
+				for _, s := range specs {
+					values := make([]Value, len(g.rowIds))
+					for k, ri := range g.rowIds {
+						values[k] = ptableCell(rows[ri], s.col)
+					}
+					row = append(row, ptableAggregate(c, s.how, values, "PTableGroup.agg"))
+				}
+				rvRows[gi] = row
+			}
+			return ptableNew(c, headers, formats, rvRows)
+		}).
+		Method("count", func(c *Context, this ValueObject, args []Value) Value {
+			var name ValueStr
+			EnsureFuncParams(c, "PTableGroup.count", args,
+				ArgRuleOptional("name", TypeStr, &name, NewStr("count")),
+			)
+			var (
+				meta, rows = ptableData(c, this.GetMember("_table", c))
+				cols       = this.GetMember("_cols", c).ToGoValue(c).([]int)
+				headers    = make([]string, 0, len(cols)+1)
+				formats    = make([]string, 0, len(cols)+1)
+			)
+			for _, i := range cols {
+				headers = append(headers, meta.headers[i])
+				formats = append(formats, meta.format(i))
+			}
+			headers = append(headers, name.Value())
+			formats = append(formats, "")
+			groups := ptableGroupRows(c, rows, cols)
+			rvRows := make([][]Value, len(groups))
+			for gi, g := range groups {
+				rvRows[gi] = append(append([]Value{}, g.keys...), NewInt(int64(len(g.rowIds))))
+			}
+			return ptableNew(c, headers, formats, rvRows)
+		}).
+		Build()
+}
@@ -0,0 +1,80 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testPTableOpsSetup = `
+pt := import('ptable')
+t := pt('name', 'dept', 'salary')
+t.add('a', 'x', 10).add('b', 'x', 20).add('c', 'y', 5).add('a', 'x', 10)
+t.colFormat(2, '%03d')
+d := pt('dept', 'title')
+d.add('x', 'Eng').add('z', 'Law')
+s := pt('name', 'q', 'v')
+s.add('a', 'q1', 1).add('a', 'q2', 2).add('b', 'q1', 3).add('a', 'q1', 4)
+`
+
+func TestPTableOps(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"WhereSelectSort", `
+			export result := t.where(r => r.dept == 'x').select('name', 'salary').sortBy('salary', true).toArray()
+		`, []interface{}{
+			[]interface{}{"b", int64(20)},
+			[]interface{}{"a", int64(10)},
+			[]interface{}{"a", int64(10)},
+		}},
+		{"GroupByAgg", `
+			export result := t.groupBy('dept').agg({salary: 'sum', avg: ['salary', 'avg'], n: ['name', 'count']}).toArray(true)
+		`, []interface{}{
+			[]interface{}{"dept", "n", "avg", "salary"},
+			[]interface{}{"x", int64(3), 40.0 / 3, int64(40)},
+			[]interface{}{"y", int64(1), 5.0, int64(5)},
+		}},
+		{"DistinctWithColumn", `
+			export result := t.distinct().withColumn('double', r => r.salary * 2).csv()
+		`, "name,dept,salary,double\na,x,010,20\nb,x,020,40\nc,y,005,10\n"},
+		{"OuterJoin", `
+			export result := t.join(d, 'dept', 'outer').select('name', 'dept', 'title').toArray()
+		`, []interface{}{
+			[]interface{}{"a", "x", "Eng"},
+			[]interface{}{"b", "x", "Eng"},
+			[]interface{}{"c", "y", nil},
+			[]interface{}{"a", "x", "Eng"},
+			[]interface{}{nil, "z", "Law"},
+		}},
+		{"Pivot", `
+			export result := s.pivot('name', 'q', 'v', 'sum').toArray(true)
+		`, []interface{}{
+			[]interface{}{"name", "q1", "q2"},
+			[]interface{}{"a", int64(5), int64(2)},
+			[]interface{}{"b", int64(3), nil},
+		}},
+		{"Melt", `
+			export result := s.pivot('name', 'q', 'v', 'sum').melt('name').sortBy(['name', 'variable']).toArray()
+		`, []interface{}{
+			[]interface{}{"a", "q1", int64(5)},
+			[]interface{}{"a", "q2", int64(2)},
+			[]interface{}{"b", "q1", int64(3)},
+			[]interface{}{"b", "q2", nil},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			exported, err := zgg.RunCode(testPTableOpsSetup + tc.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
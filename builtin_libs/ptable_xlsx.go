@@ -0,0 +1,454 @@
+package builtin_libs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// PTable的xlsx读写，基于纯go实现的excelize
+
+var (
+	ptableWorkbookClass ValueType
+)
+
+type ptableWorkbook struct {
+	f    *excelize.File
+	path string
+	// 新建的工作簿自带一个空的Sheet1，第一次写入时直接改名使用
+	fresh      bool
+	dateStyles map[int]bool
+}
+
+func (wb *ptableWorkbook) sheetName(c *Context, sheet Value, fn string) string {
+	names := wb.f.GetSheetList()
+	switch s := sheet.(type) {
+	case ValueInt:
+		i := s.AsInt()
+		if i < 0 || i >= len(names) {
+			c.RaiseRuntimeError("%s: sheet index %d out of range", fn, i)
+		}
+		return names[i]
+	case ValueStr:
+		for _, name := range names {
+			if name == s.Value() {
+				return name
+			}
+		}
+		c.RaiseRuntimeError("%s: sheet %s not found", fn, s.Value())
+	default:
+		if dbIsNil(sheet) && len(names) > 0 {
+			return names[0]
+		}
+		c.RaiseRuntimeError("%s: sheet must be a string or an integer", fn)
+	}
+	return ""
+}
+
+// 内置的日期时间格式编号
+func ptableXlsxIsDateNumFmt(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+func (wb *ptableWorkbook) isDateStyle(styleId int) bool {
+	if styleId == 0 {
+		return false
+	}
+	if isDate, found := wb.dateStyles[styleId]; found {
+		return isDate
+	}
+	isDate := false
+	if style, err := wb.f.GetStyle(styleId); err == nil && style != nil {
+		if style.CustomNumFmt != nil {
+			// 去掉引号和方括号里的内容后，包含年月日时分秒的格式视为日期
+			var b strings.Builder
+			quoted, bracket := false, false
+			for _, r := range strings.ToLower(*style.CustomNumFmt) {
+				switch {
+				case r == '"':
+					quoted = !quoted
+				case r == '[' && !quoted:
+					bracket = true
+				case r == ']' && !quoted:
+					bracket = false
+				case !quoted && !bracket:
+					b.WriteRune(r)
+				}
+			}
+			isDate = strings.ContainsAny(b.String(), "ymdhs")
+		} else {
+			isDate = ptableXlsxIsDateNumFmt(style.NumFmt)
+		}
+	}
+	wb.dateStyles[styleId] = isDate
+	return isDate
+}
+
+func (wb *ptableWorkbook) date1904() bool {
+	props, err := wb.f.GetWorkbookProps()
+	return err == nil && props.Date1904 != nil && *props.Date1904
+}
+
+func ptableXlsxNumber(raw string) Value {
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return NewInt(i)
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return NewFloat(f)
+	}
+	return NewStr(raw)
+}
+
+// 按单元格类型转换为对应的值，公式单元格读取计算结果
+func (wb *ptableWorkbook) cellValue(c *Context, sheet string, col, row int, raw string) Value {
+	cell, _ := excelize.CoordinatesToCellName(col, row)
+	cellType, err := wb.f.GetCellType(sheet, cell)
+	if err != nil {
+		c.RaiseRuntimeError("read xlsx cell %s!%s error %s", sheet, cell, err)
+	}
+	if raw == "" {
+		// 没有缓存计算结果的公式单元格，在这里计算
+		if formula, _ := wb.f.GetCellFormula(sheet, cell); formula == "" {
+			return Nil()
+		} else if raw, err = wb.f.CalcCellValue(sheet, cell, excelize.Options{RawCellValue: true}); err != nil {
+			c.RaiseRuntimeError("calc xlsx cell %s!%s error %s", sheet, cell, err)
+		}
+		return ptableXlsxNumber(raw)
+	}
+	switch cellType {
+	case excelize.CellTypeSharedString, excelize.CellTypeInlineString, excelize.CellTypeFormula, excelize.CellTypeError:
+		return NewStr(raw)
+	case excelize.CellTypeBool:
+		return NewBool(raw == "1" || strings.EqualFold(raw, "true"))
+	case excelize.CellTypeDate:
+		if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			return NewObjectAndInit(timeTimeClass, c, NewGoValue(t))
+		}
+		return NewStr(raw)
+	}
+	rv := ptableXlsxNumber(raw)
+	styleId, _ := wb.f.GetCellStyle(sheet, cell)
+	if wb.isDateStyle(styleId) {
+		var days float64
+		switch v := rv.(type) {
+		case ValueInt:
+			days = float64(v.Value())
+		case ValueFloat:
+			days = v.Value()
+		default:
+			return rv
+		}
+		if t, err := excelize.ExcelDateToTime(days, wb.date1904()); err == nil {
+			// excel的日期没有时区，按本地时间解释
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+			return NewObjectAndInit(timeTimeClass, c, NewGoValue(t))
+		}
+	}
+	return rv
+}
+
+func (wb *ptableWorkbook) read(c *Context, sheetArg Value, headerRow int) ValueObject {
+	sheet := wb.sheetName(c, sheetArg, "Workbook.read")
+	rows, err := wb.f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		c.RaiseRuntimeError("read xlsx sheet %s error %s", sheet, err)
+	}
+	var headers []string
+	if headerRow > 0 {
+		if headerRow <= len(rows) {
+			headers = rows[headerRow-1]
+		}
+	} else {
+		// 没有表头行时用列名A、B、C...作表头
+		n := 0
+		for _, row := range rows {
+			if len(row) > n {
+				n = len(row)
+			}
+		}
+		for i := 1; i <= n; i++ {
+			name, _ := excelize.ColumnNumberToName(i)
+			headers = append(headers, name)
+		}
+	}
+	tableRows := make([][]Value, 0, len(rows))
+	for i := headerRow; i < len(rows); i++ {
+		row := make([]Value, len(headers))
+		for j := range row {
+			if j < len(rows[i]) {
+				row[j] = wb.cellValue(c, sheet, j+1, i+1, rows[i][j])
+			} else {
+				row[j] = Nil()
+			}
+		}
+		tableRows = append(tableRows, row)
+	}
+	return ptableNew(c, headers, nil, tableRows)
+}
+
+func ptableXlsxGoValue(c *Context, v Value) interface{} {
+	switch vv := v.(type) {
+	case ValueNil, ValueUndefined:
+		return nil
+	case ValueInt:
+		return vv.Value()
+	case ValueFloat:
+		return vv.Value()
+	case ValueBool:
+		return vv.Value()
+	case ValueStr:
+		return vv.Value()
+	case ValueObject:
+		if vv.Type() == timeTimeClass {
+			// 写入时保留时间在其时区下的字面值
+			t := vv.Reserved.(timeTimeInfo).t
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+	}
+	return v.ToString(c)
+}
+
+// colFormats可以是按列顺序的数组，也可以是{列名: 格式}
+func ptableXlsxColFormats(c *Context, meta *ptableMeta, conf Value) []string {
+	rv := make([]string, len(meta.headers))
+	switch cf := conf.(type) {
+	case ValueArray:
+		for i := 0; i < cf.Len() && i < len(rv); i++ {
+			if f := cf.GetIndex(i, c); !dbIsNil(f) {
+				rv[i] = f.ToString(c)
+			}
+		}
+	case ValueObject:
+		cf.Iterate(func(k string, f Value) {
+			rv[meta.colIndex(c, NewStr(k), "PTable.xlsx")] = f.ToString(c)
+		})
+	}
+	return rv
+}
+
+func (wb *ptableWorkbook) write(c *Context, table Value, opts Value) {
+	meta, rows := ptableData(c, table)
+	sheet := "Sheet1"
+	if s := opts.GetMember("sheetName", c); !dbIsNil(s) {
+		sheet = s.ToString(c)
+	}
+	if wb.fresh {
+		if err := wb.f.SetSheetName(wb.f.GetSheetName(0), sheet); err != nil {
+			c.RaiseRuntimeError("write xlsx sheet %s error %s", sheet, err)
+		}
+		wb.fresh = false
+	} else if idx, _ := wb.f.GetSheetIndex(sheet); idx >= 0 {
+		c.RaiseRuntimeError("write xlsx sheet %s error: sheet already exists", sheet)
+	} else if _, err := wb.f.NewSheet(sheet); err != nil {
+		c.RaiseRuntimeError("write xlsx sheet %s error %s", sheet, err)
+	}
+	headers := make([]interface{}, len(meta.headers))
+	widths := make([]int, len(meta.headers))
+	for i, h := range meta.headers {
+		headers[i] = h
+		widths[i] = StrWidth(h)
+	}
+	if err := wb.f.SetSheetRow(sheet, "A1", &headers); err != nil {
+		c.RaiseRuntimeError("write xlsx sheet %s error %s", sheet, err)
+	}
+	for i, row := range rows {
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			values[j] = ptableXlsxGoValue(c, v)
+			if j < len(widths) {
+				var w int
+				if t, isTime := values[j].(time.Time); isTime {
+					w = len(t.Format("2006-01-02 15:04:05"))
+				} else if values[j] != nil {
+					w = StrWidth(v.ToString(c))
+				}
+				if w > widths[j] {
+					widths[j] = w
+				}
+			}
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := wb.f.SetSheetRow(sheet, cell, &values); err != nil {
+			c.RaiseRuntimeError("write xlsx sheet %s row %d error %s", sheet, i, err)
+		}
+	}
+	for j, format := range ptableXlsxColFormats(c, meta, opts.GetMember("colFormats", c)) {
+		if format == "" || len(rows) == 0 {
+			continue
+		}
+		styleId, err := wb.f.NewStyle(&excelize.Style{CustomNumFmt: &format})
+		if err != nil {
+			c.RaiseRuntimeError("write xlsx sheet %s invalid format %s: %s", sheet, format, err)
+		}
+		top, _ := excelize.CoordinatesToCellName(j+1, 2)
+		bottom, _ := excelize.CoordinatesToCellName(j+1, len(rows)+1)
+		if err := wb.f.SetCellStyle(sheet, top, bottom, styleId); err != nil {
+			c.RaiseRuntimeError("write xlsx sheet %s error %s", sheet, err)
+		}
+	}
+	if autoWidth := opts.GetMember("autoWidth", c); dbIsNil(autoWidth) || autoWidth.IsTrue() {
+		for j, w := range widths {
+			name, _ := excelize.ColumnNumberToName(j + 1)
+			width := float64(w + 2)
+			if width > 80 {
+				width = 80
+			}
+			wb.f.SetColWidth(sheet, name, name, width)
+		}
+	}
+	if opts.GetMember("freezeHeader", c).IsTrue() {
+		err := wb.f.SetPanes(sheet, &excelize.Panes{
+			Freeze:      true,
+			YSplit:      1,
+			TopLeftCell: "A2",
+			ActivePane:  "bottomLeft",
+		})
+		if err != nil {
+			c.RaiseRuntimeError("write xlsx sheet %s freeze header error %s", sheet, err)
+		}
+	}
+}
+
+func (wb *ptableWorkbook) save(c *Context, path string) Value {
+	if path == "" {
+		buf, err := wb.f.WriteToBuffer()
+		if err != nil {
+			c.RaiseRuntimeError("write xlsx error %s", err)
+		}
+		return NewBytes(buf.Bytes())
+	}
+	if err := wb.f.SaveAs(path); err != nil {
+		c.RaiseRuntimeError("save xlsx %s error %s", path, err)
+	}
+	wb.path = path
+	return nil
+}
+
+func ptableOpenWorkbook(c *Context, path string) *ptableWorkbook {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		c.RaiseRuntimeError("open xlsx %s error %s", path, err)
+	}
+	return &ptableWorkbook{f: f, path: path, dateStyles: map[int]bool{}}
+}
+
+func ptableNewWorkbook() *ptableWorkbook {
+	return &ptableWorkbook{f: excelize.NewFile(), fresh: true, dateStyles: map[int]bool{}}
+}
+
+func ptableXlsxOptions(args []Value, i int) Value {
+	if i < len(args) && !dbIsNil(args[i]) {
+		return args[i]
+	}
+	return NewObject()
+}
+
+func ptableXlsxHeaderRow(c *Context, opts Value) int {
+	headerRow := 1
+	if h := opts.GetMember("headerRow", c); !dbIsNil(h) {
+		headerRow = int(c.MustInt(h))
+	}
+	if headerRow < 0 {
+		c.RaiseRuntimeError("headerRow must not be negative")
+	}
+	return headerRow
+}
+
+var ptableFromXlsx = NewNativeFunction("ptable.fromXlsx", func(c *Context, this Value, args []Value) Value {
+	var path ValueStr
+	EnsureFuncParams(c, "ptable.fromXlsx", args,
+		ArgRuleRequired("path", TypeStr, &path),
+	)
+	opts := ptableXlsxOptions(args, 1)
+	wb := ptableOpenWorkbook(c, path.Value())
+	defer wb.f.Close()
+	return wb.read(c, opts.GetMember("sheet", c), ptableXlsxHeaderRow(c, opts))
+}, "path", "options")
+
+func ptableXlsx(c *Context, this ValueObject, args []Value) Value {
+	var path ValueStr
+	EnsureFuncParams(c, "PTable.xlsx", args,
+		ArgRuleOptional("path", TypeStr, &path, NewStr("")),
+	)
+	wb := ptableNewWorkbook()
+	defer wb.f.Close()
+	wb.write(c, this, ptableXlsxOptions(args, 1))
+	if rv := wb.save(c, path.Value()); rv != nil {
+		return rv
+	}
+	return this
+}
+
+func initPTableWorkbookClass() {
+	getWorkbook := func(this ValueObject) *ptableWorkbook {
+		return this.Reserved.(*ptableWorkbook)
+	}
+	ptableWorkbookClass = NewClassBuilder("Workbook").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var path ValueStr
+			EnsureFuncParams(c, "Workbook.__init__", args,
+				ArgRuleOptional("path", TypeStr, &path, NewStr("")),
+			)
+			if p := path.Value(); p == "" {
+				this.Reserved = ptableNewWorkbook()
+			} else if _, err := os.Stat(p); os.IsNotExist(err) {
+				wb := ptableNewWorkbook()
+				wb.path = p
+				this.Reserved = wb
+			} else {
+				this.Reserved = ptableOpenWorkbook(c, p)
+			}
+		}).
+		Method("sheets", func(c *Context, this ValueObject, args []Value) Value {
+			wb := getWorkbook(this)
+			if wb.fresh {
+				return NewArray()
+			}
+			names := wb.f.GetSheetList()
+			rv := NewArray(len(names))
+			for _, name := range names {
+				rv.PushBack(NewStr(name))
+			}
+			return rv
+		}).
+		Method("read", func(c *Context, this ValueObject, args []Value) Value {
+			var sheet Value = Nil()
+			if len(args) > 0 {
+				sheet = args[0]
+			}
+			opts := ptableXlsxOptions(args, 1)
+			return getWorkbook(this).read(c, sheet, ptableXlsxHeaderRow(c, opts))
+		}, "sheet", "options").
+		Method("write", func(c *Context, this ValueObject, args []Value) Value {
+			var table ValueObject
+			EnsureFuncParams(c, "Workbook.write", args,
+				ArgRuleRequired("table", TypeObject, &table),
+			)
+			ptableMustTable(c, table, "Workbook.write")
+			getWorkbook(this).write(c, table, ptableXlsxOptions(args, 1))
+			return this
+		}, "table", "options").
+		Method("save", func(c *Context, this ValueObject, args []Value) Value {
+			wb := getWorkbook(this)
+			var path ValueStr
+			EnsureFuncParams(c, "Workbook.save", args,
+				ArgRuleOptional("path", TypeStr, &path, NewStr(wb.path)),
+			)
+			if rv := wb.save(c, path.Value()); rv != nil {
+				return rv
+			}
+			return this
+		}, "path").
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			if err := getWorkbook(this).f.Close(); err != nil {
+				c.RaiseRuntimeError("close xlsx error %s", err)
+			}
+			return Undefined()
+		}).
+		Build()
+}
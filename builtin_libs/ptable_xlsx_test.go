@@ -0,0 +1,96 @@
+package builtin_libs_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	zgg "github.com/zgg-lang/zgg-go"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const testPTableXlsxSetup = `
+pt := import('ptable')
+time := import('time')
+`
+
+// testXlsxFixture 生成带公式和日期格式单元格的xlsx文件
+func testXlsxFixture(t *testing.T, path string) {
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", "calc")
+	f.SetSheetRow("calc", "A1", &[]interface{}{"x", "y", "sum", "day"})
+	f.SetSheetRow("calc", "A2", &[]interface{}{1, 2})
+	f.SetCellFormula("calc", "C2", "A2+B2")
+	f.SetCellValue("calc", "D2", 45000)
+	style, _ := f.NewStyle(&excelize.Style{NumFmt: 14})
+	f.SetCellStyle("calc", "D2", "D2", style)
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPTableXlsx(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"RoundTrip", `
+			t := pt('name', 'n', 'price', 'ok', 'at')
+			t.add('a', 1, 1.5, true, time.Time('2024-03-05 10:20:30'))
+			t.add('b', 2, 2.25, false, nil)
+			t.xlsx('{dir}/t.xlsx', {sheetName: 'data', colFormats: {price: '0.00'}, freezeHeader: true})
+			r := pt.fromXlsx('{dir}/t.xlsx')
+			export result := [r.columns(), r.toArray()]
+		`, []interface{}{
+			[]interface{}{"name", "n", "price", "ok", "at"},
+			[]interface{}{
+				[]interface{}{"a", int64(1), 1.5, true, time.Date(2024, time.March, 5, 10, 20, 30, 0, time.Local)},
+				[]interface{}{"b", int64(2), 2.25, false, nil},
+			},
+		}},
+		{"WorkbookAddSheet", `
+			t := pt('name')
+			t.add('a').add('b')
+			pt.Workbook('{dir}/fixture.xlsx').write(t, {sheetName: 'names'}).save()
+			wb := pt.Workbook('{dir}/fixture.xlsx')
+			export result := [wb.sheets(), wb.read('names').toArray()]
+			wb.close()
+		`, []interface{}{
+			[]interface{}{"calc", "names"},
+			[]interface{}{[]interface{}{"a"}, []interface{}{"b"}},
+		}},
+		{"FormulaAndDate", `
+			wb := pt.Workbook('{dir}/fixture.xlsx')
+			export result := wb.read('calc').toArray()
+			wb.close()
+		`, []interface{}{
+			[]interface{}{int64(1), int64(2), int64(3), time.Date(2023, time.March, 15, 0, 0, 0, 0, time.Local)},
+		}},
+		{"NoHeaderRow", `
+			wb := pt.Workbook('{dir}/fixture.xlsx')
+			export result := wb.read(0, {headerRow: 0}).toArray(true)
+			wb.close()
+		`, []interface{}{
+			[]interface{}{"A", "B", "C", "D"},
+			[]interface{}{"x", "y", "sum", "day"},
+			[]interface{}{int64(1), int64(2), int64(3), time.Date(2023, time.March, 15, 0, 0, 0, 0, time.Local)},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			testXlsxFixture(t, filepath.Join(dir, "fixture.xlsx"))
+			exported, err := zgg.RunCode(strings.ReplaceAll(testPTableXlsxSetup+tc.code, "{dir}", dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exported["result"], tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, exported["result"])
+			}
+		})
+	}
+}
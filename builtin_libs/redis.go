@@ -1,6 +1,7 @@
 package builtin_libs
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"time"
@@ -13,6 +14,8 @@ import (
 var (
 	redisClientClass      ValueType
 	redisPipeSessionClass ValueType
+	redisScriptClass      ValueType
+	redisTransactionClass ValueType
 )
 
 func libRedis(*Context) ValueObject {
@@ -22,53 +25,178 @@ func libRedis(*Context) ValueObject {
 		EnsureFuncParams(c, "redis.open", args,
 			ArgRuleOptional("addr", TypeStr, &redisAddr, NewStr("127.0.0.1:6379")),
 		)
-		opts := make([]redis.DialOption, 0)
-		for i := 1; i < len(args); i++ {
-			switch v := args[i].(type) {
-			case ValueInt:
-				opts = append(opts, redis.DialDatabase(v.AsInt()))
-			case ValueObject:
-				if val, ok := v.GetMember("username", c).(ValueStr); ok {
-					opts = append(opts, redis.DialUsername(val.Value()))
-				}
-				if val, ok := v.GetMember("password", c).(ValueStr); ok {
-					opts = append(opts, redis.DialPassword(val.Value()))
-				}
-				if val, ok := v.GetMember("database", c).(ValueInt); ok {
-					opts = append(opts, redis.DialDatabase(val.AsInt()))
-				}
-				switch val := v.GetMember("readTimeout", c).(type) {
-				case ValueFloat:
-					opts = append(opts, redis.DialReadTimeout(time.Duration(val.Value())*time.Second))
-				case ValueInt:
-					opts = append(opts, redis.DialReadTimeout(time.Duration(val.Value())*time.Second))
-				}
-				switch val := v.GetMember("connTimeout", c).(type) {
-				case ValueFloat:
-					opts = append(opts, redis.DialConnectTimeout(time.Duration(val.Value())*time.Second))
-				case ValueInt:
-					opts = append(opts, redis.DialConnectTimeout(time.Duration(val.Value())*time.Second))
-				}
-				switch val := v.GetMember("writeTimeout", c).(type) {
-				case ValueFloat:
-					opts = append(opts, redis.DialWriteTimeout(time.Duration(val.Value())*time.Second))
-				case ValueInt:
-					opts = append(opts, redis.DialWriteTimeout(time.Duration(val.Value())*time.Second))
-				}
-			}
+		opts, _ := redisDialOptions(c, args)
+		ctx := c.Ctx
+		dial := func() (redis.Conn, error) {
+			return redis.DialContext(ctx, "tcp", redisAddr.Value(), opts...)
 		}
-		conn, err := redis.DialContext(c.Ctx, "tcp", redisAddr.Value(), opts...)
+		conn, err := dial()
 		if err != nil {
 			c.RaiseRuntimeError("redis.open error: %s", err)
 			return nil
 		}
-		return NewObjectAndInit(redisClientClass, c, NewGoValue(conn))
+		return NewObjectAndInit(redisClientClass, c, NewGoValue(&redisClientInfo{conn: conn, dial: dial}))
 	}), nil)
+	lib.SetMember("pool", NewNativeFunction("pool", func(c *Context, this Value, args []Value) Value {
+		var redisAddr ValueStr
+		EnsureFuncParams(c, "redis.pool", args,
+			ArgRuleOptional("addr", TypeStr, &redisAddr, NewStr("127.0.0.1:6379")),
+		)
+		opts, optsObj := redisDialOptions(c, args)
+		pool := &redis.Pool{
+			MaxIdle: 10,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", redisAddr.Value(), opts...)
+			},
+		}
+		if optsObj != nil {
+			if val, ok := optsObj.GetMember("maxIdle", c).(ValueInt); ok {
+				pool.MaxIdle = val.AsInt()
+			}
+			if val, ok := optsObj.GetMember("maxActive", c).(ValueInt); ok {
+				pool.MaxActive = val.AsInt()
+			}
+			if val, ok := redisSeconds(optsObj.GetMember("idleTimeout", c)); ok {
+				pool.IdleTimeout = val
+			}
+			if val, ok := redisSeconds(optsObj.GetMember("maxConnLifetime", c)); ok {
+				pool.MaxConnLifetime = val
+			}
+			pool.Wait = optsObj.GetMember("wait", c).IsTrue()
+		}
+		return NewObjectAndInit(redisClientClass, c, NewGoValue(&redisClientInfo{pool: pool}))
+	}), nil)
+	lib.SetMember("decode", redisDecoders(), nil)
 	lib.SetMember("RedisClient", redisClientClass, nil)
 	lib.SetMember("RedisPipeSession", redisPipeSessionClass, nil)
+	lib.SetMember("RedisScript", redisScriptClass, nil)
+	lib.SetMember("RedisTransaction", redisTransactionClass, nil)
 	return lib
 }
 
+func redisSeconds(v Value) (time.Duration, bool) {
+	switch val := v.(type) {
+	case ValueFloat:
+		return time.Duration(val.Value() * float64(time.Second)), true
+	case ValueInt:
+		return time.Duration(val.Value()) * time.Second, true
+	}
+	return 0, false
+}
+
+// redisDialOptions 解析open/pool地址之后的连接参数，整数表示数据库编号，对象为详细配置
+func redisDialOptions(c *Context, args []Value) ([]redis.DialOption, ValueObject) {
+	var (
+		opts    = make([]redis.DialOption, 0)
+		optsObj ValueObject
+	)
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch v := arg.(type) {
+		case ValueInt:
+			opts = append(opts, redis.DialDatabase(v.AsInt()))
+		case ValueObject:
+			optsObj = v
+			if val, ok := v.GetMember("username", c).(ValueStr); ok {
+				opts = append(opts, redis.DialUsername(val.Value()))
+			}
+			if val, ok := v.GetMember("password", c).(ValueStr); ok {
+				opts = append(opts, redis.DialPassword(val.Value()))
+			}
+			if val, ok := v.GetMember("database", c).(ValueInt); ok {
+				opts = append(opts, redis.DialDatabase(val.AsInt()))
+			}
+			if val, ok := redisSeconds(v.GetMember("readTimeout", c)); ok {
+				opts = append(opts, redis.DialReadTimeout(val))
+			}
+			if val, ok := redisSeconds(v.GetMember("connTimeout", c)); ok {
+				opts = append(opts, redis.DialConnectTimeout(val))
+			}
+			if val, ok := redisSeconds(v.GetMember("writeTimeout", c)); ok {
+				opts = append(opts, redis.DialWriteTimeout(val))
+			}
+		}
+	}
+	return opts, optsObj
+}
+
+// redisClientInfo open得到的客户端独占一个连接，pool得到的客户端每条命令从连接池取连接
+type redisClientInfo struct {
+	conn redis.Conn
+	dial func() (redis.Conn, error)
+	pool *redis.Pool
+}
+
+// get 返回执行普通命令用的连接，用完需调用release
+func (info *redisClientInfo) get(c *Context) (conn redis.Conn, release func()) {
+	if info.pool == nil {
+		return info.conn, func() {}
+	}
+	conn = info.pool.Get()
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		c.RaiseRuntimeError("redis: get connection from pool error %s", err)
+	}
+	return conn, func() { conn.Close() }
+}
+
+// dedicated 返回一个新连接，用于订阅等会占住连接的操作
+func (info *redisClientInfo) dedicated(c *Context) redis.Conn {
+	dial := info.dial
+	if info.pool != nil {
+		// 不从池中取，避免订阅状态的连接被放回池里
+		dial = info.pool.Dial
+	}
+	conn, err := dial()
+	if err != nil {
+		c.RaiseRuntimeError("redis: dial error %s", err)
+	}
+	return conn
+}
+
+func redisClientOf(c *Context, this ValueObject) *redisClientInfo {
+	return this.Reserved.(*redisClientInfo)
+}
+
+func redisReplyValue(c *Context, rsp interface{}) Value {
+	if rsp == nil {
+		return Nil()
+	}
+	return FromGoValue(reflect.ValueOf(rsp), c)
+}
+
+func redisDo(c *Context, funcName string, conn redis.Conn, cmd string, args []Value) Value {
+	cmdArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg.ToGoValue(c)
+	}
+	rsp, err := conn.Do(cmd, cmdArgs...)
+	if err != nil {
+		if err == redis.ErrNil {
+			return Nil()
+		}
+		c.RaiseRuntimeError("%s error %s", funcName, err)
+		return nil
+	}
+	return redisReplyValue(c, rsp)
+}
+
+// redisReceiveAll 发送完管道中的命令后读取全部回复
+func redisReceiveAll(c *Context, funcName string, conn redis.Conn, n int) ValueArray {
+	if err := conn.Flush(); err != nil {
+		c.RaiseRuntimeError("%s: flush piped command error: %s", funcName, err)
+	}
+	rv := NewArray(n)
+	for i := 0; i < n; i++ {
+		rsp, err := conn.Receive()
+		if err != nil {
+			c.RaiseRuntimeError("%s: receive piped command error: %s", funcName, err)
+		}
+		rv.PushBack(redisReplyValue(c, rsp))
+	}
+	return rv
+}
+
 type redisPipeCmd struct {
 	cmd  string
 	args []interface{}
@@ -106,21 +234,49 @@ func initRedisPipeSessionClass() ValueType {
 func initRedisClientClass() ValueType {
 	rv := NewClassBuilder("RedisClient").
 		Constructor(func(c *Context, thisObj ValueObject, args []Value) {
-			thisObj.SetMember("_conn", args[0], c)
+			switch v := args[0].ToGoValue(c).(type) {
+			case *redisClientInfo:
+				thisObj.Reserved = v
+			case redis.Conn:
+				thisObj.Reserved = &redisClientInfo{conn: v, dial: func() (redis.Conn, error) {
+					return nil, errors.New("client is not reconnectable")
+				}}
+			default:
+				c.RaiseRuntimeError("RedisClient: invalid connection")
+			}
 		}).
 		Method("close", func(c *Context, this ValueObject, args []Value) Value {
-			conn := this.GetMember("_conn", c).ToGoValue(c).(redis.Conn)
-			if err := conn.Close(); err != nil {
+			info := redisClientOf(c, this)
+			var err error
+			if info.pool != nil {
+				err = info.pool.Close()
+			} else {
+				err = info.conn.Close()
+			}
+			if err != nil {
 				c.RaiseRuntimeError("RedisClient.close fail on close: %s", err)
 			}
 			return Undefined()
 		}).
+		Method("stats", func(c *Context, this ValueObject, args []Value) Value {
+			info := redisClientOf(c, this)
+			if info.pool == nil {
+				return Nil()
+			}
+			stats := info.pool.Stats()
+			rv := NewObject()
+			rv.SetMember("activeCount", NewInt(int64(stats.ActiveCount)), c)
+			rv.SetMember("idleCount", NewInt(int64(stats.IdleCount)), c)
+			rv.SetMember("waitCount", NewInt(stats.WaitCount), c)
+			return rv
+		}).
 		Method("exec", func(c *Context, this ValueObject, args []Value) Value {
 			if len(args) < 1 {
 				c.RaiseRuntimeError("RedisClient.exec requires at least 1 argument")
 				return nil
 			}
-			conn := this.GetMember("_conn", c).ToGoValue(c).(redis.Conn)
+			conn, release := redisClientOf(c, this).get(c)
+			defer release()
 			if cmds, ok := args[0].(ValueArray); ok {
 				n := cmds.Len()
 				for i := 0; i < n; i++ {
@@ -147,42 +303,10 @@ func initRedisClientClass() ValueType {
 						c.RaiseRuntimeError("redis.exec: send piped command error: %s", err)
 					}
 				}
-				if err := conn.Flush(); err != nil {
-					c.RaiseRuntimeError("redis.exec: flush piped command error: %s", err)
-				}
-				rv := NewArray(n)
-				for i := 0; i < n; i++ {
-					rsp, err := conn.Receive()
-					if err != nil {
-						c.RaiseRuntimeError("redis.exec: receive piped command error: %s", err)
-					}
-					if rsp == nil {
-						rv.PushBack(Nil())
-					} else {
-						v := reflect.ValueOf(rsp)
-						rv.PushBack(FromGoValue(v, c))
-					}
-				}
-				return rv
+				return redisReceiveAll(c, "redis.exec", conn, n)
 			} else {
 				cmd := c.MustStr(args[0], "command")
-				cmdArgs := make([]interface{}, len(args)-1)
-				for i, arg := range args[1:] {
-					cmdArgs[i] = arg.ToGoValue(c)
-				}
-				rsp, err := conn.Do(cmd, cmdArgs...)
-				if err != nil {
-					if err == redis.ErrNil {
-						return Nil()
-					}
-					c.RaiseRuntimeError("RedisClient.exec error %s", err)
-					return nil
-				}
-				if rsp == nil {
-					return Nil()
-				}
-				v := reflect.ValueOf(rsp)
-				return FromGoValue(v, c)
+				return redisDo(c, "RedisClient.exec", conn, cmd, args[1:])
 			}
 		}).
 		Method("pipe", func(c *Context, this ValueObject, args []Value) Value {
@@ -190,53 +314,37 @@ func initRedisClientClass() ValueType {
 			session := NewObjectAndInit(redisPipeSessionClass, c)
 			c.Invoke(action, nil, Args(session))
 			cmds := session.GetMember("_cmds", c).ToGoValue(c).([]redisPipeCmd)
-			conn := this.GetMember("_conn", c).ToGoValue(c).(redis.Conn)
+			conn, release := redisClientOf(c, this).get(c)
+			defer release()
 			for _, cmd := range cmds {
 				if err := conn.Send(cmd.cmd, cmd.args...); err != nil {
 					c.RaiseRuntimeError("redis.pipe: send piped command error: %s", err)
 				}
 			}
-			if err := conn.Flush(); err != nil {
-				c.RaiseRuntimeError("redis.pipe: flush piped command error: %s", err)
-			}
-			n := len(cmds)
-			rv := NewArray(n)
-			for i := 0; i < n; i++ {
-				rsp, err := conn.Receive()
-				if err != nil {
-					c.RaiseRuntimeError("redis.exec: receive piped command error: %s", err)
-				}
-				if rsp == nil {
-					rv.PushBack(Nil())
-				} else {
-					v := reflect.ValueOf(rsp)
-					rv.PushBack(FromGoValue(v, c))
-				}
-			}
-			return rv
+			return redisReceiveAll(c, "redis.pipe", conn, len(cmds))
+		}).
+		Method("subscribe", func(c *Context, this ValueObject, args []Value) Value {
+			return redisSubscribe(c, redisClientOf(c, this), "RedisClient.subscribe", false, args)
+		}).
+		Method("psubscribe", func(c *Context, this ValueObject, args []Value) Value {
+			return redisSubscribe(c, redisClientOf(c, this), "RedisClient.psubscribe", true, args)
+		}).
+		Method("script", func(c *Context, this ValueObject, args []Value) Value {
+			var src ValueStr
+			EnsureFuncParams(c, "RedisClient.script", args, ArgRuleRequired("lua", TypeStr, &src))
+			return NewObjectAndInit(redisScriptClass, c, this, src)
+		}).
+		Method("transaction", func(c *Context, this ValueObject, args []Value) Value {
+			return redisTransaction(c, redisClientOf(c, this), args)
 		}).
 		Method("__getAttr__", func(c *Context, this ValueObject, args []Value) Value {
 			cmd := c.MustStr(args[0])
 			if cmd == strings.ToUpper(cmd) {
-				conn := this.GetMember("_conn", c).ToGoValue(c).(redis.Conn)
+				info := redisClientOf(c, this)
 				return NewNativeFunction("", func(c *Context, this Value, args []Value) Value {
-					cmdArgs := make([]interface{}, len(args))
-					for i, arg := range args {
-						cmdArgs[i] = arg.ToGoValue(c)
-					}
-					rsp, err := conn.Do(cmd, cmdArgs...)
-					if err != nil {
-						if err == redis.ErrNil {
-							return Nil()
-						}
-						c.RaiseRuntimeError("RedisClient.exec error %s", err)
-						return nil
-					}
-					if rsp == nil {
-						return Nil()
-					}
-					v := reflect.ValueOf(rsp)
-					return FromGoValue(v, c)
+					conn, release := info.get(c)
+					defer release()
+					return redisDo(c, "RedisClient.exec", conn, cmd, args)
 				})
 			} else {
 				return Undefined()
@@ -249,4 +357,99 @@ func initRedisClientClass() ValueType {
 func init() {
 	redisClientClass = initRedisClientClass()
 	redisPipeSessionClass = initRedisPipeSessionClass()
+	redisScriptClass = initRedisScriptClass()
+	redisTransactionClass = initRedisTransactionClass()
+}
+
+// redisDecoders 把命令回复转换为常用类型，回复为nil时返回nil
+func redisDecoders() ValueObject {
+	decoders := NewObject()
+	define := func(name string, decode func(c *Context, reply interface{}) (Value, error)) {
+		decoders.SetMember(name, NewNativeFunction("redis.decode."+name, func(c *Context, this Value, args []Value) Value {
+			var reply Value
+			EnsureFuncParams(c, "redis.decode."+name, args, ArgRuleRequired("reply", TypeAny, &reply))
+			if dbIsNil(reply) {
+				return Nil()
+			}
+			rv, err := decode(c, reply.ToGoValue(c))
+			if err != nil {
+				c.RaiseRuntimeError("redis.decode.%s: %s", name, err)
+			}
+			return rv
+		}, "reply"), nil)
+	}
+	define("str", func(c *Context, reply interface{}) (Value, error) {
+		s, err := redis.String(reply, nil)
+		return NewStr(s), err
+	})
+	define("bytes", func(c *Context, reply interface{}) (Value, error) {
+		bs, err := redis.Bytes(reply, nil)
+		return NewBytes(bs), err
+	})
+	define("int", func(c *Context, reply interface{}) (Value, error) {
+		n, err := redis.Int64(reply, nil)
+		return NewInt(n), err
+	})
+	define("float", func(c *Context, reply interface{}) (Value, error) {
+		f, err := redis.Float64(reply, nil)
+		return NewFloat(f), err
+	})
+	define("bool", func(c *Context, reply interface{}) (Value, error) {
+		b, err := redis.Bool(reply, nil)
+		return NewBool(b), err
+	})
+	define("strs", func(c *Context, reply interface{}) (Value, error) {
+		values, err := redis.Values(reply, nil)
+		rv := NewArray(len(values))
+		for _, v := range values {
+			if v == nil {
+				rv.PushBack(Nil())
+			} else if s, e := redis.String(v, nil); e != nil {
+				return nil, e
+			} else {
+				rv.PushBack(NewStr(s))
+			}
+		}
+		return rv, err
+	})
+	define("ints", func(c *Context, reply interface{}) (Value, error) {
+		values, err := redis.Values(reply, nil)
+		rv := NewArray(len(values))
+		for _, v := range values {
+			if v == nil {
+				rv.PushBack(Nil())
+			} else if n, e := redis.Int64(v, nil); e != nil {
+				return nil, e
+			} else {
+				rv.PushBack(NewInt(n))
+			}
+		}
+		return rv, err
+	})
+	define("floats", func(c *Context, reply interface{}) (Value, error) {
+		values, err := redis.Float64s(reply, nil)
+		rv := NewArray(len(values))
+		for _, v := range values {
+			rv.PushBack(NewFloat(v))
+		}
+		return rv, err
+	})
+	// object 把HGETALL等返回的field/value交替列表转为对象
+	define("object", func(c *Context, reply interface{}) (Value, error) {
+		m, err := redis.StringMap(reply, nil)
+		rv := NewObject()
+		for k, v := range m {
+			rv.SetMember(k, NewStr(v), c)
+		}
+		return rv, err
+	})
+	define("intObject", func(c *Context, reply interface{}) (Value, error) {
+		m, err := redis.Int64Map(reply, nil)
+		rv := NewObject()
+		for k, v := range m {
+			rv.SetMember(k, NewInt(v), c)
+		}
+		return rv, err
+	})
+	return decoders
 }
@@ -0,0 +1,135 @@
+package builtin_libs
+
+import (
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// redisSubscribe 在独立连接上订阅频道。传入handler时循环回调直到handler返回false，
+// 否则返回逐条产出消息的可迭代对象。timeout秒内没有新消息时结束
+func redisSubscribe(c *Context, info *redisClientInfo, funcName string, pattern bool, args []Value) Value {
+	var (
+		channels []interface{}
+		handler  Value
+		timeout  time.Duration
+	)
+	if len(args) < 1 {
+		c.RaiseRuntimeError("%s requires at least 1 argument", funcName)
+	}
+	if arr, ok := args[0].(ValueArray); ok {
+		for i := 0; i < arr.Len(); i++ {
+			channels = append(channels, arr.GetIndex(i, c).ToString(c))
+		}
+	} else {
+		channels = append(channels, args[0].ToString(c))
+	}
+	for _, arg := range args[1:] {
+		if c.IsCallable(arg) {
+			handler = arg
+		} else if opts, ok := arg.(ValueObject); ok {
+			if val, ok := redisSeconds(opts.GetMember("timeout", c)); ok {
+				timeout = val
+			}
+		}
+	}
+	psc := redis.PubSubConn{Conn: info.dedicated(c)}
+	var err error
+	if pattern {
+		err = psc.PSubscribe(channels...)
+	} else {
+		err = psc.Subscribe(channels...)
+	}
+	if err != nil {
+		psc.Close()
+		c.RaiseRuntimeError("%s error %s", funcName, err)
+	}
+	// 在单独的goroutine里读取，这样可以响应超时和取消
+	received := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		defer close(received)
+		for {
+			msg := psc.Receive()
+			select {
+			case received <- msg:
+			case <-done:
+				return
+			}
+			if _, isErr := msg.(error); isErr {
+				return
+			}
+		}
+	}()
+	closed := false
+	closeFn := func() {
+		if !closed {
+			closed = true
+			close(done)
+			psc.Close()
+		}
+	}
+	next := func() Value {
+		for {
+			var timer <-chan time.Time
+			if timeout > 0 {
+				timer = time.After(timeout)
+			}
+			var msg interface{}
+			select {
+			case msg = <-received:
+			case <-timer:
+				return nil
+			case <-c.Ctx.Done():
+				closeFn()
+				c.AbortIfCancelled()
+				return nil
+			}
+			switch m := msg.(type) {
+			case nil:
+				return nil
+			case redis.Message:
+				rv := NewObject()
+				if m.Pattern != "" {
+					rv.SetMember("type", NewStr("pmessage"), c)
+				} else {
+					rv.SetMember("type", NewStr("message"), c)
+				}
+				rv.SetMember("channel", NewStr(m.Channel), c)
+				rv.SetMember("pattern", NewStr(m.Pattern), c)
+				rv.SetMember("data", NewStr(string(m.Data)), c)
+				return rv
+			case redis.Subscription:
+				if m.Count == 0 {
+					return nil
+				}
+			case error:
+				if closed {
+					return nil
+				}
+				closeFn()
+				c.RaiseRuntimeError("%s receive error %s", funcName, m)
+			}
+		}
+	}
+	if handler == nil {
+		return MakeIterator(c, func() Value {
+			if closed {
+				return nil
+			}
+			return next()
+		}, closeFn)
+	}
+	defer closeFn()
+	count := 0
+	for msg := next(); msg != nil; msg = next() {
+		count++
+		c.Invoke(handler, nil, Args(msg))
+		if ret, ok := c.RetVal.(ValueBool); ok && !ret.Value() {
+			break
+		}
+	}
+	return NewInt(int64(count))
+}
@@ -0,0 +1,178 @@
+package builtin_libs
+
+import (
+	"strings"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type redisScriptInfo struct {
+	client *redisClientInfo
+	script *redis.Script
+}
+
+type redisTxInfo struct {
+	conn  redis.Conn
+	multi bool
+}
+
+// initRedisScriptClass 脚本对象通过EVALSHA执行，服务端没有缓存时自动改用EVAL
+func initRedisScriptClass() ValueType {
+	call := func(c *Context, this ValueObject, args []Value) Value {
+		info := this.Reserved.(*redisScriptInfo)
+		cmdArgs := []interface{}{0}
+		if len(args) > 0 {
+			if keys, ok := args[0].(ValueArray); ok {
+				cmdArgs[0] = keys.Len()
+				for i := 0; i < keys.Len(); i++ {
+					cmdArgs = append(cmdArgs, keys.GetIndex(i, c).ToGoValue(c))
+				}
+			} else if !dbIsNil(args[0]) {
+				c.RaiseRuntimeError("RedisScript.call: keys must be an array")
+			}
+			for _, arg := range args[1:] {
+				cmdArgs = append(cmdArgs, arg.ToGoValue(c))
+			}
+		}
+		conn, release := info.client.get(c)
+		defer release()
+		rsp, err := info.script.Do(conn, cmdArgs...)
+		if err != nil {
+			if err == redis.ErrNil {
+				return Nil()
+			}
+			c.RaiseRuntimeError("RedisScript.call error %s", err)
+		}
+		return redisReplyValue(c, rsp)
+	}
+	return NewClassBuilder("RedisScript").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var (
+				client ValueObject
+				src    ValueStr
+			)
+			EnsureFuncParams(c, "RedisScript.__init__", args,
+				ArgRuleRequired("client", TypeObject, &client),
+				ArgRuleRequired("lua", TypeStr, &src),
+			)
+			info, ok := client.Reserved.(*redisClientInfo)
+			if !ok {
+				c.RaiseRuntimeError("RedisScript.__init__: client must be a RedisClient")
+			}
+			script := redis.NewScript(-1, src.Value())
+			this.Reserved = &redisScriptInfo{client: info, script: script}
+			this.SetMember("hash", NewStr(script.Hash()), c)
+		}).
+		Method("call", call).
+		Method("__call__", call).
+		Method("load", func(c *Context, this ValueObject, args []Value) Value {
+			info := this.Reserved.(*redisScriptInfo)
+			conn, release := info.client.get(c)
+			defer release()
+			if err := info.script.Load(conn); err != nil {
+				c.RaiseRuntimeError("RedisScript.load error %s", err)
+			}
+			return this
+		}).
+		Build()
+}
+
+// initRedisTransactionClass 调用multi()之前的命令立即执行（用于读取WATCH的key），之后的命令进入事务队列
+func initRedisTransactionClass() ValueType {
+	return NewClassBuilder("RedisTransaction").
+		Method("multi", func(c *Context, this ValueObject, args []Value) Value {
+			info := this.Reserved.(*redisTxInfo)
+			if info.multi {
+				c.RaiseRuntimeError("RedisTransaction.multi: already in multi")
+			}
+			if _, err := info.conn.Do("MULTI"); err != nil {
+				c.RaiseRuntimeError("RedisTransaction.multi error %s", err)
+			}
+			info.multi = true
+			return this
+		}).
+		Method("__getAttr__", func(c *Context, this ValueObject, args []Value) Value {
+			cmd := c.MustStr(args[0])
+			if cmd != strings.ToUpper(cmd) {
+				return Undefined()
+			}
+			info := this.Reserved.(*redisTxInfo)
+			return NewNativeFunction("", func(c *Context, this Value, args []Value) Value {
+				return redisDo(c, "RedisTransaction.exec", info.conn, cmd, args)
+			})
+		}).
+		Build()
+}
+
+// redisTransaction 执行fn(tx)并提交事务。watch的key被修改导致EXEC失败时最多重试retries次，
+// 仍失败或fn没有调用multi()时返回nil，否则返回EXEC的结果
+func redisTransaction(c *Context, client *redisClientInfo, args []Value) Value {
+	var (
+		fn      Value
+		opts    ValueObject
+		watch   []interface{}
+		retries int64
+	)
+	EnsureFuncParams(c, "RedisClient.transaction", args,
+		ArgRuleRequired("fn", TypeCallable, &fn),
+		ArgRuleOptional("opts", TypeObject, &opts, NewObject()),
+	)
+	switch keys := opts.GetMember("watch", c).(type) {
+	case ValueArray:
+		for i := 0; i < keys.Len(); i++ {
+			watch = append(watch, keys.GetIndex(i, c).ToString(c))
+		}
+	case ValueStr:
+		watch = append(watch, keys.Value())
+	}
+	if n, ok := opts.GetMember("retries", c).(ValueInt); ok {
+		retries = n.Value()
+	}
+	attempt := func() (Value, bool) {
+		conn, release := client.get(c)
+		info := &redisTxInfo{conn: conn}
+		finished := false
+		defer func() {
+			if !finished {
+				// fn出错时复位连接状态
+				if info.multi {
+					conn.Do("DISCARD")
+				} else if len(watch) > 0 {
+					conn.Do("UNWATCH")
+				}
+			}
+			release()
+		}()
+		if len(watch) > 0 {
+			if _, err := conn.Do("WATCH", watch...); err != nil {
+				c.RaiseRuntimeError("RedisClient.transaction: watch error %s", err)
+			}
+		}
+		tx := NewObjectAndInit(redisTransactionClass, c)
+		tx.Reserved = info
+		c.Invoke(fn, nil, Args(tx))
+		if !info.multi {
+			return Nil(), true
+		}
+		finished = true
+		rsp, err := conn.Do("EXEC")
+		if err != nil {
+			if err == redis.ErrNil {
+				return Nil(), false
+			}
+			c.RaiseRuntimeError("RedisClient.transaction: exec error %s", err)
+		}
+		if rsp == nil {
+			return Nil(), false
+		}
+		return redisReplyValue(c, rsp), true
+	}
+	for i := int64(0); ; i++ {
+		rv, ok := attempt()
+		if ok || i >= retries {
+			return rv
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package builtin_libs_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+const testRedisSetup = `
+redis := import('redis')
+concurrent := import('concurrent')
+time := import('time')
+kv := import('kv')
+cli := redis.pool('{addr}', {maxIdle: 2})
+keysOf := it => {
+	rv := []
+	for k in it {
+		rv.push(k)
+	}
+	return rv
+}
+`
+
+func TestRedis(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"Decode", `
+			cli.HSET('h', 'a', 1, 'b', 2)
+			export result := [redis.decode.intObject(cli.HGETALL('h')), redis.decode.str(cli.GET('none'))]
+		`, []interface{}{map[string]interface{}{"a": int64(1), "b": int64(2)}, nil}},
+		{"ConcurrentPool", `
+			concurrent.all(() => cli.INCRBY('n', 1), () => cli.INCRBY('n', 2), () => cli.INCRBY('n', 3))
+			export result := redis.decode.int(cli.GET('n'))
+		`, int64(6)},
+		{"Script", `
+			cli.SET('n', 6)
+			script := cli.script('return redis.call(\'INCRBY\', KEYS[1], ARGV[1])')
+			export result := [script(['n'], 10), script.call(['n'], 1), len(script.hash)]
+		`, []interface{}{int64(16), int64(17), int64(40)}},
+		{"Transaction", `
+			cli.SET('n', 17)
+			export result := cli.transaction(tx => {
+				n := redis.decode.int(tx.GET('n'))
+				tx.multi()
+				tx.SET('n', n * 2)
+				tx.GET('n')
+			}, {watch: ['n']})
+		`, []interface{}{"OK", []byte("34")}},
+		{"TransactionWatchAborts", `
+			rv := cli.transaction(tx => {
+				tx.multi()
+				cli.SET('w', 'changed')
+				tx.SET('w', 'tx')
+			}, {watch: 'w'})
+			export result := [rv, redis.decode.str(cli.GET('w'))]
+		`, []interface{}{nil, "changed"}},
+		{"Subscribe", `
+			msgs := []
+			concurrent.all(() => {
+				cli.subscribe(['c1', 'c2'], m => {
+					msgs.push(m.channel + ':' + m.data)
+					return len(msgs) < 2
+				}, {timeout: 2})
+			}, () => {
+				time.sleep(0.2)
+				cli.PUBLISH('c1', 'x')
+				cli.PUBLISH('c2', 'y')
+			})
+			export result := msgs
+		`, []interface{}{"c1:x", "c2:y"}},
+		{"PSubscribe", `
+			msgs := []
+			concurrent.all(() => {
+				for m in cli.psubscribe('p.*', {timeout: 0.5}) {
+					msgs.push([m.type, m.pattern, m.channel, m.data])
+				}
+			}, () => {
+				time.sleep(0.2)
+				cli.PUBLISH('p.1', 'z')
+			})
+			export result := msgs
+		`, []interface{}{[]interface{}{"pmessage", "p.*", "p.1", "z"}}},
+		{"KvAdapter", `
+			m := kv.Manager('redis://{addr}?prefix=k:')
+			m.set('a', '1')
+			export result := [m.incr('a', 2), m.compareAndSwap('a', '3', 'x'), m.compareAndSwap('a', '3', 'y'), str(m.get('a')), keysOf(m.keys()), m.delete('a')]
+		`, []interface{}{int64(3), true, false, "x", []interface{}{"a"}, true}},
+		{"KvHashAdapter", `
+			m := kv.Manager('redishash://{addr}/hk')
+			export result := [m.compareAndSwap('f', nil, '1'), m.compareAndSwap('f', nil, '2'), m.incr('f'), keysOf(m.setMany({g: 1}).keys()), m.getMany(['g', 'x'])]
+		`, []interface{}{true, false, int64(2), []interface{}{"f", "g"}, []interface{}{[]byte("1"), nil}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			exported, err := zgg.RunCode(strings.ReplaceAll(testRedisSetup+tc.code, "{addr}", server.Addr()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package builtin_libs
 
 import (
+	"bufio"
+	"crypto/tls"
 	"io"
 	"net"
 	"time"
@@ -11,6 +13,7 @@ import (
 var (
 	tcpConnClass     ValueType
 	tcpListenerClass ValueType
+	tcpFramedClass   ValueType
 	tcpNoDeadline    ValueObject
 )
 
@@ -19,6 +22,7 @@ func libTcp(c *Context) ValueObject {
 	tcpNoDeadline = NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(-1)))
 	tcpInitConnClass()
 	tcpInitListenerClass()
+	tcpInitFramedClass()
 	lib.SetMember("connect", NewNativeFunction("connect", func(c *Context, _ Value, args []Value) Value {
 		var (
 			remoteAddr   ValueStr
@@ -44,8 +48,46 @@ func libTcp(c *Context) ValueObject {
 		}
 		return nil
 	}), nil)
+	lib.SetMember("connectTLS", NewNativeFunction("connectTLS", func(c *Context, _ Value, args []Value) Value {
+		var (
+			remoteAddr   ValueStr
+			options      ValueObject
+			waitDuration timeDurationArg
+		)
+		EnsureFuncParams(c, "connectTLS", args,
+			ArgRuleRequired("remoteAddr", TypeStr, &remoteAddr),
+			ArgRuleOptional("options", TypeObject, &options, NewObject()),
+			waitDuration.Rule(c, "waitDuration", tcpNoDeadline),
+		)
+		config := tlsConfigFromOptions(c, "connectTLS", options, false)
+		if config.ServerName == "" {
+			if host, _, err := net.SplitHostPort(remoteAddr.Value()); err == nil {
+				config.ServerName = host
+			}
+		}
+		dialer := &net.Dialer{}
+		if d := waitDuration.GetDuration(c); d >= 0 {
+			dialer.Timeout = d
+		}
+		conn, err := tls.DialWithDialer(dialer, "tcp", remoteAddr.Value(), config)
+		if err != nil {
+			c.RaiseRuntimeError("connecting to %s error %+v", remoteAddr.Value(), err)
+		}
+		return NewObjectAndInit(tcpConnClass, c, NewGoValue(conn))
+	}, "remoteAddr", "options", "waitDuration"), nil)
 	lib.SetMember("TcpListener", tcpListenerClass, nil)
 	lib.SetMember("listen", tcpListenerClass, nil)
+	lib.SetMember("listenTLS", NewNativeFunction("listenTLS", func(c *Context, _ Value, args []Value) Value {
+		var (
+			addr    ValueStr
+			options ValueObject
+		)
+		EnsureFuncParams(c, "listenTLS", args,
+			ArgRuleRequired("addr", TypeStr, &addr),
+			ArgRuleRequired("options", TypeObject, &options),
+		)
+		return NewObjectAndInit(tcpListenerClass, c, addr, options)
+	}, "addr", "options"), nil)
 	lib.SetMember("serve", NewNativeFunction("serve", func(c *Context, _ Value, args []Value) Value {
 		var (
 			argAddr    ValueStr
@@ -81,10 +123,12 @@ func libTcp(c *Context) ValueObject {
 
 type (
 	tcpConnReserved struct {
-		conn *net.TCPConn
+		conn net.Conn
+		// rd 在使用分帧读取后创建，之后recv也从中读取，避免丢失已缓冲的数据
+		rd *bufio.Reader
 	}
 	tcpListenerReserved struct {
-		listener *net.TCPListener
+		listener net.Listener
 	}
 )
 
@@ -96,7 +140,7 @@ func tcpInitConnClass() {
 				ArgRuleRequired("conn", TypeGoValue, &conn),
 			)
 			this.Reserved = &tcpConnReserved{
-				conn: conn.ToGoValue(c).(*net.TCPConn),
+				conn: conn.ToGoValue(c).(net.Conn),
 			}
 		}).
 		Method("addr", func(c *Context, this ValueObject, args []Value) Value {
@@ -150,14 +194,23 @@ func tcpInitConnClass() {
 				ArgRuleRequired("maxRecv", TypeInt, &maxRecv),
 				waitDuration.Rule(c, "waitDuration", tcpNoDeadline),
 			)
-			conn := this.Reserved.(*tcpConnReserved).conn
+			info := this.Reserved.(*tcpConnReserved)
+			conn := info.conn
 			buf := make([]byte, maxRecv.AsInt())
 			if d := waitDuration.GetDuration(c); d >= 0 {
 				if err := conn.SetReadDeadline(time.Now().Add(d)); err != nil {
 					c.RaiseRuntimeError("TcpConn.recv: recv set read deadline error %+v", err)
 				}
 			}
-			n, err := conn.Read(buf)
+			var (
+				n   int
+				err error
+			)
+			if info.rd != nil {
+				n, err = info.rd.Read(buf)
+			} else {
+				n, err = conn.Read(buf)
+			}
 			if err != nil {
 				if err == io.EOF {
 					return Nil()
@@ -168,28 +221,42 @@ func tcpInitConnClass() {
 			}
 			return NewBytes(buf[:n])
 		}, "maxRecv", "waitDuration").
+		Method("framed", func(c *Context, this ValueObject, args []Value) Value {
+			return NewObjectAndInit(tcpFramedClass, c, append([]Value{this}, args...)...)
+		}, "kind", "options").
 		Build()
 }
 
 func tcpInitListenerClass() {
 	tcpListenerClass = NewClassBuilder("TcpListener").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
-			var addr ValueStr
-			EnsureFuncParams(c, "TcpListener.__init__", args, ArgRuleRequired("addr", TypeStr, &addr))
+			var (
+				addr       ValueStr
+				tlsOptions ValueObject
+			)
+			EnsureFuncParams(c, "TcpListener.__init__", args,
+				ArgRuleRequired("addr", TypeStr, &addr),
+				ArgRuleOptional("tlsOptions", TypeObject, &tlsOptions, nil),
+			)
 			if laddr, err := net.ResolveTCPAddr("tcp", addr.Value()); err != nil {
 				c.RaiseRuntimeError("Resolve addr %s error %+v", addr.Value(), err)
 			} else if l, err := net.ListenTCP("tcp", laddr); err != nil {
 				c.RaiseRuntimeError("Create listener for addr %s error %+v", addr.Value(), err)
+			} else if tlsOptions != nil {
+				this.Reserved = &tcpListenerReserved{listener: tls.NewListener(l, tlsConfigFromOptions(c, "listenTLS", tlsOptions, true))}
 			} else {
 				this.Reserved = &tcpListenerReserved{listener: l}
 			}
 		}).
+		Method("addr", func(c *Context, this ValueObject, _ []Value) Value {
+			return NewStr(this.Reserved.(*tcpListenerReserved).listener.Addr().String())
+		}).
 		Method("close", func(c *Context, this ValueObject, _ []Value) Value {
 			this.Reserved.(*tcpListenerReserved).listener.Close()
 			return Undefined()
 		}).
 		Method("accept", func(c *Context, this ValueObject, _ []Value) Value {
-			conn, err := this.Reserved.(*tcpListenerReserved).listener.AcceptTCP()
+			conn, err := this.Reserved.(*tcpListenerReserved).listener.Accept()
 			if err != nil {
 				c.RaiseRuntimeError("accept error %+v", err)
 			}
@@ -199,7 +266,7 @@ func tcpInitListenerClass() {
 			var handler ValueCallable
 			EnsureFuncParams(c, "TcpListener.acceptLoop", args, ArgRuleRequired("handler", TypeCallable, &handler))
 			for {
-				conn, err := this.Reserved.(*tcpListenerReserved).listener.AcceptTCP()
+				conn, err := this.Reserved.(*tcpListenerReserved).listener.Accept()
 				if err != nil {
 					c.RaiseRuntimeError("accept error %+v", err)
 				}
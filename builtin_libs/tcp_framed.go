@@ -0,0 +1,327 @@
+package builtin_libs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+const tcpDefaultMaxFrameSize = 16 << 20
+
+type tcpFramed struct {
+	conn         net.Conn
+	rd           *bufio.Reader
+	kind         string
+	delim        []byte
+	lenSize      int
+	byteOrder    binary.ByteOrder
+	maxFrameSize int
+	wmu          sync.Mutex
+	// err 读取到一半出错后，流中的位置已不在帧边界上，之后的读取直接报错
+	err error
+}
+
+var errTcpFrameTooLarge = errors.New("frame too large")
+
+func newTcpFramed(c *Context, info *tcpConnReserved, kind string, delim []byte, maxFrameSize int) *tcpFramed {
+	if info.rd == nil {
+		info.rd = bufio.NewReader(info.conn)
+	}
+	f := &tcpFramed{conn: info.conn, rd: info.rd, kind: kind, maxFrameSize: maxFrameSize}
+	switch kind {
+	case "u8":
+		f.lenSize = 1
+	case "u16be", "u16le":
+		f.lenSize = 2
+	case "u32be", "u32le":
+		f.lenSize = 4
+	case "u64be", "u64le":
+		f.lenSize = 8
+	case "varint":
+	case "line":
+		f.delim = []byte("\n")
+	case "delim":
+		if len(delim) == 0 {
+			c.RaiseRuntimeError("TcpConn.framed: delim requires a non-empty delimiter")
+		}
+		f.delim = delim
+	default:
+		c.RaiseRuntimeError("TcpConn.framed: unknown frame kind %s", kind)
+	}
+	if f.lenSize > 1 {
+		if kind[len(kind)-2:] == "be" {
+			f.byteOrder = binary.BigEndian
+		} else {
+			f.byteOrder = binary.LittleEndian
+		}
+	}
+	return f
+}
+
+// readFrame 读取一帧，在帧边界上遇到EOF时返回nil
+func (f *tcpFramed) readFrame() ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if _, err := f.rd.Peek(1); err != nil {
+		// 尚未读取任何数据，超时后仍可重试
+		return nil, err
+	}
+	frame, err := f.readFrameBody()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		f.err = err
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (f *tcpFramed) readFrameBody() ([]byte, error) {
+	if f.delim != nil {
+		return f.readDelimited()
+	}
+	var size uint64
+	switch f.lenSize {
+	case 0:
+		n, err := binary.ReadUvarint(f.rd)
+		if err != nil {
+			return nil, err
+		}
+		size = n
+	default:
+		var head [8]byte
+		if _, err := io.ReadFull(f.rd, head[:f.lenSize]); err != nil {
+			return nil, err
+		}
+		switch f.lenSize {
+		case 1:
+			size = uint64(head[0])
+		case 2:
+			size = uint64(f.byteOrder.Uint16(head[:]))
+		case 4:
+			size = uint64(f.byteOrder.Uint32(head[:]))
+		case 8:
+			size = f.byteOrder.Uint64(head[:])
+		}
+	}
+	if size > uint64(f.maxFrameSize) {
+		return nil, fmt.Errorf("%w: %d > %d", errTcpFrameTooLarge, size, f.maxFrameSize)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(f.rd, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (f *tcpFramed) readDelimited() ([]byte, error) {
+	last := f.delim[len(f.delim)-1]
+	var frame []byte
+	for {
+		chunk, err := f.rd.ReadSlice(last)
+		if len(frame)+len(chunk) > f.maxFrameSize+len(f.delim) {
+			return nil, fmt.Errorf("%w: exceeds %d", errTcpFrameTooLarge, f.maxFrameSize)
+		}
+		frame = append(frame, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(frame, f.delim) {
+			frame = frame[:len(frame)-len(f.delim)]
+			if f.kind == "line" {
+				frame = bytes.TrimSuffix(frame, []byte("\r"))
+			}
+			return frame, nil
+		}
+	}
+}
+
+// writeFrame 把帧头(或分隔符)与内容一次写出，多个协程同时写也不会交错
+func (f *tcpFramed) writeFrame(frame []byte) error {
+	if len(frame) > f.maxFrameSize {
+		return fmt.Errorf("%w: %d > %d", errTcpFrameTooLarge, len(frame), f.maxFrameSize)
+	}
+	var pkg []byte
+	switch {
+	case f.delim != nil:
+		if bytes.Contains(frame, f.delim) {
+			return errors.New("frame contains delimiter")
+		}
+		pkg = append(append(make([]byte, 0, len(frame)+len(f.delim)), frame...), f.delim...)
+	case f.lenSize == 0:
+		pkg = binary.AppendUvarint(make([]byte, 0, len(frame)+binary.MaxVarintLen64), uint64(len(frame)))
+		pkg = append(pkg, frame...)
+	default:
+		if f.lenSize < 8 && uint64(len(frame)) >= 1<<(8*f.lenSize) {
+			return fmt.Errorf("%w for %s: %d", errTcpFrameTooLarge, f.kind, len(frame))
+		}
+		pkg = make([]byte, f.lenSize, f.lenSize+len(frame))
+		switch f.lenSize {
+		case 1:
+			pkg[0] = byte(len(frame))
+		case 2:
+			f.byteOrder.PutUint16(pkg, uint16(len(frame)))
+		case 4:
+			f.byteOrder.PutUint32(pkg, uint32(len(frame)))
+		case 8:
+			f.byteOrder.PutUint64(pkg, uint64(len(frame)))
+		}
+		pkg = append(pkg, frame...)
+	}
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	_, err := f.conn.Write(pkg)
+	return err
+}
+
+// tcpFrameContent 取出要发送的内容，支持bytes、字符串以及binary.Writer
+func tcpFrameContent(c *Context, v Value) []byte {
+	switch arg := v.(type) {
+	case ValueBytes:
+		return arg.Value()
+	case ValueObject:
+		if info, is := arg.Reserved.(*binaryWriterInfo); is {
+			if buf, is := info.w.(*bytes.Buffer); is {
+				return buf.Bytes()
+			}
+			c.RaiseRuntimeError("TcpFramed.writeFrame: binary.Writer is not a byte buffer")
+		}
+	}
+	return []byte(v.ToString(c))
+}
+
+func tcpInitFramedClass() {
+	readFrame := func(c *Context, this ValueObject, args []Value, funcName string) []byte {
+		var waitDuration timeDurationArg
+		EnsureFuncParams(c, funcName, args,
+			waitDuration.Rule(c, "waitDuration", tcpNoDeadline),
+		)
+		f := this.Reserved.(*tcpFramed)
+		if d := waitDuration.GetDuration(c); d >= 0 {
+			if err := f.conn.SetReadDeadline(time.Now().Add(d)); err != nil {
+				c.RaiseRuntimeError("%s: set read deadline error %+v", funcName, err)
+			}
+			defer f.conn.SetReadDeadline(time.Time{})
+		}
+		frame, err := f.readFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			c.RaiseRuntimeError("%s: read frame error %+v", funcName, err)
+		}
+		return frame
+	}
+	tcpFramedClass = NewClassBuilder("TcpFramed").
+		Constructor(func(c *Context, this ValueObject, args []Value) {
+			var (
+				conn    ValueObject
+				kind    ValueStr
+				delim   []byte
+				options ValueObject = NewObject()
+			)
+			EnsureFuncParams(c, "TcpConn.framed", args[:min(len(args), 2)],
+				ArgRuleRequired("conn", TypeObject, &conn),
+				ArgRuleRequired("kind", TypeStr, &kind),
+			)
+			rest := args[2:]
+			if kind.Value() == "delim" && len(rest) > 0 {
+				switch d := rest[0].(type) {
+				case ValueBytes:
+					delim = d.Value()
+				case ValueStr:
+					delim = []byte(d.Value())
+				default:
+					c.RaiseRuntimeError("TcpConn.framed: delimiter must be a str or bytes")
+				}
+				rest = rest[1:]
+			}
+			if len(rest) > 0 {
+				opts, is := rest[0].(ValueObject)
+				if !is {
+					c.RaiseRuntimeError("TcpConn.framed: options must be an object")
+				}
+				options = opts
+			}
+			maxFrameSize := tcpDefaultMaxFrameSize
+			if n, is := options.GetMember("maxFrameSize", c).(ValueInt); is {
+				maxFrameSize = n.AsInt()
+			}
+			info, is := conn.Reserved.(*tcpConnReserved)
+			if !is {
+				c.RaiseRuntimeError("TcpConn.framed: not a tcp connection")
+			}
+			this.Reserved = newTcpFramed(c, info, kind.Value(), delim, maxFrameSize)
+			this.SetMember("conn", conn, c)
+		}).
+		Method("readFrame", func(c *Context, this ValueObject, args []Value) Value {
+			if frame := readFrame(c, this, args, "TcpFramed.readFrame"); frame != nil {
+				return NewBytes(frame)
+			}
+			return Nil()
+		}, "waitDuration").
+		Method("readReader", func(c *Context, this ValueObject, args []Value) Value {
+			if frame := readFrame(c, this, args, "TcpFramed.readReader"); frame != nil {
+				reader := NewObjectAndInit(binaryReaderClass, c, NewBytes(frame))
+				if this.Reserved.(*tcpFramed).byteOrder == binary.BigEndian {
+					c.InvokeMethod(reader, "big", NoArgs)
+				}
+				return reader
+			}
+			return Nil()
+		}, "waitDuration").
+		Method("writeFrame", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				content      Value
+				waitDuration timeDurationArg
+			)
+			EnsureFuncParams(c, "TcpFramed.writeFrame", args,
+				ArgRuleRequired("content", TypeAny, &content),
+				waitDuration.Rule(c, "waitDuration", tcpNoDeadline),
+			)
+			f := this.Reserved.(*tcpFramed)
+			if d := waitDuration.GetDuration(c); d >= 0 {
+				if err := f.conn.SetWriteDeadline(time.Now().Add(d)); err != nil {
+					c.RaiseRuntimeError("TcpFramed.writeFrame: set write deadline error %+v", err)
+				}
+				defer f.conn.SetWriteDeadline(time.Time{})
+			}
+			if err := f.writeFrame(tcpFrameContent(c, content)); err != nil {
+				c.RaiseRuntimeError("TcpFramed.writeFrame: write frame error %+v", err)
+			}
+			return this
+		}, "content", "waitDuration").
+		Method("writer", func(c *Context, this ValueObject, args []Value) Value {
+			writer := NewObjectAndInit(binaryWriterClass, c)
+			if this.Reserved.(*tcpFramed).byteOrder == binary.BigEndian {
+				c.InvokeMethod(writer, "big", NoArgs)
+			}
+			return writer
+		}).
+		Method("__iter__", func(c *Context, this ValueObject, args []Value) Value {
+			iter := MakeIterator(c, func() Value {
+				if frame := readFrame(c, this, nil, "TcpFramed.__iter__"); frame != nil {
+					return NewBytes(frame)
+				}
+				return nil
+			}, func() {})
+			return c.InvokeMethod(iter, "__iter__", NoArgs)
+		}).
+		Method("close", func(c *Context, this ValueObject, args []Value) Value {
+			this.Reserved.(*tcpFramed).conn.Close()
+			return this
+		}).
+		Build()
+}
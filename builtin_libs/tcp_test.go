@@ -0,0 +1,254 @@
+package builtin_libs_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+// testTcpPeer 启动只处理一个连接的tcp服务，handle返回后关闭连接
+func testTcpPeer(t *testing.T, handle func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return ln.Addr().String()
+}
+
+// testTcpWriteSlowly 分多次写出，使对端每次只能读到部分数据
+func testTcpWriteSlowly(conn net.Conn, chunks ...string) {
+	for _, chunk := range chunks {
+		conn.Write([]byte(chunk))
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func testTcpExpectRead(t *testing.T, conn net.Conn, expected string) {
+	buf := make([]byte, len(expected))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Errorf("read %q error %s", expected, err)
+	} else if string(buf) != expected {
+		t.Errorf("expected to read %q, got %q", expected, buf)
+	}
+}
+
+const testTcpFramedSetup = `
+tcp := import('tcp')
+errorOf := f => {
+	try {
+		f()
+		return 'no error'
+	} catch (e) {
+		return e.message
+	}
+}
+`
+
+func TestTcpFramed(t *testing.T) {
+	cases := []struct {
+		name     string
+		peer     func(t *testing.T, conn net.Conn)
+		code     string
+		expected interface{}
+	}{
+		{"U16SplitFrames", func(t *testing.T, conn net.Conn) {
+			testTcpExpectRead(t, conn, "\x00\x03abc")
+			// 帧头和内容都被拆开发送
+			testTcpWriteSlowly(conn, "\x00", "\x05he", "llo")
+		}, `
+			f := tcp.connect('{addr}').framed('u16be')
+			f.writeFrame('abc')
+			export result := str(f.readFrame())
+		`, "hello"},
+		{"WriteTooLarge", func(t *testing.T, conn net.Conn) {}, `
+			f := tcp.connect('{addr}').framed('u16be', {maxFrameSize: 16})
+			export result := errorOf(() => f.writeFrame('x' * 20))
+		`, "TcpFramed.writeFrame: write frame error frame too large: 20 > 16"},
+		{"ReadTooLarge", func(t *testing.T, conn net.Conn) {
+			conn.Write([]byte("\x01\x00"))
+			time.Sleep(100 * time.Millisecond)
+		}, `
+			f := tcp.connect('{addr}').framed('u16be', {maxFrameSize: 16})
+			// 出错后流已不在帧边界上，之后的读取都返回同样的错误
+			export result := [errorOf(() => f.readFrame()), errorOf(() => f.readFrame())]
+		`, []interface{}{
+			"TcpFramed.readFrame: read frame error frame too large: 256 > 16",
+			"TcpFramed.readFrame: read frame error frame too large: 256 > 16",
+		}},
+		{"Varint", func(t *testing.T, conn net.Conn) {
+			testTcpExpectRead(t, conn, "\x05hello")
+			conn.Write([]byte("\x02hi\x00\x03abc"))
+		}, `
+			f := tcp.connect('{addr}').framed('varint')
+			f.writeFrame(bytes('hello'))
+			frames := []
+			for frame in f {
+				frames.push(str(frame))
+			}
+			export result := frames
+		`, []interface{}{"hi", "", "abc"}},
+		{"Line", func(t *testing.T, conn net.Conn) {
+			testTcpExpectRead(t, conn, "ping\n")
+			testTcpWriteSlowly(conn, "one\r\ntw", "o\nthr", "ee")
+		}, `
+			f := tcp.connect('{addr}').framed('line')
+			f.writeFrame('ping')
+			export result := [str(f.readFrame()), str(f.readFrame()), errorOf(() => f.readFrame())]
+		`, []interface{}{"one", "two", "TcpFramed.readFrame: read frame error unexpected EOF"}},
+		{"DelimInFrame", func(t *testing.T, conn net.Conn) {}, `
+			f := tcp.connect('{addr}').framed('delim', '||')
+			export result := errorOf(() => f.writeFrame('x||y'))
+		`, "TcpFramed.writeFrame: write frame error frame contains delimiter"},
+		{"Delim", func(t *testing.T, conn net.Conn) {
+			testTcpWriteSlowly(conn, "a|b|", "|c|", "|toolong||")
+		}, `
+			f := tcp.connect('{addr}').framed('delim', '||', {maxFrameSize: 4})
+			export result := [str(f.readFrame()), str(f.readFrame()), errorOf(() => f.readFrame())]
+		`, []interface{}{"a|b", "c", "TcpFramed.readFrame: read frame error frame too large: exceeds 4"}},
+		{"ReadReader", func(t *testing.T, conn net.Conn) {
+			conn.Write([]byte("\x04\x00\x00\x00\x01\x02hi"))
+		}, `
+			f := tcp.connect('{addr}').framed('u32le')
+			reader := f.readReader()
+			export result := [reader.u16(), str(reader.bytes(2))]
+		`, []interface{}{int64(0x0201), "hi"}},
+		{"EOFAtBoundary", func(t *testing.T, conn net.Conn) {
+			conn.Write([]byte("\x00\x00\x00\x00"))
+		}, `
+			f := tcp.connect('{addr}').framed('u32le')
+			export result := [str(f.readFrame()), f.readFrame()]
+		`, []interface{}{"", nil}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := testTcpPeer(t, func(conn net.Conn) { tc.peer(t, conn) })
+			exported, err := zgg.RunCode(strings.ReplaceAll(testTcpFramedSetup+tc.code, "{addr}", addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// testTlsCert 生成127.0.0.1及localhost的自签名证书，同时作为CA使用
+func testTlsCert(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zgg test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certPem, keyPem bytes.Buffer
+	pem.Encode(&certPem, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&keyPem, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPem.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPem.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testTcpTLSSetup = `
+tcp := import('tcp')
+concurrent := import('concurrent')
+ln := tcp.listenTLS('127.0.0.1:0', {certFile: '{dir}/cert.pem', keyFile: '{dir}/key.pem', caFile: '{dir}/cert.pem', clientAuth: true})
+server := concurrent.start(() => {
+	conn := ln.accept()
+	served := 'served'
+	try {
+		f := conn.framed('u8')
+		f.writeFrame('echo ' + str(f.readFrame()))
+	} catch (e) {
+		served = 'rejected'
+	}
+	conn.close()
+	return served
+})
+`
+
+func TestTcpTLS(t *testing.T) {
+	dir := t.TempDir()
+	testTlsCert(t, dir)
+	cases := []struct {
+		name     string
+		code     string
+		expected interface{}
+	}{
+		{"MutualAuth", `
+			client := tcp.connectTLS(ln.addr(), {certFile: '{dir}/cert.pem', keyFile: '{dir}/key.pem', caFile: '{dir}/cert.pem'}, 5).framed('u8')
+			client.writeFrame('hi')
+			reply := str(client.readFrame())
+			client.close()
+			export result := [reply, server.await()]
+		`, []interface{}{"echo hi", "served"}},
+		{"UnknownAuthority", `
+			unverified := 'connected'
+			try {
+				conn := tcp.connectTLS(ln.addr(), {}, 5)
+				conn.framed('u8').readFrame()
+			} catch (e) {
+				unverified = e.message.contains('certificate') ? 'unknown authority' : e.message
+			}
+			export result := [unverified, server.await()]
+		`, []interface{}{"unknown authority", "rejected"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code := strings.ReplaceAll(testTcpTLSSetup+tc.code+"\nln.close()\n", "{dir}", dir)
+			exported, err := zgg.RunCode(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package builtin_libs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// tlsConfigFromOptions 根据{certFile, keyFile, caFile, serverName, insecureSkipVerify, clientAuth}生成tls配置，
+// caFile在客户端用于校验服务端证书，在服务端用于校验客户端证书
+func tlsConfigFromOptions(c *Context, funcName string, opts ValueObject, isServer bool) *tls.Config {
+	config := &tls.Config{}
+	if v, ok := opts.GetMember("insecureSkipVerify", c).(ValueBool); ok {
+		config.InsecureSkipVerify = v.Value()
+	}
+	if v, ok := opts.GetMember("serverName", c).(ValueStr); ok {
+		config.ServerName = v.Value()
+	}
+	if caFile, ok := opts.GetMember("caFile", c).(ValueStr); ok {
+		ca, err := os.ReadFile(caFile.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: load ca file error %s", funcName, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			c.RaiseRuntimeError("%s: no certificate found in %s", funcName, caFile.Value())
+		}
+		if isServer {
+			config.ClientCAs = pool
+		} else {
+			config.RootCAs = pool
+		}
+	}
+	certFile, hasCert := opts.GetMember("certFile", c).(ValueStr)
+	keyFile, hasKey := opts.GetMember("keyFile", c).(ValueStr)
+	if hasCert && hasKey {
+		cert, err := tls.LoadX509KeyPair(certFile.Value(), keyFile.Value())
+		if err != nil {
+			c.RaiseRuntimeError("%s: load key pair error %s", funcName, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if isServer {
+		c.RaiseRuntimeError("%s: certFile and keyFile are required", funcName)
+	}
+	if isServer && opts.GetMember("clientAuth", c).IsTrue() {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
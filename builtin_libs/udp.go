@@ -1,10 +1,12 @@
 package builtin_libs
 
 import (
-	"fmt"
 	"net"
 	"time"
 
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
 	. "github.com/zgg-lang/zgg-go/runtime"
 )
 
@@ -13,12 +15,38 @@ var (
 	udpNoDeadline ValueObject
 )
 
+const udpMaxPacketSize = 65535
+
 func libUdp(c *Context) ValueObject {
 	lib := NewObject()
 	udpInitConnClass()
 	udpNoDeadline = NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Duration(-1)))
 	lib.SetMember("Conn", udpConnClass, c)
 	lib.SetMember("__call__", udpConnClass, c)
+	lib.SetMember("listenMulticast", NewNativeFunction("listenMulticast", func(c *Context, _ Value, args []Value) Value {
+		var (
+			group ValueStr
+			iface ValueStr
+		)
+		EnsureFuncParams(c, "listenMulticast", args,
+			ArgRuleRequired("group", TypeStr, &group),
+			ArgRuleOptional("iface", TypeStr, &iface, NewStr("")),
+		)
+		gaddr, err := net.ResolveUDPAddr("udp", group.Value())
+		if err != nil {
+			c.RaiseRuntimeError("listenMulticast: resolve address %s error %+v", group.Value(), err)
+		}
+		conn, err := net.ListenMulticastUDP("udp", udpInterface(c, "listenMulticast", iface.Value()), gaddr)
+		if err != nil {
+			c.RaiseRuntimeError("listenMulticast: listen %s error %+v", group.Value(), err)
+		}
+		return NewObjectAndInit(udpConnClass, c, NewGoValue(conn))
+	}, "group", "iface"), nil)
+	lib.SetMember("exchange", NewNativeFunction("exchange", func(c *Context, _ Value, args []Value) Value {
+		conn := NewObjectAndInit(udpConnClass, c)
+		defer conn.Reserved.(*udpConnReserved).conn.Close()
+		return c.InvokeMethod(conn, "exchange", Args(args...))
+	}, "raddr", "content", "timeout", "retries"), nil)
 	return lib
 }
 
@@ -28,9 +56,105 @@ type (
 	}
 )
 
+// udpInterface 按名称查找网卡，名称为空时返回nil，即由系统选择
+func udpInterface(c *Context, funcName, name string) *net.Interface {
+	if name == "" {
+		return nil
+	}
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		c.RaiseRuntimeError("%s: find interface %s error %+v", funcName, name, err)
+	}
+	return ifi
+}
+
+// udpGroupAddr 解析组播地址，可以带端口也可以只有IP
+func udpGroupAddr(c *Context, funcName, group string) *net.UDPAddr {
+	ip := net.ParseIP(group)
+	if ip == nil {
+		addr, err := net.ResolveUDPAddr("udp", group)
+		if err != nil {
+			c.RaiseRuntimeError("%s: resolve group %s error %+v", funcName, group, err)
+		}
+		ip = addr.IP
+	}
+	if !ip.IsMulticast() {
+		c.RaiseRuntimeError("%s: %s is not a multicast address", funcName, group)
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+func udpContent(c *Context, content Value) []byte {
+	switch arg := content.(type) {
+	case ValueBytes:
+		return arg.Value()
+	default:
+		return []byte(arg.ToString(c))
+	}
+}
+
+func udpIsIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
+// udpSetOption 设置ipv4或ipv6的socket选项，监听在[::]上的双栈socket两者都设置，有一个成功即可
+func udpSetOption(conn *net.UDPConn, v4 func(*ipv4.PacketConn) error, v6 func(*ipv6.PacketConn) error) error {
+	if la, is := conn.LocalAddr().(*net.UDPAddr); is && udpIsIPv4(la.IP) {
+		return v4(ipv4.NewPacketConn(conn))
+	}
+	err := v6(ipv6.NewPacketConn(conn))
+	if e := v4(ipv4.NewPacketConn(conn)); e == nil {
+		return nil
+	}
+	return err
+}
+
 func udpInitConnClass() {
+	// ipv4/ipv6的socket选项不同，根据目标地址选择
+	groupOp := func(name string, join bool) func(*Context, ValueObject, []Value) Value {
+		funcName := "Conn." + name
+		return func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				group ValueStr
+				iface ValueStr
+			)
+			EnsureFuncParams(c, funcName, args,
+				ArgRuleRequired("group", TypeStr, &group),
+				ArgRuleOptional("iface", TypeStr, &iface, NewStr("")),
+			)
+			conn := this.Reserved.(*udpConnReserved).conn
+			gaddr := udpGroupAddr(c, funcName, group.Value())
+			ifi := udpInterface(c, funcName, iface.Value())
+			var err error
+			if udpIsIPv4(gaddr.IP) {
+				p := ipv4.NewPacketConn(conn)
+				if join {
+					err = p.JoinGroup(ifi, gaddr)
+				} else {
+					err = p.LeaveGroup(ifi, gaddr)
+				}
+			} else {
+				p := ipv6.NewPacketConn(conn)
+				if join {
+					err = p.JoinGroup(ifi, gaddr)
+				} else {
+					err = p.LeaveGroup(ifi, gaddr)
+				}
+			}
+			if err != nil {
+				c.RaiseRuntimeError("%s: %s error %+v", funcName, group.Value(), err)
+			}
+			return this
+		}
+	}
 	udpConnClass = NewClassBuilder("Conn").
 		Constructor(func(c *Context, this ValueObject, args []Value) {
+			if len(args) == 1 {
+				if gv, is := args[0].(GoValue); is {
+					this.Reserved = &udpConnReserved{conn: gv.ToGoValue(c).(*net.UDPConn)}
+					return
+				}
+			}
 			var laddr ValueStr
 			EnsureFuncParams(c, "Conn.__init__", args,
 				ArgRuleOptional("laddr", TypeStr, &laddr, NewStr("")),
@@ -42,7 +166,6 @@ func udpInitConnClass() {
 				la, err = net.ResolveUDPAddr("udp", addr)
 				if err == nil {
 					conn, err = net.ListenUDP("udp", la)
-					fmt.Println("ListenUDP", la, err)
 				}
 			} else {
 				conn, err = net.ListenUDP("udp", nil)
@@ -84,14 +207,7 @@ func udpInitConnClass() {
 					c.RaiseRuntimeError("Conn.sendTo: sendTo set write deadline error %+v", err)
 				}
 			}
-			var pkg []byte
-			switch arg := content.(type) {
-			case ValueBytes:
-				pkg = arg.Value()
-			default:
-				pkg = []byte(arg.ToString(c))
-			}
-			n, err := conn.WriteTo(pkg, raddr)
+			n, err := conn.WriteTo(udpContent(c, content), raddr)
 			if err != nil {
 				if e, is := err.(net.Error); is && e.Timeout() {
 					return NewInt(0)
@@ -125,5 +241,152 @@ func udpInitConnClass() {
 			}
 			return NewArrayByValues(NewBytes(buf[:n]), NewStr(addr.String()))
 		}, "maxRecv", "waitDuration").
+		Method("joinGroup", groupOp("joinGroup", true), "group", "iface").
+		Method("leaveGroup", groupOp("leaveGroup", false), "group", "iface").
+		Method("setMulticastTTL", func(c *Context, this ValueObject, args []Value) Value {
+			var ttl ValueInt
+			EnsureFuncParams(c, "Conn.setMulticastTTL", args, ArgRuleRequired("ttl", TypeInt, &ttl))
+			err := udpSetOption(this.Reserved.(*udpConnReserved).conn,
+				func(p *ipv4.PacketConn) error { return p.SetMulticastTTL(ttl.AsInt()) },
+				func(p *ipv6.PacketConn) error { return p.SetMulticastHopLimit(ttl.AsInt()) },
+			)
+			if err != nil {
+				c.RaiseRuntimeError("Conn.setMulticastTTL: %+v", err)
+			}
+			return this
+		}, "ttl").
+		Method("setTTL", func(c *Context, this ValueObject, args []Value) Value {
+			var ttl ValueInt
+			EnsureFuncParams(c, "Conn.setTTL", args, ArgRuleRequired("ttl", TypeInt, &ttl))
+			err := udpSetOption(this.Reserved.(*udpConnReserved).conn,
+				func(p *ipv4.PacketConn) error { return p.SetTTL(ttl.AsInt()) },
+				func(p *ipv6.PacketConn) error { return p.SetHopLimit(ttl.AsInt()) },
+			)
+			if err != nil {
+				c.RaiseRuntimeError("Conn.setTTL: %+v", err)
+			}
+			return this
+		}, "ttl").
+		Method("setMulticastInterface", func(c *Context, this ValueObject, args []Value) Value {
+			var iface ValueStr
+			EnsureFuncParams(c, "Conn.setMulticastInterface", args, ArgRuleRequired("iface", TypeStr, &iface))
+			ifi := udpInterface(c, "Conn.setMulticastInterface", iface.Value())
+			err := udpSetOption(this.Reserved.(*udpConnReserved).conn,
+				func(p *ipv4.PacketConn) error { return p.SetMulticastInterface(ifi) },
+				func(p *ipv6.PacketConn) error { return p.SetMulticastInterface(ifi) },
+			)
+			if err != nil {
+				c.RaiseRuntimeError("Conn.setMulticastInterface: %+v", err)
+			}
+			return this
+		}, "iface").
+		Method("setMulticastLoopback", func(c *Context, this ValueObject, args []Value) Value {
+			var on ValueBool
+			EnsureFuncParams(c, "Conn.setMulticastLoopback", args, ArgRuleRequired("on", TypeBool, &on))
+			err := udpSetOption(this.Reserved.(*udpConnReserved).conn,
+				func(p *ipv4.PacketConn) error { return p.SetMulticastLoopback(on.Value()) },
+				func(p *ipv6.PacketConn) error { return p.SetMulticastLoopback(on.Value()) },
+			)
+			if err != nil {
+				c.RaiseRuntimeError("Conn.setMulticastLoopback: %+v", err)
+			}
+			return this
+		}, "on").
+		Method("setBroadcast", func(c *Context, this ValueObject, args []Value) Value {
+			var on ValueBool
+			EnsureFuncParams(c, "Conn.setBroadcast", args, ArgRuleRequired("on", TypeBool, &on))
+			if err := udpSetBroadcast(this.Reserved.(*udpConnReserved).conn, on.Value()); err != nil {
+				c.RaiseRuntimeError("Conn.setBroadcast: %+v", err)
+			}
+			return this
+		}, "on").
+		Method("broadcast", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				content      Value
+				port         ValueInt
+				waitDuration timeDurationArg
+			)
+			EnsureFuncParams(c, "Conn.broadcast", args,
+				ArgRuleRequired("content", TypeAny, &content),
+				ArgRuleRequired("port", TypeInt, &port),
+				waitDuration.Rule(c, "waitDuration", udpNoDeadline),
+			)
+			conn := this.Reserved.(*udpConnReserved).conn
+			if err := udpSetBroadcast(conn, true); err != nil {
+				c.RaiseRuntimeError("Conn.broadcast: %+v", err)
+			}
+			if d := waitDuration.GetDuration(c); d >= 0 {
+				if err := conn.SetWriteDeadline(time.Now().Add(d)); err != nil {
+					c.RaiseRuntimeError("Conn.broadcast: set write deadline error %+v", err)
+				}
+			}
+			n, err := conn.WriteTo(udpContent(c, content), &net.UDPAddr{IP: net.IPv4bcast, Port: port.AsInt()})
+			if err != nil {
+				if e, is := err.(net.Error); is && e.Timeout() {
+					return NewInt(0)
+				}
+				c.RaiseRuntimeError("Conn.broadcast: send error %+v", err)
+			}
+			return NewInt(int64(n))
+		}, "content", "port", "waitDuration").
+		Method("exchange", func(c *Context, this ValueObject, args []Value) Value {
+			var (
+				address ValueStr
+				content Value
+				timeout timeDurationArg
+				retries ValueInt
+			)
+			EnsureFuncParams(c, "Conn.exchange", args,
+				ArgRuleRequired("raddr", TypeStr, &address),
+				ArgRuleRequired("content", TypeAny, &content),
+				timeout.Rule(c, "timeout", NewObjectAndInit(timeDurationClass, c, NewGoValue(time.Second))),
+				ArgRuleOptional("retries", TypeInt, &retries, NewInt(2)),
+			)
+			raddr, err := net.ResolveUDPAddr("udp", address.Value())
+			if err != nil {
+				c.RaiseRuntimeError("Conn.exchange: resolve address %s error %+v", address.Value(), err)
+			}
+			rv := udpExchange(c, this.Reserved.(*udpConnReserved).conn, raddr, udpContent(c, content), timeout.GetDuration(c), retries.AsInt())
+			if rv == nil {
+				return Nil()
+			}
+			return rv
+		}, "raddr", "content", "timeout", "retries").
 		Build()
 }
+
+// udpExchange 发送请求并等待来自raddr的响应，返回[响应, 来源地址]，超时后重发，全部超时返回nil。
+// 发往组播或广播地址时接受任意来源的第一个响应
+func udpExchange(c *Context, conn *net.UDPConn, raddr *net.UDPAddr, pkg []byte, timeout time.Duration, retries int) Value {
+	anySource := raddr.IP.IsMulticast() || raddr.IP.Equal(net.IPv4bcast)
+	if raddr.IP.Equal(net.IPv4bcast) {
+		if err := udpSetBroadcast(conn, true); err != nil {
+			c.RaiseRuntimeError("Conn.exchange: %+v", err)
+		}
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, udpMaxPacketSize)
+	for attempt := 0; attempt <= retries; attempt++ {
+		if _, err := conn.WriteTo(pkg, raddr); err != nil {
+			c.RaiseRuntimeError("Conn.exchange: send error %+v", err)
+		}
+		deadline := time.Now().Add(timeout)
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			c.RaiseRuntimeError("Conn.exchange: set read deadline error %+v", err)
+		}
+		for {
+			c.AbortIfCancelled()
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if e, is := err.(net.Error); is && e.Timeout() {
+					break
+				}
+				c.RaiseRuntimeError("Conn.exchange: recv error %+v", err)
+			}
+			if anySource || (from.IP.Equal(raddr.IP) && from.Port == raddr.Port) {
+				return NewArrayByValues(NewBytes(append([]byte(nil), buf[:n]...)), NewStr(from.String()))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package builtin_libs
+
+import (
+	"net"
+	"syscall"
+)
+
+func udpSetBroadcast(conn *net.UDPConn, on bool) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	value := 0
+	if on {
+		value = 1
+	}
+	var setErr error
+	if err := raw.Control(func(fd uintptr) {
+		setErr = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, value)
+	}); err != nil {
+		return err
+	}
+	return setErr
+}
@@ -0,0 +1,155 @@
+package builtin_libs_test
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	zgg "github.com/zgg-lang/zgg-go"
+)
+
+// testUdpServer 启动udp服务，reply返回要回复的内容，返回nil时不回复
+func testUdpServer(t *testing.T, reply func(n int32, conn *net.UDPConn, from *net.UDPAddr, pkg []byte) []byte) (string, *int32) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var count int32
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if rsp := reply(atomic.AddInt32(&count, 1), conn, from, buf[:n]); rsp != nil {
+				conn.WriteToUDP(rsp, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), &count
+}
+
+func TestUdpExchange(t *testing.T) {
+	noise, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noise.Close()
+	cases := []struct {
+		name     string
+		reply    func(n int32, conn *net.UDPConn, from *net.UDPAddr, pkg []byte) []byte
+		code     string
+		expected interface{}
+		attempts int32
+	}{
+		{"RetryUntilReply", func(n int32, conn *net.UDPConn, from *net.UDPAddr, pkg []byte) []byte {
+			if n < 3 {
+				return nil
+			}
+			// 来自其他地址的包应被忽略
+			noise.WriteToUDP([]byte("noise"), from)
+			return append([]byte("pong:"), pkg...)
+		}, `
+			conn := udp.Conn()
+			rsp := conn.exchange('{addr}', 'ping', 0.1, 3)
+			conn.close()
+			export result := [str(rsp[0]), rsp[1] == '{addr}']
+		`, []interface{}{"pong:ping", true}, 3},
+		{"Timeout", func(int32, *net.UDPConn, *net.UDPAddr, []byte) []byte {
+			return nil
+		}, `
+			export result := udp.exchange('{addr}', 'ping', 0.05, 1)
+		`, nil, 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, count := testUdpServer(t, tc.reply)
+			exported, err := zgg.RunCode("udp := import('udp')\n" + strings.ReplaceAll(tc.code, "{addr}", addr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, actual)
+			}
+			if n := atomic.LoadInt32(count); n != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, n)
+			}
+		})
+	}
+}
+
+const testUdpMulticastSetup = `
+udp := import('udp')
+sender := udp.Conn().setMulticastLoopback(true).setMulticastTTL(1).setMulticastInterface('{iface}')
+`
+
+func TestUdpMulticastLoopback(t *testing.T) {
+	var iface string
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 {
+			iface = ifi.Name
+			break
+		}
+	}
+	if iface == "" {
+		t.Skip("no multicast interface")
+	}
+	cases := []struct {
+		name string
+		code string
+	}{
+		{"ListenMulticast", `
+			group := '239.255.77.1:{port}'
+			listener := udp.listenMulticast(group)
+			received := nil
+			for i := 0; i < 10 && received == nil; i++ {
+				sender.sendTo('hello', group)
+				pkg := listener.recvFrom(100, 0.2)
+				if len(pkg[0]) > 0 {
+					received = str(pkg[0])
+				}
+			}
+			listener.close()
+			export result := received
+		`},
+		{"JoinGroup", `
+			joined := udp.Conn('0.0.0.0:{port}').joinGroup('239.255.77.2', '{iface}')
+			received := nil
+			for i := 0; i < 10 && received == nil; i++ {
+				sender.sendTo('hello', '239.255.77.2:{port}')
+				pkg := joined.recvFrom(100, 0.2)
+				if len(pkg[0]) > 0 {
+					received = str(pkg[0])
+				}
+			}
+			joined.close()
+			export result := received
+		`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+			conn.Close()
+			code := strings.NewReplacer("{port}", port, "{iface}", iface).Replace(testUdpMulticastSetup + tc.code)
+			exported, err := zgg.RunCode(code)
+			if err != nil && strings.Contains(err.Error(), "network is unreachable") {
+				t.Skip("no multicast route")
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if actual := exported["result"]; actual != "hello" {
+				t.Fatalf("expected hello, got %#v", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zgg-lang/zgg-go/internal/exporter"
+)
+
+const zggModulePath = "github.com/zgg-lang/zgg-go"
+
+//go:embed buildruntime_pkg.tpl
+var buildRuntimePkgTpl string
+
+//go:embed buildruntime_main.tpl
+var buildRuntimeMainTpl string
+
+type buildRuntimePkg struct {
+	ImportPath string
+	Version    string
+	Mapping    string
+}
+
+func newBuildRuntimePkg(arg, mapping string) buildRuntimePkg {
+	p := buildRuntimePkg{ImportPath: arg, Mapping: mapping}
+	if i := strings.LastIndex(arg, "@"); i > 0 {
+		p.ImportPath, p.Version = arg[:i], arg[i+1:]
+	}
+	return p
+}
+
+type buildRuntimeEnv struct {
+	goCmd   string
+	workDir string
+	verbose bool
+	// 离线模式下使用的本地module cache目录
+	modCache string
+}
+
+func buildRuntimeLog(verbose bool, tag, msg string, args ...interface{}) {
+	if !verbose && tag == "VER" {
+		return
+	}
+	now := time.Now().Format("2006-01-02 15:04:05.000")
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	fmt.Fprintln(os.Stderr, now+"|"+tag+"|"+msg)
+}
+
+func (e *buildRuntimeEnv) run(args ...string) (string, error) {
+	buildRuntimeLog(e.verbose, "VER", "%s %s", e.goCmd, strings.Join(args, " "))
+	cmd := exec.Command(e.goCmd, args...)
+	cmd.Dir = e.workDir
+	cmd.Env = os.Environ()
+	if e.modCache != "" {
+		cmd.Env = append(cmd.Env, "GOPROXY=off", "GOFLAGS=-mod=mod")
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %s\n%s", e.goCmd, strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// 与scripts/gostd_pkgs.txt相同的格式：每行"包路径 [符号类型指定]"
+func buildRuntimeReadPkgsFile(filename string) ([]buildRuntimePkg, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rv []buildRuntimePkg
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if p := strings.Index(line, "//"); p >= 0 {
+			line = line[:p]
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			rv = append(rv, newBuildRuntimePkg(fields[0], ""))
+		default:
+			rv = append(rv, newBuildRuntimePkg(fields[0], fields[1]))
+		}
+	}
+	return rv, scanner.Err()
+}
+
+func buildRuntimeZggVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == zggModulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			return info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == zggModulePath {
+				return dep.Version
+			}
+		}
+	}
+	return "latest"
+}
+
+// module cache中的路径把大写字母转义为"!小写字母"
+func buildRuntimeEscapePath(p string) string {
+	var sb strings.Builder
+	for _, r := range p {
+		if r >= 'A' && r <= 'Z' {
+			sb.WriteRune('!')
+			r += 'a' - 'A'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func buildRuntimeVersionLess(a, b string) bool {
+	split := func(v string) ([]int, string) {
+		v = strings.TrimPrefix(v, "v")
+		pre := ""
+		if i := strings.IndexAny(v, "-+"); i >= 0 {
+			v, pre = v[:i], v[i:]
+		}
+		var nums []int
+		for _, s := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(s)
+			nums = append(nums, n)
+		}
+		return nums, pre
+	}
+	na, pa := split(a)
+	nb, pb := split(b)
+	for i := 0; i < len(na) && i < len(nb); i++ {
+		if na[i] != nb[i] {
+			return na[i] < nb[i]
+		}
+	}
+	if len(na) != len(nb) {
+		return len(na) < len(nb)
+	}
+	if pa == "" || pb == "" {
+		return pa != "" && pb == ""
+	}
+	return pa < pb
+}
+
+func (e *buildRuntimeEnv) cachedVersion(importPath string) string {
+	for modPath := importPath; modPath != "." && modPath != ""; modPath = filepath.ToSlash(filepath.Dir(modPath)) {
+		dir := filepath.Join(e.modCache, "cache", "download", filepath.FromSlash(buildRuntimeEscapePath(modPath)), "@v")
+		zips, _ := filepath.Glob(filepath.Join(dir, "*.zip"))
+		if len(zips) == 0 {
+			continue
+		}
+		versions := make([]string, len(zips))
+		for i, z := range zips {
+			versions[i] = strings.TrimSuffix(filepath.Base(z), ".zip")
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return buildRuntimeVersionLess(versions[i], versions[j])
+		})
+		return versions[len(versions)-1]
+	}
+	return ""
+}
+
+func buildRuntimeFileName(importPath string) string {
+	r := strings.NewReplacer("/", "__", ".", "_", "-", "_")
+	return "gopkg_" + r.Replace(importPath) + ".go"
+}
+
+func runBuildRuntime(args []string) {
+	var (
+		output   string
+		workDir  string
+		pkgsFile string
+		zggDir   string
+		goCmd    string
+		offline  bool
+		verbose  bool
+	)
+	flagset := flag.NewFlagSet("build-runtime", flag.ExitOnError)
+	flagset.StringVar(&output, "o", "zgg-custom", "生成的可执行文件路径")
+	flagset.StringVar(&workDir, "dir", "", "生成代码的工作目录，不指定时使用临时目录并在完成后删除")
+	flagset.StringVar(&pkgsFile, "f", "", "包列表文件，格式与scripts/gostd_pkgs.txt相同")
+	flagset.StringVar(&zggDir, "zgg", "", "本地zgg-go源码路径，指定时使用replace引用")
+	flagset.StringVar(&goCmd, "go", "go", "go命令路径")
+	flagset.BoolVar(&offline, "offline", false, "只使用本地module cache，不访问网络")
+	flagset.BoolVar(&verbose, "v", false, "show detail logs")
+	flagset.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: zgg build-runtime [options] importPath1[@version] importPath2[@version] ...")
+		flagset.PrintDefaults()
+	}
+	flagset.Parse(args)
+	var pkgs []buildRuntimePkg
+	if pkgsFile != "" {
+		filePkgs, err := buildRuntimeReadPkgsFile(pkgsFile)
+		if err != nil {
+			buildRuntimeLog(verbose, "ERR", "读取包列表文件%s失败: %s", pkgsFile, err)
+			os.Exit(1)
+		}
+		pkgs = append(pkgs, filePkgs...)
+	}
+	for _, p := range flagset.Args() {
+		pkgs = append(pkgs, newBuildRuntimePkg(p, ""))
+	}
+	if len(pkgs) == 0 {
+		flagset.Usage()
+		os.Exit(1)
+	}
+	if err := buildRuntime(pkgs, output, workDir, zggDir, goCmd, offline, verbose); err != nil {
+		buildRuntimeLog(verbose, "ERR", "%s", err)
+		os.Exit(1)
+	}
+}
+
+func buildRuntime(pkgs []buildRuntimePkg, output, workDir, zggDir, goCmd string, offline, verbose bool) error {
+	var err error
+	if output, err = filepath.Abs(output); err != nil {
+		return err
+	}
+	if workDir == "" {
+		if workDir, err = os.MkdirTemp("", "zgg-runtime-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+	} else if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+	env := &buildRuntimeEnv{goCmd: goCmd, workDir: workDir, verbose: verbose}
+	if offline {
+		modCache, err := env.run("env", "GOMODCACHE")
+		if err != nil {
+			return err
+		}
+		env.modCache = modCache
+	}
+	buildRuntimeLog(verbose, "INF", "正在%s生成自定义运行时代码", workDir)
+	// go.mod
+	gomod := "module zgg_custom_runtime\n\ngo 1.22\n"
+	zggVersion := buildRuntimeZggVersion()
+	if zggDir != "" {
+		if zggDir, err = filepath.Abs(zggDir); err != nil {
+			return err
+		}
+		zggVersion = "v0.0.0-00010101000000-000000000000"
+		gomod += fmt.Sprintf("\nrequire %s %s\n\nreplace %s => %s\n", zggModulePath, zggVersion, zggModulePath, zggDir)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "go.mod"), []byte(gomod), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "main.go"), []byte(buildRuntimeMainTpl), 0644); err != nil {
+		return err
+	}
+	if zggDir == "" {
+		if zggVersion == "latest" && env.modCache != "" {
+			// 开发版本无法确定自身版本号，离线时同样从module cache中选择
+			if zggVersion = env.cachedVersion(zggModulePath); zggVersion == "" {
+				return fmt.Errorf("本地module cache中没有%s，无法离线构建", zggModulePath)
+			}
+		}
+		if _, err := env.run("get", zggModulePath+"@"+zggVersion); err != nil {
+			return err
+		}
+	}
+	// 生成各个包的绑定代码
+	for _, p := range pkgs {
+		buildRuntimeLog(verbose, "INF", "正在导出%s", p.ImportPath)
+		target := p.ImportPath
+		if p.Version != "" {
+			target += "@" + p.Version
+		} else if env.modCache != "" {
+			// GOPROXY=off时无法查询latest，从本地module cache中选出已下载的最高版本
+			if version := env.cachedVersion(p.ImportPath); version != "" {
+				target += "@" + version
+			}
+		}
+		if _, err := env.run("get", target); err != nil {
+			return err
+		}
+		dir, err := env.run("list", "-f", "{{.Dir}}", p.ImportPath)
+		if err != nil {
+			return err
+		}
+		info, err := exporter.ExportPkg(goCmd, dir, p.ImportPath)
+		if err != nil {
+			return fmt.Errorf("导出%s失败: %w", p.ImportPath, err)
+		} else if info == nil {
+			return fmt.Errorf("找不到指定包:%s", p.ImportPath)
+		}
+		info.SetTypeMapping(p.Mapping)
+		code, err := info.Render(buildRuntimePkgTpl, true)
+		if err != nil {
+			return fmt.Errorf("导出%s失败: %w", p.ImportPath, err)
+		}
+		if err := os.WriteFile(filepath.Join(workDir, buildRuntimeFileName(p.ImportPath)), code, 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := env.run("mod", "tidy"); err != nil {
+		return err
+	}
+	buildRuntimeLog(verbose, "INF", "正在编译%s", output)
+	if _, err := env.run("build", "-o", output, "."); err != nil {
+		return err
+	}
+	buildRuntimeLog(verbose, "INF", "完成")
+	return nil
+}
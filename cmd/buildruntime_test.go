@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildRuntimeVersionLess(t *testing.T) {
+	cases := []struct {
+		a, b string
+		less bool
+	}{
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.10.0", "v1.9.0", false},
+		{"v1.2", "v1.2.1", true},
+		{"v1.2.0-rc1", "v1.2.0", true},
+		{"v1.2.0", "v1.2.0-rc1", false},
+		{"v1.2.0-alpha", "v1.2.0-beta", true},
+		{"v1.2.0", "v1.2.0", false},
+	}
+	for _, tc := range cases {
+		if got := buildRuntimeVersionLess(tc.a, tc.b); got != tc.less {
+			t.Errorf("buildRuntimeVersionLess(%s, %s) = %v", tc.a, tc.b, got)
+		}
+	}
+}
+
+func TestBuildRuntimeEscapePath(t *testing.T) {
+	if got := buildRuntimeEscapePath("github.com/BurntSushi/TOML"); got != "github.com/!burnt!sushi/!t!o!m!l" {
+		t.Errorf("got %s", got)
+	}
+	if got := buildRuntimeEscapePath("golang.org/x/text"); got != "golang.org/x/text" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestNewBuildRuntimePkg(t *testing.T) {
+	expected := buildRuntimePkg{ImportPath: "github.com/a/b", Version: "v1.0.0", Mapping: "Foo:struct"}
+	if got := newBuildRuntimePkg("github.com/a/b@v1.0.0", "Foo:struct"); got != expected {
+		t.Errorf("got %+v", got)
+	}
+	if got := newBuildRuntimePkg("strings", ""); got != (buildRuntimePkg{ImportPath: "strings"}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestBuildRuntimeReadPkgsFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "pkgs.txt")
+	content := "// 注释\nstrings\n\ngithub.com/a/b@v1.2.0 Foo:struct // 行尾注释\n   \nnet/url\n"
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := buildRuntimeReadPkgsFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []buildRuntimePkg{
+		{ImportPath: "strings"},
+		{ImportPath: "github.com/a/b", Version: "v1.2.0", Mapping: "Foo:struct"},
+		{ImportPath: "net/url"},
+	}
+	if !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("got %+v", pkgs)
+	}
+	if _, err := buildRuntimeReadPkgsFile(filepath.Join(t.TempDir(), "none.txt")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestBuildRuntimeCachedVersion(t *testing.T) {
+	modCache := t.TempDir()
+	dir := filepath.Join(modCache, "cache", "download", "github.com", "!foo", "bar", "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"v1.9.0", "v1.10.0", "v1.10.1-rc1"} {
+		if err := os.WriteFile(filepath.Join(dir, v+".zip"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := &buildRuntimeEnv{modCache: modCache}
+	if got := env.cachedVersion("github.com/Foo/bar/sub/pkg"); got != "v1.10.1-rc1" {
+		t.Errorf("got %s", got)
+	}
+	if got := env.cachedVersion("github.com/none/pkg"); got != "" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -124,6 +124,21 @@ func runBuiltinModule(moduleName string, isDebug bool, args []string) {
 	c.IsDebug = isDebug
 	c.Args = args
 	c.ImportFunc = parser.SimpleImport
+	defer func() {
+		if !isDebug {
+			e := recover()
+			if e == nil {
+				return
+			}
+			switch err := e.(type) {
+			case runtime.Exception:
+				fmt.Fprint(c.Stderr, err.MessageWithStack())
+			default:
+				fmt.Fprintln(c.Stderr, err)
+			}
+			os.Exit(1)
+		}
+	}()
 	builtin_libs.FindLibEx(c, moduleName, true)
 }
 
@@ -177,8 +192,12 @@ func main() {
 			runDeps(os.Args[2:])
 		case "add":
 			runAddDep(os.Args[2:])
+		case "build-runtime":
+			runBuildRuntime(os.Args[2:])
 		case "ws":
 			runWebsocket(isDebug, os.Args[2:])
+		case "db":
+			runBuiltinModule("db", isDebug, os.Args[2:])
 		case "expr-ast":
 			runShowAstExpr(isDebug, os.Args[2:])
 		default:
@@ -0,0 +1,246 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+type Info struct {
+	Name          string
+	ImportPath    string
+	Exported      bool
+	Funcs         []string
+	Consts        []string
+	Vars          []string
+	NonInterfaces []string
+	TypeMapping   map[string]string
+	symbols       map[string]bool
+}
+
+func exportValue(info *Info, s ast.Spec, toArr []string) []string {
+	if spec, ok := s.(*ast.ValueSpec); ok {
+		for _, nameIdent := range spec.Names {
+			name := nameIdent.Name
+			if !ast.IsExported(name) {
+				continue
+			}
+			if info.symbols[name] {
+				continue
+			}
+			info.symbols[name] = true
+			toArr = append(toArr, name)
+		}
+	}
+	return toArr
+}
+
+func exportProcessSrcFile(info *Info, srcFile *ast.File) error {
+	for _, d := range srcFile.Decls {
+		switch decl := d.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil { // 只导出函数，不导出方法
+				// 泛型函数无法直接取reflect.Value，跳过
+				if decl.Type.TypeParams != nil && decl.Type.TypeParams.NumFields() > 0 {
+					continue
+				}
+				if name := decl.Name.Name; ast.IsExported(name) && !info.symbols[name] {
+					info.Funcs = append(info.Funcs, name)
+					info.symbols[name] = true
+				}
+			}
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.CONST:
+				for _, spec := range decl.Specs {
+					info.Consts = exportValue(info, spec, info.Consts)
+				}
+			case token.VAR:
+				for _, spec := range decl.Specs {
+					info.Vars = exportValue(info, spec, info.Vars)
+				}
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					ts, ok := spec.(*ast.TypeSpec)
+					if !ok {
+						continue
+					}
+					if ts.TypeParams != nil && ts.TypeParams.NumFields() > 0 {
+						continue
+					}
+					if name := ts.Name.Name; ast.IsExported(name) && !info.symbols[name] {
+						if _, ok := ts.Type.(*ast.InterfaceType); !ok {
+							info.NonInterfaces = append(info.NonInterfaces, name)
+							info.symbols[name] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func dirExists(filepath string) (bool, error) {
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	} else if !fi.IsDir() {
+		return false, nil
+	}
+	return true, nil
+}
+
+func getFilesDirectly(cmdGo, dir, pkgName string) (string, error) {
+	getFilesCmd := exec.Command(cmdGo, "list", "-f", "{{range $i,$f := .GoFiles}}{{if $i}},{{end}}{{$f}}{{end}}")
+	getFilesCmd.Dir = dir
+	output, err := getFilesCmd.CombinedOutput()
+	return string(output), err
+}
+
+func getFilesFromMktemp(cmdGo, dir, pkgName string) (string, error) {
+	tmpdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpdir)
+	//TODO copy files from dir to tmpdir
+	filepath.Walk(dir, func(path string, info os.FileInfo, walkErr error) error {
+		if path == dir || walkErr != nil {
+			return nil
+		}
+		relativeName := path[len(dir)+1:]
+		// skip dir
+		if info.IsDir() {
+			return nil
+		}
+		// create dir
+		filedir := filepath.Dir(relativeName)
+		if err := os.MkdirAll(filepath.Join(tmpdir, filedir), info.Mode()); err != nil {
+			return nil
+		}
+		// copy file
+		src, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		defer src.Close()
+		dst, err := os.Create(filepath.Join(tmpdir, relativeName))
+		if err != nil {
+			return nil
+		}
+		defer dst.Close()
+		io.Copy(dst, src)
+		return nil
+	})
+	//TODO create empty go.mod
+	gomod, err := os.Create(filepath.Join(tmpdir, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	defer gomod.Close()
+	fmt.Fprintf(gomod, "module %s\n", pkgName)
+	return getFilesDirectly(cmdGo, tmpdir, pkgName)
+}
+
+func getFiles(cmdGo, dir, pkgName string) (string, error) {
+	output, err := getFilesDirectly(cmdGo, dir, pkgName)
+	if err == nil {
+		return output, err
+	}
+	return getFilesFromMktemp(cmdGo, dir, pkgName)
+}
+
+// ExportPkg 解析dir下的包源码，收集可导出的函数、常量、变量和非接口类型。
+// dir不存在时返回nil, nil
+func ExportPkg(cmdGo, dir, pkg string) (*Info, error) {
+	fs := token.NewFileSet()
+	if exists, err := dirExists(dir); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	srcFiles := map[string]bool{}
+	if output, err := getFiles(cmdGo, dir, pkg); err != nil {
+		return nil, fmt.Errorf("go list error: %s", err)
+	} else {
+		for _, f := range strings.Split(output, ",") {
+			if f != "" {
+				srcFiles[strings.Trim(f, " \n\r\t")] = true
+			}
+		}
+	}
+	fileFilter := func(fi os.FileInfo) bool {
+		return srcFiles[fi.Name()]
+	}
+	pkgs, err := parser.ParseDir(fs, dir, fileFilter, 0)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{ImportPath: pkg, symbols: map[string]bool{}, TypeMapping: map[string]string{}}
+	for _, pkg := range pkgs {
+		if pkg.Name == "main" {
+			continue
+		}
+		info.Name = pkg.Name
+		for filename, srcFile := range pkg.Files {
+			if !strings.HasSuffix(filename, ".go") {
+				continue
+			}
+			if strings.HasSuffix(filename, "_test.go") {
+				continue
+			}
+			if err := exportProcessSrcFile(info, srcFile); err != nil {
+				return nil, err
+			}
+		}
+		break
+	}
+	info.Exported = len(info.symbols) > 0
+	return info, nil
+}
+
+// SetTypeMapping 解析形如"Name1:type1;Name2:type2"的符号类型指定
+func (info *Info) SetTypeMapping(spec string) {
+	if spec == "" {
+		return
+	}
+	for _, mapping := range strings.Split(spec, ";") {
+		kv := strings.Split(mapping, ":")
+		if len(kv) == 2 {
+			info.TypeMapping[kv[0]] = kv[1]
+		}
+	}
+}
+
+// Render 使用模板生成代码，shouldFormat为true时按go代码格式化输出
+func (info *Info) Render(tplContent string, shouldFormat bool) ([]byte, error) {
+	t, err := template.New("code").Parse(tplContent)
+	if err != nil {
+		return nil, fmt.Errorf("解析模板时发生错误：%w", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, info); err != nil {
+		return nil, fmt.Errorf("渲染模板时发生错误：%w", err)
+	}
+	if !shouldFormat {
+		return buf.Bytes(), nil
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("格式化输出代码时发生错误：%w", err)
+	}
+	return out, nil
+}
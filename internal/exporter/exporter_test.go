@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testExporterSrc = `package demo
+
+const (
+	Answer = 42
+	hidden = 1
+)
+
+var Default, other = 1, 2
+
+type Point struct{ X, Y int }
+
+type Shape interface{ Area() float64 }
+
+type Box[T any] struct{ V T }
+
+func New() *Point { return &Point{} }
+
+func Map[T any](v T) T { return v }
+
+func (p *Point) Move() {}
+
+func internal() {}
+`
+
+func TestExportPkg(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "demo.go"), []byte(testExporterSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "demo_test.go"), []byte("package demo\n\nfunc TestOnly() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := ExportPkg("go", dir, "example.com/demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "demo" || !info.Exported {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	for name, pair := range map[string][2][]string{
+		"Funcs":         {info.Funcs, {"New"}},
+		"Consts":        {info.Consts, {"Answer"}},
+		"Vars":          {info.Vars, {"Default"}},
+		"NonInterfaces": {info.NonInterfaces, {"Point"}},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s: expected %v, got %v", name, pair[1], pair[0])
+		}
+	}
+	info.SetTypeMapping("Point:struct;Bad")
+	if !reflect.DeepEqual(info.TypeMapping, map[string]string{"Point": "struct"}) {
+		t.Errorf("unexpected type mapping %v", info.TypeMapping)
+	}
+	code, err := info.Render("package {{.Name}}\nvar funcs = []string{ {{range .Funcs}}\"{{.}}\",{{end}} }\n", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(code), `var funcs = []string{"New"}`) {
+		t.Errorf("unexpected render result %s", code)
+	}
+	if _, err := info.Render("package {{.Name", false); err == nil {
+		t.Error("expected template parse error")
+	}
+}
+
+func TestExportPkgMissingDir(t *testing.T) {
+	info, err := ExportPkg("go", filepath.Join(t.TempDir(), "none"), "example.com/none")
+	if info != nil || err != nil {
+		t.Fatalf("expected nil, nil; got %v, %v", info, err)
+	}
+}
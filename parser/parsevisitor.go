@@ -340,6 +340,14 @@ func SimpleImport(c *runtime.Context, name string, code string, importType strin
 		c.RaiseRuntimeError("import: cannot find module file %s", name)
 		return
 	}
+	if strings.HasPrefix(name, "gopkg/") {
+		goName := name[6:]
+		if lib, found := stdgolibs.FindGoPkg(c, goName); found {
+			return lib, 0, true
+		}
+		c.RaiseRuntimeError("import: go package %s is not built into this runtime, see zgg build-runtime", goName)
+		return
+	}
 	filename := GetModulePath(c, name)
 	if filename == "" {
 		c.RaiseRuntimeError("import: cannot find module file %s", name)
@@ -148,6 +148,7 @@ func (v *ParseVisitor) VisitLiteralFunc(ctx *LiteralFuncContext) interface{} {
 	}
 	body := ctx.CodeBlock().Accept(v).(*ast.Block)
 	f := runtime.NewFunc("", args, ctx.MORE_ARGS() != nil, body)
+	f.Source = getSource(ctx, ctx.GetStart().GetStart())
 	return &ast.ExprFunc{Value: f}
 }
 
@@ -164,6 +165,7 @@ func (v *ParseVisitor) VisitLiteralLambdaExpr(ctx *LiteralLambdaExprContext) int
 		},
 	}
 	f := runtime.NewFunc("", args, ctx.MORE_ARGS() != nil, block)
+	f.Source = getSource(ctx, ctx.GetStart().GetStart())
 	return &ast.ExprFunc{Value: f}
 }
 
@@ -175,6 +177,7 @@ func (v *ParseVisitor) VisitLiteralLambdaBlock(ctx *LiteralLambdaBlockContext) i
 	}
 	body := ctx.CodeBlock().Accept(v).(*ast.Block)
 	f := runtime.NewFunc("", args, ctx.MORE_ARGS() != nil, body)
+	f.Source = getSource(ctx, ctx.GetStart().GetStart())
 	return &ast.ExprFunc{Value: f}
 }
 
@@ -241,6 +244,7 @@ func (v *ParseVisitor) VisitKVKeyFunc(ctx *KVKeyFuncContext) interface{} {
 	}
 	body := ctx.CodeBlock().Accept(v).(*ast.Block)
 	fVal := runtime.NewFunc(id, args[1:], ctx.MORE_ARGS() != nil, body)
+	fVal.Source = "func" + getSource(ctx, allArgs[0].GetSymbol().GetStop()+1)
 	fNode := &ast.ExprFunc{Value: fVal}
 	return kvPair{
 		key: &ast.ExprStr{Value: runtime.NewStr(id)},
@@ -214,6 +214,7 @@ func (v *ParseVisitor) VisitStmtExportFuncDefine(ctx *StmtExportFuncDefineContex
 	funcExpr := &ast.ExprFunc{
 		Value: runtime.NewFunc(name, args, ctx.MORE_ARGS() != nil, body),
 	}
+	funcExpr.Value.Source = "func" + getSource(ctx, ids[0].GetSymbol().GetStop()+1)
 	return &ast.StmtExport{
 		Pos:  getPos(v, ctx),
 		Name: name,
@@ -245,6 +246,7 @@ func (v *ParseVisitor) VisitStmtFuncDefine(ctx *StmtFuncDefineContext) interface
 	funcExpr := &ast.ExprFunc{
 		Value: runtime.NewFunc(name, args, ctx.MORE_ARGS() != nil, body),
 	}
+	funcExpr.Value.Source = "func" + getSource(ctx, ids[0].GetSymbol().GetStop()+1)
 	return &ast.ExprLocalAssign{
 		Names: []string{name},
 		Type:  ast.AssignTypeSingle,
@@ -277,6 +279,7 @@ func (v *ParseVisitor) VisitStmtClassDefine(ctx *StmtClassDefineContext) interfa
 		Name:     ctx.GetClassName().GetText(),
 		Static:   staticBody,
 		Body:     body,
+		Source:   getSource(ctx, ctx.CLASS().GetSymbol().GetStart()),
 	}
 	bases := ctx.GetBaseCls()
 	rv.Bases = make([]ast.Expr, 0, len(bases))
@@ -18,3 +18,12 @@ func getPos(v *ParseVisitor, c antlr.ParserRuleContext) ast.Pos {
 		FileName: filename,
 	}
 }
+
+// getSource 返回c从字符位置start开始到结束的源码
+func getSource(c antlr.ParserRuleContext, start int) string {
+	input := c.GetStart().GetInputStream()
+	if input == nil || c.GetStop() == nil {
+		return ""
+	}
+	return input.GetText(start, c.GetStop().GetStop())
+}
@@ -0,0 +1,162 @@
+package zgg
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/zgg-lang/zgg-go/ast"
+	"github.com/zgg-lang/zgg-go/parser"
+	"github.com/zgg-lang/zgg-go/runtime"
+)
+
+type (
+	// Program 编译后的zgg程序。Program本身只读，可以在多个goroutine中并发执行，
+	// 每次执行都使用独立的Context
+	Program struct {
+		module   *ast.Module
+		filename string
+		// Snapshot后保存的模块初始化结果
+		base *runtime.Context
+	}
+	// Output 为单次执行指定标准输出和标准错误，为nil的不修改
+	Output struct {
+		Stdout io.Writer
+		Stderr io.Writer
+	}
+)
+
+func (o Output) Apply(runner *Runner) {
+	if o.Stdout != nil {
+		runner.Stdout(o.Stdout)
+	}
+	if o.Stderr != nil {
+		runner.Stderr(o.Stderr)
+	}
+}
+
+// CompileProgram 编译代码，code为源码字符串或已编译的模块
+func CompileProgram(filename string, code interface{}) (*Program, error) {
+	var node ast.Node
+	switch codeVal := code.(type) {
+	case ast.Node:
+		node = codeVal
+	case string:
+		astNode, syntaxErrors := parser.ParseFromString(filename, codeVal, true)
+		if len(syntaxErrors) > 0 {
+			return nil, errors.New(syntaxErrors[0].String())
+		}
+		node = astNode
+	default:
+		return nil, errors.New("invalid code type")
+	}
+	module, ok := node.(*ast.Module)
+	if !ok {
+		return nil, errors.New("code is not a module")
+	}
+	return &Program{module: module, filename: filename}, nil
+}
+
+// Snapshot 执行一次模块初始化，并保存模块顶层定义的变量、导出的值及导入的模块。
+// 返回的Program之后的执行不再重复初始化，而是直接从保存的结果开始。
+// 保存的值(包括Snapshot时导入的模块)在各次执行间共享，脚本不应修改它们；
+// 执行时才导入的脚本模块在每次执行中单独加载，状态不会带到其它执行中
+func (p *Program) Snapshot(ctx context.Context, opts ...ExecOption) (*Program, error) {
+	runner := NewRunner(ctx).Filename(p.filename)
+	for _, opt := range opts {
+		opt.Apply(runner)
+	}
+	c := runner.context
+	if err := runner.protect(func() { p.module.EvalTop(c) }); err != nil {
+		return nil, err
+	}
+	return &Program{module: p.module, filename: p.filename, base: c}, nil
+}
+
+// NewRunner 创建一个执行该程序的Runner，各Runner之间互不影响
+func (p *Program) NewRunner(ctx context.Context) *Runner {
+	if p.base == nil {
+		return NewRunner(ctx).Filename(p.filename)
+	}
+	c := p.base.Derive(ctx)
+	p.base.ExportValue.Iterate(func(name string, value runtime.Value) {
+		c.ExportValue.SetMember(name, value, c)
+		c.ForceSetLocalValue(name, value)
+	})
+	return &Runner{context: c, filename: p.filename}
+}
+
+func (p *Program) newRunner(ctx context.Context, opts []ExecOption) *Runner {
+	runner := p.NewRunner(ctx)
+	for _, opt := range opts {
+		opt.Apply(runner)
+	}
+	return runner
+}
+
+// prepare 创建Runner，未Snapshot的程序先在其根栈帧中执行一遍模块代码
+func (p *Program) prepare(ctx context.Context, opts []ExecOption) (*Runner, error) {
+	runner := p.newRunner(ctx, opts)
+	if p.base == nil {
+		if err := runner.protect(func() { p.module.EvalTop(runner.context) }); err != nil {
+			return nil, err
+		}
+	}
+	return runner, nil
+}
+
+// RunValue 执行程序并返回导出的值。对于Snapshot得到的程序，不再执行模块代码
+func (p *Program) RunValue(ctx context.Context, opts ...ExecOption) (runtime.Value, error) {
+	runner := p.newRunner(ctx, opts)
+	if p.base != nil {
+		return runner.context.ExportValue, nil
+	}
+	return runner.RunValue(p.module)
+}
+
+// Run 执行程序并返回导出的值，与RunCode相同
+func (p *Program) Run(ctx context.Context, opts ...ExecOption) (map[string]interface{}, error) {
+	runner := p.newRunner(ctx, opts)
+	var (
+		exported runtime.Value
+		err      error
+	)
+	if p.base != nil {
+		exported = runner.context.ExportValue
+	} else if exported, err = runner.RunValue(p.module); err != nil {
+		return nil, err
+	}
+	rv, _ := exported.ToGoValue(runner.context).(map[string]interface{})
+	if rv == nil {
+		rv = map[string]interface{}{}
+	}
+	return rv, nil
+}
+
+// EvalValue 在程序的上下文中计算表达式expr。未Snapshot的程序会先执行一遍模块代码
+func (p *Program) EvalValue(ctx context.Context, expr interface{}, opts ...ExecOption) (runtime.Value, error) {
+	runner, err := p.prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return runner.EvalValue(expr)
+}
+
+// Call 调用程序中定义的函数funcName。未Snapshot的程序会先执行一遍模块代码
+func (p *Program) Call(ctx context.Context, funcName string, args []interface{}, opts ...ExecOption) (runtime.Value, error) {
+	runner, err := p.prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return runner.Call(funcName, args...)
+}
+
+// ProgramCallAs 调用程序中定义的函数funcName，并把返回值转换为T类型
+func ProgramCallAs[T any](ctx context.Context, p *Program, funcName string, args []interface{}, opts ...ExecOption) (T, error) {
+	runner, err := p.prepare(ctx, opts)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return CallAs[T](runner, funcName, args...)
+}
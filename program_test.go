@@ -0,0 +1,128 @@
+package zgg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testProgramCode = `
+json := import('json')
+onInit()
+base := 100
+export rule := (x, factor) => {
+	println('x=' + str(x))
+	return base + x * factor
+}
+`
+
+func TestProgramConcurrent(t *testing.T) {
+	var inits int32
+	onInit := func() { atomic.AddInt32(&inits, 1) }
+	prog, err := CompileProgram("rule.zgg", testProgramCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := prog.Snapshot(context.Background(), Var{Name: "onInit", Value: onInit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Program{prog, snapshot} {
+		var wg sync.WaitGroup
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var out strings.Builder
+				rv, err := ProgramCallAs[int64](context.Background(), p, "rule", []interface{}{Val{Value: i}, Val{Value: i}},
+					Var{Name: "onInit", Value: onInit},
+					Output{Stdout: &out})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if rv != int64(100+i*i) {
+					t.Errorf("rule(%d) = %d", i, rv)
+				}
+				if s := out.String(); s != fmt.Sprintf("x=%d\n", i) {
+					t.Errorf("unexpected output %q", s)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+	if inits != 65 {
+		t.Fatalf("module should be initialized 65 times, got %d", inits)
+	}
+	v, err := snapshot.EvalValue(context.Background(), "base + len(json.decode('[1, 2]')) + n", Var{Name: "n", Value: Val{Value: 1}})
+	if err != nil || v.ToGoValue(nil) != int64(103) {
+		t.Fatalf("eval in snapshot returns %v, %v", v, err)
+	}
+	exported, err := snapshot.Run(context.Background())
+	if _, found := exported["rule"]; err != nil || !found {
+		t.Fatalf("unexpected exports %v, %v", exported, err)
+	}
+}
+
+func benchmarkProgram(b *testing.B, p *Program) {
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			i++
+			ProgramCallAs[int64](context.Background(), p, "rule", []interface{}{Val{Value: i}, Val{Value: 2}},
+				Var{Name: "onInit", Value: func() {}},
+				Output{Stdout: io.Discard})
+		}
+	})
+}
+
+func BenchmarkProgramParallel(b *testing.B) {
+	prog, _ := CompileProgram("rule.zgg", testProgramCode)
+	b.ResetTimer()
+	benchmarkProgram(b, prog)
+}
+
+func BenchmarkProgramSnapshotParallel(b *testing.B) {
+	prog, _ := CompileProgram("rule.zgg", testProgramCode)
+	snapshot, _ := prog.Snapshot(context.Background(), Var{Name: "onInit", Value: func() {}})
+	b.ResetTimer()
+	benchmarkProgram(b, snapshot)
+}
+
+func BenchmarkRunCodeParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			RunCode(testProgramCode+"\nexport result := rule(1, 2)",
+				Var{Name: "onInit", Value: func() {}},
+				Output{Stdout: io.Discard})
+		}
+	})
+}
+
+func TestProgramSnapshotModuleIsolation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "counter.zgg"), []byte("n := 0\nexport next := () => {\n\tn++\n\treturn n\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	code := strings.ReplaceAll("export count := () => import('{dir}/counter.zgg').next()", "{dir}", dir)
+	prog, err := CompileProgram("counter.zgg", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := prog.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		// 每次执行时导入的模块都重新加载，计数不会延续
+		if n, err := ProgramCallAs[int64](context.Background(), snapshot, "count", nil); err != nil || n != 1 {
+			t.Fatalf("run %d: count() returns %d, %v", i, n, err)
+		}
+	}
+}
@@ -75,6 +75,9 @@ func (c *ConsoleReplContext) ReadAction(shouldRecover bool) ReplAction {
 		if code == "exit" {
 			return ReplExit{}
 		}
+		if cmd := ParseReplCommand(code); cmd != nil {
+			return cmd
+		}
 		if strings.TrimSpace(code) == "" {
 			return ReplNoop{}
 		}
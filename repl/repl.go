@@ -68,6 +68,15 @@ func ParseInputCode(code string, shouldRecover bool) (compiled runtime.IEval, er
 	return
 }
 
+// evalReplItem 在根栈帧中执行输入的代码，使其中定义的函数和类在之后的输入中可见
+func evalReplItem(c *runtime.Context, code runtime.IEval) {
+	if block, ok := code.(*ast.Block); ok {
+		block.EvalStmts(c)
+	} else {
+		code.Eval(c)
+	}
+}
+
 func ReplLoop(context ReplContext, shouldRecover bool) {
 	context.OnEnter()
 	for {
@@ -101,7 +110,7 @@ func (rrc ReplRunCode) Handle(context ReplContext, shouldRecover bool) (shouldCo
 			}
 		}
 	}()
-	codeAst.Eval(c)
+	evalReplItem(c, codeAst)
 	retVal := c.RetVal
 	if shouldWriteResult(context, codeAst) {
 		context.WriteResult(retVal)
@@ -0,0 +1,554 @@
+package repl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zgg-lang/zgg-go/parser"
+	"github.com/zgg-lang/zgg-go/runtime"
+)
+
+const stateVersion = 1
+
+type (
+	// State 保存的解释器状态：根栈帧中的全局变量、脚本定义的类及已导入模块的引用
+	State struct {
+		Version int                   `json:"version"`
+		Classes []stateClass          `json:"classes,omitempty"`
+		Globals map[string]stateValue `json:"globals"`
+	}
+
+	stateClass struct {
+		Name   string   `json:"name"`
+		Bases  []string `json:"bases,omitempty"`
+		Source string   `json:"source"`
+	}
+
+	stateValue struct {
+		Kind   string                `json:"kind"`
+		Value  interface{}           `json:"value,omitempty"`
+		Items  []stateValue          `json:"items,omitempty"`
+		Fields map[string]stateValue `json:"fields,omitempty"`
+		Class  string                `json:"class,omitempty"`
+		Name   string                `json:"name,omitempty"`
+		Source string                `json:"source,omitempty"`
+		Module string                `json:"module,omitempty"`
+		Member string                `json:"member,omitempty"`
+	}
+
+	// StateIssue 保存或恢复状态时无法完整处理的变量。Skipped为true时该变量没有被保存或恢复
+	StateIssue struct {
+		Name    string
+		Reason  string
+		Skipped bool
+	}
+
+	stateSaver struct {
+		c       *runtime.Context
+		modules map[string]runtime.Value
+		classes map[string]stateClass
+		// 正在保存的对象，用于发现循环引用
+		visiting map[interface{}]bool
+		issues   []StateIssue
+	}
+)
+
+func (i StateIssue) String() string {
+	if i.Skipped {
+		return fmt.Sprintf("%s: %s (skipped)", i.Name, i.Reason)
+	}
+	return fmt.Sprintf("%s: %s", i.Name, i.Reason)
+}
+
+// 保存时忽略的变量
+var stateIgnoredNames = map[string]bool{
+	"__last__": true,
+}
+
+type stateError struct {
+	reason string
+}
+
+func (s *stateSaver) fail(reason string, args ...interface{}) {
+	panic(stateError{fmt.Sprintf(reason, args...)})
+}
+
+// findModuleMember 在已导入的模块及其成员中查找v
+func (s *stateSaver) findModuleMember(v runtime.Value) (stateValue, bool) {
+	paths := make([]string, 0, len(s.modules))
+	for path := range s.modules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		mod := s.modules[path]
+		if stateSame(mod, v) {
+			return stateValue{Kind: "module", Module: path}, true
+		}
+		obj, ok := mod.(runtime.ValueObject)
+		if !ok {
+			continue
+		}
+		var found stateValue
+		obj.Each(func(name string, member runtime.Value) bool {
+			if stateSame(member, v) {
+				found = stateValue{Kind: "module", Module: path, Member: name}
+				return false
+			}
+			return true
+		})
+		if found.Kind != "" {
+			return found, true
+		}
+	}
+	return stateValue{}, false
+}
+
+func (s *stateSaver) saveClass(name string, t runtime.ValueType) {
+	if t.Source == "" {
+		s.fail("class %s is not defined by script", t.Name)
+	}
+	if saved, found := s.classes[t.Name]; found {
+		if saved.Source != t.Source {
+			s.fail("there are different classes named %s", t.Name)
+		}
+		return
+	}
+	cls := stateClass{Name: t.Name, Source: t.Source}
+	for _, base := range t.Bases {
+		if base == runtime.TypeObject {
+			continue
+		}
+		cls.Bases = append(cls.Bases, base.Name)
+		if _, found := s.findModuleMember(base); !found {
+			s.saveClass(base.Name, base)
+		}
+	}
+	s.classes[t.Name] = cls
+}
+
+func (s *stateSaver) save(name string, v runtime.Value) stateValue {
+	if ref, found := s.findModuleMember(v); found {
+		return ref
+	}
+	switch val := v.(type) {
+	case runtime.ValueBoundMethod:
+		return s.save(name, runtime.Unbound(val))
+	case runtime.ValueNil:
+		return stateValue{Kind: "nil"}
+	case runtime.ValueUndefined:
+		return stateValue{Kind: "undefined"}
+	case runtime.ValueBool:
+		return stateValue{Kind: "bool", Value: val.Value()}
+	case runtime.ValueInt:
+		return stateValue{Kind: "int", Value: val.Value()}
+	case runtime.ValueFloat:
+		return stateValue{Kind: "float", Value: val.Value()}
+	case runtime.ValueStr:
+		return stateValue{Kind: "str", Value: val.Value()}
+	case runtime.ValueBytes:
+		return stateValue{Kind: "bytes", Value: base64.StdEncoding.EncodeToString(val.Value())}
+	case runtime.ValueArray:
+		defer s.leave(s.enter(v))
+		rv := stateValue{Kind: "array", Items: make([]stateValue, val.Len())}
+		for i := range rv.Items {
+			rv.Items[i] = s.save(fmt.Sprintf("%s[%d]", name, i), val.GetIndex(i, s.c))
+		}
+		return rv
+	case runtime.ValueMap:
+		defer s.leave(s.enter(v))
+		rv := stateValue{Kind: "map"}
+		val.Each(func(key, value runtime.Value) bool {
+			rv.Items = append(rv.Items, s.save(name+".key", key), s.save(name+"["+key.ToString(s.c)+"]", value))
+			return true
+		})
+		return rv
+	case runtime.ValueObject:
+		defer s.leave(s.enter(v))
+		rv := stateValue{Kind: "object", Fields: map[string]stateValue{}}
+		if t := val.Type(); t != runtime.TypeObject {
+			s.saveClass(t.Name, t)
+			rv.Class = t.Name
+		}
+		val.Each(func(key string, member runtime.Value) bool {
+			rv.Fields[key] = s.save(name+"."+key, member)
+			return true
+		})
+		return rv
+	case runtime.ValueType:
+		s.saveClass(name, val)
+		return stateValue{Kind: "class", Class: val.Name}
+	case *runtime.ValueFunc:
+		if val.Source == "" {
+			s.fail("source of function %s is unavailable", val.ToString(s.c))
+		}
+		if val.CapturesLocals(s.c) {
+			s.issues = append(s.issues, StateIssue{Name: name, Reason: "function is a closure, captured local variables will not be restored"})
+		}
+		return stateValue{Kind: "func", Name: val.Name, Source: val.Source}
+	}
+	s.fail("value of type %s is not serializable", v.Type().Name)
+	return stateValue{}
+}
+
+// stateIdentity 返回引用类型的值的标识，值类型返回nil
+func stateIdentity(v runtime.Value) interface{} {
+	v = runtime.Unbound(v)
+	if arr, ok := v.(runtime.ValueArray); ok {
+		return arr.Values
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		return v
+	}
+	return nil
+}
+
+func stateSame(a, b runtime.Value) bool {
+	ia := stateIdentity(a)
+	return ia != nil && ia == stateIdentity(b)
+}
+
+func (s *stateSaver) enter(v runtime.Value) interface{} {
+	id := stateIdentity(v)
+	if s.visiting[id] {
+		s.fail("circular reference")
+	}
+	s.visiting[id] = true
+	return id
+}
+
+func (s *stateSaver) leave(id interface{}) {
+	delete(s.visiting, id)
+}
+
+func (s *stateSaver) saveGlobal(name string, v runtime.Value) (rv stateValue, ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			se, isStateError := e.(stateError)
+			if !isStateError {
+				panic(e)
+			}
+			s.issues = append(s.issues, StateIssue{Name: name, Reason: se.reason, Skipped: true})
+			s.visiting = map[interface{}]bool{}
+			ok = false
+		}
+	}()
+	return s.save(name, v), true
+}
+
+// sortClasses 按继承关系排序，基类在前
+func sortClasses(classes map[string]stateClass) []stateClass {
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rv := make([]stateClass, 0, len(classes))
+	added := map[string]bool{}
+	var add func(name string)
+	add = func(name string) {
+		cls, found := classes[name]
+		if !found || added[name] {
+			return
+		}
+		added[name] = true
+		for _, base := range cls.Bases {
+			add(base)
+		}
+		rv = append(rv, cls)
+	}
+	for _, name := range names {
+		add(name)
+	}
+	return rv
+}
+
+// SaveState 把c的全局变量、脚本定义的函数和类以及已导入模块的引用写入w。
+// 无法保存的值(如go对象、网络连接、数据库连接等)会被跳过并在返回的issues中说明。
+// 同一个对象被多个变量引用时，恢复后成为各自独立的副本
+func SaveState(c *runtime.Context, w io.Writer) (issues []StateIssue, err error) {
+	s := &stateSaver{
+		c:        c,
+		modules:  c.Modules(),
+		classes:  map[string]stateClass{},
+		visiting: map[interface{}]bool{},
+	}
+	state := State{Version: stateVersion, Globals: map[string]stateValue{}}
+	globals := c.Globals()
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		if !stateIgnoredNames[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if v, ok := s.saveGlobal(name, globals[name]); ok {
+			state.Globals[name] = v
+		}
+	}
+	state.Classes = sortClasses(s.classes)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(state); err != nil {
+		return s.issues, err
+	}
+	return s.issues, nil
+}
+
+// SaveStateFile 与SaveState相同，写入文件filename
+func SaveStateFile(c *runtime.Context, filename string) ([]StateIssue, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return SaveState(c, f)
+}
+
+type stateLoader struct {
+	c       *runtime.Context
+	classes map[string]runtime.ValueType
+}
+
+func (l *stateLoader) evalSource(source string) runtime.Value {
+	node, errs := parser.ParseReplFromString(source, true)
+	if len(errs) > 0 {
+		panic(stateError{errs[0].String()})
+	} else if node == nil {
+		panic(stateError{"parse source fail"})
+	}
+	node.Eval(l.c)
+	return l.c.RetVal
+}
+
+func (l *stateLoader) class(name string) runtime.ValueType {
+	t, found := l.classes[name]
+	if !found {
+		panic(stateError{"class " + name + " not found"})
+	}
+	return t
+}
+
+func (l *stateLoader) load(v stateValue) runtime.Value {
+	c := l.c
+	switch v.Kind {
+	case "nil":
+		return runtime.Nil()
+	case "undefined":
+		return runtime.Undefined()
+	case "bool":
+		b, _ := v.Value.(bool)
+		return runtime.NewBool(b)
+	case "int":
+		n, _ := v.Value.(json.Number).Int64()
+		return runtime.NewInt(n)
+	case "float":
+		f, _ := v.Value.(json.Number).Float64()
+		return runtime.NewFloat(f)
+	case "str":
+		s, _ := v.Value.(string)
+		return runtime.NewStr(s)
+	case "bytes":
+		s, _ := v.Value.(string)
+		bs, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			panic(stateError{"invalid bytes: " + err.Error()})
+		}
+		return runtime.NewBytes(bs)
+	case "array":
+		rv := runtime.NewArray(len(v.Items))
+		for _, item := range v.Items {
+			rv.PushBack(l.load(item))
+		}
+		return rv
+	case "map":
+		kvs := make([]runtime.Value, len(v.Items))
+		for i, item := range v.Items {
+			kvs[i] = l.load(item)
+		}
+		return runtime.NewMapWithPairs(c, kvs)
+	case "object":
+		var rv runtime.ValueObject
+		if v.Class != "" {
+			rv = runtime.NewObject(l.class(v.Class))
+		} else {
+			rv = runtime.NewObject()
+		}
+		for name, field := range v.Fields {
+			rv.SetMember(name, l.load(field), c)
+		}
+		return rv
+	case "class":
+		return l.class(v.Class)
+	case "func":
+		f, ok := l.evalSource(v.Source).(*runtime.ValueFunc)
+		if !ok {
+			panic(stateError{"source is not a function"})
+		}
+		f.Name = v.Name
+		return f
+	case "module":
+		mod := c.ImportModule(v.Module, false, runtime.ImportTypeScript)
+		if v.Member == "" {
+			return mod
+		}
+		return mod.GetMember(v.Member, c)
+	}
+	panic(stateError{"unknown value kind " + v.Kind})
+}
+
+// loadClasses 在一个临时栈帧中依次定义各个类，使基类可以按名称找到
+func (l *stateLoader) loadClasses(classes []stateClass) (issues []StateIssue) {
+	c := l.c
+	c.PushStack()
+	defer c.PopStack()
+	for _, cls := range classes {
+		for _, base := range cls.Bases {
+			if t, found := l.classes[base]; found {
+				c.ForceSetLocalValue(base, t)
+			}
+		}
+		func() {
+			defer func() {
+				if e := recover(); e != nil {
+					issues = append(issues, StateIssue{Name: "class " + cls.Name, Reason: fmt.Sprint(stateRecovered(e)), Skipped: true})
+				}
+			}()
+			t, ok := l.evalSource(cls.Source).(runtime.ValueType)
+			if !ok {
+				panic(stateError{"source is not a class"})
+			}
+			l.classes[cls.Name] = t
+		}()
+	}
+	return
+}
+
+func stateRecovered(e interface{}) string {
+	switch ee := e.(type) {
+	case stateError:
+		return ee.reason
+	case runtime.Exception:
+		return ee.GetMessage()
+	case error:
+		return ee.Error()
+	}
+	return fmt.Sprint(e)
+}
+
+// LoadState 从r中读取SaveState保存的状态，恢复到c的根栈帧中，同名变量会被覆盖
+func LoadState(c *runtime.Context, r io.Reader) (issues []StateIssue, err error) {
+	var state State
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&state); err != nil {
+		return nil, err
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	l := &stateLoader{c: c, classes: map[string]runtime.ValueType{}}
+	var modNames, names []string
+	for name, v := range state.Globals {
+		if v.Kind == "module" {
+			modNames = append(modNames, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(modNames)
+	sort.Strings(names)
+	loadGlobal := func(name string) {
+		defer func() {
+			if e := recover(); e != nil {
+				issues = append(issues, StateIssue{Name: name, Reason: stateRecovered(e), Skipped: true})
+			}
+		}()
+		c.ForceSetLocalValue(name, l.load(state.Globals[name]))
+	}
+	// 先恢复模块，类的定义中可能引用模块中的基类
+	for _, name := range modNames {
+		loadGlobal(name)
+	}
+	issues = append(issues, l.loadClasses(state.Classes)...)
+	for _, name := range names {
+		loadGlobal(name)
+	}
+	return issues, nil
+}
+
+// LoadStateFile 与LoadState相同，从文件filename中读取
+func LoadStateFile(c *runtime.Context, filename string) ([]StateIssue, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadState(c, f)
+}
+
+// ReplSaveState 保存状态到文件，对应REPL命令 :save <file>
+type ReplSaveState string
+
+// ReplLoadState 从文件恢复状态，对应REPL命令 :load <file>
+type ReplLoadState string
+
+// ParseReplCommand 解析以:开头的REPL命令，不是命令时返回nil
+func ParseReplCommand(code string) ReplAction {
+	code = strings.TrimSpace(code)
+	if !strings.HasPrefix(code, ":") {
+		return nil
+	}
+	cmd, arg, _ := strings.Cut(code[1:], " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case "save":
+		return ReplSaveState(arg)
+	case "load":
+		return ReplLoadState(arg)
+	}
+	return nil
+}
+
+func writeStateIssues(context ReplContext, issues []StateIssue) {
+	for _, issue := range issues {
+		context.WriteResult("  " + issue.String())
+	}
+}
+
+func (filename ReplSaveState) Handle(context ReplContext, shouldRecover bool) bool {
+	if filename == "" {
+		context.WriteResult("usage: :save <file>")
+		return true
+	}
+	issues, err := SaveStateFile(context.Context(), string(filename))
+	if err != nil {
+		context.WriteResult(fmt.Sprintf("save state to %s failed: %s", filename, err))
+		return true
+	}
+	context.WriteResult(fmt.Sprintf("state saved to %s", filename))
+	writeStateIssues(context, issues)
+	return true
+}
+
+func (filename ReplLoadState) Handle(context ReplContext, shouldRecover bool) bool {
+	if filename == "" {
+		context.WriteResult("usage: :load <file>")
+		return true
+	}
+	issues, err := LoadStateFile(context.Context(), string(filename))
+	if err != nil {
+		context.WriteResult(fmt.Sprintf("load state from %s failed: %s", filename, err))
+		return true
+	}
+	context.WriteResult(fmt.Sprintf("state loaded from %s", filename))
+	writeStateIssues(context, issues)
+	return true
+}
@@ -0,0 +1,90 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zgg-lang/zgg-go/parser"
+	"github.com/zgg-lang/zgg-go/runtime"
+)
+
+func newStateTestContext() *runtime.Context {
+	c := runtime.NewContext(true, false, false, context.Background())
+	c.ImportFunc = parser.SimpleImport
+	return c
+}
+
+func evalInRepl(t *testing.T, c *runtime.Context, code string) runtime.Value {
+	compiled, err := ParseInputCode(code, true)
+	if err != nil {
+		t.Fatalf("parse %s: %s", code, err)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			t.Fatalf("eval %s: %v", code, e)
+		}
+	}()
+	evalReplItem(c, compiled)
+	return c.RetVal
+}
+
+func TestSaveAndLoadState(t *testing.T) {
+	c := newStateTestContext()
+	for _, code := range []string{
+		`json := import('json')`,
+		`class Shape { area() { return 0 } }`,
+		`class Rect(Shape) { __init__(w, h) { this.w = w; this.h = h } area() { return this.w * this.h } }`,
+		`r := Rect(3, 4)`,
+		`func scale(v, n) { return v * n * factor }`,
+		`factor := 2`,
+		`double := x => x * 2`,
+		`data := {name: 'zgg', tags: ['a', 'b'], bs: bytes('xy'), ratio: 1.5, ok: true, nothing: nil}`,
+		`encode := json.encode`,
+		`cyc := {}`,
+		`cyc.self = cyc`,
+	} {
+		evalInRepl(t, c, code)
+	}
+	c.ForceSetLocalValue("conn", runtime.NewGoValue(&bytes.Buffer{}))
+	var buf bytes.Buffer
+	issues, err := SaveState(c, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := map[string]string{}
+	for _, issue := range issues {
+		if issue.Skipped {
+			skipped[issue.Name] = issue.Reason
+		}
+	}
+	for _, name := range []string{"cyc", "conn"} {
+		if _, found := skipped[name]; !found {
+			t.Errorf("%s should be skipped, issues: %v", name, issues)
+		}
+	}
+	saved := buf.String()
+
+	restored := newStateTestContext()
+	issues, err = LoadState(restored, strings.NewReader(saved))
+	if err != nil || len(issues) > 0 {
+		t.Fatalf("load state: %v, %v\n%s", issues, err, saved)
+	}
+	for code, expected := range map[string]string{
+		`r.area()`:                 "12",
+		`r is Shape`:               "true",
+		`scale(3, 5)`:              "30",
+		`double(21)`:               "42",
+		`data.tags[1] + data.name`: "bzgg",
+		`len(data.bs)`:             "2",
+		`data.ratio`:               "1.5",
+		`encode([1])`:              "[1]\n",
+		`json.decode('[2]')[0]`:    "2",
+		`Rect(1, 2).area()`:        "2",
+	} {
+		if rv := evalInRepl(t, restored, code).ToString(restored); rv != expected {
+			t.Errorf("%s = %s, expected %s", code, rv, expected)
+		}
+	}
+}
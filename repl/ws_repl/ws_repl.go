@@ -64,6 +64,9 @@ func (c *WebsocketReplContext) ReadAction(shouldRecover bool) repl.ReplAction {
 	}
 	switch input.Type {
 	}
+	if cmd := repl.ParseReplCommand(input.Content); cmd != nil {
+		return cmd
+	}
 	compiled, err := repl.ParseInputCode(input.Content, shouldRecover)
 	return repl.ReplRunCode{Compiled: compiled, Err: err}
 }
@@ -141,6 +141,11 @@ func GetImportPaths() []string {
 }
 
 func NewContext(isMain bool, isDebug, canEval bool, ctx context.Context) *Context {
+	return newContext(isMain, isDebug, canEval, ctx, nil)
+}
+
+// newContext builtinValues不为nil时与其它Context共享内置值，不再复制
+func newContext(isMain bool, isDebug, canEval bool, ctx context.Context, builtinValues *sync.Map) *Context {
 	f := newContextFrame(nil)
 	c := &Context{
 		RetVal:          Undefined(),
@@ -167,6 +172,10 @@ func NewContext(isMain bool, isDebug, canEval bool, ctx context.Context) *Contex
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
+	if builtinValues != nil {
+		c.builtins = builtinValues
+		return c
+	}
 	for name, value := range builtins {
 		c.builtins.Store(name, value)
 	}
@@ -242,6 +251,26 @@ func (c *Context) FindValue(name string) (Value, bool) {
 	return nil, false
 }
 
+// Globals 返回根栈帧中定义的变量
+func (c *Context) Globals() map[string]Value {
+	rv := map[string]Value{}
+	c.rootFrame.variables.Range(func(k, v interface{}) bool {
+		rv[k.(string)] = v.(Value)
+		return true
+	})
+	return rv
+}
+
+// Modules 返回已导入的模块，key为导入时的模块路径
+func (c *Context) Modules() map[string]Value {
+	rv := map[string]Value{}
+	c.modules.Range(func(k, v interface{}) bool {
+		rv[k.(string)] = v.(ModuleInfo).Value
+		return true
+	})
+	return rv
+}
+
 func (c *Context) ModifyValue(name string, value Value) {
 	if name == "_" {
 		return
@@ -320,8 +349,12 @@ func (c *Context) PopStack() {
 	if frame.funcLevel != c.curFrame.funcLevel {
 		c.Returned = false
 		funcRoot := c.curFrame.parent
+		if c.curFrame == c.rootFrame {
+			// 不能越过根栈帧，Derive出的Context的根栈帧之上是共享的栈帧
+			funcRoot = c.rootFrame
+		}
 		if funcRoot != nil {
-			for funcRoot.parent != nil && funcRoot.parent.funcLevel == funcRoot.funcLevel {
+			for funcRoot != c.rootFrame && funcRoot.parent != nil && funcRoot.parent.funcLevel == funcRoot.funcLevel {
 				funcRoot = funcRoot.parent
 			}
 		}
@@ -554,9 +587,13 @@ func (c *Context) Clone() *Context {
 }
 
 func (c *Context) CloneWithContext(ctx context.Context) *Context {
+	return c.cloneWithContext(ctx, nil)
+}
+
+func (c *Context) cloneWithContext(ctx context.Context, builtinValues *sync.Map) *Context {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	newContext := NewContext(false, c.IsDebug, c.CanEval, ctx)
+	newContext := newContext(false, c.IsDebug, c.CanEval, ctx, builtinValues)
 	newContext.Args = c.Args
 	newContext.debugLogger = c.debugLogger
 	newContext.ImportFunc = c.ImportFunc
@@ -569,6 +606,22 @@ func (c *Context) CloneWithContext(ctx context.Context) *Context {
 	return newContext
 }
 
+// Derive 创建一个新的Context，c根栈帧中的变量(如模块顶层定义的函数、类和导入的模块)在新Context中可见。
+// 这些变量及内置值在派生出的各个Context之间共享，不应再被修改。
+// 新Context有自己的模块缓存：c中已导入的模块被共享，之后才导入的模块只在新Context中加载和缓存
+func (c *Context) Derive(ctx context.Context) *Context {
+	newContext := c.cloneWithContext(ctx, c.builtins)
+	newContext.modules = new(sync.Map)
+	c.modules.Range(func(k, v interface{}) bool {
+		newContext.modules.Store(k, v)
+		return true
+	})
+	newContext.Path = c.Path
+	newContext.ImportPaths = c.ImportPaths
+	newContext.rootFrame.parent = c.rootFrame
+	return newContext
+}
+
 func (c *Context) Recover() {
 	if err := recover(); err != nil {
 		switch e := err.(type) {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -133,6 +134,16 @@ func loadGoToZggCaster(goTyp reflect.Type) g2zCaster {
 }
 
 func toGoValue(c *Context, v Value, goVal reflect.Value) {
+	convertToGoValue(c, v, goVal, false)
+}
+
+// DecodeGoValue 与ToGoValue相同，但空接口类型的目标(包括结构体字段、map和slice元素)直接取v.ToGoValue，
+// 保留int/float、bytes等类型信息，不经过json转换
+func DecodeGoValue(c *Context, v Value, goVal reflect.Value) {
+	convertToGoValue(c, v, goVal, true)
+}
+
+func convertToGoValue(c *Context, v Value, goVal reflect.Value, keepTypes bool) {
 	if Nullish(v) {
 		switch goVal.Kind() {
 		case reflect.Ptr:
@@ -157,6 +168,24 @@ func toGoValue(c *Context, v Value, goVal reflect.Value) {
 		}
 		return
 	}
+	if goTyp.Kind() == reflect.Interface && goTyp.NumMethod() > 0 {
+		if gv, ok := v.(GoValue); ok && gv.v.Type().Implements(goTyp) {
+			goVal.Set(gv.v)
+			return
+		}
+		impl, err := ImplementGoInterface(c, v, goTyp)
+		if err != nil {
+			c.RaiseRuntimeError("value %s to go value %s error: %s", v.ToString(c), goTyp.String(), err)
+		}
+		goVal.Set(impl)
+		return
+	}
+	if goTyp.Kind() == reflect.Interface && keepTypes {
+		if !Nullish(v) {
+			goVal.Set(reflect.ValueOf(v.ToGoValue(c)))
+		}
+		return
+	}
 	switch goTyp.Kind() {
 	case reflect.Int:
 		goVal.Set(reflect.ValueOf(int(c.MustInt(v))).Convert(goVal.Type()))
@@ -192,7 +221,7 @@ func toGoValue(c *Context, v Value, goVal reflect.Value) {
 		outVal := reflect.MakeSlice(reflect.SliceOf(elType), 0, arr.Len())
 		for i := 0; i < arr.Len(); i++ {
 			el := reflect.New(elType)
-			toGoValue(c, arr.GetIndex(i, c), el.Elem())
+			convertToGoValue(c, arr.GetIndex(i, c), el.Elem(), keepTypes)
 			outVal = reflect.Append(outVal, el.Elem())
 		}
 		goVal.Set(outVal)
@@ -200,12 +229,40 @@ func toGoValue(c *Context, v Value, goVal reflect.Value) {
 		nf := goTyp.NumField()
 		for i := 0; i < nf; i++ {
 			f := goTyp.Field(i)
-			fieldVal := v.GetMember(f.Name, c)
+			name, embedded, skip := goStructFieldName(f)
+			if skip {
+				continue
+			}
+			if embedded {
+				convertToGoValue(c, v, goVal.Field(i), keepTypes)
+				continue
+			}
+			fieldVal := v.GetMember(name, c)
+			if IsUndefined(fieldVal) && name != f.Name {
+				fieldVal = v.GetMember(f.Name, c)
+			}
 			if IsUndefined(fieldVal) {
 				continue
 			}
-			toGoValue(c, fieldVal, goVal.Field(i))
+			convertToGoValue(c, fieldVal, goVal.Field(i), keepTypes)
+		}
+	case reflect.Ptr:
+		el := reflect.New(goTyp.Elem())
+		convertToGoValue(c, v, el.Elem(), keepTypes)
+		goVal.Set(el)
+	case reflect.Map:
+		obj, isObj := v.(ValueObject)
+		if !isObj || goTyp.Key().Kind() != reflect.String {
+			toGoValueByJson(c, v, goVal)
+			return
 		}
+		m := reflect.MakeMapWithSize(goTyp, obj.Len())
+		obj.Iterate(func(key string, value Value) {
+			el := reflect.New(goTyp.Elem()).Elem()
+			convertToGoValue(c, value, el, keepTypes)
+			m.SetMapIndex(reflect.ValueOf(key).Convert(goTyp.Key()), el)
+		})
+		goVal.Set(m)
 	case reflect.Func:
 		callable := c.MustCallable(v)
 		goVal.Set(reflect.MakeFunc(goTyp, func(args []reflect.Value) []reflect.Value {
@@ -220,13 +277,13 @@ func toGoValue(c *Context, v Value, goVal reflect.Value) {
 			case 0:
 			case 1:
 				rv[0] = reflect.Zero(goTyp.Out(0))
-				toGoValue(c, c.RetVal, rv[0])
+				convertToGoValue(c, c.RetVal, rv[0], keepTypes)
 			default:
 				if retArr, ok := c.RetVal.(ValueArray); ok {
 					var i int
 					for i = 0; i < retArr.Len(); i++ {
 						rv[i] = reflect.Zero(goTyp.Out(i))
-						toGoValue(c, retArr.GetIndex(i, c), rv[i])
+						convertToGoValue(c, retArr.GetIndex(i, c), rv[i], keepTypes)
 					}
 					for ; i < outN; i++ {
 						rv[i] = reflect.Zero(goTyp.Out(i))
@@ -236,19 +293,52 @@ func toGoValue(c *Context, v Value, goVal reflect.Value) {
 			return rv
 		}))
 	default:
-		{
-			goIntr := goVal.Addr().Interface()
-			jsonBs, err := json.Marshal(buildJson(v, c))
-			if err != nil {
-				c.RaiseRuntimeError("value %s to go value error: " + err.Error())
-				return
-			}
-			if err := json.Unmarshal(jsonBs, goIntr); err != nil {
-				c.RaiseRuntimeError("value %s, to go value %s error: %s", v.ToString(c), goVal.Type().String(), err.Error())
-				return
+		toGoValueByJson(c, v, goVal)
+	}
+}
+
+func toGoValueByJson(c *Context, v Value, goVal reflect.Value) {
+	goIntr := goVal.Addr().Interface()
+	jsonBs, err := json.Marshal(buildJson(v, c))
+	if err != nil {
+		c.RaiseRuntimeError("value %s to go value error: %s", v.ToString(c), err.Error())
+		return
+	}
+	if err := json.Unmarshal(jsonBs, goIntr); err != nil {
+		c.RaiseRuntimeError("value %s, to go value %s error: %s", v.ToString(c), goVal.Type().String(), err.Error())
+		return
+	}
+}
+
+// goStructFieldName 按json tag的规则确定结构体字段对应的成员名。
+// 没有tag的内嵌结构体字段的成员直接从同一个zgg对象中读取
+func goStructFieldName(f reflect.StructField) (name string, embedded, skip bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	if p := strings.Index(tag, ","); p >= 0 {
+		tag = tag[:p]
+	}
+	if f.Anonymous && tag == "" {
+		t := f.Type
+		if t.Kind() == reflect.Ptr {
+			if !f.IsExported() {
+				return "", false, true
 			}
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			return "", true, false
 		}
 	}
+	if !f.IsExported() {
+		return "", false, true
+	}
+	if tag != "" {
+		return tag, false, false
+	}
+	return f.Name, false, false
 }
 
 var ToGoValue = toGoValue
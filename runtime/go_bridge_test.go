@@ -15,3 +15,18 @@ func TestToGoValue(t *testing.T) {
 		t.Logf("outval: %+v", outVal.Elem().Interface())
 	}
 }
+
+func TestDecodeGoValueKeepsTypes(t *testing.T) {
+	c := NewContext(true, false, false, context.Background())
+	obj := NewObject()
+	obj.SetMember("n", NewInt(1), c)
+	var general, decoded interface{}
+	toGoValue(c, obj, reflect.ValueOf(&general).Elem())
+	if !reflect.DeepEqual(general, map[string]interface{}{"n": float64(1)}) {
+		t.Errorf("toGoValue: %#v", general)
+	}
+	DecodeGoValue(c, obj, reflect.ValueOf(&decoded).Elem())
+	if m, ok := decoded.(map[string]interface{}); !ok || m["n"] != int64(1) {
+		t.Errorf("DecodeGoValue: %#v", decoded)
+	}
+}
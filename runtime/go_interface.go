@@ -0,0 +1,302 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// GoInterfaceDelegate 把go接口的方法调用转发给zgg对象的同名成员。
+// 每次调用都在Clone出来的Context中执行，因此可以被多个goroutine同时调用
+type GoInterfaceDelegate struct {
+	// 创建时脚本上下文的快照，每次调用从它复制
+	c   *Context
+	obj Value
+	// Read返回的内容超出缓冲区时剩余的部分，留给下一次Read
+	pendingLock sync.Mutex
+	pending     []byte
+}
+
+type goInterfaceImpl = func(*GoInterfaceDelegate) interface{}
+
+var (
+	goInterfaceLock sync.RWMutex
+	goInterfaces    = map[reflect.Type]goInterfaceImpl{}
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// RegisterGoInterface 注册go接口的实现。由于reflect无法在运行时创建带方法的类型，
+// 每个接口都需要一个内嵌*GoInterfaceDelegate并通过Call转发方法的实现类型
+func RegisterGoInterface(ifaceType reflect.Type, newImpl func(*GoInterfaceDelegate) interface{}) {
+	if ifaceType.Kind() != reflect.Interface {
+		panic("RegisterGoInterface: " + ifaceType.String() + " is not an interface")
+	}
+	if impl := reflect.TypeOf(newImpl(nil)); !impl.Implements(ifaceType) {
+		panic("RegisterGoInterface: " + impl.String() + " does not implement " + ifaceType.String())
+	}
+	goInterfaceLock.Lock()
+	defer goInterfaceLock.Unlock()
+	goInterfaces[ifaceType] = newImpl
+}
+
+// FindGoInterface 按名称(如io.Reader)查找已注册的接口类型
+func FindGoInterface(name string) (reflect.Type, bool) {
+	goInterfaceLock.RLock()
+	defer goInterfaceLock.RUnlock()
+	for t := range goInterfaces {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// GoInterfacesOf 返回包pkgPath中已注册的接口类型，key为接口名
+func GoInterfacesOf(pkgPath string) map[string]reflect.Type {
+	goInterfaceLock.RLock()
+	defer goInterfaceLock.RUnlock()
+	rv := map[string]reflect.Type{}
+	for t := range goInterfaces {
+		if t.PkgPath() == pkgPath && t.Name() != "" {
+			rv[t.Name()] = t
+		}
+	}
+	return rv
+}
+
+func GoInterfaceNames() []string {
+	goInterfaceLock.RLock()
+	defer goInterfaceLock.RUnlock()
+	rv := make([]string, 0, len(goInterfaces))
+	for t := range goInterfaces {
+		rv = append(rv, t.String())
+	}
+	return rv
+}
+
+// ImplementGoInterface 创建一个实现了ifaceType接口的go值，方法调用转发给obj。
+// 创建时对c做快照，之后的方法调用都从快照复制上下文，因此脚本可以在go协程调用方法的同时继续运行
+func ImplementGoInterface(c *Context, obj Value, ifaceType reflect.Type) (reflect.Value, error) {
+	if ifaceType.Kind() != reflect.Interface {
+		return reflect.Value{}, fmt.Errorf("%s is not an interface", ifaceType)
+	}
+	goInterfaceLock.RLock()
+	newImpl, found := goInterfaces[ifaceType]
+	goInterfaceLock.RUnlock()
+	if !found {
+		return reflect.Value{}, fmt.Errorf("go interface %s is not registered", ifaceType)
+	}
+	d := &GoInterfaceDelegate{c: c.Clone(), obj: obj}
+	rv := reflect.New(ifaceType).Elem()
+	rv.Set(reflect.ValueOf(newImpl(d)))
+	return rv, nil
+}
+
+func (d *GoInterfaceDelegate) Object() Value {
+	return d.obj
+}
+
+// HasMethod 判断zgg对象是否有method方法(或首字母小写的同名方法)
+func (d *GoInterfaceDelegate) HasMethod(method string) bool {
+	_, found := d.findMethod(d.c.Clone(), method)
+	return found
+}
+
+func (d *GoInterfaceDelegate) findMethod(c *Context, method string) (ValueCallable, bool) {
+	if f, ok := c.GetCallable(d.obj.GetMember(method, c)); ok {
+		return f, true
+	}
+	lower := strings.ToLower(method[:1]) + method[1:]
+	return c.GetCallable(d.obj.GetMember(lower, c))
+}
+
+// fillReadBuf 把Read方法返回的内容复制进buf，Read多出的部分留给下一次调用
+func (d *GoInterfaceDelegate) fillReadBuf(c *Context, method string, buf, data []byte, out reflect.Value) {
+	n := copy(buf, data)
+	if n < len(data) {
+		if method != "Read" {
+			c.RaiseRuntimeError("%s returns %d bytes, more than buffer size %d", method, len(data), len(buf))
+		}
+		// data可能与zgg中的bytes共享内存，复制一份
+		d.pending = append([]byte(nil), data[n:]...)
+	}
+	out.SetInt(int64(n))
+}
+
+func goInterfaceArg(c *Context, arg interface{}) Value {
+	if arg == nil {
+		return constNil
+	}
+	switch a := arg.(type) {
+	case []byte:
+		// 不复制，zgg方法中对bytes的修改会直接写入go的缓冲区
+		return NewBytes(a)
+	case error:
+		return NewGoValue(a)
+	}
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return FromGoValue(v, c)
+	}
+	if loadGoToZggCaster(v.Type()) != nil {
+		return FromGoValue(v, c)
+	}
+	return NewReflectedGoValue(v)
+}
+
+func goInterfaceToError(c *Context, v Value) error {
+	switch ev := v.(type) {
+	case ValueNil, ValueUndefined:
+		return nil
+	case ValueStr:
+		return errors.New(ev.Value())
+	case GoValue:
+		switch e := ev.v.Interface().(type) {
+		case error:
+			return e
+		case *error:
+			return *e
+		}
+	case ValueObject:
+		if msg, ok := ev.GetMember("message", c).(ValueStr); ok {
+			return errors.New(msg.Value())
+		}
+	}
+	return errors.New(v.ToString(c))
+}
+
+func goInterfaceSetOut(c *Context, v Value, out reflect.Value) {
+	t := out.Type()
+	switch {
+	case t == errorType:
+		if err := goInterfaceToError(c, v); err != nil {
+			out.Set(reflect.ValueOf(err))
+		}
+	case t.Kind() == reflect.Interface && t.NumMethod() == 0:
+		if !Nullish(v) {
+			out.Set(reflect.ValueOf(v.ToGoValue(c)))
+		}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		if s, ok := v.(ValueStr); ok {
+			out.SetBytes([]byte(s.Value()))
+			return
+		}
+		toGoValue(c, v, out)
+	default:
+		if gv, ok := v.(GoValue); ok && gv.v.Type().AssignableTo(t) {
+			out.Set(gv.v)
+			return
+		}
+		toGoValue(c, v, out)
+	}
+}
+
+// Call 调用zgg对象的method方法(找不到时尝试首字母小写的名称)。
+// ins为go实参，outs为指向各返回值的指针。zgg侧抛出的异常在最后一个返回值为error时转换为该error，
+// 否则以panic的形式抛给go调用方。
+// 对于以Read开头、首个参数为[]byte且首个返回值为int的方法，zgg方法可以返回bytes/str，其内容会被复制进缓冲区，
+// 返回nil表示io.EOF。Read返回的内容超出缓冲区时，多出的部分会在之后的Read中依次返回，
+// 其他Read开头的方法(如ReadAt)则视为错误；以Write开头的同类方法没有返回写入的字节数时视为全部写入
+func (d *GoInterfaceDelegate) Call(method string, ins []interface{}, outs []interface{}) {
+	outVals := make([]reflect.Value, len(outs))
+	for i, o := range outs {
+		outVals[i] = reflect.ValueOf(o).Elem()
+	}
+	var errOut reflect.Value
+	if n := len(outVals); n > 0 && outVals[n-1].Type() == errorType {
+		errOut = outVals[n-1]
+	}
+	var buf []byte
+	if len(ins) > 0 && len(outVals) > 0 && outVals[0].Kind() == reflect.Int {
+		buf, _ = ins[0].([]byte)
+	}
+	if buf != nil && method == "Read" {
+		d.pendingLock.Lock()
+		defer d.pendingLock.Unlock()
+		if len(d.pending) > 0 {
+			n := copy(buf, d.pending)
+			d.pending = d.pending[n:]
+			outVals[0].SetInt(int64(n))
+			return
+		}
+	}
+	c := d.c.Clone()
+	defer func() {
+		if e := recover(); e != nil {
+			var err error
+			switch ee := e.(type) {
+			case error:
+				err = ee
+			default:
+				err = errors.New(fmt.Sprint(ee))
+			}
+			if !errOut.IsValid() {
+				panic(err)
+			}
+			errOut.Set(reflect.ValueOf(err))
+		}
+	}()
+	f, found := d.findMethod(c, method)
+	if !found {
+		c.RaiseRuntimeError("go interface method %s is not implemented by %s", method, d.obj.Type().Name)
+	}
+	args := make([]Value, len(ins))
+	for i, a := range ins {
+		args[i] = goInterfaceArg(c, a)
+	}
+	c.Invoke(f, d.obj, Args(args...))
+	ret := c.RetVal
+	if buf != nil && strings.HasPrefix(method, "Read") {
+		switch r := ret.(type) {
+		case ValueBytes:
+			d.fillReadBuf(c, method, buf, r.Value(), outVals[0])
+			return
+		case ValueStr:
+			d.fillReadBuf(c, method, buf, []byte(r.Value()), outVals[0])
+			return
+		case ValueNil:
+			if errOut.IsValid() {
+				errOut.Set(reflect.ValueOf(io.EOF))
+			}
+			return
+		}
+	} else if buf != nil && strings.HasPrefix(method, "Write") {
+		written := false
+		switch r := ret.(type) {
+		case ValueInt:
+			written = true
+		case ValueArray:
+			_, written = r.GetIndex(0, c).(ValueInt)
+		}
+		if !written {
+			outVals[0].SetInt(int64(len(buf)))
+			return
+		}
+	}
+	var rets []Value
+	switch len(outVals) {
+	case 0:
+		return
+	case 1:
+		rets = []Value{ret}
+	default:
+		if arr, ok := ret.(ValueArray); ok {
+			rets = *arr.Values
+		} else {
+			rets = []Value{ret}
+		}
+	}
+	for i, out := range outVals {
+		if i >= len(rets) {
+			break
+		}
+		goInterfaceSetOut(c, rets[i], out)
+	}
+}
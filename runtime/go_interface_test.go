@@ -0,0 +1,140 @@
+package runtime
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testGreeter interface {
+	Greet(name string) (string, error)
+}
+
+type testGreeterImpl struct{ *GoInterfaceDelegate }
+
+func (d testGreeterImpl) Greet(name string) (s string, err error) {
+	d.Call("Greet", []interface{}{name}, []interface{}{&s, &err})
+	return
+}
+
+func TestImplementGoInterface(t *testing.T) {
+	ifaceType := reflect.TypeOf((*testGreeter)(nil)).Elem()
+	RegisterGoInterface(ifaceType, func(d *GoInterfaceDelegate) interface{} { return testGreeterImpl{d} })
+	c := NewContext(true, false, false, context.Background())
+	cls := NewClassBuilder("Greeter").
+		Method("greet", func(c *Context, this ValueObject, args []Value) Value {
+			name := c.MustStr(args[0])
+			if name == "" {
+				c.RaiseRuntimeError("empty name")
+			}
+			return NewStr("hello " + name)
+		}).
+		Build()
+	var g testGreeter
+	gv := reflect.ValueOf(&g).Elem()
+	toGoValue(c, NewObjectAndInit(cls, c), gv)
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, err := g.Greet("zgg"); err != nil || s != "hello zgg" {
+				t.Errorf("Greet returns %q, %v", s, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := g.Greet(""); err == nil || err.Error() != "empty name" {
+		t.Fatalf("expected error 'empty name', got %v", err)
+	}
+}
+
+type testReaderImpl struct{ *GoInterfaceDelegate }
+
+func (d testReaderImpl) Read(p []byte) (n int, err error) {
+	d.Call("Read", []interface{}{p}, []interface{}{&n, &err})
+	return
+}
+
+func TestImplementGoReaderRemainder(t *testing.T) {
+	ifaceType := reflect.TypeOf((*io.Reader)(nil)).Elem()
+	RegisterGoInterface(ifaceType, func(d *GoInterfaceDelegate) interface{} { return testReaderImpl{d} })
+	c := NewContext(true, false, false, context.Background())
+	chunks := []string{"hello ", "wonderful world"}
+	cls := NewClassBuilder("Chunks").
+		Method("read", func(c *Context, this ValueObject, args []Value) Value {
+			if len(chunks) == 0 {
+				return Nil()
+			}
+			chunk := chunks[0]
+			chunks = chunks[1:]
+			return NewBytes([]byte(chunk))
+		}).
+		Build()
+	rv, err := ImplementGoInterface(c, NewObjectAndInit(cls, c), ifaceType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rv.Interface().(io.Reader)
+	var reads []string
+	buf := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		reads = append(reads, string(buf[:n]))
+	}
+	expected := []string{"hell", "o ", "wond", "erfu", "l wo", "rld"}
+	if !reflect.DeepEqual(reads, expected) {
+		t.Fatalf("expected %q, got %q", expected, reads)
+	}
+}
+
+func TestImplementGoInterfaceWhileScriptRuns(t *testing.T) {
+	ifaceType := reflect.TypeOf((*testGreeter)(nil)).Elem()
+	RegisterGoInterface(ifaceType, func(d *GoInterfaceDelegate) interface{} { return testGreeterImpl{d} })
+	c := NewContext(true, false, false, context.Background())
+	cls := NewClassBuilder("Greeter").
+		Method("greet", func(c *Context, this ValueObject, args []Value) Value {
+			return NewStr("hello " + c.MustStr(args[0]))
+		}).
+		Build()
+	rv, err := ImplementGoInterface(c, NewObjectAndInit(cls, c), ifaceType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := rv.Interface().(testGreeter)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if s, err := g.Greet("zgg"); err != nil || s != "hello zgg" {
+					t.Errorf("Greet returns %q, %v", s, err)
+					return
+				}
+			}
+		}()
+	}
+	// 模拟脚本在主协程继续执行：不断进出栈帧、更新行号
+	for start, i := time.Now(), 0; time.Since(start) < 200*time.Millisecond; i++ {
+		c.PushFuncStack("main")
+		c.SetPosition("main.zgg", i)
+		c.PopStack()
+	}
+	close(done)
+	wg.Wait()
+}
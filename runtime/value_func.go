@@ -15,6 +15,8 @@ type ValueFunc struct {
 	This       Value
 	Body       IEval
 	BelongType ValueType
+	// Source 定义该函数的源码(求值结果为该函数的表达式)，用于保存和恢复解释器状态
+	Source string
 }
 
 func NewFunc(name string, args []string, expandLast bool, body IEval) *ValueFunc {
@@ -35,6 +37,11 @@ func (v *ValueFunc) CloneWithEnv(c *Context) *ValueFunc {
 	return &newFunc
 }
 
+// CapturesLocals 函数是否在c的根栈帧之外定义，即可能引用了外层函数或代码块中的局部变量
+func (v *ValueFunc) CapturesLocals(c *Context) bool {
+	return v.env != nil && v.env.cur != nil && v.env.cur.variables != c.rootFrame.variables
+}
+
 func (v *ValueFunc) GetIndex(index int, c *Context) Value {
 	return constUndefined
 }
@@ -18,6 +18,8 @@ type (
 		New       func(c *Context, args []Value) Value
 		Members   *sync.Map
 		Statics   *sync.Map
+		// Source 脚本中定义该类的源码，内置类型为空
+		Source string
 	}
 	ValueType = *valueType
 )
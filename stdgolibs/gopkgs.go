@@ -0,0 +1,62 @@
+package stdgolibs
+
+import (
+	"reflect"
+	"sync"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// 由zgg build-runtime生成的自定义运行时注册的第三方go包，通过import('gopkg/<path>')引用
+var (
+	goPkgLock   sync.RWMutex
+	goPkgValues = map[string]map[string]reflect.Value{}
+	goPkgTypes  = map[string]map[string]reflect.Type{}
+)
+
+func RegisterGoPkg(importPath string, values map[string]reflect.Value, types map[string]reflect.Type) {
+	goPkgLock.Lock()
+	defer goPkgLock.Unlock()
+	if cur, found := goPkgValues[importPath]; found {
+		for k, v := range values {
+			cur[k] = v
+		}
+	} else {
+		goPkgValues[importPath] = values
+	}
+	if cur, found := goPkgTypes[importPath]; found {
+		for k, t := range types {
+			cur[k] = t
+		}
+	} else {
+		goPkgTypes[importPath] = types
+	}
+}
+
+func GoPkgs() []string {
+	goPkgLock.RLock()
+	defer goPkgLock.RUnlock()
+	rv := make([]string, 0, len(goPkgValues))
+	for importPath := range goPkgValues {
+		rv = append(rv, importPath)
+	}
+	return rv
+}
+
+func FindGoPkg(c *Context, importPath string) (Value, bool) {
+	goPkgLock.RLock()
+	defer goPkgLock.RUnlock()
+	values, valuesFound := goPkgValues[importPath]
+	types, typesFound := goPkgTypes[importPath]
+	if !valuesFound && !typesFound {
+		return nil, false
+	}
+	rv := NewObject()
+	for name, val := range values {
+		rv.SetMember(name, NewReflectedGoValue(val), c)
+	}
+	for name, typ := range types {
+		rv.SetMember(name, NewGoType(typ), c)
+	}
+	return rv, true
+}
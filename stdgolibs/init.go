@@ -67,6 +67,10 @@ func FindLib(c *Context, importPath string) (Value, bool) {
 		}
 		libFound = true
 	}
+	for name, typ := range GoInterfacesOf(importPath) {
+		rv.SetMember(name, NewGoType(typ), c)
+		libFound = true
+	}
 	if content, found := funcs[importPath]; found {
 		for name, f := range content {
 			rv.SetMember(name, f, c)
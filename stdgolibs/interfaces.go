@@ -0,0 +1,217 @@
+package stdgolibs
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// 常用go接口的实现，方法调用通过GoInterfaceDelegate转发给zgg对象
+type (
+	goError        struct{ *GoInterfaceDelegate }
+	goStringer     struct{ *GoInterfaceDelegate }
+	goReader       struct{ *GoInterfaceDelegate }
+	goWriter       struct{ *GoInterfaceDelegate }
+	goCloser       struct{ *GoInterfaceDelegate }
+	goSeeker       struct{ *GoInterfaceDelegate }
+	goReaderAt     struct{ *GoInterfaceDelegate }
+	goWriterAt     struct{ *GoInterfaceDelegate }
+	goStringWriter struct{ *GoInterfaceDelegate }
+	goByteReader   struct{ *GoInterfaceDelegate }
+	goByteWriter   struct{ *GoInterfaceDelegate }
+	goReadCloser   struct {
+		goReader
+		goCloser
+	}
+	goWriteCloser struct {
+		goWriter
+		goCloser
+	}
+	goReadWriter struct {
+		goReader
+		goWriter
+	}
+	goReadWriteCloser struct {
+		goReader
+		goWriter
+		goCloser
+	}
+	goReadSeeker struct {
+		goReader
+		goSeeker
+	}
+	goSortInterface     struct{ *GoInterfaceDelegate }
+	goHttpHandler       struct{ *GoInterfaceDelegate }
+	goDriverValuer      struct{ *GoInterfaceDelegate }
+	goSqlScanner        struct{ *GoInterfaceDelegate }
+	goJsonMarshaler     struct{ *GoInterfaceDelegate }
+	goJsonUnmarshaler   struct{ *GoInterfaceDelegate }
+	goTextMarshaler     struct{ *GoInterfaceDelegate }
+	goTextUnmarshaler   struct{ *GoInterfaceDelegate }
+	goBinaryMarshaler   struct{ *GoInterfaceDelegate }
+	goBinaryUnmarshaler struct{ *GoInterfaceDelegate }
+)
+
+func (d goError) Error() (s string) {
+	d.Call("Error", []interface{}{}, []interface{}{&s})
+	return
+}
+
+func (d goStringer) String() (s string) {
+	d.Call("String", []interface{}{}, []interface{}{&s})
+	return
+}
+
+func (d goReader) Read(p []byte) (n int, err error) {
+	d.Call("Read", []interface{}{p}, []interface{}{&n, &err})
+	return
+}
+
+func (d goWriter) Write(p []byte) (n int, err error) {
+	d.Call("Write", []interface{}{p}, []interface{}{&n, &err})
+	return
+}
+
+func (d goCloser) Close() (err error) {
+	d.Call("Close", []interface{}{}, []interface{}{&err})
+	return
+}
+
+func (d goSeeker) Seek(offset int64, whence int) (n int64, err error) {
+	d.Call("Seek", []interface{}{offset, whence}, []interface{}{&n, &err})
+	return
+}
+
+func (d goReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	d.Call("ReadAt", []interface{}{p, off}, []interface{}{&n, &err})
+	return
+}
+
+func (d goWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	d.Call("WriteAt", []interface{}{p, off}, []interface{}{&n, &err})
+	return
+}
+
+func (d goStringWriter) WriteString(s string) (n int, err error) {
+	d.Call("WriteString", []interface{}{s}, []interface{}{&n, &err})
+	return
+}
+
+func (d goByteReader) ReadByte() (b byte, err error) {
+	d.Call("ReadByte", []interface{}{}, []interface{}{&b, &err})
+	return
+}
+
+func (d goByteWriter) WriteByte(b byte) (err error) {
+	d.Call("WriteByte", []interface{}{b}, []interface{}{&err})
+	return
+}
+
+func (d goSortInterface) Len() (n int) {
+	d.Call("Len", []interface{}{}, []interface{}{&n})
+	return
+}
+
+func (d goSortInterface) Less(i, j int) (less bool) {
+	d.Call("Less", []interface{}{i, j}, []interface{}{&less})
+	return
+}
+
+func (d goSortInterface) Swap(i, j int) {
+	d.Call("Swap", []interface{}{i, j}, []interface{}{})
+}
+
+func (d goHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Call("ServeHTTP", []interface{}{w, r}, []interface{}{})
+}
+
+func (d goDriverValuer) Value() (v driver.Value, err error) {
+	d.Call("Value", []interface{}{}, []interface{}{&v, &err})
+	return
+}
+
+func (d goSqlScanner) Scan(src any) (err error) {
+	d.Call("Scan", []interface{}{src}, []interface{}{&err})
+	return
+}
+
+func (d goJsonMarshaler) MarshalJSON() (bs []byte, err error) {
+	d.Call("MarshalJSON", []interface{}{}, []interface{}{&bs, &err})
+	return
+}
+
+func (d goJsonUnmarshaler) UnmarshalJSON(bs []byte) (err error) {
+	d.Call("UnmarshalJSON", []interface{}{bs}, []interface{}{&err})
+	return
+}
+
+func (d goTextMarshaler) MarshalText() (bs []byte, err error) {
+	d.Call("MarshalText", []interface{}{}, []interface{}{&bs, &err})
+	return
+}
+
+func (d goTextUnmarshaler) UnmarshalText(bs []byte) (err error) {
+	d.Call("UnmarshalText", []interface{}{bs}, []interface{}{&err})
+	return
+}
+
+func (d goBinaryMarshaler) MarshalBinary() (bs []byte, err error) {
+	d.Call("MarshalBinary", []interface{}{}, []interface{}{&bs, &err})
+	return
+}
+
+func (d goBinaryUnmarshaler) UnmarshalBinary(bs []byte) (err error) {
+	d.Call("UnmarshalBinary", []interface{}{bs}, []interface{}{&err})
+	return
+}
+
+func interfaceTypeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func init() {
+	RegisterGoInterface(interfaceTypeOf[error](), func(d *GoInterfaceDelegate) interface{} { return goError{d} })
+	RegisterGoInterface(interfaceTypeOf[fmt.Stringer](), func(d *GoInterfaceDelegate) interface{} { return goStringer{d} })
+	RegisterGoInterface(interfaceTypeOf[io.Reader](), func(d *GoInterfaceDelegate) interface{} { return goReader{d} })
+	RegisterGoInterface(interfaceTypeOf[io.Writer](), func(d *GoInterfaceDelegate) interface{} { return goWriter{d} })
+	RegisterGoInterface(interfaceTypeOf[io.Closer](), func(d *GoInterfaceDelegate) interface{} { return goCloser{d} })
+	RegisterGoInterface(interfaceTypeOf[io.Seeker](), func(d *GoInterfaceDelegate) interface{} { return goSeeker{d} })
+	RegisterGoInterface(interfaceTypeOf[io.ReaderAt](), func(d *GoInterfaceDelegate) interface{} { return goReaderAt{d} })
+	RegisterGoInterface(interfaceTypeOf[io.WriterAt](), func(d *GoInterfaceDelegate) interface{} { return goWriterAt{d} })
+	RegisterGoInterface(interfaceTypeOf[io.StringWriter](), func(d *GoInterfaceDelegate) interface{} { return goStringWriter{d} })
+	RegisterGoInterface(interfaceTypeOf[io.ByteReader](), func(d *GoInterfaceDelegate) interface{} { return goByteReader{d} })
+	RegisterGoInterface(interfaceTypeOf[io.ByteWriter](), func(d *GoInterfaceDelegate) interface{} { return goByteWriter{d} })
+	RegisterGoInterface(interfaceTypeOf[io.ReadCloser](), func(d *GoInterfaceDelegate) interface{} {
+		return goReadCloser{goReader{d}, goCloser{d}}
+	})
+	RegisterGoInterface(interfaceTypeOf[io.WriteCloser](), func(d *GoInterfaceDelegate) interface{} {
+		return goWriteCloser{goWriter{d}, goCloser{d}}
+	})
+	RegisterGoInterface(interfaceTypeOf[io.ReadWriter](), func(d *GoInterfaceDelegate) interface{} {
+		return goReadWriter{goReader{d}, goWriter{d}}
+	})
+	RegisterGoInterface(interfaceTypeOf[io.ReadWriteCloser](), func(d *GoInterfaceDelegate) interface{} {
+		return goReadWriteCloser{goReader{d}, goWriter{d}, goCloser{d}}
+	})
+	RegisterGoInterface(interfaceTypeOf[io.ReadSeeker](), func(d *GoInterfaceDelegate) interface{} {
+		return goReadSeeker{goReader{d}, goSeeker{d}}
+	})
+	RegisterGoInterface(interfaceTypeOf[sort.Interface](), func(d *GoInterfaceDelegate) interface{} { return goSortInterface{d} })
+	RegisterGoInterface(interfaceTypeOf[http.Handler](), func(d *GoInterfaceDelegate) interface{} { return goHttpHandler{d} })
+	RegisterGoInterface(interfaceTypeOf[driver.Valuer](), func(d *GoInterfaceDelegate) interface{} { return goDriverValuer{d} })
+	RegisterGoInterface(interfaceTypeOf[sql.Scanner](), func(d *GoInterfaceDelegate) interface{} { return goSqlScanner{d} })
+	RegisterGoInterface(interfaceTypeOf[json.Marshaler](), func(d *GoInterfaceDelegate) interface{} { return goJsonMarshaler{d} })
+	RegisterGoInterface(interfaceTypeOf[json.Unmarshaler](), func(d *GoInterfaceDelegate) interface{} { return goJsonUnmarshaler{d} })
+	RegisterGoInterface(interfaceTypeOf[encoding.TextMarshaler](), func(d *GoInterfaceDelegate) interface{} { return goTextMarshaler{d} })
+	RegisterGoInterface(interfaceTypeOf[encoding.TextUnmarshaler](), func(d *GoInterfaceDelegate) interface{} { return goTextUnmarshaler{d} })
+	RegisterGoInterface(interfaceTypeOf[encoding.BinaryMarshaler](), func(d *GoInterfaceDelegate) interface{} { return goBinaryMarshaler{d} })
+	RegisterGoInterface(interfaceTypeOf[encoding.BinaryUnmarshaler](), func(d *GoInterfaceDelegate) interface{} { return goBinaryUnmarshaler{d} })
+}
@@ -0,0 +1,93 @@
+package stdgolibs
+
+import (
+	"io"
+
+	. "github.com/zgg-lang/zgg-go/runtime"
+)
+
+// ioDelegate 同时实现io包中的各个接口，供io.Delegate使用。
+// 对象没有ReadFrom、WriteTo或WriteString方法时，退回到用Read/Write实现
+type ioDelegate struct {
+	goReader
+	goWriter
+	goCloser
+	goSeeker
+	goReaderAt
+	goWriterAt
+	goStringWriter
+	goByteReader
+	goByteWriter
+}
+
+type ioDelegateInterface interface {
+	io.ReadWriteCloser
+	io.Seeker
+	io.ReaderAt
+	io.WriterAt
+	io.ReaderFrom
+	io.WriterTo
+	io.StringWriter
+	io.ByteScanner
+	io.ByteWriter
+	io.RuneScanner
+}
+
+func (d ioDelegate) UnreadByte() (err error) {
+	d.goReader.Call("UnreadByte", []interface{}{}, []interface{}{&err})
+	return
+}
+
+func (d ioDelegate) ReadRune() (r rune, size int, err error) {
+	d.goReader.Call("ReadRune", []interface{}{}, []interface{}{&r, &size, &err})
+	return
+}
+
+func (d ioDelegate) UnreadRune() (err error) {
+	d.goReader.Call("UnreadRune", []interface{}{}, []interface{}{&err})
+	return
+}
+
+func (d ioDelegate) WriteString(s string) (n int, err error) {
+	if !d.goReader.HasMethod("WriteString") {
+		return d.goWriter.Write([]byte(s))
+	}
+	return d.goStringWriter.WriteString(s)
+}
+
+func (d ioDelegate) ReadFrom(r io.Reader) (n int64, err error) {
+	if !d.goReader.HasMethod("ReadFrom") {
+		return io.Copy(d.goWriter, r)
+	}
+	d.goReader.Call("ReadFrom", []interface{}{r}, []interface{}{&n, &err})
+	return
+}
+
+func (d ioDelegate) WriteTo(w io.Writer) (n int64, err error) {
+	if !d.goReader.HasMethod("WriteTo") {
+		return io.Copy(w, d.goReader)
+	}
+	d.goReader.Call("WriteTo", []interface{}{w}, []interface{}{&n, &err})
+	return
+}
+
+func init() {
+	RegisterGoInterface(interfaceTypeOf[ioDelegateInterface](), func(d *GoInterfaceDelegate) interface{} {
+		return ioDelegate{
+			goReader{d}, goWriter{d}, goCloser{d}, goSeeker{d}, goReaderAt{d},
+			goWriterAt{d}, goStringWriter{d}, goByteReader{d}, goByteWriter{d},
+		}
+	})
+	// 保留旧的io.Delegate，等价于把对象传给接受相应io接口的go函数时的自动转换
+	registerFuncs("io", map[string]*ValueBuiltinFunction{
+		"Delegate": NewNativeFunction("io.Delegate", func(c *Context, this Value, args []Value) Value {
+			var obj ValueObject
+			EnsureFuncParams(c, "Delegate", args, ArgRuleRequired("delegate", TypeObject, &obj))
+			rv, err := ImplementGoInterface(c, obj, interfaceTypeOf[ioDelegateInterface]())
+			if err != nil {
+				c.RaiseRuntimeError("io.Delegate: %s", err)
+			}
+			return NewGoValue(rv.Interface())
+		}),
+	})
+}
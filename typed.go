@@ -0,0 +1,43 @@
+package zgg
+
+import (
+	"github.com/zgg-lang/zgg-go/runtime"
+)
+
+// ValueAs 把zgg值v转换为T类型的go值，转换规则见Runner.Decode
+func ValueAs[T any](r *Runner, v runtime.Value) (T, error) {
+	var rv T
+	err := r.Decode(v, &rv)
+	return rv, err
+}
+
+// RunnerEvalAs 在r中执行表达式expr，并把结果转换为T类型
+func RunnerEvalAs[T any](r *Runner, expr interface{}) (T, error) {
+	v, err := r.EvalValue(expr)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return ValueAs[T](r, v)
+}
+
+// CallAs 调用脚本函数funcName，并把返回值转换为T类型
+func CallAs[T any](r *Runner, funcName string, args ...interface{}) (T, error) {
+	v, err := r.Call(funcName, args...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return ValueAs[T](r, v)
+}
+
+// EvalAs 与Eval相同，但把结果转换为T类型
+func EvalAs[T any](expr interface{}, opts ...ExecOption) (T, error) {
+	runner := runnerPool.Get().(*Runner)
+	defer runnerPool.Put(runner)
+	runner.Reset()
+	for _, opt := range opts {
+		opt.Apply(runner)
+	}
+	return RunnerEvalAs[T](runner, expr)
+}
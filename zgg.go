@@ -10,6 +10,7 @@ import (
 
 	"github.com/zgg-lang/zgg-go/ast"
 	"github.com/zgg-lang/zgg-go/parser"
+	"github.com/zgg-lang/zgg-go/repl"
 	"github.com/zgg-lang/zgg-go/runtime"
 )
 
@@ -70,12 +71,19 @@ func (r *Runner) Args(args ...string) *Runner {
 	return r
 }
 
-func (r *Runner) Var(name string, value interface{}) *Runner {
-	if zggval, ok := value.(Val); ok {
-		r.context.ForceSetLocalValue(name, runtime.FromGoValue(reflect.ValueOf(zggval.Value), r.context))
-	} else {
-		r.context.ForceSetLocalValue(name, runtime.NewGoValue(value))
+// toValue runtime.Value原样使用；Val按值转换为zgg值；其它值包装为GoValue，不复制
+func (r *Runner) toValue(value interface{}) runtime.Value {
+	switch v := value.(type) {
+	case runtime.Value:
+		return v
+	case Val:
+		return runtime.FromGoValue(reflect.ValueOf(v.Value), r.context)
 	}
+	return runtime.NewGoValue(value)
+}
+
+func (r *Runner) Var(name string, value interface{}) *Runner {
+	r.context.ForceSetLocalValue(name, r.toValue(value))
 	return r
 }
 
@@ -122,11 +130,8 @@ func (r *Runner) CompileExpr(code interface{}) (ast.Node, error) {
 	return r.compile(code, r.compileExpr)
 }
 
-func (r *Runner) execute(code interface{}, compileFunc compileFunc) (rv interface{}, err error) {
-	codeNode, err := r.compile(code, compileFunc)
-	if err != nil {
-		return nil, err
-	}
+// protect 执行f，把其中抛出的异常转换为error
+func (r *Runner) protect(f func()) (err error) {
 	defer func() {
 		e := recover()
 		if e != nil {
@@ -138,8 +143,30 @@ func (r *Runner) execute(code interface{}, compileFunc compileFunc) (rv interfac
 			}
 		}
 	}()
-	codeNode.Eval(r.context)
-	rv = r.context.RetVal.ToGoValue(r.context)
+	f()
+	return
+}
+
+func (r *Runner) executeValue(code interface{}, compileFunc compileFunc) (rv runtime.Value, err error) {
+	codeNode, err := r.compile(code, compileFunc)
+	if err != nil {
+		return nil, err
+	}
+	err = r.protect(func() {
+		codeNode.Eval(r.context)
+		rv = r.context.RetVal
+	})
+	return
+}
+
+func (r *Runner) execute(code interface{}, compileFunc compileFunc) (rv interface{}, err error) {
+	v, err := r.executeValue(code, compileFunc)
+	if err != nil {
+		return nil, err
+	}
+	err = r.protect(func() {
+		rv = v.ToGoValue(r.context)
+	})
 	return
 }
 
@@ -159,6 +186,71 @@ func (r *Runner) Eval(expr interface{}) (interface{}, error) {
 	return r.execute(expr, r.compileExpr)
 }
 
+// RunValue 与Run相同，但直接返回zgg值，不转换为go值
+func (r *Runner) RunValue(code interface{}) (runtime.Value, error) {
+	return r.executeValue(code, r.compileCode)
+}
+
+// EvalValue 与Eval相同，但直接返回zgg值，不转换为go值
+func (r *Runner) EvalValue(expr interface{}) (runtime.Value, error) {
+	return r.executeValue(expr, r.compileExpr)
+}
+
+// Get 查找通过Var设置的变量或内置值，找不到时查找脚本导出的值
+func (r *Runner) Get(name string) (runtime.Value, bool) {
+	if v, found := r.context.FindValue(name); found {
+		return v, true
+	}
+	if v := r.context.ExportValue.GetMember(name, r.context); !runtime.IsUndefined(v) {
+		return v, true
+	}
+	return nil, false
+}
+
+// Call 调用脚本中已定义的函数funcName，参数的转换规则与Var相同
+func (r *Runner) Call(funcName string, args ...interface{}) (rv runtime.Value, err error) {
+	fn, found := r.Get(funcName)
+	if !found {
+		return nil, fmt.Errorf("function %s not found", funcName)
+	}
+	err = r.protect(func() {
+		callable := r.context.MustCallable(fn, funcName)
+		callArgs := make([]runtime.Value, len(args))
+		for i, arg := range args {
+			callArgs[i] = r.toValue(arg)
+		}
+		r.context.Invoke(callable, nil, runtime.Args(callArgs...))
+		rv = r.context.RetVal
+	})
+	return
+}
+
+// SaveState 保存全局变量、脚本定义的函数和类以及已导入模块的引用，见repl.SaveState
+func (r *Runner) SaveState(w io.Writer) ([]repl.StateIssue, error) {
+	return repl.SaveState(r.context, w)
+}
+
+// LoadState 恢复SaveState保存的状态，见repl.LoadState
+func (r *Runner) LoadState(rd io.Reader) ([]repl.StateIssue, error) {
+	return repl.LoadState(r.context, rd)
+}
+
+// Decode 把zgg值v转换为out指向的go值。结构体字段名优先使用json tag，
+// interface{}类型的目标保留int/float、bytes等类型信息，out为*runtime.Value时直接赋值
+func (r *Runner) Decode(v runtime.Value, out interface{}) error {
+	if p, ok := out.(*runtime.Value); ok {
+		*p = v
+		return nil
+	}
+	outVal := reflect.ValueOf(out)
+	if outVal.Kind() != reflect.Ptr || outVal.IsNil() {
+		return errors.New("decode target must be a non-nil pointer")
+	}
+	return r.protect(func() {
+		runtime.DecodeGoValue(r.context, v, outVal.Elem())
+	})
+}
+
 var runnerPool = sync.Pool{
 	New: func() interface{} {
 		return NewRunner(context.Background())
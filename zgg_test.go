@@ -1,6 +1,7 @@
 package zgg
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -35,7 +36,7 @@ func TestRunEval(t *testing.T) {
 
 func TestRunner(t *testing.T) {
 	var outbuf strings.Builder
-	runner := NewRunner().
+	runner := NewRunner(context.Background()).
 		Stdout(&outbuf).
 		Var("a", 10).
 		Var("b", 11)
@@ -61,12 +62,12 @@ func TestCustomImport(t *testing.T) {
 
 func BenchmarkWithoutPrecompile(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		NewRunner().Eval("1+2+3")
+		NewRunner(context.Background()).Eval("1+2+3")
 	}
 }
 
 func BenchmarkWithoutPrecompileReuseRunner(b *testing.B) {
-	runner := NewRunner()
+	runner := NewRunner(context.Background())
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		runner.Eval("1+2+3")
@@ -74,15 +75,15 @@ func BenchmarkWithoutPrecompileReuseRunner(b *testing.B) {
 }
 
 func BenchmarkWithPrecompile(b *testing.B) {
-	code, _ := NewRunner().CompileExpr("1+2+3")
+	code, _ := NewRunner(context.Background()).CompileExpr("1+2+3")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		NewRunner().Eval(code)
+		NewRunner(context.Background()).Eval(code)
 	}
 }
 
 func BenchmarkWithPrecompileAndReuseRunner(b *testing.B) {
-	runner := NewRunner()
+	runner := NewRunner(context.Background())
 	code, _ := runner.CompileExpr("1+2+3")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -100,7 +101,7 @@ func BenchmarkRunWithPool(b *testing.B) {
 
 func BenchmarkCalcWithPrecalc(b *testing.B) {
 	parser.CanCalcInCompileTime = true
-	code, _ := NewRunner().CompileExpr("1+2+3*3-3**234.2")
+	code, _ := NewRunner(context.Background()).CompileExpr("1+2+3*3-3**234.2")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		Eval(code)
@@ -109,9 +110,125 @@ func BenchmarkCalcWithPrecalc(b *testing.B) {
 
 func BenchmarkCalcWithoutPrecalc(b *testing.B) {
 	parser.CanCalcInCompileTime = false
-	code, _ := NewRunner().CompileExpr("1+2+3*3-3**234.2")
+	code, _ := NewRunner(context.Background()).CompileExpr("1+2+3*3-3**234.2")
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		Eval(code)
 	}
 }
+
+type testRuleResult struct {
+	Passed bool               `json:"passed"`
+	Score  int64              `json:"score"`
+	Ratio  float64            `json:"ratio"`
+	Tags   []string           `json:"tags"`
+	Extra  map[string]any     `json:"extra"`
+	Detail *testRuleDetail    `json:"detail"`
+	Ignore string             `json:"-"`
+	Counts map[string]float64 `json:"counts"`
+}
+
+type testRuleDetail struct {
+	Reason string `json:"reason"`
+}
+
+func TestTypedRunner(t *testing.T) {
+	runner := NewRunner(context.Background())
+	_, err := runner.Run(`
+		class Point {
+			__init__(x, y) { this.x = x; this.y = y }
+		}
+		export check := (score, factor) => {
+			return {
+				passed: score > 60,
+				score: score,
+				ratio: score * factor,
+				tags: ['a', 'b'],
+				extra: {n: 1, f: 1.5, bs: bytes('xy')},
+				detail: {reason: 'ok'},
+				Ignore: 'ignored',
+				counts: {a: 1},
+			}
+		}
+		export p := Point(1, 2)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		r, err := CallAs[testRuleResult](runner, "check", Val{int64(70 + i)}, Val{0.5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Passed || r.Score != int64(70+i) || r.Ratio != float64(70+i)*0.5 || len(r.Tags) != 2 ||
+			r.Detail == nil || r.Detail.Reason != "ok" || r.Ignore != "" || r.Counts["a"] != 1 {
+			t.Fatalf("unexpected result %+v", r)
+		}
+		if _, isInt := r.Extra["n"].(int64); !isInt {
+			t.Fatalf("extra.n should be int64, got %T", r.Extra["n"])
+		}
+		if _, isBytes := r.Extra["bs"].([]byte); !isBytes {
+			t.Fatalf("extra.bs should be []byte, got %T", r.Extra["bs"])
+		}
+	}
+	p, found := runner.Get("p")
+	if !found {
+		t.Fatal("p not found")
+	}
+	if name := p.Type().Name; name != "Point" {
+		t.Fatalf("p should be a Point, got %s", name)
+	}
+	x, err := RunnerEvalAs[int](runner.Var("q", p), "q.x + q.y")
+	if err != nil || x != 3 {
+		t.Fatalf("q.x + q.y = %d, %v", x, err)
+	}
+	if _, err := runner.Call("notExists"); err == nil {
+		t.Fatal("calling undefined function should fail")
+	}
+	n, err := EvalAs[int64]("a * 2", Var{"a", Val{21}})
+	if err != nil || n != 42 {
+		t.Fatalf("EvalAs returns %d, %v", n, err)
+	}
+}
+
+func BenchmarkCallCompiledFunction(b *testing.B) {
+	runner := NewRunner(context.Background())
+	runner.Run(`export score := (a, b) => a * 2 + b`)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CallAs[int64](runner, "score", Val{i}, Val{1})
+	}
+}
+
+func TestIoDelegate(t *testing.T) {
+	r, err := RunCode(`
+		io := import('gostd/io')
+		class Src {
+			__init__() { this.chunks = ['hello ', 'world'] }
+			read(buf) {
+				if len(this.chunks) == 0 { return nil }
+				c := this.chunks[0]
+				this.chunks = this.chunks[1:]
+				return c
+			}
+		}
+		class Dst {
+			__init__() { this.got = '' }
+			write(bs) { this.got += str(bs) }
+		}
+		dst := Dst()
+		io.Copy(io.Delegate(dst), io.Delegate(Src()))
+		io.WriteString(io.Delegate(dst), '!')
+		export copied := dst.got
+		export readAll := str(io.ReadAll(io.Delegate(Src()))[0])
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r["copied"] != "hello world!" {
+		t.Errorf("copied: %v", r["copied"])
+	}
+	if r["readAll"] != "hello world" {
+		t.Errorf("readAll: %v", r["readAll"])
+	}
+}